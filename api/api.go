@@ -10,6 +10,7 @@ import (
 
 	"github.com/PlakarKorp/plakar/cmd/plakar/utils"
 	"github.com/PlakarKorp/plakar/repository"
+	"github.com/PlakarKorp/plakar/server/audit"
 	"github.com/PlakarKorp/plakar/snapshot"
 	"github.com/PlakarKorp/plakar/storage"
 )
@@ -88,6 +89,7 @@ func (view APIView) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 func TokenAuthMiddleware(token string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			auditResource(r)
 			if token != "" {
 				key := r.Header.Get("Authorization")
 				if key == "" {
@@ -99,6 +101,7 @@ func TokenAuthMiddleware(token string) func(http.Handler) http.Handler {
 					handleError(w, r, authError("invalid token"))
 					return
 				}
+				audit.SetIdentity(r, "token")
 			}
 
 			next.ServeHTTP(w, r)
@@ -106,6 +109,17 @@ func TokenAuthMiddleware(token string) func(http.Handler) http.Handler {
 	}
 }
 
+// auditResource records the snapshot, path, state or packfile r
+// accesses in the access log.
+func auditResource(r *http.Request) {
+	for _, name := range []string{"snapshot_path", "snapshot", "state", "packfile"} {
+		if value := r.PathValue(name); value != "" {
+			audit.SetResource(r, value)
+			return
+		}
+	}
+}
+
 func apiInfo(w http.ResponseWriter, r *http.Request) error {
 	res := &struct {
 		Version string `json:"version"`
@@ -8,6 +8,7 @@ import (
 	"github.com/PlakarKorp/plakar/cmd/plakar/utils"
 	"github.com/PlakarKorp/plakar/objects"
 	"github.com/PlakarKorp/plakar/repository"
+	"github.com/PlakarKorp/plakar/snapshot"
 	"github.com/PlakarKorp/plakar/snapshot/header"
 )
 
@@ -26,6 +27,27 @@ func SnapshotPathParam(r *http.Request, repo *repository.Repository, param strin
 	return mac, path, nil
 }
 
+// Load a snapshot viewed on the source given by the "source" query
+// parameter, the first one when it is missing.
+func SnapshotSourceParam(r *http.Request, repo *repository.Repository, mac objects.MAC) (*snapshot.Snapshot, error) {
+	idx, _, err := QueryParamToUint32(r, "source")
+	if err != nil {
+		return nil, parameterError("source", BadNumber, err)
+	}
+
+	snap, err := snapshot.Load(repo, mac)
+	if err != nil {
+		return nil, err
+	}
+
+	view, err := snap.WithSource(int(idx))
+	if err != nil {
+		snap.Close()
+		return nil, parameterError("source", InvalidArgument, err)
+	}
+	return view, nil
+}
+
 func PathParamToID(r *http.Request, param string) (id [32]byte, err error) {
 	idstr := r.PathValue(param)
 
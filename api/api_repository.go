@@ -53,7 +53,7 @@ func repositorySnapshots(w http.ResponseWriter, r *http.Request) error {
 			return err
 		}
 
-		if importerType != "" && strings.ToLower(snap.Header.GetSource(0).Importer.Type) != strings.ToLower(importerType) {
+		if importerType != "" && !hasImporterType(snap.Header, importerType) {
 			snap.Close()
 			continue
 		}
@@ -135,7 +135,9 @@ func repositoryImporterTypes(w http.ResponseWriter, r *http.Request) error {
 		if err != nil {
 			return err
 		}
-		importerTypesMap[strings.ToLower(snap.Header.GetSource(0).Importer.Type)] = struct{}{}
+		for _, source := range snap.Header.Sources {
+			importerTypesMap[strings.ToLower(source.Importer.Type)] = struct{}{}
+		}
 	}
 
 	importerTypes := make([]string, 0, len(importerTypesMap))
@@ -206,33 +208,7 @@ func repositoryLocatePathname(w http.ResponseWriter, r *http.Request) error {
 			return err
 		}
 
-		if importerType != "" && strings.ToLower(snap.Header.GetSource(0).Importer.Type) != strings.ToLower(importerType) {
-			snap.Close()
-			continue
-		}
-
-		if importerOrigin != "" && strings.ToLower(snap.Header.GetSource(0).Importer.Origin) != strings.ToLower(importerOrigin) {
-			snap.Close()
-			continue
-		}
-
-		path := snap.Header.GetSource(0).Importer.Directory
-		if path != "/" {
-			path = path + "/"
-		}
-		if !strings.HasPrefix(resource, path) {
-			snap.Close()
-			continue
-		}
-
-		pvfs, err := snap.Filesystem()
-		if err != nil {
-			snap.Close()
-			continue
-		}
-
-		_, err = pvfs.GetEntry(resource)
-		if err != nil {
+		if !hasResource(snap, importerType, importerOrigin, resource) {
 			snap.Close()
 			continue
 		}
@@ -265,3 +241,50 @@ func repositoryLocatePathname(w http.ResponseWriter, r *http.Request) error {
 
 	return json.NewEncoder(w).Encode(items)
 }
+
+func hasImporterType(hdr *header.Header, importerType string) bool {
+	for _, source := range hdr.Sources {
+		if strings.EqualFold(source.Importer.Type, importerType) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasResource tells whether one of the snapshot sources matching the
+// importer type and origin, when given, holds the resource.
+func hasResource(snap *snapshot.Snapshot, importerType, importerOrigin, resource string) bool {
+	for i := range snap.Header.Sources {
+		view, err := snap.WithSource(i)
+		if err != nil {
+			return false
+		}
+		source := view.Source()
+
+		if importerType != "" && !strings.EqualFold(source.Importer.Type, importerType) {
+			continue
+		}
+
+		if importerOrigin != "" && !strings.EqualFold(source.Importer.Origin, importerOrigin) {
+			continue
+		}
+
+		path := source.Importer.Directory
+		if path != "/" {
+			path = path + "/"
+		}
+		if !strings.HasPrefix(resource, path) {
+			continue
+		}
+
+		pvfs, err := view.Filesystem()
+		if err != nil {
+			continue
+		}
+
+		if _, err := pvfs.GetEntry(resource); err == nil {
+			return true
+		}
+	}
+	return false
+}
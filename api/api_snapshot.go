@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/PlakarKorp/plakar/objects"
+	"github.com/PlakarKorp/plakar/server/audit"
 	"github.com/PlakarKorp/plakar/snapshot"
 	"github.com/PlakarKorp/plakar/snapshot/header"
 	"github.com/PlakarKorp/plakar/snapshot/vfs"
@@ -25,6 +26,7 @@ import (
 
 type downloadSignedUrl struct {
 	snapshotID [32]byte
+	source     int
 	rebase     bool
 	files      []string
 }
@@ -68,7 +70,7 @@ func snapshotReader(w http.ResponseWriter, r *http.Request) error {
 		do_highlight = true
 	}
 
-	snap, err := snapshot.Load(lrepository, snapshotID32)
+	snap, err := SnapshotSourceParam(r, lrepository, snapshotID32)
 	if err != nil {
 		return err
 	}
@@ -239,6 +241,8 @@ func (signer SnapshotReaderURLSigner) VerifyMiddleware(next http.Handler) http.H
 			return
 		}
 
+		auditResource(r)
+		audit.SetIdentity(r, "signed-url")
 		next.ServeHTTP(w, r)
 	})
 }
@@ -249,7 +253,7 @@ func snapshotVFSBrowse(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	snap, err := snapshot.Load(lrepository, snapshotID32)
+	snap, err := SnapshotSourceParam(r, lrepository, snapshotID32)
 	if err != nil {
 		return err
 	}
@@ -296,7 +300,7 @@ func snapshotVFSChildren(w http.ResponseWriter, r *http.Request) error {
 	}
 	_ = sortKeys
 
-	snap, err := snapshot.Load(lrepository, snapshotID32)
+	snap, err := SnapshotSourceParam(r, lrepository, snapshotID32)
 	if err != nil {
 		return err
 	}
@@ -372,7 +376,7 @@ func snapshotVFSSearch(w http.ResponseWriter, r *http.Request) error {
 		limit = int(o)
 	}
 
-	snap, err := snapshot.Load(lrepository, snapshotID32)
+	snap, err := SnapshotSourceParam(r, lrepository, snapshotID32)
 	if err != nil {
 		return err
 	}
@@ -388,6 +392,7 @@ func snapshotVFSSearch(w http.ResponseWriter, r *http.Request) error {
 	searchOpts := snapshot.SearchOpts{
 		Recursive: r.URL.Query().Get("recursive") == "true",
 		Mime:      r.URL.Query().Get("mime"),
+		Class:     r.URL.Query().Get("class"),
 		Prefix:    path,
 
 		Offset: offset,
@@ -443,7 +448,7 @@ func snapshotVFSErrors(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	snap, err := snapshot.Load(lrepository, snapshotID32)
+	snap, err := SnapshotSourceParam(r, lrepository, snapshotID32)
 	if err != nil {
 		return err
 	}
@@ -502,8 +507,9 @@ func snapshotVFSDownloader(w http.ResponseWriter, r *http.Request) error {
 		return parameterError("BODY", InvalidArgument, err)
 	}
 
-	if _, err = snapshot.Load(lrepository, snapshotID32); err != nil {
-		return nil
+	snap, err := SnapshotSourceParam(r, lrepository, snapshotID32)
+	if err != nil {
+		return err
 	}
 
 	for {
@@ -514,6 +520,7 @@ func snapshotVFSDownloader(w http.ResponseWriter, r *http.Request) error {
 
 		url := downloadSignedUrl{
 			snapshotID: snapshotID32,
+			source:     snap.SourceIndex(),
 			rebase:     query.Rebase,
 		}
 
@@ -548,6 +555,11 @@ func snapshotVFSDownloaderSigned(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
+	snap, err = snap.WithSource(link.source)
+	if err != nil {
+		return err
+	}
+
 	name := r.URL.Query().Get("name")
 	if name == "" {
 		name = fmt.Sprintf("snapshot-%x-%s", link.snapshotID[:4], time.Now().Format("2006-01-02-15-04-05"))
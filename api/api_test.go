@@ -2,6 +2,7 @@ package api
 
 import (
 	"bytes"
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -14,6 +15,7 @@ import (
 	"github.com/PlakarKorp/plakar/logging"
 	"github.com/PlakarKorp/plakar/repository"
 	"github.com/PlakarKorp/plakar/resources"
+	"github.com/PlakarKorp/plakar/server/audit"
 	"github.com/PlakarKorp/plakar/storage"
 	ptesting "github.com/PlakarKorp/plakar/testing"
 	"github.com/PlakarKorp/plakar/versioning"
@@ -84,6 +86,15 @@ func TestAuthMiddleware(t *testing.T) {
 	if w3.Code != http.StatusOK {
 		t.Errorf("Expected status code 200, got %d", w3.Code)
 	}
+
+	var accessLog bytes.Buffer
+	w4 := httptest.NewRecorder()
+	audit.Middleware(audit.NewLogger(&accessLog), nil, mux).ServeHTTP(w4, req)
+	var entry audit.Entry
+	require.NoError(t, json.Unmarshal(accessLog.Bytes(), &entry))
+	require.Equal(t, "token", entry.Identity)
+	require.Equal(t, "GET /api/storage/configuration", entry.Route)
+	require.Equal(t, http.StatusOK, entry.Status)
 }
 
 func Test_UnknownEndpoint(t *testing.T) {
@@ -21,6 +21,10 @@ type AppContext struct {
 	secret  []byte           `msgpack:"-"`
 	Config  *config.Config   `msgpack:"-"`
 
+	// StoreConfig is the configuration of the repository plakar runs
+	// on, which may name the provider of its passphrase.
+	StoreConfig map[string]string `msgpack:"-"`
+
 	Stdout io.Writer `msgpack:"-"`
 	Stderr io.Writer `msgpack:"-"`
 
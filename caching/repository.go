@@ -220,6 +220,10 @@ func (c *_RepositoryCache) HasPackfile(packfile objects.MAC) (bool, error) {
 	return c.has("__packfile__", fmt.Sprintf("%x", packfile))
 }
 
+func (c *_RepositoryCache) GetPackfile(packfile objects.MAC) ([]byte, error) {
+	return c.get("__packfile__", fmt.Sprintf("%x", packfile))
+}
+
 func (c *_RepositoryCache) DelPackfile(packfile objects.MAC) error {
 	return c.delete("__packfile__", fmt.Sprintf("%x", packfile))
 }
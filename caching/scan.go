@@ -221,6 +221,10 @@ func (c *ScanCache) HasPackfile(packfile objects.MAC) (bool, error) {
 	return c.has("__packfile__", fmt.Sprintf("%x", packfile))
 }
 
+func (c *ScanCache) GetPackfile(packfile objects.MAC) ([]byte, error) {
+	return c.get("__packfile__", fmt.Sprintf("%x", packfile))
+}
+
 func (c *ScanCache) DelPackfile(packfile objects.MAC) error {
 	return c.delete("__packfile__", fmt.Sprintf("%x", packfile))
 }
@@ -30,6 +30,7 @@ type StateCache interface {
 	PutPackfile(packfile objects.MAC, data []byte) error
 	DelPackfile(packfile objects.MAC) error
 	HasPackfile(packfile objects.MAC) (bool, error)
+	GetPackfile(packfile objects.MAC) ([]byte, error)
 	GetPackfiles() iter.Seq2[objects.MAC, []byte]
 
 	PutConfiguration(key string, data []byte) error
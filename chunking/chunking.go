@@ -1,10 +1,45 @@
 package chunking
 
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	chunkers "github.com/PlakarKorp/go-cdc-chunkers"
+	"github.com/PlakarKorp/go-cdc-chunkers/chunkers/fastcdc"
+	"github.com/PlakarKorp/go-cdc-chunkers/chunkers/jc"
+	"github.com/PlakarKorp/go-cdc-chunkers/chunkers/ultracdc"
+	"github.com/dustin/go-humanize"
+	"github.com/gobwas/glob"
+)
+
 type Configuration struct {
 	Algorithm  string // Content-defined chunking algorithm (e.g., "rolling-hash", "fastcdc")
 	MinSize    uint32 // Minimum chunk size
 	NormalSize uint32 // Expected (average) chunk size
 	MaxSize    uint32 // Maximum chunk size
+
+	// Threshold is the size below which files are stored as a single
+	// chunk, MinSize if unset.
+	Threshold uint32 `msgpack:",omitempty"`
+
+	// Policies override the chunking of the files they match, the
+	// first matching one applies.
+	Policies []Policy `msgpack:",omitempty"`
+}
+
+// Policy is the chunking of the files matching Pattern and ContentType,
+// the unset parameters being those of the repository.
+type Policy struct {
+	Pattern     string // glob matched against the path
+	ContentType string // MIME type, or prefix ending with a slash
+
+	Algorithm  string `msgpack:",omitempty"`
+	MinSize    uint32 `msgpack:",omitempty"`
+	NormalSize uint32 `msgpack:",omitempty"`
+	MaxSize    uint32 `msgpack:",omitempty"`
+	Threshold  uint32 `msgpack:",omitempty"`
 }
 
 func NewDefaultConfiguration() *Configuration {
@@ -15,3 +50,221 @@ func NewDefaultConfiguration() *Configuration {
 		MaxSize:    4 * 1024 * 1024,
 	}
 }
+
+// algorithms are the chunkers available, by name.
+var algorithms = map[string]chunkers.ChunkerImplementation{
+	"FASTCDC":  &fastcdc.FastCDC{},
+	"ULTRACDC": &ultracdc.UltraCDC{},
+	"JC":       &jc.JC{},
+	"FIXED":    &fixed{},
+}
+
+// Algorithms returns the names of the chunkers available.
+func Algorithms() []string {
+	names := make([]string, 0, len(algorithms))
+	for name := range algorithms {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func (c *Configuration) options() *chunkers.ChunkerOpts {
+	// fixed-size chunks only have a normal size
+	if strings.ToUpper(c.Algorithm) == "FIXED" {
+		return &chunkers.ChunkerOpts{
+			MinSize:    int(c.NormalSize),
+			NormalSize: int(c.NormalSize),
+			MaxSize:    int(c.NormalSize),
+		}
+	}
+	return &chunkers.ChunkerOpts{
+		MinSize:    int(c.MinSize),
+		NormalSize: int(c.NormalSize),
+		MaxSize:    int(c.MaxSize),
+	}
+}
+
+// GetThreshold returns the size below which files are not chunked.
+func (c *Configuration) GetThreshold() uint32 {
+	if c.Threshold == 0 {
+		return c.MinSize
+	}
+	return c.Threshold
+}
+
+// Validate checks that the algorithm exists and accepts the chunk
+// sizes, for the configuration and each of its policies.
+func (c *Configuration) Validate() error {
+	impl, ok := algorithms[strings.ToUpper(c.Algorithm)]
+	if !ok {
+		return fmt.Errorf("unknown chunking algorithm: %s", c.Algorithm)
+	}
+	if err := impl.Validate(c.options()); err != nil {
+		return fmt.Errorf("%s: %w", c.Algorithm, err)
+	}
+	// files below the threshold are read whole in memory
+	if c.Threshold > c.MaxSize {
+		return fmt.Errorf("chunking threshold %s is above the maximum chunk size %s",
+			humanize.IBytes(uint64(c.Threshold)), humanize.IBytes(uint64(c.MaxSize)))
+	}
+
+	for i := range c.Policies {
+		if _, err := glob.Compile(c.Policies[i].Pattern); err != nil {
+			return fmt.Errorf("invalid chunking policy pattern: %s", c.Policies[i].Pattern)
+		}
+		if err := c.apply(&c.Policies[i]).Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply returns the configuration of the files matched by policy.
+func (c *Configuration) apply(policy *Policy) *Configuration {
+	applied := *c
+	applied.Policies = nil
+	if policy.Algorithm != "" {
+		applied.Algorithm = policy.Algorithm
+	}
+	if policy.MinSize != 0 {
+		applied.MinSize = policy.MinSize
+	}
+	if policy.NormalSize != 0 {
+		applied.NormalSize = policy.NormalSize
+	}
+	if policy.MaxSize != 0 {
+		applied.MaxSize = policy.MaxSize
+	}
+	if policy.Threshold != 0 {
+		applied.Threshold = policy.Threshold
+	}
+	return &applied
+}
+
+// NewChunker returns a chunker splitting rd as configured.
+func (c *Configuration) NewChunker(rd io.Reader) (*chunkers.Chunker, error) {
+	return chunkers.NewChunker(strings.ToLower(c.Algorithm), rd, c.options())
+}
+
+// Selector finds the chunking configuration of files.
+type Selector struct {
+	config   *Configuration
+	patterns []glob.Glob
+	applied  []*Configuration
+}
+
+func NewSelector(config *Configuration) (*Selector, error) {
+	s := &Selector{config: config}
+	for i := range config.Policies {
+		policy := &config.Policies[i]
+		g, err := glob.Compile(policy.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chunking policy pattern: %s", policy.Pattern)
+		}
+		s.patterns = append(s.patterns, g)
+		s.applied = append(s.applied, config.apply(policy))
+	}
+	return s, nil
+}
+
+// Select returns the configuration for the file at pathname, of the
+// given content type.
+func (s *Selector) Select(pathname, contentType string) *Configuration {
+	for i := range s.config.Policies {
+		policy := &s.config.Policies[i]
+		if policy.Pattern != "" && !s.patterns[i].Match(pathname) {
+			continue
+		}
+		if policy.ContentType != "" && !matchContentType(policy.ContentType, contentType) {
+			continue
+		}
+		return s.applied[i]
+	}
+	return s.config
+}
+
+func matchContentType(pattern, contentType string) bool {
+	// drop the parameters, as in "text/plain; charset=utf-8"
+	contentType, _, _ = strings.Cut(contentType, ";")
+	if strings.HasSuffix(pattern, "/") {
+		return strings.HasPrefix(contentType, pattern)
+	}
+	return contentType == pattern
+}
+
+// ParsePolicy parses a policy given as comma-separated key=value
+// pairs, the keys being path, type, algorithm, min, normal, max and
+// threshold, for example "path=*.qcow2,algorithm=fixed,normal=4MiB".
+func ParsePolicy(spec string) (*Policy, error) {
+	policy := &Policy{}
+	for _, pair := range strings.Split(spec, ",") {
+		key, value, found := strings.Cut(pair, "=")
+		if !found || value == "" {
+			return nil, fmt.Errorf("invalid chunking policy, expected key=value: %s", pair)
+		}
+
+		var size *uint32
+		switch key {
+		case "path":
+			policy.Pattern = value
+		case "type":
+			policy.ContentType = value
+		case "algorithm":
+			policy.Algorithm = strings.ToUpper(value)
+		case "min":
+			size = &policy.MinSize
+		case "normal":
+			size = &policy.NormalSize
+		case "max":
+			size = &policy.MaxSize
+		case "threshold":
+			size = &policy.Threshold
+		default:
+			return nil, fmt.Errorf("invalid chunking policy key: %s", key)
+		}
+
+		if size != nil {
+			n, err := ParseSize(value)
+			if err != nil {
+				return nil, err
+			}
+			*size = n
+		}
+	}
+	return policy, nil
+}
+
+// String returns the policy in the form parsed by ParsePolicy.
+func (p *Policy) String() string {
+	var pairs []string
+	add := func(key, value string) {
+		if value != "" {
+			pairs = append(pairs, key+"="+value)
+		}
+	}
+	size := func(n uint32) string {
+		if n == 0 {
+			return ""
+		}
+		return humanize.IBytes(uint64(n))
+	}
+
+	add("path", p.Pattern)
+	add("type", p.ContentType)
+	add("algorithm", p.Algorithm)
+	add("min", size(p.MinSize))
+	add("normal", size(p.NormalSize))
+	add("max", size(p.MaxSize))
+	add("threshold", size(p.Threshold))
+	return strings.Join(pairs, ",")
+}
+
+// ParseSize parses a chunk size such as "64KiB".
+func ParseSize(value string) (uint32, error) {
+	n, err := humanize.ParseBytes(value)
+	if err != nil || n == 0 || n > 1<<30 {
+		return 0, fmt.Errorf("invalid chunk size: %s", value)
+	}
+	return uint32(n), nil
+}
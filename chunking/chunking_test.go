@@ -1,6 +1,9 @@
 package chunking
 
 import (
+	"bytes"
+	"io"
+	"slices"
 	"testing"
 
 	chunkers "github.com/PlakarKorp/go-cdc-chunkers"
@@ -34,3 +37,125 @@ func TestDefaultConfiguration(t *testing.T) {
 		t.Errorf("DefaultConfiguration MaxSize failed: expected %v, got %v", expected.MaxSize, result.MaxSize)
 	}
 }
+
+func TestValidate(t *testing.T) {
+	config := NewDefaultConfiguration()
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Validate failed on the default configuration: %v", err)
+	}
+
+	config.Algorithm = "RABIN"
+	if err := config.Validate(); err == nil {
+		t.Errorf("Validate accepted an unknown algorithm")
+	}
+
+	config = NewDefaultConfiguration()
+	config.MinSize = config.MaxSize * 2
+	if err := config.Validate(); err == nil {
+		t.Errorf("Validate accepted a minimum size above the maximum size")
+	}
+
+	config = NewDefaultConfiguration()
+	config.Threshold = config.MaxSize + 1
+	if err := config.Validate(); err == nil {
+		t.Errorf("Validate accepted a threshold above the maximum size")
+	}
+
+	config = NewDefaultConfiguration()
+	config.Policies = []Policy{{Pattern: "*.iso", Threshold: 1 << 30}}
+	if err := config.Validate(); err == nil {
+		t.Errorf("Validate accepted a policy threshold above the maximum size")
+	}
+
+	config = NewDefaultConfiguration()
+	config.Policies = []Policy{{Pattern: "[", Algorithm: "FIXED"}}
+	if err := config.Validate(); err == nil {
+		t.Errorf("Validate accepted an invalid policy pattern")
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	policy, err := ParsePolicy("path=**/*.qcow2,type=application/,algorithm=fixed,normal=4MiB,threshold=1MiB")
+	if err != nil {
+		t.Fatalf("ParsePolicy failed: %v", err)
+	}
+	expected := Policy{
+		Pattern:     "**/*.qcow2",
+		ContentType: "application/",
+		Algorithm:   "FIXED",
+		NormalSize:  4 * 1024 * 1024,
+		Threshold:   1024 * 1024,
+	}
+	if *policy != expected {
+		t.Errorf("ParsePolicy failed: expected %+v, got %+v", expected, *policy)
+	}
+
+	reparsed, err := ParsePolicy(policy.String())
+	if err != nil || *reparsed != *policy {
+		t.Errorf("ParsePolicy of %q failed: got %+v, %v", policy.String(), reparsed, err)
+	}
+
+	for _, spec := range []string{"", "path", "color=blue", "min=none", "max=2GiB"} {
+		if _, err := ParsePolicy(spec); err == nil {
+			t.Errorf("ParsePolicy accepted %q", spec)
+		}
+	}
+}
+
+func TestSelector(t *testing.T) {
+	config := NewDefaultConfiguration()
+	config.Policies = []Policy{
+		{Pattern: "/vm/**", Algorithm: "FIXED", NormalSize: 4 * 1024 * 1024},
+		{ContentType: "video/", Threshold: 8 * 1024 * 1024},
+		{Pattern: "**/*.log", ContentType: "text/plain", MaxSize: 1024 * 1024},
+	}
+
+	selector, err := NewSelector(config)
+	if err != nil {
+		t.Fatalf("NewSelector failed: %v", err)
+	}
+
+	if c := selector.Select("/vm/disk.img", "application/octet-stream"); c.Algorithm != "FIXED" || c.NormalSize != 4*1024*1024 {
+		t.Errorf("Select failed for the path policy: got %+v", c)
+	}
+	if c := selector.Select("/home/movie.mp4", "video/mp4"); c.Algorithm != "FASTCDC" || c.GetThreshold() != 8*1024*1024 {
+		t.Errorf("Select failed for the content type policy: got %+v", c)
+	}
+	if c := selector.Select("/var/log/app.log", "text/plain; charset=utf-8"); c.MaxSize != 1024*1024 {
+		t.Errorf("Select failed for the combined policy: got %+v", c)
+	}
+	if c := selector.Select("/var/log/app.log", "application/gzip"); c != config {
+		t.Errorf("Select failed for an unmatched file: got %+v", c)
+	}
+}
+
+func TestFixedChunker(t *testing.T) {
+	config := &Configuration{Algorithm: "FIXED", NormalSize: 1000}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	chk, err := config.NewChunker(bytes.NewReader(make([]byte, 4500)))
+	if err != nil {
+		t.Fatalf("NewChunker failed: %v", err)
+	}
+
+	var sizes []int
+	for {
+		chunk, err := chk.Next()
+		if err != nil && err != io.EOF {
+			t.Fatalf("Next failed: %v", err)
+		}
+		if chunk != nil {
+			sizes = append(sizes, len(chunk))
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+
+	expected := []int{1000, 1000, 1000, 1000, 500}
+	if !slices.Equal(sizes, expected) {
+		t.Errorf("fixed chunker failed: expected %v, got %v", expected, sizes)
+	}
+}
@@ -0,0 +1,38 @@
+package chunking
+
+import (
+	"errors"
+
+	chunkers "github.com/PlakarKorp/go-cdc-chunkers"
+)
+
+func init() {
+	chunkers.Register("fixed", func() chunkers.ChunkerImplementation {
+		return &fixed{}
+	})
+}
+
+var errFixedSize = errors.New("NormalSize is required and must be 64B <= NormalSize <= 1GB")
+
+// fixed cuts chunks of NormalSize bytes, which suits data modified in
+// place, such as VM images, better than content-defined chunking.
+type fixed struct{}
+
+func (c *fixed) DefaultOptions() *chunkers.ChunkerOpts {
+	return &chunkers.ChunkerOpts{
+		MinSize:    1024 * 1024,
+		NormalSize: 1024 * 1024,
+		MaxSize:    1024 * 1024,
+	}
+}
+
+func (c *fixed) Validate(options *chunkers.ChunkerOpts) error {
+	if options.NormalSize < 64 || options.NormalSize > 1024*1024*1024 {
+		return errFixedSize
+	}
+	return nil
+}
+
+func (c *fixed) Algorithm(options *chunkers.ChunkerOpts, data []byte, n int) int {
+	return min(n, options.NormalSize)
+}
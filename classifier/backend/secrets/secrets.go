@@ -0,0 +1,210 @@
+package secrets
+
+import (
+	"bytes"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/PlakarKorp/plakar/classifier"
+	"github.com/PlakarKorp/plakar/snapshot/vfs"
+)
+
+const NAME = "secrets"
+
+const (
+	// bytes of a file scanned at most, secrets sit in small files
+	MAX_SCANNED = 16 << 20
+
+	// bytes kept from a write to match patterns spanning two of them
+	OVERLAP = 512
+
+	// bytes of the first write searched for a NUL to spot binaries
+	BINARY_PROBE = 8000
+)
+
+func init() {
+	classifier.Register(NAME, NewClassifier)
+}
+
+// rule is a pattern classifying the content matching it. When validate
+// is set, a match only counts if it accepts the matched bytes.
+type rule struct {
+	class    string
+	pattern  *regexp.Regexp
+	validate func([]byte) bool
+
+	// configOnly restricts the rule to configuration files
+	configOnly bool
+}
+
+var rules = []rule{
+	{class: "secret/private-key", pattern: regexp.MustCompile(`-----BEGIN (?:RSA |DSA |EC |OPENSSH |ENCRYPTED |PGP )?PRIVATE KEY(?: BLOCK)?-----`)},
+	{class: "secret/aws-access-key", pattern: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{class: "secret/aws-secret-key", pattern: regexp.MustCompile(`(?i)aws_?secret_?access_?key["']?\s*[:=]\s*["']?[A-Za-z0-9/+=]{40}\b`)},
+	{class: "secret/github-token", pattern: regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36}\b`)},
+	{class: "secret/slack-token", pattern: regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}`)},
+	{class: "secret/jwt", pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`)},
+	{class: "secret/password", pattern: regexp.MustCompile(`(?im)^\s*["']?[\w.-]*(?:password|passwd|pwd|secret)["']?\s*[:=]\s*["']?[^\s"'$<{}]{4,}`), configOnly: true},
+
+	{class: "pii/email", pattern: regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`)},
+	{class: "pii/credit-card", pattern: regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`), validate: luhn},
+	{class: "pii/iban", pattern: regexp.MustCompile(`\b[A-Z]{2}\d{2}(?: ?[A-Z0-9]{4}){2,7}(?: ?[A-Z0-9]{1,3})?\b`), validate: iban},
+}
+
+// names of files which are secrets whatever their content
+var keyFiles = []string{"id_rsa", "id_dsa", "id_ecdsa", "id_ed25519"}
+var keyExtensions = []string{".pem", ".key", ".p12", ".pfx", ".jks", ".keystore"}
+var credentialFiles = []string{".env", ".netrc", ".pgpass", ".git-credentials", ".htpasswd", "credentials"}
+
+// extensions of the configuration files passwords are looked for in
+var configExtensions = []string{".env", ".ini", ".conf", ".cfg", ".cnf", ".properties", ".toml", ".yaml", ".yml", ".json", ".xml"}
+
+type Classifier struct {
+}
+
+type Processor struct {
+	classifier classifier.Backend
+	pathname   string
+	config     bool
+
+	scanned int
+	tail    []byte
+	found   map[string]struct{}
+}
+
+func NewClassifier() classifier.Backend {
+	return &Classifier{}
+}
+
+func (c *Classifier) Processor(backend classifier.Backend, pathname string) classifier.ProcessorBackend {
+	return &Processor{
+		classifier: backend,
+		pathname:   pathname,
+		config:     isConfig(pathname),
+		found:      make(map[string]struct{}),
+	}
+}
+
+func (c *Classifier) Close() error {
+	return nil
+}
+
+func isConfig(pathname string) bool {
+	name := path.Base(pathname)
+	if slices.Contains(credentialFiles, name) || strings.HasPrefix(name, ".env.") {
+		return true
+	}
+	return slices.Contains(configExtensions, strings.ToLower(path.Ext(name)))
+}
+
+func (p *Processor) Name() string {
+	return NAME
+}
+
+func (p *Processor) Directory(dirEntry *vfs.Entry) []string {
+	return []string{}
+}
+
+func (p *Processor) File(fileEntry *vfs.Entry) []string {
+	name := path.Base(p.pathname)
+	switch {
+	case slices.Contains(keyFiles, name), slices.Contains(keyExtensions, strings.ToLower(path.Ext(name))):
+		return []string{"secret/key-file"}
+	case slices.Contains(credentialFiles, name), strings.HasPrefix(name, ".env."):
+		return []string{"secret/credentials-file"}
+	}
+	return []string{}
+}
+
+func (p *Processor) Write(data []byte) bool {
+	if p.scanned == 0 && bytes.IndexByte(data[:min(len(data), BINARY_PROBE)], 0) != -1 {
+		return false
+	}
+	if remaining := MAX_SCANNED - p.scanned; len(data) > remaining {
+		data = data[:remaining]
+	}
+	p.scanned += len(data)
+
+	// matches ending in the tail were found with the previous write
+	buf := append(p.tail, data...)
+	for _, rule := range rules {
+		if _, done := p.found[rule.class]; done || (rule.configOnly && !p.config) {
+			continue
+		}
+		for _, loc := range rule.pattern.FindAllIndex(buf, -1) {
+			if loc[1] <= len(p.tail) {
+				continue
+			}
+			if rule.validate == nil || rule.validate(buf[loc[0]:loc[1]]) {
+				p.found[rule.class] = struct{}{}
+				break
+			}
+		}
+	}
+	p.tail = append(p.tail[:0], buf[max(0, len(buf)-OVERLAP):]...)
+
+	return p.scanned < MAX_SCANNED && len(p.found) < len(rules)
+}
+
+func (p *Processor) Finalize() []string {
+	classes := make([]string, 0, len(p.found))
+	for class := range p.found {
+		classes = append(classes, class)
+	}
+	slices.Sort(classes)
+	return classes
+}
+
+func digits(match []byte) []int {
+	ret := make([]int, 0, len(match))
+	for _, c := range match {
+		if c >= '0' && c <= '9' {
+			ret = append(ret, int(c-'0'))
+		}
+	}
+	return ret
+}
+
+// luhn checks the digits of a payment card number.
+func luhn(match []byte) bool {
+	d := digits(match)
+	if len(d) < 13 || len(d) > 19 {
+		return false
+	}
+
+	sum := 0
+	for i := range d {
+		n := d[len(d)-1-i]
+		if i%2 == 1 {
+			if n *= 2; n > 9 {
+				n -= 9
+			}
+		}
+		sum += n
+	}
+	return sum%10 == 0
+}
+
+// iban checks the mod-97 checksum of an account number.
+func iban(match []byte) bool {
+	account := bytes.ReplaceAll(match, []byte(" "), nil)
+	if len(account) < 15 || len(account) > 34 {
+		return false
+	}
+	account = append(account[4:], account[:4]...)
+
+	remainder := 0
+	for _, c := range account {
+		switch {
+		case c >= '0' && c <= '9':
+			remainder = (remainder*10 + int(c-'0')) % 97
+		case c >= 'A' && c <= 'Z':
+			remainder = (remainder*100 + int(c-'A') + 10) % 97
+		default:
+			return false
+		}
+	}
+	return remainder == 1
+}
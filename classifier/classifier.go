@@ -19,6 +19,7 @@ package classifier
 import (
 	"fmt"
 	"log"
+	"slices"
 	"sync"
 
 	"github.com/PlakarKorp/plakar/appcontext"
@@ -53,14 +54,20 @@ type Classifier struct {
 	appcontext *appcontext.AppContext
 }
 
-func NewClassifier(ctx *appcontext.AppContext) (*Classifier, error) {
+// NewClassifier returns a classifier running the registered backends
+// named by analyzers, none if it is empty.
+func NewClassifier(ctx *appcontext.AppContext, analyzers ...string) (*Classifier, error) {
 	muBackends.Lock()
 	defer muBackends.Unlock()
 
 	cf := &Classifier{}
 	cf.appcontext = ctx
 
-	for name, backend := range backends {
+	for _, name := range analyzers {
+		backend, ok := backends[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown analyzer '%s'", name)
+		}
 		if inst := backend(); inst == nil {
 			return nil, fmt.Errorf("backend '%s' failed to initialize", name)
 		} else {
@@ -97,6 +104,7 @@ func (cf *Classifier) Processor(pathname string) *Processor {
 		cf:        cf,
 		backends:  backends,
 		writeDone: make(map[string]struct{}),
+		Pathname:  pathname,
 	}
 }
 
@@ -141,7 +149,7 @@ func (p *Processor) Directory(dirEntry *vfs.Entry) []Classification {
 func (p *Processor) Write(buf []byte) {
 	for _, backend := range p.backends {
 		// if this backend has already returned false, don't call it again
-		if _, done := p.writeDone[backend.Name()]; done {
+		if _, done := p.writeDone[backend.Name()]; !done {
 			ok := backend.Write(buf)
 			if !ok {
 				// if the backend returns false, don't call it again
@@ -165,3 +173,24 @@ func (p *Processor) Finalize() []Classification {
 	}
 	return ret
 }
+
+// Classify returns the classes of fileEntry along with those found in
+// the content written to the processor, merged per analyzer.
+func (p *Processor) Classify(fileEntry *vfs.Entry) []Classification {
+	ret := p.File(fileEntry)
+	for _, result := range p.Finalize() {
+		i := slices.IndexFunc(ret, func(c Classification) bool {
+			return c.Analyzer == result.Analyzer
+		})
+		if i == -1 {
+			ret = append(ret, result)
+			continue
+		}
+		for _, class := range result.Classes {
+			if !slices.Contains(ret[i].Classes, class) {
+				ret[i].Classes = append(ret[i].Classes, class)
+			}
+		}
+	}
+	return ret
+}
@@ -19,9 +19,9 @@ import (
 	"github.com/PlakarKorp/plakar/cmd/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/cmd/plakar/utils"
 	"github.com/PlakarKorp/plakar/config"
-	"github.com/PlakarKorp/plakar/encryption"
 	"github.com/PlakarKorp/plakar/logging"
 	"github.com/PlakarKorp/plakar/repository"
+	"github.com/PlakarKorp/plakar/secrets"
 	"github.com/PlakarKorp/plakar/storage"
 	"github.com/PlakarKorp/plakar/versioning"
 	"github.com/denisbrodbeck/machineid"
@@ -39,11 +39,13 @@ import (
 	_ "github.com/PlakarKorp/plakar/snapshot/importer/s3"
 	_ "github.com/PlakarKorp/plakar/snapshot/importer/sftp"
 
+	_ "github.com/PlakarKorp/plakar/snapshot/exporter/archive"
 	_ "github.com/PlakarKorp/plakar/snapshot/exporter/fs"
 	_ "github.com/PlakarKorp/plakar/snapshot/exporter/s3"
 	_ "github.com/PlakarKorp/plakar/snapshot/exporter/sftp"
 
 	_ "github.com/PlakarKorp/plakar/classifier/backend/noop"
+	_ "github.com/PlakarKorp/plakar/classifier/backend/secrets"
 )
 
 func main() {
@@ -289,6 +291,8 @@ func entryPoint() int {
 		}
 	}
 
+	ctx.StoreConfig = storeConfig
+
 	// create is a special case, it operates without a repository...
 	// but needs a repository location to store the new repository
 	if command == "create" || command == "server" {
@@ -332,6 +336,14 @@ func entryPoint() int {
 		skipPassphrase = true
 	}
 
+	// special case, rekey runs without the agent and doesn't rebuild the
+	// state as it may be halfway encrypted with a new key
+	skipRebuild := command == "server"
+	if command == "rekey" {
+		opt_agentless = true
+		skipRebuild = true
+	}
+
 	store, serializedConfig, err := storage.Open(storeConfig)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s: failed to open the repository at %s: %s\n", flag.CommandLine.Name(), storeConfig["location"], err)
@@ -354,55 +366,11 @@ func entryPoint() int {
 	var secret []byte
 	if !skipPassphrase {
 		if repoConfig.Encryption != nil {
-			derived := false
-			envPassphrase := os.Getenv("PLAKAR_PASSPHRASE")
-			if ctx.KeyFromFile == "" {
-				if passphrase, ok := storeConfig["passphrase"]; ok {
-					key, err := encryption.DeriveKey(repoConfig.Encryption.KDFParams, []byte(passphrase))
-					if err == nil {
-						if encryption.VerifyCanary(repoConfig.Encryption, key) {
-							secret = key
-							derived = true
-						}
-					}
-				} else {
-					for attempts := 0; attempts < 3; attempts++ {
-						var passphrase []byte
-						if envPassphrase == "" {
-							passphrase, err = utils.GetPassphrase("repository")
-							if err != nil {
-								break
-							}
-						} else {
-							passphrase = []byte(envPassphrase)
-						}
-
-						key, err := encryption.DeriveKey(repoConfig.Encryption.KDFParams, passphrase)
-						if err != nil {
-							continue
-						}
-						if !encryption.VerifyCanary(repoConfig.Encryption, key) {
-							if envPassphrase != "" {
-								break
-							}
-							continue
-						}
-						secret = key
-						derived = true
-						break
-					}
-				}
-			} else {
-				key, err := encryption.DeriveKey(repoConfig.Encryption.KDFParams, []byte(ctx.KeyFromFile))
-				if err == nil {
-					if encryption.VerifyCanary(repoConfig.Encryption, key) {
-						secret = key
-						derived = true
-					}
-				}
-			}
-			if !derived {
-				fmt.Fprintf(os.Stderr, "%s: could not derive secret\n", flag.CommandLine.Name())
+			secret, err = secrets.Unlock(ctx, storeConfig, repoConfig.Encryption, func() ([]byte, error) {
+				return utils.GetPassphrase("repository")
+			})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: could not derive secret: %s\n", flag.CommandLine.Name(), err)
 				os.Exit(1)
 			}
 			ctx.SetSecret(secret)
@@ -410,7 +378,7 @@ func entryPoint() int {
 	}
 
 	var repo *repository.Repository
-	if opt_agentless && command != "server" {
+	if opt_agentless && !skipRebuild {
 		repo, err = repository.New(ctx, store, serializedConfig)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "%s: %s\n", flag.CommandLine.Name(), err)
@@ -20,14 +20,18 @@ import (
 	"bufio"
 	"flag"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/classifier/backend/secrets"
 	"github.com/PlakarKorp/plakar/cmd/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/repository"
 	"github.com/PlakarKorp/plakar/snapshot"
+	"github.com/PlakarKorp/plakar/snapshot/header"
 	"github.com/PlakarKorp/plakar/snapshot/importer"
 	"github.com/dustin/go-humanize"
 	"github.com/gobwas/glob"
@@ -37,47 +41,129 @@ func init() {
 	subcommands.Register("backup", parse_cmd_backup)
 }
 
-type excludeFlags []string
+type listFlags []string
 
-func (e *excludeFlags) String() string {
-	return strings.Join(*e, ",")
+func (l *listFlags) String() string {
+	return strings.Join(*l, ",")
 }
 
-func (e *excludeFlags) Set(value string) error {
-	*e = append(*e, value)
+func (l *listFlags) Set(value string) error {
+	*l = append(*l, value)
 	return nil
 }
 
+// parseMeta turns key=value pairs into snapshot context entries.
+func parseMeta(pairs []string) ([]header.KeyValue, error) {
+	meta := make([]header.KeyValue, 0, len(pairs))
+	for _, pair := range pairs {
+		key, value, found := strings.Cut(pair, "=")
+		if !found || key == "" {
+			return nil, fmt.Errorf("invalid metadata, expected key=value: %s", pair)
+		}
+		meta = append(meta, header.KeyValue{Key: key, Value: value})
+	}
+	return meta, nil
+}
+
 func parse_cmd_backup(ctx *appcontext.AppContext, repo *repository.Repository, args []string) (subcommands.Subcommand, error) {
-	var opt_tags string
+	var opt_name string
+	var opt_category string
+	var opt_environment string
+	var opt_perimeter string
+	var opt_tags listFlags
+	var opt_meta listFlags
 	var opt_excludes string
-	var opt_exclude excludeFlags
+	var opt_exclude listFlags
 	var opt_concurrency uint64
 	var opt_quiet bool
 	var opt_silent bool
 	var opt_check bool
+	var opt_noignore bool
+	var opt_excludecaches bool
+	var opt_onefilesystem bool
+	var opt_excludefstypes string
+	var opt_maxfilesize string
+	var opt_skipspecial bool
+	var opt_followsymlinks bool
+	var opt_changedetection string
+	var opt_forcerehash bool
+	var opt_scansecrets bool
+	var opt_prehook string
+	var opt_posthook string
+	var opt_failurehook string
+	var opt_hooktimeout time.Duration
+	var opt_maxerrors int64
+	var opt_maxerrorratio float64
 	// var opt_stdio bool
 
 	excludes := []string{}
 
 	flags := flag.NewFlagSet("backup", flag.ExitOnError)
 	flags.Usage = func() {
-		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS] path\n", flags.Name())
-		fmt.Fprintf(flags.Output(), "       %s [OPTIONS] s3://path\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS] path...\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "       %s [OPTIONS] s3://path...\n", flags.Name())
 		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
 		flags.PrintDefaults()
 	}
 
 	flags.Uint64Var(&opt_concurrency, "concurrency", uint64(ctx.MaxConcurrency), "maximum number of parallel tasks")
-	flags.StringVar(&opt_tags, "tag", "", "tag to assign to this snapshot")
+	flags.StringVar(&opt_name, "name", "default", "name of this snapshot")
+	flags.StringVar(&opt_category, "category", "", "category of this snapshot")
+	flags.StringVar(&opt_environment, "environment", "", "environment of this snapshot")
+	flags.StringVar(&opt_perimeter, "perimeter", "", "perimeter of this snapshot")
+	flags.Var(&opt_tags, "tag", "tag to assign to this snapshot, can be specified multiple times")
+	flags.Var(&opt_meta, "meta", "key=value metadata to record in this snapshot, can be specified multiple times")
 	flags.StringVar(&opt_excludes, "excludes", "", "path to a file containing newline-separated regex patterns, treated as -exclude")
 	flags.Var(&opt_exclude, "exclude", "glob pattern to exclude files, can be specified multiple times to add several exclusion patterns")
+	flags.BoolVar(&opt_noignore, "no-ignore", false, "do not honor the "+importer.IgnoreFile+" files found in scanned directories")
+	flags.BoolVar(&opt_excludecaches, "exclude-caches", false, "exclude the directories holding a valid CACHEDIR.TAG file")
+	flags.BoolVar(&opt_onefilesystem, "one-file-system", false, "do not cross filesystem boundaries")
+	flags.StringVar(&opt_excludefstypes, "exclude-fstypes", "", "comma-separated list of filesystem types not to descend into (e.g. proc,sysfs,tmpfs)")
+	flags.StringVar(&opt_maxfilesize, "max-file-size", "", "skip files larger than this size (e.g. 10MB)")
+	flags.BoolVar(&opt_skipspecial, "skip-special", false, "skip devices, sockets and named pipes")
+	flags.BoolVar(&opt_followsymlinks, "follow-symlinks", false, "back up the targets of symlinks rather than the symlinks")
+	flags.StringVar(&opt_changedetection, "change-detection", string(snapshot.ChangeDetectionInode), "how to detect unchanged files: mtime, ctime, inode or content")
+	flags.BoolVar(&opt_forcerehash, "force-rehash", false, "read all files again, as -change-detection content")
+	flags.BoolVar(&opt_scansecrets, "scan-secrets", false, "scan the files read for secrets and personal data")
+	flags.StringVar(&opt_prehook, "pre", "", "command to run before the backup")
+	flags.StringVar(&opt_posthook, "post", "", "command to run once the data is backed up, before the snapshot is committed")
+	flags.StringVar(&opt_failurehook, "on-failure", "", "command to run if the backup fails")
+	flags.DurationVar(&opt_hooktimeout, "hook-timeout", DefaultHookTimeout, "time after which a hook is killed, 0 for none")
+	flags.Int64Var(&opt_maxerrors, "max-errors", -1, "number of errors past which the snapshot is failed, -1 for no limit")
+	flags.Float64Var(&opt_maxerrorratio, "max-error-ratio", 0, "ratio of the paths which may be errors before the snapshot is failed, 0 for no limit")
 	flags.BoolVar(&opt_quiet, "quiet", false, "suppress output")
 	flags.BoolVar(&opt_silent, "silent", false, "suppress ALL output")
 	flags.BoolVar(&opt_check, "check", false, "check the snapshot after creating it")
 	//flags.BoolVar(&opt_stdio, "stdio", false, "output one line per file to stdout instead of the default interactive output")
 	flags.Parse(args)
 
+	meta, err := parseMeta(opt_meta)
+	if err != nil {
+		return nil, err
+	}
+
+	changeDetection, err := snapshot.ParseChangeDetection(opt_changedetection)
+	if err != nil {
+		return nil, err
+	}
+
+	var errorBudget *snapshot.ErrorBudget
+	if opt_maxerrorratio < 0 || opt_maxerrorratio > 1 {
+		return nil, fmt.Errorf("invalid max error ratio: %g", opt_maxerrorratio)
+	}
+	if opt_maxerrors >= 0 || opt_maxerrorratio > 0 {
+		errorBudget = &snapshot.ErrorBudget{
+			MaxErrors: opt_maxerrors,
+			MaxRatio:  opt_maxerrorratio,
+		}
+	}
+
+	if opt_maxfilesize != "" {
+		if _, err := humanize.ParseBytes(opt_maxfilesize); err != nil {
+			return nil, fmt.Errorf("invalid max file size: %s", opt_maxfilesize)
+		}
+	}
+
 	for _, item := range opt_exclude {
 		if _, err := glob.Compile(item); err != nil {
 			return nil, fmt.Errorf("failed to compile exclude pattern: %s", item)
@@ -110,10 +196,30 @@ func parse_cmd_backup(ctx *appcontext.AppContext, repo *repository.Repository, a
 		RepositoryLocation: repo.Location(),
 		RepositorySecret:   ctx.GetSecret(),
 		Concurrency:        opt_concurrency,
+		OptName:            opt_name,
+		OptCategory:        opt_category,
+		OptEnvironment:     opt_environment,
+		OptPerimeter:       opt_perimeter,
 		Tags:               opt_tags,
+		Meta:               meta,
 		Excludes:           excludes,
+		NoIgnore:           opt_noignore,
+		ExcludeCaches:      opt_excludecaches,
+		OneFileSystem:      opt_onefilesystem,
+		ExcludeFSTypes:     opt_excludefstypes,
+		MaxFileSize:        opt_maxfilesize,
+		SkipSpecial:        opt_skipspecial,
+		FollowSymlinks:     opt_followsymlinks,
+		ChangeDetection:    changeDetection,
+		ForceRehash:        opt_forcerehash,
+		ScanSecrets:        opt_scansecrets,
+		PreHook:            opt_prehook,
+		PostHook:           opt_posthook,
+		FailureHook:        opt_failurehook,
+		HookTimeout:        opt_hooktimeout,
+		ErrorBudget:        errorBudget,
 		Quiet:              opt_quiet,
-		Path:               flags.Arg(0),
+		Paths:              flags.Args(),
 		OptCheck:           opt_check,
 	}, nil
 }
@@ -124,12 +230,39 @@ type Backup struct {
 	Job                string
 
 	Concurrency uint64
-	Tags        string
-	Excludes    []string
-	Silent      bool
-	Quiet       bool
-	Path        string
-	OptCheck    bool
+
+	OptName        string
+	OptCategory    string
+	OptEnvironment string
+	OptPerimeter   string
+	Tags           []string
+	Meta           []header.KeyValue
+
+	Excludes      []string
+	NoIgnore      bool
+	ExcludeCaches bool
+
+	OneFileSystem  bool
+	ExcludeFSTypes string
+	MaxFileSize    string
+	SkipSpecial    bool
+	FollowSymlinks bool
+
+	ChangeDetection snapshot.ChangeDetection
+	ForceRehash     bool
+	ScanSecrets     bool
+
+	PreHook     string
+	PostHook    string
+	FailureHook string
+	HookTimeout time.Duration
+
+	ErrorBudget *snapshot.ErrorBudget
+
+	Silent   bool
+	Quiet    bool
+	Paths    []string
+	OptCheck bool
 }
 
 func (cmd *Backup) Name() string {
@@ -148,13 +281,6 @@ func (cmd *Backup) Execute(ctx *appcontext.AppContext, repo *repository.Reposito
 		snap.Header.Job = cmd.Job
 	}
 
-	var tags []string
-	if cmd.Tags == "" {
-		tags = []string{}
-	} else {
-		tags = []string{cmd.Tags}
-	}
-
 	excludes := []glob.Glob{}
 	for _, item := range cmd.Excludes {
 		g, err := glob.Compile(item)
@@ -166,52 +292,111 @@ func (cmd *Backup) Execute(ctx *appcontext.AppContext, repo *repository.Reposito
 
 	opts := &snapshot.BackupOptions{
 		MaxConcurrency: cmd.Concurrency,
-		Name:           "default",
-		Tags:           tags,
+		Name:           cmd.OptName,
+		Category:       cmd.OptCategory,
+		Environment:    cmd.OptEnvironment,
+		Perimeter:      cmd.OptPerimeter,
+		Tags:           cmd.Tags,
+		Context:        cmd.Meta,
 		Excludes:       excludes,
+
+		ChangeDetection: cmd.ChangeDetection,
+		ForceRehash:     cmd.ForceRehash,
+		ErrorBudget:     cmd.ErrorBudget,
+	}
+	if cmd.ScanSecrets {
+		opts.Analyzers = append(opts.Analyzers, secrets.NAME)
 	}
 
-	scanDir := ctx.CWD
-	if cmd.Path != "" {
-		scanDir = cmd.Path
+	env := &hookEnv{
+		repository: repo.Location(),
+		snapshotID: snap.Header.Identifier,
+		status:     "running",
 	}
 
-	importerConfig := map[string]string{
-		"location": scanDir,
+	// once -pre succeeded, -post runs whatever the outcome of the
+	// backup, so that it can release what -pre acquired
+	var started, postRan bool
+	var postErr error
+	runPost := func() (string, error) {
+		postRan = true
+		return runHook(cmd.PostHook, env.environ(HookPostBackup), cmd.HookTimeout)
 	}
-	if strings.HasPrefix(scanDir, "@") {
-		remote, ok := ctx.Config.GetRemote(scanDir[1:])
-		if !ok {
-			return 1, fmt.Errorf("could not resolve importer: %s", scanDir)
+
+	fail := func(err error) (int, error) {
+		env.status = "failure"
+		env.err = err
+		if cmd.PostHook != "" && started && !postRan {
+			output, err := runPost()
+			if output != "" {
+				ctx.GetLogger().Info("%s: %s", HookPostBackup, output)
+			}
+			if err != nil {
+				ctx.GetLogger().Error("%s hook failed: %s", HookPostBackup, err)
+			}
 		}
-		if _, ok := remote["location"]; !ok {
-			return 1, fmt.Errorf("could not resolve importer location: %s", scanDir)
-		} else {
-			importerConfig = remote
+		if cmd.FailureHook != "" {
+			output, err := runHook(cmd.FailureHook, env.environ(HookOnFailure), cmd.HookTimeout)
+			if output != "" {
+				ctx.GetLogger().Info("%s: %s", HookOnFailure, output)
+			}
+			if err != nil {
+				ctx.GetLogger().Error("%s hook failed: %s", HookOnFailure, err)
+			}
 		}
+		return 1, err
 	}
 
-	imp, err := importer.NewImporter(importerConfig)
-	if err != nil {
-		if !filepath.IsAbs(scanDir) {
-			scanDir = filepath.Join(ctx.CWD, scanDir)
+	if cmd.PreHook != "" {
+		output, err := runHook(cmd.PreHook, env.environ(HookPreBackup), cmd.HookTimeout)
+		recordHook(snap.Header, HookPreBackup, output, err)
+		if err != nil {
+			return fail(fmt.Errorf("%s hook failed: %w", HookPreBackup, err))
+		}
+	}
+	started = true
+
+	if cmd.PostHook != "" {
+		opts.PostBackup = func(hdr *header.Header) {
+			env.status = "success"
+			if hdr.GetStatus() == header.StatusFailed {
+				env.status = "failure"
+			}
+			env.hdr = hdr
+			var output string
+			output, postErr = runPost()
+			recordHook(hdr, HookPostBackup, output, postErr)
+		}
+	}
+
+	paths := cmd.Paths
+	if len(paths) == 0 {
+		paths = []string{ctx.CWD}
+	}
+
+	imps := make([]importer.Importer, 0, len(paths))
+	defer func() {
+		for _, imp := range imps {
+			imp.Close()
 		}
-		imp, err = importer.NewImporter(map[string]string{"location": "fs://" + scanDir})
+	}()
+	for _, scanDir := range paths {
+		imp, err := newImporter(ctx, scanDir, cmd.importerOptions())
 		if err != nil {
-			return 1, fmt.Errorf("failed to create an importer for %s: %s", scanDir, err)
+			return fail(err)
 		}
+		imps = append(imps, imp)
 	}
-	defer imp.Close()
 
 	if cmd.Silent {
-		if err := snap.Backup(imp, opts); err != nil {
-			return 1, fmt.Errorf("failed to create snapshot: %w", err)
+		if err := snap.BackupSources(imps, opts); err != nil {
+			return fail(fmt.Errorf("failed to create snapshot: %w", err))
 		}
 	} else {
-		ep := startEventsProcessor(ctx, imp.Root(), true, cmd.Quiet)
-		if err := snap.Backup(imp, opts); err != nil {
+		ep := startEventsProcessor(ctx, imps[0].Root(), true, cmd.Quiet)
+		if err := snap.BackupSources(imps, opts); err != nil {
 			ep.Close()
-			return 1, fmt.Errorf("failed to create snapshot: %w", err)
+			return fail(fmt.Errorf("failed to create snapshot: %w", err))
 		}
 		ep.Close()
 	}
@@ -226,16 +411,22 @@ func (cmd *Backup) Execute(ctx *appcontext.AppContext, repo *repository.Reposito
 
 		checkSnap, err := snapshot.Load(repo, snap.Header.Identifier)
 		if err != nil {
-			return 1, fmt.Errorf("failed to load snapshot: %w", err)
+			return fail(fmt.Errorf("failed to load snapshot: %w", err))
 		}
 		defer checkSnap.Close()
 
-		ok, err := checkSnap.Check("/", checkOptions)
-		if err != nil {
-			return 1, fmt.Errorf("failed to check snapshot: %w", err)
-		}
-		if !ok {
-			return 1, fmt.Errorf("snapshot is not valid")
+		for i := range checkSnap.Header.Sources {
+			source, err := checkSnap.WithSource(i)
+			if err != nil {
+				return fail(err)
+			}
+			ok, err := source.Check("/", checkOptions)
+			if err != nil {
+				return fail(fmt.Errorf("failed to check snapshot: %w", err))
+			}
+			if !ok {
+				return fail(fmt.Errorf("snapshot is not valid"))
+			}
 		}
 	}
 
@@ -243,7 +434,80 @@ func (cmd *Backup) Execute(ctx *appcontext.AppContext, repo *repository.Reposito
 		cmd.Name(),
 		"unsigned",
 		snap.Header.GetIndexShortID(),
-		humanize.Bytes(snap.Header.GetSource(0).Summary.Directory.Size+snap.Header.GetSource(0).Summary.Below.Size),
+		humanize.Bytes(snap.Header.GetSize()),
 		snap.Header.Duration)
+
+	switch snap.Header.GetStatus() {
+	case header.StatusFailed:
+		return fail(fmt.Errorf("snapshot %x failed with %d errors",
+			snap.Header.GetIndexShortID(), snap.Header.GetErrors()))
+	case header.StatusPartial:
+		ctx.GetLogger().Warn("%s: snapshot %x is partial with %d errors",
+			cmd.Name(), snap.Header.GetIndexShortID(), snap.Header.GetErrors())
+	}
+
+	// the snapshot is committed, but it may not be consistent
+	if postErr != nil {
+		return fail(fmt.Errorf("%s hook failed: %w", HookPostBackup, postErr))
+	}
 	return 0, nil
 }
+
+// importerOptions returns the importer configuration implied by the
+// command line, a remote configuration taking precedence over it.
+func (cmd *Backup) importerOptions() map[string]string {
+	options := make(map[string]string)
+	if cmd.NoIgnore {
+		options["ignore_file"] = ""
+	}
+	if cmd.ExcludeCaches {
+		options["exclude_caches"] = "true"
+	}
+	if cmd.OneFileSystem {
+		options["one_file_system"] = "true"
+	}
+	if cmd.ExcludeFSTypes != "" {
+		options["exclude_fstypes"] = cmd.ExcludeFSTypes
+	}
+	if cmd.MaxFileSize != "" {
+		options["max_file_size"] = cmd.MaxFileSize
+	}
+	if cmd.SkipSpecial {
+		options["skip_special"] = "true"
+	}
+	if cmd.FollowSymlinks {
+		options["follow_symlinks"] = "true"
+	}
+	return options
+}
+
+func newImporter(ctx *appcontext.AppContext, scanDir string, options map[string]string) (importer.Importer, error) {
+	importerConfig := maps.Clone(options)
+	importerConfig["location"] = scanDir
+	if strings.HasPrefix(scanDir, "@") {
+		remote, ok := ctx.Config.GetRemote(scanDir[1:])
+		if !ok {
+			return nil, fmt.Errorf("could not resolve importer: %s", scanDir)
+		}
+		if _, ok := remote["location"]; !ok {
+			return nil, fmt.Errorf("could not resolve importer location: %s", scanDir)
+		} else {
+			importerConfig = maps.Clone(options)
+			maps.Copy(importerConfig, remote)
+		}
+	}
+
+	imp, err := importer.NewImporter(importerConfig)
+	if err != nil {
+		if !filepath.IsAbs(scanDir) {
+			scanDir = filepath.Join(ctx.CWD, scanDir)
+		}
+		importerConfig = maps.Clone(options)
+		importerConfig["location"] = "fs://" + scanDir
+		imp, err = importer.NewImporter(importerConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create an importer for %s: %s", scanDir, err)
+		}
+	}
+	return imp, nil
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
 
@@ -14,6 +16,7 @@ import (
 	"github.com/PlakarKorp/plakar/logging"
 	"github.com/PlakarKorp/plakar/repository"
 	"github.com/PlakarKorp/plakar/resources"
+	"github.com/PlakarKorp/plakar/snapshot"
 	_ "github.com/PlakarKorp/plakar/snapshot/importer/fs"
 	"github.com/PlakarKorp/plakar/storage"
 	bfs "github.com/PlakarKorp/plakar/storage/backends/fs"
@@ -169,3 +172,213 @@ func TestExecuteCmdCreateDefaultWithExcludes(t *testing.T) {
 	lastline := lines[len(lines)-1]
 	require.Contains(t, lastline, "created unsigned snapshot")
 }
+
+func TestExecuteCmdCreateWithMetadata(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, tmpBackupDir := generateFixtures(t, bufOut, bufErr)
+
+	ctx := repo.AppContext()
+	ctx.MaxConcurrency = 1
+	// override the homedir to avoid having test overwriting existing home configuration
+	ctx.HomeDir = repo.Location()
+	args := []string{"-name", "www", "-category", "web", "-environment", "production",
+		"-perimeter", "eu", "-tag", "daily", "-tag", "keep", "-meta", "owner=web-team", tmpBackupDir}
+
+	subcommand, err := parse_cmd_backup(ctx, repo, args)
+	require.NoError(t, err)
+	require.NotNil(t, subcommand)
+
+	status, err := subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	require.NoError(t, repo.RebuildState())
+	snapshotIDs, err := repo.GetSnapshots()
+	require.NoError(t, err)
+	require.Len(t, snapshotIDs, 1)
+
+	snap, err := snapshot.Load(repo, snapshotIDs[0])
+	require.NoError(t, err)
+	require.Equal(t, "www", snap.Header.Name)
+	require.Equal(t, "web", snap.Header.Category)
+	require.Equal(t, "production", snap.Header.Environment)
+	require.Equal(t, "eu", snap.Header.Perimeter)
+	require.Equal(t, []string{"daily", "keep"}, snap.Header.Tags)
+	require.Equal(t, "web-team", snap.Header.GetContext("owner"))
+}
+
+func TestParseCmdBackupInvalidMeta(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, tmpBackupDir := generateFixtures(t, bufOut, bufErr)
+
+	_, err := parse_cmd_backup(repo.AppContext(), repo, []string{"-meta", "owner", tmpBackupDir})
+	require.Error(t, err)
+}
+
+func TestParseCmdBackupImporterOptions(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, tmpBackupDir := generateFixtures(t, bufOut, bufErr)
+
+	_, err := parse_cmd_backup(repo.AppContext(), repo, []string{"-max-file-size", "huge", tmpBackupDir})
+	require.Error(t, err)
+
+	subcommand, err := parse_cmd_backup(repo.AppContext(), repo, []string{"-one-file-system", "-skip-special", "-max-file-size", "1MB", "-exclude-fstypes", "proc,sysfs", tmpBackupDir})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"one_file_system": "true",
+		"skip_special":    "true",
+		"max_file_size":   "1MB",
+		"exclude_fstypes": "proc,sysfs",
+	}, subcommand.(*Backup).importerOptions())
+}
+
+func TestParseCmdBackupChangeDetection(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, tmpBackupDir := generateFixtures(t, bufOut, bufErr)
+
+	_, err := parse_cmd_backup(repo.AppContext(), repo, []string{"-change-detection", "atime", tmpBackupDir})
+	require.Error(t, err)
+
+	subcommand, err := parse_cmd_backup(repo.AppContext(), repo, []string{"-change-detection", "ctime", "-force-rehash", tmpBackupDir})
+	require.NoError(t, err)
+	require.Equal(t, snapshot.ChangeDetectionCtime, subcommand.(*Backup).ChangeDetection)
+	require.True(t, subcommand.(*Backup).ForceRehash)
+}
+
+func TestExecuteCmdCreateWithHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks are run by cmd on windows")
+	}
+
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, tmpBackupDir := generateFixtures(t, bufOut, bufErr)
+
+	ctx := repo.AppContext()
+	ctx.MaxConcurrency = 1
+	ctx.HomeDir = repo.Location()
+	args := []string{
+		"-pre", "echo $PLAKAR_HOOK $PLAKAR_STATUS",
+		"-post", "echo $PLAKAR_HOOK $PLAKAR_STATUS $PLAKAR_SNAPSHOT_FILES $PLAKAR_SNAPSHOT_ID",
+		tmpBackupDir,
+	}
+
+	subcommand, err := parse_cmd_backup(ctx, repo, args)
+	require.NoError(t, err)
+
+	status, err := subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	require.NoError(t, repo.RebuildState())
+	snapshotIDs, err := repo.GetSnapshots()
+	require.NoError(t, err)
+	require.Len(t, snapshotIDs, 1)
+
+	snap, err := snapshot.Load(repo, snapshotIDs[0])
+	require.NoError(t, err)
+	require.Equal(t, "pre_backup running\n", snap.Header.GetContext("hook.pre_backup.output"))
+	require.Equal(t, fmt.Sprintf("post_backup success 4 %x\n", snapshotIDs[0]), snap.Header.GetContext("hook.post_backup.output"))
+	require.Empty(t, snap.Header.GetContext("hook.post_backup.error"))
+}
+
+func TestExecuteCmdCreateWithFailingHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks are run by cmd on windows")
+	}
+
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, tmpBackupDir := generateFixtures(t, bufOut, bufErr)
+
+	ctx := repo.AppContext()
+	ctx.MaxConcurrency = 1
+	ctx.HomeDir = repo.Location()
+
+	failed := filepath.Join(t.TempDir(), "failed")
+	for _, pre := range []string{"exit 3", "sleep 5"} {
+		args := []string{
+			"-pre", pre,
+			"-on-failure", "echo $PLAKAR_STATUS: $PLAKAR_ERROR > " + failed,
+			"-hook-timeout", "100ms",
+			tmpBackupDir,
+		}
+		subcommand, err := parse_cmd_backup(ctx, repo, args)
+		require.NoError(t, err)
+
+		status, execErr := subcommand.Execute(ctx, repo)
+		require.Error(t, execErr)
+		require.Equal(t, 1, status)
+
+		content, err := os.ReadFile(failed)
+		require.NoError(t, err)
+		require.Equal(t, "failure: "+execErr.Error()+"\n", string(content))
+	}
+
+	require.NoError(t, repo.RebuildState())
+	snapshotIDs, err := repo.GetSnapshots()
+	require.NoError(t, err)
+	require.Len(t, snapshotIDs, 0)
+}
+
+func TestExecuteCmdCreateFailingRunsPostHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks are run by cmd on windows")
+	}
+
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, tmpBackupDir := generateFixtures(t, bufOut, bufErr)
+
+	ctx := repo.AppContext()
+	ctx.MaxConcurrency = 1
+	ctx.HomeDir = repo.Location()
+
+	log := filepath.Join(t.TempDir(), "log")
+	args := []string{
+		"-pre", "echo $PLAKAR_HOOK >> " + log,
+		"-post", "echo $PLAKAR_HOOK $PLAKAR_STATUS >> " + log,
+		"-on-failure", "echo $PLAKAR_HOOK $PLAKAR_STATUS >> " + log,
+		filepath.Join(tmpBackupDir, "missing"),
+	}
+	subcommand, err := parse_cmd_backup(ctx, repo, args)
+	require.NoError(t, err)
+
+	status, err := subcommand.Execute(ctx, repo)
+	require.Error(t, err)
+	require.Equal(t, 1, status)
+
+	// what -pre acquired is released by -post before -on-failure runs
+	content, err := os.ReadFile(log)
+	require.NoError(t, err)
+	require.Equal(t, "pre_backup\npost_backup failure\non_failure failure\n", string(content))
+}
+
+func TestParseCmdBackupErrorBudget(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, tmpBackupDir := generateFixtures(t, bufOut, bufErr)
+
+	_, err := parse_cmd_backup(repo.AppContext(), repo, []string{"-max-error-ratio", "2", tmpBackupDir})
+	require.Error(t, err)
+
+	subcommand, err := parse_cmd_backup(repo.AppContext(), repo, []string{tmpBackupDir})
+	require.NoError(t, err)
+	require.Nil(t, subcommand.(*Backup).ErrorBudget)
+
+	subcommand, err = parse_cmd_backup(repo.AppContext(), repo, []string{"-max-errors", "0", tmpBackupDir})
+	require.NoError(t, err)
+	require.Equal(t, &snapshot.ErrorBudget{MaxErrors: 0}, subcommand.(*Backup).ErrorBudget)
+}
@@ -0,0 +1,102 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/PlakarKorp/plakar/shell"
+	"github.com/PlakarKorp/plakar/snapshot/header"
+)
+
+const (
+	HookPreBackup  = "pre_backup"
+	HookPostBackup = "post_backup"
+	HookOnFailure  = "on_failure"
+)
+
+// DefaultHookTimeout is the time after which hooks are killed.
+const DefaultHookTimeout = 10 * time.Minute
+
+// maxHookOutput is the amount of the output of a hook kept in the
+// snapshot context.
+const maxHookOutput = 64 * 1024
+
+// hookEnv describes the snapshot to the hooks run around a backup.
+type hookEnv struct {
+	repository string
+	snapshotID [32]byte
+	status     string
+	err        error
+	hdr        *header.Header
+}
+
+func (e *hookEnv) environ(hook string) []string {
+	env := append(os.Environ(),
+		"PLAKAR_HOOK="+hook,
+		"PLAKAR_REPOSITORY="+e.repository,
+		fmt.Sprintf("PLAKAR_SNAPSHOT_ID=%x", e.snapshotID),
+		"PLAKAR_STATUS="+e.status,
+	)
+	if e.err != nil {
+		env = append(env, "PLAKAR_ERROR="+e.err.Error())
+	}
+	if e.hdr != nil {
+		var files, directories uint64
+		for _, source := range e.hdr.Sources {
+			files += source.Summary.Directory.Files + source.Summary.Below.Files
+			directories += source.Summary.Directory.Directories + source.Summary.Below.Directories
+		}
+		env = append(env,
+			"PLAKAR_SNAPSHOT_SIZE="+strconv.FormatUint(e.hdr.GetSize(), 10),
+			"PLAKAR_SNAPSHOT_FILES="+strconv.FormatUint(files, 10),
+			"PLAKAR_SNAPSHOT_DIRECTORIES="+strconv.FormatUint(directories, 10),
+			"PLAKAR_SNAPSHOT_ERRORS="+strconv.FormatUint(e.hdr.GetErrors(), 10),
+			"PLAKAR_SNAPSHOT_STATUS="+e.hdr.GetStatus(),
+			"PLAKAR_SNAPSHOT_DURATION="+strconv.FormatFloat(e.hdr.Duration.Seconds(), 'f', 3, 64),
+		)
+	}
+	return env
+}
+
+// runHook runs command through the shell and returns its combined
+// output.  It is killed once timeout, if any, expires.
+func runHook(command string, env []string, timeout time.Duration) (string, error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	cmd := shell.Command(ctx, command)
+	cmd.Env = env
+	// children of a killed shell may keep its output open
+	cmd.WaitDelay = time.Second
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+
+	out := output.String()
+	if len(out) > maxHookOutput {
+		out = out[:maxHookOutput]
+	}
+	return out, err
+}
+
+// recordHook keeps the outcome of a hook in the snapshot context.
+func recordHook(hdr *header.Header, hook string, output string, err error) {
+	hdr.SetContext("hook."+hook+".output", output)
+	if err != nil {
+		hdr.SetContext("hook."+hook+".error", err.Error())
+	}
+}
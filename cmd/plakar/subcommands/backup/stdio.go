@@ -26,6 +26,8 @@ func startEventsProcessorStdio(ctx *appcontext.AppContext, quiet bool) eventsPro
 				if !quiet {
 					ctx.GetLogger().Stdout("%x: OK %s %s", event.SnapshotID[:4], checkMark, event.Pathname)
 				}
+			case events.FileChange:
+				ctx.GetLogger().Trace("backup", "%x: %s %s", event.SnapshotID[:4], event.Reason, event.Pathname)
 			case events.Done:
 				done <- struct{}{}
 			default:
@@ -175,7 +175,11 @@ func (cmd *Check) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 			return 1, err
 		}
 		for _, snapshotID := range snapshotIDs {
-			snapshots = append(snapshots, fmt.Sprintf("%x:", snapshotID))
+			paths, err := utils.SnapshotSourcePaths(repo, snapshotID, "", "")
+			if err != nil {
+				return 1, err
+			}
+			snapshots = append(snapshots, paths...)
 		}
 	} else {
 		for _, snapshotPath := range cmd.Snapshots {
@@ -202,7 +206,11 @@ func (cmd *Check) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 				return 1, err
 			}
 			for _, snapshotID := range snapshotIDs {
-				snapshots = append(snapshots, fmt.Sprintf("%x:%s", snapshotID, path))
+				paths, err := utils.SnapshotSourcePaths(repo, snapshotID, prefix, path)
+				if err != nil {
+					return 1, err
+				}
+				snapshots = append(snapshots, paths...)
 			}
 		}
 	}
@@ -237,9 +245,9 @@ func (cmd *Check) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 		}
 
 		if !failures {
-			ctx.GetLogger().Info("%s: verification of %x:%s completed successfully",
+			ctx.GetLogger().Info("%s: verification of %s:%s completed successfully",
 				cmd.Name(),
-				snap.Header.GetIndexShortID(),
+				utils.SnapshotSourceName(snap),
 				pathname)
 		}
 
@@ -17,6 +17,7 @@ import (
 	"github.com/PlakarKorp/plakar/resources"
 	"github.com/PlakarKorp/plakar/snapshot"
 	_ "github.com/PlakarKorp/plakar/snapshot/exporter/fs"
+	"github.com/PlakarKorp/plakar/snapshot/importer"
 	"github.com/PlakarKorp/plakar/snapshot/importer/fs"
 	"github.com/PlakarKorp/plakar/storage"
 	bfs "github.com/PlakarKorp/plakar/storage/backends/fs"
@@ -191,3 +192,63 @@ func TestExecuteCmdCheckSpecificSnapshot(t *testing.T) {
 	lastline := lines[len(lines)-1]
 	require.Contains(t, lastline, fmt.Sprintf("info: check: verification of %s:%s completed successfully", hex.EncodeToString(snap.Header.GetIndexShortID()[:]), snap.Header.GetSource(0).Importer.Directory))
 }
+
+func TestExecuteCmdCheckSources(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	snap := generateSnapshot(t, bufOut, bufErr)
+	defer snap.Close()
+
+	ctx := snap.AppContext()
+	ctx.MaxConcurrency = 1
+	repo := snap.Repository()
+	ctx.HomeDir = repo.Location()
+
+	// back up a second source along with the first one
+	tmpSecondDir := t.TempDir()
+	err := os.WriteFile(tmpSecondDir+"/second.txt", []byte("only in the second source"), 0644)
+	require.NoError(t, err)
+
+	first, err := fs.NewFSImporter(map[string]string{"location": "fs://" + snap.Header.GetSource(0).Importer.Directory})
+	require.NoError(t, err)
+	second, err := fs.NewFSImporter(map[string]string{"location": "fs://" + tmpSecondDir})
+	require.NoError(t, err)
+
+	multi, err := snapshot.New(repo)
+	require.NoError(t, err)
+	defer multi.Close()
+	err = multi.BackupSources([]importer.Importer{first, second}, &snapshot.BackupOptions{Name: "test_backup", MaxConcurrency: 1})
+	require.NoError(t, err)
+	require.NoError(t, repo.RebuildState())
+
+	multiID := multi.Header.GetIndexID()
+	check := func() int {
+		subcommand, err := parse_cmd_check(ctx, repo, []string{hex.EncodeToString(multiID[:])})
+		require.NoError(t, err)
+		status, _ := subcommand.Execute(ctx, repo)
+		return status
+	}
+	require.Equal(t, 0, check())
+
+	// lose the content of the file of the second source
+	loaded, err := snapshot.Load(repo, multiID)
+	require.NoError(t, err)
+	defer loaded.Close()
+	view, err := loaded.WithSource(1)
+	require.NoError(t, err)
+	vfs, err := view.Filesystem()
+	require.NoError(t, err)
+	entry, err := vfs.GetEntry(tmpSecondDir + "/second.txt")
+	require.NoError(t, err)
+	object, err := view.LookupObject(entry.Object)
+	require.NoError(t, err)
+	for _, chunk := range object.Chunks {
+		packfileMAC, exists, err := repo.GetPackfileForBlob(resources.RT_CHUNK, chunk.ContentMAC)
+		require.NoError(t, err)
+		require.True(t, exists)
+		require.NoError(t, repo.RemoveBlob(resources.RT_CHUNK, chunk.ContentMAC, packfileMAC))
+	}
+
+	require.Equal(t, 1, check())
+}
@@ -28,6 +28,7 @@ import (
 
 	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/plakar/cmd/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/encryption"
 	"github.com/PlakarKorp/plakar/hashing"
 	"github.com/PlakarKorp/plakar/objects"
 	"github.com/PlakarKorp/plakar/repository"
@@ -84,7 +85,7 @@ func (cmd *Clone) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 
 	var hasher hash.Hash
 	if configuration.Encryption != nil {
-		hasher = hashing.GetMACHasher(storage.DEFAULT_HASHING_ALGORITHM, ctx.GetSecret())
+		hasher = hashing.GetMACHasher(storage.DEFAULT_HASHING_ALGORITHM, encryption.MACKey(ctx.GetSecret()))
 	} else {
 		hasher = hashing.GetHasher(storage.DEFAULT_HASHING_ALGORITHM)
 	}
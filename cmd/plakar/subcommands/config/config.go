@@ -120,7 +120,7 @@ func cmd_remote(ctx *appcontext.AppContext, args []string) error {
 
 func cmd_repository(ctx *appcontext.AppContext, args []string) error {
 	if len(args) == 0 {
-		return fmt.Errorf("usage: plakar config repository [create | default | set | unset | validate]")
+		return fmt.Errorf("usage: plakar config repository [create | default | set | unset | show | upgrade | validate]")
 	}
 
 	switch args[0] {
@@ -171,6 +171,28 @@ func cmd_repository(ctx *appcontext.AppContext, args []string) error {
 		delete(ctx.Config.Repositories[name], option)
 		return ctx.Config.Save()
 
+	case "show":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: plakar config repository show name")
+		}
+		repo, err := openRepository(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		defer repo.Close()
+		return showRepository(ctx.Stdout, repo)
+
+	case "upgrade":
+		if len(args) < 3 {
+			return fmt.Errorf("usage: plakar config repository upgrade name option=value...")
+		}
+		repo, err := openRepository(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		defer repo.Close()
+		return upgradeRepository(repo, args[2:])
+
 	case "validate":
 		if len(args) != 2 {
 			return fmt.Errorf("usage: plakar config repository validate name")
@@ -178,6 +200,6 @@ func cmd_repository(ctx *appcontext.AppContext, args []string) error {
 		return fmt.Errorf("validation not implemented")
 
 	default:
-		return fmt.Errorf("usage: plakar config repository [create | default | set | unset | validate]")
+		return fmt.Errorf("usage: plakar config repository [create | default | set | unset | show | upgrade | validate]")
 	}
 }
@@ -0,0 +1,201 @@
+package version
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/caching"
+	"github.com/PlakarKorp/plakar/chunking"
+	"github.com/PlakarKorp/plakar/cmd/plakar/utils"
+	"github.com/PlakarKorp/plakar/compression"
+	"github.com/PlakarKorp/plakar/repository"
+	"github.com/PlakarKorp/plakar/secrets"
+	"github.com/PlakarKorp/plakar/storage"
+	"github.com/PlakarKorp/plakar/versioning"
+	"github.com/dustin/go-humanize"
+)
+
+var (
+	ErrUnknownSetting = errors.New("unknown repository setting")
+	ErrNotUpgradable  = errors.New("setting cannot be upgraded")
+)
+
+// notUpgradable explains why the settings left out of upgrades are.
+var notUpgradable = map[string]string{
+	"hashing":    "blobs are identified by MACs computed with it, which existing data would no longer match",
+	"encryption": "existing data would have to be encrypted anew",
+}
+
+// openRepository opens the configured repository name, unlocking it if
+// it is encrypted.
+func openRepository(ctx *appcontext.AppContext, name string) (*repository.Repository, error) {
+	storeConfig, ok := ctx.Config.GetRepository(name)
+	if !ok {
+		return nil, fmt.Errorf("repository %q does not exists", name)
+	}
+	if _, ok := storeConfig["location"]; !ok {
+		return nil, fmt.Errorf("repository %q doesn't have a location set", name)
+	}
+
+	store, serializedConfig, err := storage.Open(storeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the repository at %s: %w", storeConfig["location"], err)
+	}
+
+	repoConfig, err := storage.NewConfigurationFromWrappedBytes(serializedConfig)
+	if err != nil {
+		return nil, err
+	}
+	if repoConfig.Version != versioning.FromString(storage.VERSION) {
+		return nil, fmt.Errorf("incompatible repository version: %s != %s", repoConfig.Version, storage.VERSION)
+	}
+
+	if repoConfig.Encryption != nil {
+		secret, err := secrets.Unlock(ctx, storeConfig, repoConfig.Encryption, func() ([]byte, error) {
+			return utils.GetPassphrase("repository")
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not derive secret: %w", err)
+		}
+		ctx.SetSecret(secret)
+	}
+
+	repo, err := repository.New(ctx, store, serializedConfig)
+	if errors.Is(err, caching.ErrInUse) {
+		return nil, fmt.Errorf("%w: stop the agent or run with -no-agent", err)
+	}
+	return repo, err
+}
+
+func showRepository(w io.Writer, repo *repository.Repository) error {
+	stored := repo.Configuration()
+	effective := repo.EffectiveConfiguration()
+
+	upgrade, upgradedAt, err := repo.LastUpgrade()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "Version:", stored.Version)
+	fmt.Fprintln(w, "Timestamp:", stored.Timestamp)
+	fmt.Fprintln(w, "RepositoryID:", stored.RepositoryID)
+	if upgrade != nil {
+		fmt.Fprintln(w, "Upgraded:", upgradedAt)
+	}
+
+	fmt.Fprintf(w, "hashing: %s (%d bits)\n", stored.Hashing.Algorithm, stored.Hashing.Bits)
+	if stored.Encryption != nil {
+		fmt.Fprintln(w, "encryption:", stored.Encryption.DataAlgorithm)
+	} else {
+		fmt.Fprintln(w, "encryption: none")
+	}
+	if stored.Immutability != nil {
+		fmt.Fprintf(w, "immutability: %d days (%s)\n", stored.Immutability.RetentionDays, stored.Immutability.Mode)
+	} else {
+		fmt.Fprintln(w, "immutability: none")
+	}
+
+	// the settings of new snapshots, along with those the repository
+	// was created with when they were upgraded
+	show := func(option, value, created string) {
+		if value == created {
+			fmt.Fprintf(w, "%s: %s\n", option, value)
+		} else {
+			fmt.Fprintf(w, "%s: %s (created with %s)\n", option, value, created)
+		}
+	}
+	size := func(n uint64) string {
+		return fmt.Sprintf("%s (%d bytes)", humanize.Bytes(n), n)
+	}
+
+	show("packfile.max_size", size(effective.Packfile.MaxSize), size(stored.Packfile.MaxSize))
+	show("chunking.algorithm", effective.Chunking.Algorithm, stored.Chunking.Algorithm)
+	show("chunking.min_size", size(uint64(effective.Chunking.MinSize)), size(uint64(stored.Chunking.MinSize)))
+	show("chunking.normal_size", size(uint64(effective.Chunking.NormalSize)), size(uint64(stored.Chunking.NormalSize)))
+	show("chunking.max_size", size(uint64(effective.Chunking.MaxSize)), size(uint64(stored.Chunking.MaxSize)))
+	show("chunking.threshold", size(uint64(effective.Chunking.GetThreshold())), size(uint64(stored.Chunking.GetThreshold())))
+	for _, policy := range effective.Chunking.Policies {
+		fmt.Fprintln(w, "chunking.policy:", policy.String())
+	}
+	show("compression", compressionName(effective.Compression), compressionName(stored.Compression))
+
+	return nil
+}
+
+func compressionName(config *compression.Configuration) string {
+	if config == nil {
+		return "none"
+	}
+	return config.Algorithm
+}
+
+// applyUpgrade sets a setting of upgrade from an option=value pair.
+func applyUpgrade(upgrade *repository.Upgrade, setting string) error {
+	option, value, found := strings.Cut(setting, "=")
+	if !found {
+		return fmt.Errorf("invalid setting %q, expected option=value", setting)
+	}
+
+	if reason, exists := notUpgradable[option]; exists {
+		return fmt.Errorf("%w: %s: %s", ErrNotUpgradable, option, reason)
+	}
+
+	switch option {
+	case "packfile.max_size":
+		n, err := humanize.ParseBytes(value)
+		if err != nil || n == 0 || n > math.MaxUint32 {
+			return fmt.Errorf("invalid packfile size: %s", value)
+		}
+		upgrade.Packfile.MaxSize = n
+
+	case "chunking.algorithm":
+		upgrade.Chunking.Algorithm = strings.ToUpper(value)
+
+	case "chunking.min_size", "chunking.normal_size", "chunking.max_size", "chunking.threshold":
+		n, err := chunking.ParseSize(value)
+		if err != nil {
+			return err
+		}
+		switch option {
+		case "chunking.min_size":
+			upgrade.Chunking.MinSize = n
+		case "chunking.normal_size":
+			upgrade.Chunking.NormalSize = n
+		case "chunking.max_size":
+			upgrade.Chunking.MaxSize = n
+		case "chunking.threshold":
+			upgrade.Chunking.Threshold = n
+		}
+
+	case "compression":
+		if strings.EqualFold(value, "none") {
+			upgrade.Compression = nil
+			break
+		}
+		config, err := compression.LookupDefaultConfiguration(strings.ToUpper(value))
+		if err != nil {
+			return fmt.Errorf("unknown compression algorithm: %s", value)
+		}
+		upgrade.Compression = config
+
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownSetting, option)
+	}
+	return nil
+}
+
+func upgradeRepository(repo *repository.Repository, settings []string) error {
+	effective := repo.EffectiveConfiguration()
+	upgrade := repository.NewUpgrade(&effective)
+	for _, setting := range settings {
+		if err := applyUpgrade(upgrade, setting); err != nil {
+			return err
+		}
+	}
+
+	return repo.Upgrade(upgrade)
+}
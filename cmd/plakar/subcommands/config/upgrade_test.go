@@ -0,0 +1,35 @@
+package version
+
+import (
+	"testing"
+
+	"github.com/PlakarKorp/plakar/repository"
+	"github.com/PlakarKorp/plakar/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyUpgrade(t *testing.T) {
+	upgrade := repository.NewUpgrade(storage.NewConfiguration())
+
+	require.NoError(t, applyUpgrade(upgrade, "packfile.max_size=64MB"))
+	require.Equal(t, uint64(64_000_000), upgrade.Packfile.MaxSize)
+
+	require.NoError(t, applyUpgrade(upgrade, "chunking.algorithm=ultracdc"))
+	require.NoError(t, applyUpgrade(upgrade, "chunking.normal_size=2MiB"))
+	require.Equal(t, "ULTRACDC", upgrade.Chunking.Algorithm)
+	require.Equal(t, uint32(2<<20), upgrade.Chunking.NormalSize)
+
+	require.NoError(t, applyUpgrade(upgrade, "compression=gzip"))
+	require.Equal(t, "GZIP", upgrade.Compression.Algorithm)
+	require.NoError(t, applyUpgrade(upgrade, "compression=none"))
+	require.Nil(t, upgrade.Compression)
+	require.NoError(t, upgrade.Validate())
+
+	for _, setting := range []string{"hashing=SHA256", "encryption=XCHACHA20"} {
+		require.ErrorIs(t, applyUpgrade(upgrade, setting), ErrNotUpgradable)
+	}
+	require.ErrorIs(t, applyUpgrade(upgrade, "packfile.min_size=1MB"), ErrUnknownSetting)
+	require.Error(t, applyUpgrade(upgrade, "compression=zstd"))
+	require.Error(t, applyUpgrade(upgrade, "packfile.max_size=0"))
+	require.Error(t, applyUpgrade(upgrade, "compression"))
+}
@@ -22,10 +22,12 @@ import (
 	"fmt"
 	"hash"
 	"io"
+	"math"
 	"os"
 	"strings"
 
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/chunking"
 	"github.com/PlakarKorp/plakar/cmd/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/cmd/plakar/utils"
 	"github.com/PlakarKorp/plakar/compression"
@@ -33,6 +35,7 @@ import (
 	"github.com/PlakarKorp/plakar/hashing"
 	"github.com/PlakarKorp/plakar/repository"
 	"github.com/PlakarKorp/plakar/resources"
+	"github.com/PlakarKorp/plakar/secrets"
 	"github.com/PlakarKorp/plakar/storage"
 	"github.com/PlakarKorp/plakar/versioning"
 )
@@ -41,11 +44,40 @@ func init() {
 	subcommands.Register("create", parse_cmd_create)
 }
 
+type policyFlags []chunking.Policy
+
+func (p *policyFlags) String() string {
+	specs := make([]string, 0, len(*p))
+	for _, policy := range *p {
+		specs = append(specs, policy.String())
+	}
+	return strings.Join(specs, " ")
+}
+
+func (p *policyFlags) Set(value string) error {
+	policy, err := chunking.ParsePolicy(value)
+	if err != nil {
+		return err
+	}
+	*p = append(*p, *policy)
+	return nil
+}
+
 func parse_cmd_create(ctx *appcontext.AppContext, repo *repository.Repository, args []string) (subcommands.Subcommand, error) {
 	var opt_hashing string
 	var opt_noencryption bool
+	var opt_encryption string
+	var opt_asymmetric bool
 	var opt_nocompression bool
 	var opt_allowweak bool
+	var opt_chunking string
+	var opt_chunkingmin string
+	var opt_chunkingnormal string
+	var opt_chunkingmax string
+	var opt_chunkingthreshold string
+	var opt_chunkingpolicies policyFlags
+	var opt_immutable uint
+	var opt_retentionmode string
 
 	flags := flag.NewFlagSet("create", flag.ExitOnError)
 	flags.Usage = func() {
@@ -58,22 +90,82 @@ func parse_cmd_create(ctx *appcontext.AppContext, repo *repository.Repository, a
 	flags.BoolVar(&opt_allowweak, "weak-passphrase", false, "allow weak passphrase to protect the repository")
 	flags.StringVar(&opt_hashing, "hashing", hashing.DEFAULT_HASHING_ALGORITHM, "hashing algorithm to use for digests")
 	flags.BoolVar(&opt_noencryption, "no-encryption", false, "disable transparent encryption")
+	flags.StringVar(&opt_encryption, "encryption", encryption.DEFAULT_DATA_ALGORITHM, "data encryption algorithm: "+strings.Join(encryption.DataAlgorithms(), ", ")+" or XCHACHA20 for short")
+	flags.BoolVar(&opt_asymmetric, "asymmetric", false, "wrap data keys for an X25519 key pair, allowing write-only passphrases")
 	flags.BoolVar(&opt_nocompression, "no-compression", false, "disable transparent compression")
+	flags.StringVar(&opt_chunking, "chunking", chunking.NewDefaultConfiguration().Algorithm, "chunking algorithm: "+strings.Join(chunking.Algorithms(), ", "))
+	flags.StringVar(&opt_chunkingmin, "chunking-min", "", "minimum chunk size")
+	flags.StringVar(&opt_chunkingnormal, "chunking-normal", "", "average chunk size")
+	flags.StringVar(&opt_chunkingmax, "chunking-max", "", "maximum chunk size")
+	flags.StringVar(&opt_chunkingthreshold, "chunking-threshold", "", "size below which files are not chunked, defaults to the minimum chunk size")
+	flags.Var(&opt_chunkingpolicies, "chunking-policy", "path=GLOB,type=MIME,algorithm=NAME,min=SIZE,normal=SIZE,max=SIZE,threshold=SIZE chunking of matching files, can be specified multiple times")
+	flags.UintVar(&opt_immutable, "immutable", 0, "make the repository write-once, protecting snapshots from deletion for this many days")
+	flags.StringVar(&opt_retentionmode, "retention-mode", storage.RETENTION_GOVERNANCE, "object lock mode requested from stores supporting it: GOVERNANCE or COMPLIANCE")
 	flags.Parse(args)
 
 	if flags.NArg() != 0 {
 		return nil, fmt.Errorf("%s: too many parameters", flag.CommandLine.Name())
 	}
 
+	if opt_asymmetric && opt_noencryption {
+		return nil, fmt.Errorf("%s: -asymmetric requires encryption", flag.CommandLine.Name())
+	}
+
+	dataAlgorithm, err := encryption.LookupDataAlgorithm(opt_encryption)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", flag.CommandLine.Name(), err)
+	}
+
 	if hashing.GetHasher(strings.ToUpper(opt_hashing)) == nil {
 		return nil, fmt.Errorf("%s: unknown hashing algorithm", flag.CommandLine.Name())
 	}
 
+	chunkingConfiguration := chunking.NewDefaultConfiguration()
+	chunkingConfiguration.Algorithm = strings.ToUpper(opt_chunking)
+	for _, size := range []struct {
+		value string
+		dest  *uint32
+	}{
+		{opt_chunkingmin, &chunkingConfiguration.MinSize},
+		{opt_chunkingnormal, &chunkingConfiguration.NormalSize},
+		{opt_chunkingmax, &chunkingConfiguration.MaxSize},
+		{opt_chunkingthreshold, &chunkingConfiguration.Threshold},
+	} {
+		if size.value == "" {
+			continue
+		}
+		n, err := chunking.ParseSize(size.value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", flag.CommandLine.Name(), err)
+		}
+		*size.dest = n
+	}
+	chunkingConfiguration.Policies = opt_chunkingpolicies
+	if err := chunkingConfiguration.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", flag.CommandLine.Name(), err)
+	}
+
+	var immutability *storage.ImmutabilityConfiguration
+	if opt_immutable != 0 {
+		if opt_immutable > math.MaxUint32 {
+			return nil, fmt.Errorf("%s: invalid retention: %d days", flag.CommandLine.Name(), opt_immutable)
+		}
+		immutability = storage.NewImmutabilityConfiguration(uint32(opt_immutable))
+		immutability.Mode, err = storage.ParseRetentionMode(opt_retentionmode)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", flag.CommandLine.Name(), err)
+		}
+	}
+
 	return &Create{
 		AllowWeak:     opt_allowweak,
 		Hashing:       opt_hashing,
 		NoEncryption:  opt_noencryption,
+		Encryption:    dataAlgorithm,
+		Asymmetric:    opt_asymmetric,
 		NoCompression: opt_nocompression,
+		Chunking:      chunkingConfiguration,
+		Immutability:  immutability,
 		Location:      repo.Location(),
 	}, nil
 }
@@ -82,7 +174,11 @@ type Create struct {
 	AllowWeak     bool
 	Hashing       string
 	NoEncryption  bool
+	Encryption    string
+	Asymmetric    bool
 	NoCompression bool
+	Chunking      *chunking.Configuration
+	Immutability  *storage.ImmutabilityConfiguration
 	Location      string
 }
 
@@ -100,6 +196,11 @@ func (cmd *Create) Execute(ctx *appcontext.AppContext, repo *repository.Reposito
 	}
 	storageConfiguration.Hashing = *hashingConfiguration
 
+	if cmd.Chunking != nil {
+		storageConfiguration.Chunking = *cmd.Chunking
+	}
+	storageConfiguration.Immutability = cmd.Immutability
+
 	minEntropBits := 80.
 	if cmd.AllowWeak {
 		minEntropBits = 0.
@@ -108,33 +209,36 @@ func (cmd *Create) Execute(ctx *appcontext.AppContext, repo *repository.Reposito
 	var hasher hash.Hash
 	if !cmd.NoEncryption {
 		storageConfiguration.Encryption = encryption.NewDefaultConfiguration()
+		if cmd.Encryption != "" {
+			storageConfiguration.Encryption.DataAlgorithm = cmd.Encryption
+		}
 
-		var passphrase []byte
-
-		envPassphrase := os.Getenv("PLAKAR_PASSPHRASE")
-		if ctx.KeyFromFile == "" {
-			if envPassphrase != "" {
-				passphrase = []byte(envPassphrase)
-			} else {
-				for attempt := 0; attempt < 3; attempt++ {
-					tmp, err := utils.GetPassphraseConfirm("repository", minEntropBits)
-					if err != nil {
-						fmt.Fprintf(os.Stderr, "%s\n", err)
-						continue
-					}
-					passphrase = tmp
-					break
+		passphrase, _, err := secrets.Passphrase(ctx, ctx.StoreConfig, func() ([]byte, error) {
+			var err error
+			for attempt := 0; attempt < 3; attempt++ {
+				var passphrase []byte
+				passphrase, err = utils.GetPassphraseConfirm("repository", minEntropBits)
+				if err == nil {
+					return passphrase, nil
 				}
+				fmt.Fprintf(os.Stderr, "%s\n", err)
 			}
-		} else {
-			passphrase = []byte(ctx.KeyFromFile)
+			return nil, err
+		})
+		if err != nil {
+			return 1, err
 		}
 
 		if len(passphrase) == 0 {
 			return 1, fmt.Errorf("can't encrypt the repository with an empty passphrase")
 		}
 
-		key, err := encryption.DeriveKey(storageConfiguration.Encryption.KDFParams, passphrase)
+		var key []byte
+		if cmd.Asymmetric {
+			key, err = encryption.NewAsymmetricKey(storageConfiguration.Encryption)
+		} else {
+			key, err = encryption.NewMasterKey()
+		}
 		if err != nil {
 			return 1, err
 		}
@@ -144,7 +248,13 @@ func (cmd *Create) Execute(ctx *appcontext.AppContext, repo *repository.Reposito
 			return 1, err
 		}
 		storageConfiguration.Encryption.Canary = canary
-		hasher = hashing.GetMACHasher(storage.DEFAULT_HASHING_ALGORITHM, key)
+
+		slot, err := encryption.NewKeySlot(encryption.DEFAULT_KEYSLOT, &storageConfiguration.Encryption.KDFParams, passphrase, key)
+		if err != nil {
+			return 1, err
+		}
+		storageConfiguration.Encryption.KeySlots = []encryption.KeySlot{*slot}
+		hasher = hashing.GetMACHasher(storage.DEFAULT_HASHING_ALGORITHM, encryption.MACKey(key))
 	} else {
 		storageConfiguration.Encryption = nil
 		hasher = hashing.GetHasher(storage.DEFAULT_HASHING_ALGORITHM)
@@ -8,6 +8,7 @@ import (
 
 	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/plakar/repository"
+	"github.com/PlakarKorp/plakar/storage"
 	_ "github.com/PlakarKorp/plakar/storage/backends/fs"
 	"github.com/creack/pty"
 	"github.com/stretchr/testify/require"
@@ -219,3 +220,88 @@ func TestExecuteCmdCreateDefaultWithEnvPassphrase(t *testing.T) {
 	_, err = os.Stat(fmt.Sprintf("%s/repo/CONFIG", tmpRepoDirRoot))
 	require.NoError(t, err)
 }
+
+func TestExecuteCmdCreateWithChunking(t *testing.T) {
+	tmpRepoDirRoot, err := os.MkdirTemp("", "tmp_repo")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		os.RemoveAll(tmpRepoDirRoot)
+	})
+	ctx := appcontext.NewAppContext()
+	defer ctx.Close()
+
+	repo, err := repository.Inexistent(ctx, map[string]string{"location": tmpRepoDirRoot+"/repo"})
+	require.NoError(t, err)
+	// override the homedir to avoid having test overwriting existing home configuration
+	ctx.HomeDir = tmpRepoDirRoot
+	args := []string{"--no-encryption", "--chunking", "ultracdc", "--chunking-min", "32KiB", "--chunking-normal", "512KiB", "--chunking-max", "2MiB",
+		"--chunking-policy", "path=**/*.qcow2,algorithm=fixed,normal=4MiB"}
+
+	subcommand, err := parse_cmd_create(ctx, repo, args)
+	require.NoError(t, err)
+	require.NotNil(t, subcommand)
+
+	cmd := subcommand.(*Create)
+	require.Equal(t, "ULTRACDC", cmd.Chunking.Algorithm)
+	require.Equal(t, uint32(32*1024), cmd.Chunking.MinSize)
+	require.Equal(t, uint32(512*1024), cmd.Chunking.NormalSize)
+	require.Equal(t, uint32(2*1024*1024), cmd.Chunking.MaxSize)
+	require.Len(t, cmd.Chunking.Policies, 1)
+	require.Equal(t, "FIXED", cmd.Chunking.Policies[0].Algorithm)
+
+	status, err := subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	_, err = os.Stat(fmt.Sprintf("%s/repo/CONFIG", tmpRepoDirRoot))
+	require.NoError(t, err)
+}
+
+func TestExecuteCmdCreateWithXChaCha20(t *testing.T) {
+	ctx := appcontext.NewAppContext()
+	defer ctx.Close()
+
+	location := t.TempDir() + "/repo"
+	repo, err := repository.Inexistent(ctx, map[string]string{"location": location})
+	require.NoError(t, err)
+	ctx.HomeDir = t.TempDir()
+
+	subcommand, err := parse_cmd_create(ctx, repo, []string{"-encryption", "xchacha20"})
+	require.NoError(t, err)
+	require.Equal(t, "XCHACHA20-POLY1305", subcommand.(*Create).Encryption)
+
+	t.Setenv("PLAKAR_PASSPHRASE", "aZeRtY123456$#@!@")
+	status, err := subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	_, serializedConfig, err := storage.Open(map[string]string{"location": location})
+	require.NoError(t, err)
+	config, err := storage.NewConfigurationFromWrappedBytes(serializedConfig)
+	require.NoError(t, err)
+	require.Equal(t, "XCHACHA20-POLY1305", config.Encryption.DataAlgorithm)
+
+	// the canary is encrypted with the selected algorithm
+	_, err = config.Encryption.Unlock([]byte("aZeRtY123456$#@!@"))
+	require.NoError(t, err)
+
+	_, err = parse_cmd_create(ctx, repo, []string{"-encryption", "chacha20"})
+	require.Error(t, err)
+}
+
+func TestParseCmdCreateInvalidChunking(t *testing.T) {
+	ctx := appcontext.NewAppContext()
+	defer ctx.Close()
+
+	repo, err := repository.Inexistent(ctx, map[string]string{"location": t.TempDir() + "/repo"})
+	require.NoError(t, err)
+
+	for _, args := range [][]string{
+		{"--chunking", "rabin"},
+		{"--chunking-min", "1MiB", "--chunking-max", "64KiB"},
+		{"--chunking-normal", "lots"},
+	} {
+		_, err := parse_cmd_create(ctx, repo, args)
+		require.Error(t, err, args)
+	}
+}
@@ -0,0 +1,161 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package diag
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/chunking"
+	"github.com/PlakarKorp/plakar/repository"
+	"github.com/dustin/go-humanize"
+)
+
+type DiagChunking struct {
+	RepositoryLocation string
+	RepositorySecret   []byte
+
+	Algorithms []string
+	Path       string
+}
+
+func (cmd *DiagChunking) Name() string {
+	return "diag_chunking"
+}
+
+// chunkingResult accumulates the chunks an algorithm cut in the files.
+type chunkingResult struct {
+	algorithm   string
+	chunks      uint64
+	totalSize   uint64
+	uniqueSize  uint64
+	unique      map[[32]byte]struct{}
+	elapsedTime time.Duration
+}
+
+func (res *chunkingResult) chunkFile(config *chunking.Configuration, pathname string) error {
+	fp, err := os.Open(pathname)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	t0 := time.Now()
+	defer func() { res.elapsedTime += time.Since(t0) }()
+
+	chk, err := config.NewChunker(fp)
+	if err != nil {
+		return err
+	}
+
+	for {
+		cdcChunk, err := chk.Next()
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if cdcChunk != nil {
+			sum := sha256.Sum256(cdcChunk)
+			if _, exists := res.unique[sum]; !exists {
+				res.unique[sum] = struct{}{}
+				res.uniqueSize += uint64(len(cdcChunk))
+			}
+			res.chunks++
+			res.totalSize += uint64(len(cdcChunk))
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+func (cmd *DiagChunking) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	base := repo.Configuration().Chunking
+	base.Policies = nil
+
+	algorithms := cmd.Algorithms
+	if len(algorithms) == 0 {
+		algorithms = chunking.Algorithms()
+	}
+
+	configs := make([]*chunking.Configuration, 0, len(algorithms))
+	results := make([]*chunkingResult, 0, len(algorithms))
+	for _, algorithm := range algorithms {
+		config := base
+		config.Algorithm = strings.ToUpper(algorithm)
+		if err := config.Validate(); err != nil {
+			return 1, err
+		}
+		configs = append(configs, &config)
+		results = append(results, &chunkingResult{
+			algorithm: config.Algorithm,
+			unique:    make(map[[32]byte]struct{}),
+		})
+	}
+
+	var nFiles uint64
+	err := filepath.WalkDir(cmd.Path, func(pathname string, d fs.DirEntry, err error) error {
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "%s: %s\n", pathname, err)
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		nFiles++
+		for i := range configs {
+			if err := results[i].chunkFile(configs[i], pathname); err != nil {
+				fmt.Fprintf(ctx.Stderr, "%s: %s\n", pathname, err)
+				return nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 1, err
+	}
+
+	fmt.Fprintf(ctx.Stdout, "Files: %d\n", nFiles)
+	fmt.Fprintf(ctx.Stdout, "MinSize: %s\n", humanize.IBytes(uint64(base.MinSize)))
+	fmt.Fprintf(ctx.Stdout, "NormalSize: %s\n", humanize.IBytes(uint64(base.NormalSize)))
+	fmt.Fprintf(ctx.Stdout, "MaxSize: %s\n", humanize.IBytes(uint64(base.MaxSize)))
+	fmt.Fprintf(ctx.Stdout, "%-10s %10s %10s %10s %10s %6s %s\n",
+		"ALGORITHM", "CHUNKS", "AVERAGE", "SIZE", "UNIQUE", "DEDUP", "TIME")
+	for _, res := range results {
+		var average uint64
+		if res.chunks != 0 {
+			average = res.totalSize / res.chunks
+		}
+		ratio := 1.0
+		if res.uniqueSize != 0 {
+			ratio = float64(res.totalSize) / float64(res.uniqueSize)
+		}
+		fmt.Fprintf(ctx.Stdout, "%-10s %10d %10s %10s %10s %6.2f %s\n",
+			res.algorithm, res.chunks,
+			humanize.IBytes(average),
+			humanize.IBytes(res.totalSize),
+			humanize.IBytes(res.uniqueSize),
+			ratio, res.elapsedTime.Round(time.Millisecond))
+	}
+	return 0, nil
+}
@@ -19,6 +19,8 @@ package diag
 import (
 	"flag"
 	"fmt"
+	"path/filepath"
+	"strings"
 
 	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/plakar/cmd/plakar/subcommands"
@@ -50,6 +52,7 @@ func parse_cmd_diag(ctx *appcontext.AppContext, repo *repository.Repository, arg
 		fmt.Fprintf(flags.Output(), "       %s xattr SNAPSHOT[:PATH]\n", flags.Name())
 		fmt.Fprintf(flags.Output(), "       %s contenttype SNAPSHOT[:PATH]\n", flags.Name())
 		fmt.Fprintf(flags.Output(), "       %s locks\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "       %s chunking [-algorithms ALGO,...] PATH\n", flags.Name())
 	}
 	flags.Parse(args)
 
@@ -126,6 +129,29 @@ func parse_cmd_diag(ctx *appcontext.AppContext, repo *repository.Repository, arg
 			RepositoryLocation: repo.Location(),
 			RepositorySecret:   ctx.GetSecret(),
 		}, nil
+	case "chunking":
+		chunkingFlags := flag.NewFlagSet("diag chunking", flag.ExitOnError)
+		var opt_algorithms string
+		chunkingFlags.StringVar(&opt_algorithms, "algorithms", "", "comma-separated chunking algorithms to compare, all by default")
+		chunkingFlags.Parse(flags.Args()[1:])
+		if chunkingFlags.NArg() != 1 {
+			return nil, fmt.Errorf("usage: %s chunking [-algorithms ALGO,...] PATH", flags.Name())
+		}
+		var algorithms []string
+		if opt_algorithms != "" {
+			algorithms = strings.Split(opt_algorithms, ",")
+		}
+		// the agent does not share our working directory
+		pathname := chunkingFlags.Arg(0)
+		if !filepath.IsAbs(pathname) {
+			pathname = filepath.Join(ctx.CWD, pathname)
+		}
+		return &DiagChunking{
+			RepositoryLocation: repo.Location(),
+			RepositorySecret:   ctx.GetSecret(),
+			Algorithms:         algorithms,
+			Path:               pathname,
+		}, nil
 	case "search":
 		var path, mime string
 		switch flags.NArg() {
@@ -144,5 +170,5 @@ func parse_cmd_diag(ctx *appcontext.AppContext, repo *repository.Repository, arg
 			Mime:               mime,
 		}, nil
 	}
-	return nil, fmt.Errorf("Invalid parameter. usage: diag [contenttype|snapshot|object|state|packfile|vfs|xattr|errors|search]")
+	return nil, fmt.Errorf("Invalid parameter. usage: diag [contenttype|snapshot|object|state|packfile|vfs|xattr|errors|search|locks|chunking]")
 }
@@ -793,3 +793,51 @@ func TestExecuteCmdDiagSearch(t *testing.T) {
 	output := bufOut.String()
 	require.Contains(t, output, "subdir/dummy.txt")
 }
+
+func TestExecuteCmdDiagChunking(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	snap := generateSnapshot(t, bufOut, bufErr)
+	defer snap.Close()
+
+	ctx := snap.AppContext()
+	ctx.MaxConcurrency = 1
+
+	repo := snap.Repository()
+	// override the homedir to avoid having test overwriting existing home configuration
+	ctx.HomeDir = repo.Location()
+	backupDir := snap.Header.GetSource(0).Importer.Directory
+	args := []string{"chunking", "-algorithms", "fastcdc,fixed", backupDir}
+
+	subcommand, err := parse_cmd_diag(ctx, repo, args)
+	require.NoError(t, err)
+	require.NotNil(t, subcommand)
+
+	bufOut.Reset()
+	status, err := subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	// output should look like this
+	// Files: 5
+	// MinSize: 64 KiB
+	// NormalSize: 1.0 MiB
+	// MaxSize: 4.0 MiB
+	// ALGORITHM      CHUNKS    AVERAGE       SIZE     UNIQUE  DEDUP TIME
+	// FASTCDC             5       20 B      100 B       80 B   1.25 0s
+	// FIXED               5       20 B      100 B       80 B   1.25 0s
+
+	output := bufOut.String()
+	lines := strings.Split(strings.Trim(output, "\n"), "\n")
+	require.Len(t, lines, 7)
+	require.Contains(t, lines[0], "Files: ")
+	require.True(t, strings.HasPrefix(lines[5], "FASTCDC "))
+	require.True(t, strings.HasPrefix(lines[6], "FIXED "))
+
+	args = []string{"chunking", "-algorithms", "rabin", backupDir}
+	subcommand, err = parse_cmd_diag(ctx, repo, args)
+	require.NoError(t, err)
+	_, err = subcommand.Execute(ctx, repo)
+	require.Error(t, err)
+}
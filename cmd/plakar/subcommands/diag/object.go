@@ -55,6 +55,10 @@ func (cmd *DiagObject) Execute(ctx *appcontext.AppContext, repo *repository.Repo
 	fmt.Fprintln(ctx.Stdout, "  type:", object.ContentType)
 	fmt.Fprintln(ctx.Stdout, "  chunks:")
 	for _, chunk := range object.Chunks {
+		if chunk.IsHole() {
+			fmt.Fprintf(ctx.Stdout, "    hole: %d bytes\n", chunk.Length)
+			continue
+		}
 		fmt.Fprintf(ctx.Stdout, "    MAC: %x\n", chunk.ContentMAC)
 	}
 	return 0, nil
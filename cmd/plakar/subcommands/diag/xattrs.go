@@ -38,7 +38,7 @@ func (cmd *DiagXattr) Execute(ctx *appcontext.AppContext, repo *repository.Repos
 		pathname += "/"
 	}
 
-	rd, err := repo.GetBlob(resources.RT_XATTR_BTREE, snap.Header.GetSource(0).VFS.Xattrs)
+	rd, err := repo.GetBlob(resources.RT_XATTR_BTREE, snap.Source().VFS.Xattrs)
 	if err != nil {
 		return 1, err
 	}
@@ -38,6 +38,13 @@ func (cmd *InfoRepository) Execute(ctx *appcontext.AppContext, repo *repository.
 		humanize.Bytes(uint64(repo.Configuration().Chunking.NormalSize)), repo.Configuration().Chunking.NormalSize)
 	fmt.Fprintf(ctx.Stdout, " - MaxSize: %s (%d bytes)\n",
 		humanize.Bytes(uint64(repo.Configuration().Chunking.MaxSize)), repo.Configuration().Chunking.MaxSize)
+	if repo.Configuration().Chunking.Threshold != 0 {
+		fmt.Fprintf(ctx.Stdout, " - Threshold: %s (%d bytes)\n",
+			humanize.Bytes(uint64(repo.Configuration().Chunking.Threshold)), repo.Configuration().Chunking.Threshold)
+	}
+	for _, policy := range repo.Configuration().Chunking.Policies {
+		fmt.Fprintln(ctx.Stdout, " - Policy:", policy.String())
+	}
 
 	fmt.Fprintln(ctx.Stdout, "Hashing:")
 	fmt.Fprintln(ctx.Stdout, " - Algorithm:", repo.Configuration().Hashing.Algorithm)
@@ -78,6 +85,9 @@ func (cmd *InfoRepository) Execute(ctx *appcontext.AppContext, repo *repository.
 		default:
 			fmt.Fprintf(ctx.Stdout, "   - Unsupported KDF: %s\n", repo.Configuration().Encryption.KDFParams.KDF)
 		}
+		for _, slot := range repo.Configuration().Encryption.KeySlots {
+			fmt.Fprintf(ctx.Stdout, " - KeySlot: %s (%s)\n", slot.Name, slot.KDFParams.KDF)
+		}
 	}
 
 	snapshotIDs, err := utils.LocateSnapshotIDs(repo, nil)
@@ -92,7 +102,7 @@ func (cmd *InfoRepository) Execute(ctx *appcontext.AppContext, repo *repository.
 		if err != nil {
 			return 1, err
 		}
-		totalSize += snap.Header.GetSource(0).Summary.Directory.Size + snap.Header.GetSource(0).Summary.Below.Size
+		totalSize += snap.Header.GetSize()
 		snap.Close()
 	}
 	fmt.Fprintf(ctx.Stdout, "Size: %s (%d bytes)\n", humanize.Bytes(totalSize), totalSize)
@@ -39,6 +39,7 @@ func (cmd *InfoSnapshot) Execute(ctx *appcontext.AppContext, repo *repository.Re
 	fmt.Fprintf(ctx.Stdout, "SnapshotID: %s\n", hex.EncodeToString(indexID[:]))
 	fmt.Fprintf(ctx.Stdout, "Timestamp: %s\n", header.Timestamp)
 	fmt.Fprintf(ctx.Stdout, "Duration: %s\n", header.Duration)
+	fmt.Fprintf(ctx.Stdout, "Status: %s\n", header.GetStatus())
 
 	fmt.Fprintf(ctx.Stdout, "Name: %s\n", header.Name)
 	fmt.Fprintf(ctx.Stdout, "Environment: %s\n", header.Environment)
@@ -54,12 +55,16 @@ func (cmd *InfoSnapshot) Execute(ctx *appcontext.AppContext, repo *repository.Re
 		fmt.Fprintf(ctx.Stdout, " - PublicKey: %s\n", base64.RawStdEncoding.EncodeToString(header.Identity.PublicKey))
 	}
 
-	fmt.Fprintf(ctx.Stdout, "VFS: %x\n", header.GetSource(0).VFS)
+	if len(header.Sources) > 1 {
+		fmt.Fprintf(ctx.Stdout, "Source: #%d (of %d)\n", snap.SourceIndex(), len(header.Sources))
+	}
+
+	fmt.Fprintf(ctx.Stdout, "VFS: %x\n", snap.Source().VFS)
 
 	fmt.Fprintln(ctx.Stdout, "Importer:")
-	fmt.Fprintf(ctx.Stdout, " - Type: %s\n", header.GetSource(0).Importer.Type)
-	fmt.Fprintf(ctx.Stdout, " - Origin: %s\n", header.GetSource(0).Importer.Origin)
-	fmt.Fprintf(ctx.Stdout, " - Directory: %s\n", header.GetSource(0).Importer.Directory)
+	fmt.Fprintf(ctx.Stdout, " - Type: %s\n", snap.Source().Importer.Type)
+	fmt.Fprintf(ctx.Stdout, " - Origin: %s\n", snap.Source().Importer.Origin)
+	fmt.Fprintf(ctx.Stdout, " - Directory: %s\n", snap.Source().Importer.Directory)
 
 	fmt.Fprintln(ctx.Stdout, "Context:")
 	fmt.Fprintf(ctx.Stdout, " - MachineID: %s\n", header.GetContext("MachineID"))
@@ -74,34 +79,43 @@ func (cmd *InfoSnapshot) Execute(ctx *appcontext.AppContext, repo *repository.Re
 	fmt.Fprintf(ctx.Stdout, " - CommandLine: %s\n", header.GetContext("CommandLine"))
 
 	fmt.Fprintln(ctx.Stdout, "Summary:")
-	fmt.Fprintf(ctx.Stdout, " - Directories: %d\n", header.GetSource(0).Summary.Directory.Directories+header.GetSource(0).Summary.Below.Directories)
-	fmt.Fprintf(ctx.Stdout, " - Files: %d\n", header.GetSource(0).Summary.Directory.Files+header.GetSource(0).Summary.Below.Files)
-	fmt.Fprintf(ctx.Stdout, " - Symlinks: %d\n", header.GetSource(0).Summary.Directory.Symlinks+header.GetSource(0).Summary.Below.Symlinks)
-	fmt.Fprintf(ctx.Stdout, " - Devices: %d\n", header.GetSource(0).Summary.Directory.Devices+header.GetSource(0).Summary.Below.Devices)
-	fmt.Fprintf(ctx.Stdout, " - Pipes: %d\n", header.GetSource(0).Summary.Directory.Pipes+header.GetSource(0).Summary.Below.Pipes)
-	fmt.Fprintf(ctx.Stdout, " - Sockets: %d\n", header.GetSource(0).Summary.Directory.Sockets+header.GetSource(0).Summary.Below.Sockets)
-	fmt.Fprintf(ctx.Stdout, " - Setuid: %d\n", header.GetSource(0).Summary.Directory.Setuid+header.GetSource(0).Summary.Below.Setuid)
-	fmt.Fprintf(ctx.Stdout, " - Setgid: %d\n", header.GetSource(0).Summary.Directory.Setgid+header.GetSource(0).Summary.Below.Setgid)
-	fmt.Fprintf(ctx.Stdout, " - Sticky: %d\n", header.GetSource(0).Summary.Directory.Sticky+header.GetSource(0).Summary.Below.Sticky)
-
-	fmt.Fprintf(ctx.Stdout, " - Objects: %d\n", header.GetSource(0).Summary.Directory.Objects+header.GetSource(0).Summary.Below.Objects)
-	fmt.Fprintf(ctx.Stdout, " - Chunks: %d\n", header.GetSource(0).Summary.Directory.Chunks+header.GetSource(0).Summary.Below.Chunks)
-	fmt.Fprintf(ctx.Stdout, " - MinSize: %s (%d bytes)\n", humanize.Bytes(min(header.GetSource(0).Summary.Directory.MinSize, header.GetSource(0).Summary.Below.MinSize)), min(header.GetSource(0).Summary.Directory.MinSize, header.GetSource(0).Summary.Below.MinSize))
-	fmt.Fprintf(ctx.Stdout, " - MaxSize: %s (%d bytes)\n", humanize.Bytes(max(header.GetSource(0).Summary.Directory.MaxSize, header.GetSource(0).Summary.Below.MaxSize)), max(header.GetSource(0).Summary.Directory.MaxSize, header.GetSource(0).Summary.Below.MaxSize))
-	fmt.Fprintf(ctx.Stdout, " - Size: %s (%d bytes)\n", humanize.Bytes(header.GetSource(0).Summary.Directory.Size+header.GetSource(0).Summary.Below.Size), header.GetSource(0).Summary.Directory.Size+header.GetSource(0).Summary.Below.Size)
-	fmt.Fprintf(ctx.Stdout, " - MinModTime: %s\n", time.Unix(min(header.GetSource(0).Summary.Directory.MinModTime, header.GetSource(0).Summary.Below.MinModTime), 0))
-	fmt.Fprintf(ctx.Stdout, " - MaxModTime: %s\n", time.Unix(max(header.GetSource(0).Summary.Directory.MaxModTime, header.GetSource(0).Summary.Below.MaxModTime), 0))
-	fmt.Fprintf(ctx.Stdout, " - MinEntropy: %f\n", min(header.GetSource(0).Summary.Directory.MinEntropy, header.GetSource(0).Summary.Below.MinEntropy))
-	fmt.Fprintf(ctx.Stdout, " - MaxEntropy: %f\n", max(header.GetSource(0).Summary.Directory.MaxEntropy, header.GetSource(0).Summary.Below.MaxEntropy))
-	fmt.Fprintf(ctx.Stdout, " - HiEntropy: %d\n", header.GetSource(0).Summary.Directory.HiEntropy+header.GetSource(0).Summary.Below.HiEntropy)
-	fmt.Fprintf(ctx.Stdout, " - LoEntropy: %d\n", header.GetSource(0).Summary.Directory.LoEntropy+header.GetSource(0).Summary.Below.LoEntropy)
-	fmt.Fprintf(ctx.Stdout, " - MIMEAudio: %d\n", header.GetSource(0).Summary.Directory.MIMEAudio+header.GetSource(0).Summary.Below.MIMEAudio)
-	fmt.Fprintf(ctx.Stdout, " - MIMEVideo: %d\n", header.GetSource(0).Summary.Directory.MIMEVideo+header.GetSource(0).Summary.Below.MIMEVideo)
-	fmt.Fprintf(ctx.Stdout, " - MIMEImage: %d\n", header.GetSource(0).Summary.Directory.MIMEImage+header.GetSource(0).Summary.Below.MIMEImage)
-	fmt.Fprintf(ctx.Stdout, " - MIMEText: %d\n", header.GetSource(0).Summary.Directory.MIMEText+header.GetSource(0).Summary.Below.MIMEText)
-	fmt.Fprintf(ctx.Stdout, " - MIMEApplication: %d\n", header.GetSource(0).Summary.Directory.MIMEApplication+header.GetSource(0).Summary.Below.MIMEApplication)
-	fmt.Fprintf(ctx.Stdout, " - MIMEOther: %d\n", header.GetSource(0).Summary.Directory.MIMEOther+header.GetSource(0).Summary.Below.MIMEOther)
-
-	fmt.Fprintf(ctx.Stdout, " - Errors: %d\n", header.GetSource(0).Summary.Directory.Errors+header.GetSource(0).Summary.Below.Errors)
+	fmt.Fprintf(ctx.Stdout, " - Directories: %d\n", snap.Source().Summary.Directory.Directories+snap.Source().Summary.Below.Directories)
+	fmt.Fprintf(ctx.Stdout, " - Files: %d\n", snap.Source().Summary.Directory.Files+snap.Source().Summary.Below.Files)
+	fmt.Fprintf(ctx.Stdout, " - Symlinks: %d\n", snap.Source().Summary.Directory.Symlinks+snap.Source().Summary.Below.Symlinks)
+	fmt.Fprintf(ctx.Stdout, " - Devices: %d\n", snap.Source().Summary.Directory.Devices+snap.Source().Summary.Below.Devices)
+	fmt.Fprintf(ctx.Stdout, " - Pipes: %d\n", snap.Source().Summary.Directory.Pipes+snap.Source().Summary.Below.Pipes)
+	fmt.Fprintf(ctx.Stdout, " - Sockets: %d\n", snap.Source().Summary.Directory.Sockets+snap.Source().Summary.Below.Sockets)
+	fmt.Fprintf(ctx.Stdout, " - Setuid: %d\n", snap.Source().Summary.Directory.Setuid+snap.Source().Summary.Below.Setuid)
+	fmt.Fprintf(ctx.Stdout, " - Setgid: %d\n", snap.Source().Summary.Directory.Setgid+snap.Source().Summary.Below.Setgid)
+	fmt.Fprintf(ctx.Stdout, " - Sticky: %d\n", snap.Source().Summary.Directory.Sticky+snap.Source().Summary.Below.Sticky)
+
+	fmt.Fprintf(ctx.Stdout, " - Objects: %d\n", snap.Source().Summary.Directory.Objects+snap.Source().Summary.Below.Objects)
+	fmt.Fprintf(ctx.Stdout, " - Chunks: %d\n", snap.Source().Summary.Directory.Chunks+snap.Source().Summary.Below.Chunks)
+	fmt.Fprintf(ctx.Stdout, " - MinSize: %s (%d bytes)\n", humanize.Bytes(min(snap.Source().Summary.Directory.MinSize, snap.Source().Summary.Below.MinSize)), min(snap.Source().Summary.Directory.MinSize, snap.Source().Summary.Below.MinSize))
+	fmt.Fprintf(ctx.Stdout, " - MaxSize: %s (%d bytes)\n", humanize.Bytes(max(snap.Source().Summary.Directory.MaxSize, snap.Source().Summary.Below.MaxSize)), max(snap.Source().Summary.Directory.MaxSize, snap.Source().Summary.Below.MaxSize))
+	fmt.Fprintf(ctx.Stdout, " - Size: %s (%d bytes)\n", humanize.Bytes(snap.Source().Summary.Directory.Size+snap.Source().Summary.Below.Size), snap.Source().Summary.Directory.Size+snap.Source().Summary.Below.Size)
+	fmt.Fprintf(ctx.Stdout, " - MinModTime: %s\n", time.Unix(min(snap.Source().Summary.Directory.MinModTime, snap.Source().Summary.Below.MinModTime), 0))
+	fmt.Fprintf(ctx.Stdout, " - MaxModTime: %s\n", time.Unix(max(snap.Source().Summary.Directory.MaxModTime, snap.Source().Summary.Below.MaxModTime), 0))
+	fmt.Fprintf(ctx.Stdout, " - MinEntropy: %f\n", min(snap.Source().Summary.Directory.MinEntropy, snap.Source().Summary.Below.MinEntropy))
+	fmt.Fprintf(ctx.Stdout, " - MaxEntropy: %f\n", max(snap.Source().Summary.Directory.MaxEntropy, snap.Source().Summary.Below.MaxEntropy))
+	fmt.Fprintf(ctx.Stdout, " - HiEntropy: %d\n", snap.Source().Summary.Directory.HiEntropy+snap.Source().Summary.Below.HiEntropy)
+	fmt.Fprintf(ctx.Stdout, " - LoEntropy: %d\n", snap.Source().Summary.Directory.LoEntropy+snap.Source().Summary.Below.LoEntropy)
+	fmt.Fprintf(ctx.Stdout, " - MIMEAudio: %d\n", snap.Source().Summary.Directory.MIMEAudio+snap.Source().Summary.Below.MIMEAudio)
+	fmt.Fprintf(ctx.Stdout, " - MIMEVideo: %d\n", snap.Source().Summary.Directory.MIMEVideo+snap.Source().Summary.Below.MIMEVideo)
+	fmt.Fprintf(ctx.Stdout, " - MIMEImage: %d\n", snap.Source().Summary.Directory.MIMEImage+snap.Source().Summary.Below.MIMEImage)
+	fmt.Fprintf(ctx.Stdout, " - MIMEText: %d\n", snap.Source().Summary.Directory.MIMEText+snap.Source().Summary.Below.MIMEText)
+	fmt.Fprintf(ctx.Stdout, " - MIMEApplication: %d\n", snap.Source().Summary.Directory.MIMEApplication+snap.Source().Summary.Below.MIMEApplication)
+	fmt.Fprintf(ctx.Stdout, " - MIMEOther: %d\n", snap.Source().Summary.Directory.MIMEOther+snap.Source().Summary.Below.MIMEOther)
+
+	fmt.Fprintf(ctx.Stdout, " - Errors: %d\n", snap.Source().Summary.Directory.Errors+snap.Source().Summary.Below.Errors)
+
+	if len(header.Classifications) > 0 {
+		fmt.Fprintln(ctx.Stdout, "Classifications:")
+		for _, classification := range header.Classifications {
+			for _, class := range classification.Classes {
+				fmt.Fprintf(ctx.Stdout, " - %s: %s (%d files)\n", classification.Analyzer, class.Name, class.Count)
+			}
+		}
+	}
 	return 0, nil
 }
@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"io/fs"
 	"os/user"
+	"strings"
 	"time"
 
 	"github.com/PlakarKorp/plakar/appcontext"
@@ -50,6 +51,7 @@ func parse_cmd_ls(ctx *appcontext.AppContext, repo *repository.Repository, args
 	var opt_latest bool
 	var opt_uuid bool
 	var opt_recursive bool
+	var opt_class string
 
 	flags := flag.NewFlagSet("ls", flag.ExitOnError)
 	flags.Usage = func() {
@@ -69,6 +71,7 @@ func parse_cmd_ls(ctx *appcontext.AppContext, repo *repository.Repository, args
 	flags.BoolVar(&opt_latest, "latest", false, "use latest snapshot")
 	flags.BoolVar(&opt_uuid, "uuid", false, "display uuid instead of short ID")
 	flags.BoolVar(&opt_recursive, "recursive", false, "recursive listing")
+	flags.StringVar(&opt_class, "class", "", "filter by class, e.g. secret or pii/email")
 	flags.Parse(args)
 
 	if flags.NArg() > 1 {
@@ -107,6 +110,7 @@ func parse_cmd_ls(ctx *appcontext.AppContext, repo *repository.Repository, args
 		OptPerimeter:   opt_perimeter,
 		OptJob:         opt_job,
 		OptTag:         opt_tag,
+		OptClass:       opt_class,
 
 		Recursive:   opt_recursive,
 		DisplayUUID: opt_uuid,
@@ -128,6 +132,7 @@ type Ls struct {
 	OptPerimeter   string
 	OptJob         string
 	OptTag         string
+	OptClass       string
 
 	Recursive   bool
 	DisplayUUID bool
@@ -179,21 +184,33 @@ func (cmd *Ls) list_snapshots(ctx *appcontext.AppContext, repo *repository.Repos
 			return fmt.Errorf("ls: could not fetch snapshot: %w", err)
 		}
 
+		if cmd.OptClass != "" && !snap.Header.HasClass(cmd.OptClass) {
+			snap.Close()
+			continue
+		}
+
+		directories := make([]string, 0, len(snap.Header.Sources))
+		for _, source := range snap.Header.Sources {
+			directories = append(directories, source.Importer.Directory)
+		}
+
 		if !cmd.DisplayUUID {
-			fmt.Fprintf(ctx.Stdout, "%s %10s%10s%10s %s\n",
+			fmt.Fprintf(ctx.Stdout, "%s %10s %-8s%10s%10s %s\n",
 				snap.Header.Timestamp.UTC().Format(time.RFC3339),
 				hex.EncodeToString(snap.Header.GetIndexShortID()),
-				humanize.Bytes(snap.Header.GetSource(0).Summary.Directory.Size+snap.Header.GetSource(0).Summary.Below.Size),
+				snap.Header.GetStatus(),
+				humanize.Bytes(snap.Header.GetSize()),
 				snap.Header.Duration.Round(time.Second),
-				snap.Header.GetSource(0).Importer.Directory)
+				strings.Join(directories, ","))
 		} else {
 			indexID := snap.Header.GetIndexID()
-			fmt.Fprintf(ctx.Stdout, "%s %3s%10s%10s %s\n",
+			fmt.Fprintf(ctx.Stdout, "%s %3s %-8s%10s%10s %s\n",
 				snap.Header.Timestamp.UTC().Format(time.RFC3339),
 				hex.EncodeToString(indexID[:]),
-				humanize.Bytes(snap.Header.GetSource(0).Summary.Directory.Size+snap.Header.GetSource(0).Summary.Below.Size),
+				snap.Header.GetStatus(),
+				humanize.Bytes(snap.Header.GetSize()),
 				snap.Header.Duration.Round(time.Second),
-				snap.Header.GetSource(0).Importer.Directory)
+				strings.Join(directories, ","))
 		}
 
 		snap.Close()
@@ -220,6 +237,15 @@ func (cmd *Ls) list_snapshot(ctx *appcontext.AppContext, repo *repository.Reposi
 		if d.IsDir() && path == pathname {
 			return nil
 		}
+		if cmd.OptClass != "" {
+			// directories are kept to browse down to the files
+			if !d.IsDir() && !d.HasClass(cmd.OptClass) {
+				return nil
+			}
+			if d.IsDir() && recursive {
+				return nil
+			}
+		}
 
 		sb, err := d.Info()
 		if err != nil {
@@ -131,6 +131,10 @@ func (cmd *Maintenance) colourPass(ctx *appcontext.AppContext, cache *caching.Ma
 		return err
 	}
 
+	// packfiles within the retention period of an immutable repository
+	// are left alone
+	immutability := cmd.repository.Configuration().Immutability
+
 	orphanedPackfiles := 0
 	for _, packfileMAC := range repoPackfiles {
 		_, ok := packfiles[packfileMAC]
@@ -160,7 +164,7 @@ func (cmd *Maintenance) colourPass(ctx *appcontext.AppContext, cache *caching.Ma
 		}
 
 		packfileDate := time.Unix(0, packfile.Footer.Timestamp)
-		if packfileDate.Before(cmd.cutoff) {
+		if packfileDate.Before(cmd.cutoff) && !immutability.Protects(packfileDate) {
 			orphanedPackfiles++
 			packfiles[packfileMAC] = struct{}{}
 		}
@@ -178,11 +182,21 @@ func (cmd *Maintenance) colourPass(ctx *appcontext.AppContext, cache *caching.Ma
 	deltaState := cmd.repository.NewStateDelta(sc)
 
 	coloredPackfiles := 0
+	protectedPackfiles := 0
 	for packfile := range packfiles {
 		if cache.HasPackfile(packfile) {
 			continue
 		}
 
+		protected, err := cmd.repository.PackfileProtected(packfile)
+		if err != nil {
+			return err
+		}
+		if protected {
+			protectedPackfiles++
+			continue
+		}
+
 		has, err := cmd.repository.HasDeletedPackfile(packfile)
 		if err != nil {
 			return err
@@ -197,6 +211,9 @@ func (cmd *Maintenance) colourPass(ctx *appcontext.AppContext, cache *caching.Ma
 	}
 
 	fmt.Fprintf(ctx.Stdout, "maintenance: Coloured %d packfiles (%d orphaned) for deletion\n", coloredPackfiles, orphanedPackfiles)
+	if protectedPackfiles > 0 {
+		fmt.Fprintf(ctx.Stdout, "maintenance: Kept %d packfiles protected by the retention policy\n", protectedPackfiles)
+	}
 
 	if coloredPackfiles > 0 {
 		buf := &bytes.Buffer{}
@@ -0,0 +1,226 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package passphrase
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/cmd/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/cmd/plakar/utils"
+	"github.com/PlakarKorp/plakar/encryption"
+	"github.com/PlakarKorp/plakar/repository"
+)
+
+const minEntropyBits = 80
+
+func init() {
+	subcommands.Register("passphrase", parse_cmd_passphrase)
+}
+
+func parse_cmd_passphrase(ctx *appcontext.AppContext, repo *repository.Repository, args []string) (subcommands.Subcommand, error) {
+	var opt_kdf string
+	var opt_allowweak bool
+	var opt_writeonly bool
+	var opt_force bool
+
+	flags := flag.NewFlagSet("passphrase", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s list\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "       %s add [OPTIONS] NAME\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "       %s change [OPTIONS] [NAME]\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "       %s remove [-force] NAME\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&opt_kdf, "kdf", encryption.DEFAULT_KDF, "key derivation function of the new passphrase: ARGON2ID, SCRYPT or PBKDF2")
+	flags.BoolVar(&opt_allowweak, "weak-passphrase", false, "allow weak passphrase")
+	flags.BoolVar(&opt_writeonly, "write-only", false, "only allow the new passphrase to write to an asymmetric repository")
+	flags.BoolVar(&opt_force, "force", false, "change or remove the passphrase the master key is derived from")
+
+	if len(args) == 0 {
+		flags.Usage()
+		return nil, fmt.Errorf("no action specified")
+	}
+	action := args[0]
+	flags.Parse(args[1:])
+
+	if repo.Configuration().Encryption == nil {
+		return nil, fmt.Errorf("repository is not encrypted")
+	}
+
+	cmd := &Passphrase{
+		RepositoryLocation: repo.Location(),
+		RepositorySecret:   ctx.GetSecret(),
+		Action:             action,
+		KDF:                opt_kdf,
+		WriteOnly:          opt_writeonly,
+	}
+
+	if opt_writeonly {
+		if action != "add" {
+			return nil, fmt.Errorf("-write-only only applies to add")
+		}
+		if !repo.Configuration().Encryption.IsAsymmetric() {
+			return nil, fmt.Errorf("write-only passphrases require a repository created with -asymmetric")
+		}
+	}
+
+	switch action {
+	case "list":
+		if flags.NArg() != 0 {
+			return nil, fmt.Errorf("usage: %s list", flags.Name())
+		}
+		return cmd, nil
+	case "add", "remove":
+		if flags.NArg() != 1 {
+			return nil, fmt.Errorf("usage: %s %s NAME", flags.Name(), action)
+		}
+		cmd.Slot = flags.Arg(0)
+	case "change":
+		switch flags.NArg() {
+		case 0:
+			cmd.Slot = encryption.DEFAULT_KEYSLOT
+		case 1:
+			cmd.Slot = flags.Arg(0)
+		default:
+			return nil, fmt.Errorf("usage: %s change [NAME]", flags.Name())
+		}
+	default:
+		return nil, fmt.Errorf("unknown action %s", action)
+	}
+
+	// the master key of a repository created before key slots is
+	// derived from its passphrase and the salt of the configuration,
+	// so dropping that slot doesn't keep the passphrase from opening it
+	if action == "change" || action == "remove" {
+		for _, slot := range repo.Configuration().Encryption.Slots() {
+			if slot.Name == cmd.Slot && slot.Key == nil && !opt_force {
+				return nil, fmt.Errorf("the master key is derived from the passphrase of key slot %s, which will still open the repository: use plakar rekey to keep it from decrypting the repository, or -force to %s it anyway", cmd.Slot, action)
+			}
+		}
+	}
+
+	if action == "remove" {
+		return cmd, nil
+	}
+
+	if _, err := encryption.NewDefaultKDFParams(opt_kdf); err != nil {
+		return nil, err
+	}
+
+	// the new passphrase is read here as the command may run in the agent
+	minEntropy := float64(minEntropyBits)
+	if opt_allowweak {
+		minEntropy = 0
+	}
+	if envPassphrase := os.Getenv("PLAKAR_NEW_PASSPHRASE"); envPassphrase != "" {
+		cmd.NewPassphrase = []byte(envPassphrase)
+	} else {
+		for attempt := 0; attempt < 3; attempt++ {
+			passphrase, err := utils.GetPassphraseConfirm("new", minEntropy)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+				continue
+			}
+			cmd.NewPassphrase = passphrase
+			break
+		}
+	}
+	if len(cmd.NewPassphrase) == 0 {
+		return nil, fmt.Errorf("can't use an empty passphrase")
+	}
+
+	return cmd, nil
+}
+
+type Passphrase struct {
+	RepositoryLocation string
+	RepositorySecret   []byte
+
+	Action        string
+	Slot          string
+	KDF           string
+	WriteOnly     bool
+	NewPassphrase []byte
+}
+
+func (cmd *Passphrase) Name() string {
+	return "passphrase"
+}
+
+func (cmd *Passphrase) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	config := repo.Configuration()
+	if config.Encryption == nil {
+		return 1, fmt.Errorf("repository is not encrypted")
+	}
+
+	if cmd.Action == "list" {
+		for _, slot := range config.Encryption.Slots() {
+			timestamp := "-"
+			if !slot.Timestamp.IsZero() {
+				timestamp = slot.Timestamp.UTC().Format(time.RFC3339)
+			}
+			access := "full"
+			if slot.WriteOnly {
+				access = "write-only"
+			}
+			fmt.Fprintf(ctx.Stdout, "%-20s %-8s %-10s %s\n", timestamp, slot.KDFParams.KDF, access, slot.Name)
+		}
+		return 0, nil
+	}
+
+	// the configuration is shared with the repository, edit a copy
+	enc := *config.Encryption
+	config.Encryption = &enc
+
+	var err error
+	switch cmd.Action {
+	case "add", "change":
+		var kdfParams *encryption.KDFParams
+		kdfParams, err = encryption.NewDefaultKDFParams(cmd.KDF)
+		if err != nil {
+			return 1, err
+		}
+		if cmd.Action == "add" {
+			key := ctx.GetSecret()
+			if cmd.WriteOnly {
+				key = encryption.MACKey(key)
+			}
+			err = enc.AddKeySlot(cmd.Slot, kdfParams, cmd.NewPassphrase, key)
+		} else {
+			err = enc.ChangeKeySlot(cmd.Slot, kdfParams, cmd.NewPassphrase, ctx.GetSecret())
+		}
+	case "remove":
+		err = enc.RemoveKeySlot(cmd.Slot)
+	default:
+		err = fmt.Errorf("unknown action %s", cmd.Action)
+	}
+	if err != nil {
+		return 1, err
+	}
+
+	if err := repo.UpdateConfiguration(&config); err != nil {
+		return 1, fmt.Errorf("failed to update the repository configuration: %w", err)
+	}
+
+	ctx.GetLogger().Info("%s: %s key slot %s", cmd.Name(), cmd.Action, cmd.Slot)
+	return 0, nil
+}
@@ -0,0 +1,219 @@
+package passphrase
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/caching"
+	"github.com/PlakarKorp/plakar/encryption"
+	"github.com/PlakarKorp/plakar/hashing"
+	"github.com/PlakarKorp/plakar/logging"
+	"github.com/PlakarKorp/plakar/repository"
+	"github.com/PlakarKorp/plakar/resources"
+	"github.com/PlakarKorp/plakar/storage"
+	bfs "github.com/PlakarKorp/plakar/storage/backends/fs"
+	"github.com/PlakarKorp/plakar/versioning"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	os.Setenv("TZ", "UTC")
+}
+
+// generateRepository creates a repository opening with passphrase, with
+// a key slot unless legacy is set, in which case the master key is
+// derived from the passphrase as before key slots.
+func generateRepository(t *testing.T, bufOut *bytes.Buffer, bufErr *bytes.Buffer, passphrase string, legacy bool) *repository.Repository {
+	tmpRepoDir := t.TempDir() + "/repo"
+	tmpCacheDir := t.TempDir()
+
+	// create an encrypted storage
+	r, err := bfs.NewStore(map[string]string{"location": "fs://" + tmpRepoDir})
+	require.NoError(t, err)
+
+	config := storage.NewConfiguration()
+	kdfParams, err := encryption.NewDefaultKDFParams("PBKDF2")
+	require.NoError(t, err)
+	config.Encryption.KDFParams = *kdfParams
+
+	var key []byte
+	if legacy {
+		key, err = encryption.DeriveKey(*kdfParams, []byte(passphrase))
+	} else {
+		key, err = encryption.NewMasterKey()
+	}
+	require.NoError(t, err)
+	config.Encryption.Canary, err = encryption.DeriveCanary(config.Encryption, key)
+	require.NoError(t, err)
+	if !legacy {
+		slot, err := encryption.NewKeySlot(encryption.DEFAULT_KEYSLOT, kdfParams, []byte(passphrase), key)
+		require.NoError(t, err)
+		config.Encryption.KeySlots = []encryption.KeySlot{*slot}
+	}
+
+	serialized, err := config.ToBytes()
+	require.NoError(t, err)
+	hasher := hashing.GetMACHasher(storage.DEFAULT_HASHING_ALGORITHM, key)
+	wrappedConfigRd, err := storage.Serialize(hasher, resources.RT_CONFIG, versioning.GetCurrentVersion(resources.RT_CONFIG), bytes.NewReader(serialized))
+	require.NoError(t, err)
+	wrappedConfig, err := io.ReadAll(wrappedConfigRd)
+	require.NoError(t, err)
+	require.NoError(t, r.Create(wrappedConfig))
+
+	ctx := appcontext.NewAppContext()
+	ctx.Stdout = bufOut
+	ctx.Stderr = bufErr
+	ctx.SetCache(caching.NewManager(tmpCacheDir))
+	logger := logging.NewLogger(bufOut, bufErr)
+	logger.EnableInfo()
+	ctx.SetLogger(logger)
+	t.Cleanup(func() {
+		ctx.GetCache().Close()
+	})
+
+	return openRepository(t, ctx, tmpRepoDir, passphrase)
+}
+
+// openRepository opens the repository at location as plakar does,
+// unlocking it with passphrase.
+func openRepository(t *testing.T, ctx *appcontext.AppContext, location string, passphrase string) *repository.Repository {
+	store, serializedConfig, err := storage.Open(map[string]string{"location": location})
+	require.NoError(t, err)
+
+	config, err := storage.NewConfigurationFromWrappedBytes(serializedConfig)
+	require.NoError(t, err)
+
+	key, err := config.Encryption.Unlock([]byte(passphrase))
+	require.NoError(t, err)
+	ctx.SetSecret(key)
+
+	repo, err := repository.New(ctx, store, serializedConfig)
+	require.NoError(t, err)
+	return repo
+}
+
+func TestExecuteCmdPassphrase(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo := generateRepository(t, bufOut, bufErr, "first passphrase", false)
+	ctx := repo.AppContext()
+
+	t.Setenv("PLAKAR_NEW_PASSPHRASE", "second passphrase")
+	subcommand, err := parse_cmd_passphrase(ctx, repo, []string{"add", "-kdf", "PBKDF2", "team"})
+	require.NoError(t, err)
+	require.Equal(t, "passphrase", subcommand.(*Passphrase).Name())
+
+	status, err := subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	// both passphrases open the repository written to the store
+	repo = openRepository(t, ctx, repo.Location(), "first passphrase")
+	repo = openRepository(t, ctx, repo.Location(), "second passphrase")
+
+	subcommand, err = parse_cmd_passphrase(ctx, repo, []string{"list"})
+	require.NoError(t, err)
+	bufOut.Reset()
+	status, err = subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	// output should look like this
+	// 2025-03-04T10:00:00Z PBKDF2   full       default
+	// 2025-03-04T10:00:01Z PBKDF2   full       team
+	lines := strings.Split(strings.Trim(bufOut.String(), "\n"), "\n")
+	require.Len(t, lines, 2)
+	require.Equal(t, []string{"PBKDF2", "full", "default"}, strings.Fields(lines[0])[1:])
+	require.Equal(t, []string{"PBKDF2", "full", "team"}, strings.Fields(lines[1])[1:])
+
+	t.Setenv("PLAKAR_NEW_PASSPHRASE", "third passphrase")
+	subcommand, err = parse_cmd_passphrase(ctx, repo, []string{"change", "-kdf", "PBKDF2"})
+	require.NoError(t, err)
+	status, err = subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	subcommand, err = parse_cmd_passphrase(ctx, repo, []string{"remove", "team"})
+	require.NoError(t, err)
+	status, err = subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	_, serializedConfig, err := storage.Open(map[string]string{"location": repo.Location()})
+	require.NoError(t, err)
+	config, err := storage.NewConfigurationFromWrappedBytes(serializedConfig)
+	require.NoError(t, err)
+	for _, passphrase := range []string{"first passphrase", "second passphrase"} {
+		_, err := config.Encryption.Unlock([]byte(passphrase))
+		require.ErrorIs(t, err, encryption.ErrInvalidPassphrase)
+	}
+	repo = openRepository(t, ctx, repo.Location(), "third passphrase")
+
+	subcommand, err = parse_cmd_passphrase(ctx, repo, []string{"remove", encryption.DEFAULT_KEYSLOT})
+	require.NoError(t, err)
+	status, err = subcommand.Execute(ctx, repo)
+	require.ErrorIs(t, err, encryption.ErrLastKeySlot)
+	require.Equal(t, 1, status)
+}
+
+func TestExecuteCmdPassphraseLegacy(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo := generateRepository(t, bufOut, bufErr, "first passphrase", true)
+	ctx := repo.AppContext()
+
+	t.Setenv("PLAKAR_NEW_PASSPHRASE", "second passphrase")
+	subcommand, err := parse_cmd_passphrase(ctx, repo, []string{"add", "-kdf", "PBKDF2", "team"})
+	require.NoError(t, err)
+	status, err := subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	// the passphrase the master key is derived from can't be dropped
+	// without -force, as it would still open the repository
+	for _, args := range [][]string{
+		{"change", "-kdf", "PBKDF2"},
+		{"remove", encryption.DEFAULT_KEYSLOT},
+	} {
+		_, err := parse_cmd_passphrase(ctx, repo, args)
+		require.ErrorContains(t, err, "plakar rekey", args)
+	}
+
+	subcommand, err = parse_cmd_passphrase(ctx, repo, []string{"remove", "-force", encryption.DEFAULT_KEYSLOT})
+	require.NoError(t, err)
+	status, err = subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	repo = openRepository(t, ctx, repo.Location(), "second passphrase")
+	require.Len(t, repo.Configuration().Encryption.Slots(), 1)
+}
+
+func TestParseCmdPassphraseErrors(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo := generateRepository(t, bufOut, bufErr, "passphrase", false)
+	ctx := repo.AppContext()
+
+	t.Setenv("PLAKAR_NEW_PASSPHRASE", "new passphrase")
+	for _, args := range [][]string{
+		{"rotate"},
+		{"add"},
+		{"remove"},
+		{"list", "extra"},
+		{"change", "a", "b"},
+		{"add", "-kdf", "BCRYPT", "team"},
+		{"add", "-write-only", "team"},
+		{"change", "-write-only"},
+	} {
+		_, err := parse_cmd_passphrase(ctx, repo, args)
+		require.Error(t, err, args)
+	}
+}
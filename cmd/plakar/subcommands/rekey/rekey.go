@@ -0,0 +1,555 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package rekey
+
+import (
+	"bytes"
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/cmd/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/cmd/plakar/utils"
+	"github.com/PlakarKorp/plakar/encryption"
+	"github.com/PlakarKorp/plakar/objects"
+	"github.com/PlakarKorp/plakar/repository"
+	"github.com/PlakarKorp/plakar/snapshot"
+	"github.com/PlakarKorp/plakar/storage"
+	"github.com/google/uuid"
+	"golang.org/x/sync/errgroup"
+)
+
+const minEntropyBits = 80
+
+func init() {
+	subcommands.Register("rekey", parse_cmd_rekey)
+}
+
+func parse_cmd_rekey(ctx *appcontext.AppContext, repo *repository.Repository, args []string) (subcommands.Subcommand, error) {
+	var opt_kdf string
+	var opt_allowweak bool
+	var opt_to string
+
+	flags := flag.NewFlagSet("rekey", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS]\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&opt_kdf, "kdf", encryption.DEFAULT_KDF, "key derivation function of the new passphrase: ARGON2ID, SCRYPT or PBKDF2")
+	flags.BoolVar(&opt_allowweak, "weak-passphrase", false, "allow weak passphrase")
+	flags.StringVar(&opt_to, "to", "", "copy the repository to this location with new MAC and data keys instead of rekeying it in place")
+	flags.Parse(args)
+
+	if flags.NArg() != 0 {
+		return nil, fmt.Errorf("usage: %s [OPTIONS]", flags.Name())
+	}
+
+	config := repo.Configuration()
+	if config.Encryption == nil {
+		return nil, repository.ErrNotEncrypted
+	}
+	if config.Encryption.IsAsymmetric() {
+		return nil, encryption.ErrRekeyAsymmetric
+	}
+	if config.Rekey != nil && opt_to != "" {
+		return nil, fmt.Errorf("%w before rekeying to another location", repository.ErrRekeyInProgress)
+	}
+	if _, err := encryption.NewDefaultKDFParams(opt_kdf); err != nil {
+		return nil, err
+	}
+
+	cmd := &Rekey{
+		RepositoryLocation: repo.Location(),
+		RepositorySecret:   ctx.GetSecret(),
+		KDF:                opt_kdf,
+		To:                 opt_to,
+	}
+
+	// an interrupted rekey is resumed with the passphrase it was
+	// started with, which isn't confirmed again
+	resuming := config.Rekey != nil
+	if cmd.To != "" {
+		storeConfig, err := destination(ctx, cmd.To)
+		if err != nil {
+			return nil, err
+		}
+		if store, serializedConfig, err := storage.Open(storeConfig); err == nil {
+			dstConfig, err := storage.NewConfigurationFromWrappedBytes(serializedConfig)
+			store.Close()
+			if err != nil {
+				return nil, err
+			}
+			resuming = dstConfig.Rekey != nil
+		}
+	}
+
+	// the new passphrase is read before anything is rewritten
+	minEntropy := float64(minEntropyBits)
+	if opt_allowweak {
+		minEntropy = 0
+	}
+	if envPassphrase := os.Getenv("PLAKAR_NEW_PASSPHRASE"); envPassphrase != "" {
+		cmd.NewPassphrase = []byte(envPassphrase)
+	} else if resuming {
+		passphrase, err := utils.GetPassphrase("new")
+		if err != nil {
+			return nil, err
+		}
+		cmd.NewPassphrase = passphrase
+	} else {
+		for attempt := 0; attempt < 3; attempt++ {
+			passphrase, err := utils.GetPassphraseConfirm("new", minEntropy)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+				continue
+			}
+			cmd.NewPassphrase = passphrase
+			break
+		}
+	}
+	if len(cmd.NewPassphrase) == 0 {
+		return nil, fmt.Errorf("can't use an empty passphrase")
+	}
+
+	return cmd, nil
+}
+
+type Rekey struct {
+	RepositoryLocation string
+	RepositorySecret   []byte
+
+	KDF           string
+	To            string
+	NewPassphrase []byte
+
+	repository *repository.Repository
+	rekey      *repository.Rekey
+	rekeyID    objects.MAC
+}
+
+func (cmd *Rekey) Name() string {
+	return "rekey"
+}
+
+// destination returns the store configuration of location, which may
+// name a configured repository as @name.
+func destination(ctx *appcontext.AppContext, location string) (map[string]string, error) {
+	if !strings.HasPrefix(location, "@") {
+		return map[string]string{"location": location}, nil
+	}
+
+	storeConfig, ok := ctx.Config.GetRepository(location[1:])
+	if !ok {
+		return nil, fmt.Errorf("could not resolve repository: %s", location)
+	}
+	if _, ok := storeConfig["location"]; !ok {
+		return nil, fmt.Errorf("could not resolve repository location: %s", location)
+	}
+	return storeConfig, nil
+}
+
+// pending returns the encryption of a new rekey, with newSecret wrapped
+// by the new passphrase alone: the other key slots, and the current
+// key, are what a rekey revokes.
+func (cmd *Rekey) pending(config *storage.Configuration, newSecret []byte) (*encryption.Configuration, error) {
+	kdfParams, err := encryption.NewDefaultKDFParams(cmd.KDF)
+	if err != nil {
+		return nil, err
+	}
+
+	slot, err := encryption.NewKeySlot(encryption.DEFAULT_KEYSLOT, kdfParams, cmd.NewPassphrase, newSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	enc := *config.Encryption
+	enc.KDFParams = *kdfParams
+	enc.KeySlots = []encryption.KeySlot{*slot}
+	enc.Canary, err = encryption.DeriveCanary(&enc, newSecret)
+	if err != nil {
+		return nil, err
+	}
+	return &enc, nil
+}
+
+func (cmd *Rekey) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	cmd.repository = repo
+
+	config := repo.Configuration()
+	if config.Encryption == nil {
+		return 1, repository.ErrNotEncrypted
+	}
+
+	// an immutable repository can only be rekeyed to a copy
+	if config.Immutability != nil && cmd.To == "" {
+		return 1, fmt.Errorf("%w: use -to to rekey it to another repository", storage.ErrImmutable)
+	}
+
+	if cmd.To != "" {
+		return cmd.copy(ctx, repo)
+	}
+
+	dstConfig := config
+	var newSecret []byte
+	if dstConfig.Rekey != nil {
+		var err error
+		newSecret, err = dstConfig.Rekey.Unlock(cmd.NewPassphrase)
+		if err != nil {
+			return 1, fmt.Errorf("could not resume the rekey in progress: %w", err)
+		}
+	} else {
+		var err error
+		newSecret, err = encryption.NewRekeyedSecret(config.Encryption, ctx.GetSecret())
+		if err != nil {
+			return 1, err
+		}
+		dstConfig.Rekey, err = cmd.pending(&config, newSecret)
+		if err != nil {
+			return 1, err
+		}
+	}
+
+	rekey, err := repo.NewRekey(repo.Store(), dstConfig.Rekey, newSecret)
+	if err != nil {
+		return 1, err
+	}
+	cmd.rekey = rekey
+
+	done, err := cmd.Lock()
+	if err != nil {
+		return 1, err
+	}
+	defer cmd.Unlock(done)
+
+	// recorded before the first object is rewritten, so that the
+	// repository is only opened to complete the rekey from then on
+	if config.Rekey == nil {
+		if err := repo.UpdateConfiguration(&dstConfig); err != nil {
+			return 1, fmt.Errorf("failed to update the repository configuration: %w", err)
+		}
+	}
+
+	packfiles, err := repo.GetPackfiles()
+	if err != nil {
+		return 1, err
+	}
+	rekeyed, err := cmd.run(ctx, packfiles, rekey.Packfile)
+	if err != nil {
+		return 1, err
+	}
+	total := len(packfiles)
+
+	locks, err := repo.GetLocks()
+	if err != nil {
+		return 1, err
+	}
+	others := make([]objects.MAC, 0, len(locks))
+	for _, lockID := range locks {
+		if lockID != cmd.rekeyID {
+			others = append(others, lockID)
+		}
+	}
+	n, err := cmd.run(ctx, others, rekey.Lock)
+	if err != nil {
+		return 1, err
+	}
+	rekeyed += n
+	total += len(others)
+
+	states, err := repo.GetStates()
+	if err != nil {
+		return 1, err
+	}
+	n, err = cmd.run(ctx, states, rekey.State)
+	if err != nil {
+		return 1, err
+	}
+	rekeyed += n
+	total += len(states)
+
+	// every object now decrypts with the new key, switch to it
+	dstConfig.Encryption = dstConfig.Rekey
+	dstConfig.Rekey = nil
+	if err := repo.UpdateConfiguration(&dstConfig); err != nil {
+		return 1, fmt.Errorf("failed to update the repository configuration: %w", err)
+	}
+	ctx.SetSecret(newSecret)
+
+	ctx.GetLogger().Info("%s: %d objects rekeyed, %d already were, at %s", cmd.Name(), rekeyed, total-rekeyed, repo.Location())
+	return 0, nil
+}
+
+// copy writes the snapshots of the repository to a new one at cmd.To,
+// with a new master key: unlike a rekey in place, the MAC key changes
+// too, so every blob, packfile and state is rewritten with new MACs.
+// The new repository records its encryption as pending until all the
+// snapshots are copied, so that an interrupted copy can be resumed.
+func (cmd *Rekey) copy(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	config := repo.Configuration()
+
+	storeConfig, err := destination(ctx, cmd.To)
+	if err != nil {
+		return 1, err
+	}
+
+	var dstConfig *storage.Configuration
+	var newSecret []byte
+	store, serializedConfig, err := storage.Open(storeConfig)
+	if err == nil {
+		dstConfig, err = storage.NewConfigurationFromWrappedBytes(serializedConfig)
+		if err != nil {
+			store.Close()
+			return 1, err
+		}
+		// only a copy in progress is encrypted with its pending key
+		if dstConfig.Rekey == nil || dstConfig.Encryption == nil ||
+			!bytes.Equal(dstConfig.Rekey.Canary, dstConfig.Encryption.Canary) {
+			store.Close()
+			return 1, fmt.Errorf("a repository already exists at %s", cmd.To)
+		}
+		newSecret, err = dstConfig.Rekey.Unlock(cmd.NewPassphrase)
+		if err != nil {
+			store.Close()
+			return 1, fmt.Errorf("could not resume the rekey in progress: %w", err)
+		}
+	} else {
+		newSecret, err = encryption.NewMasterKey()
+		if err != nil {
+			return 1, err
+		}
+		enc, err := cmd.pending(&config, newSecret)
+		if err != nil {
+			return 1, err
+		}
+
+		dstConfig = &config
+		dstConfig.Timestamp = time.Now()
+		dstConfig.RepositoryID = uuid.Must(uuid.NewRandom())
+		dstConfig.Encryption = enc
+		dstConfig.Rekey = enc
+
+		serializedConfig, err = repository.WrapConfiguration(dstConfig, newSecret)
+		if err != nil {
+			return 1, err
+		}
+		store, err = storage.Create(storeConfig, serializedConfig)
+		if err != nil {
+			return 1, fmt.Errorf("could not create repository: %w", err)
+		}
+	}
+	defer store.Close()
+
+	dstCtx := appcontext.NewAppContextFrom(ctx)
+	dstCtx.SetSecret(newSecret)
+	dst, err := repository.NewNoRebuild(dstCtx, store, serializedConfig)
+	if err != nil {
+		return 1, err
+	}
+	if err := dst.RebuildState(); err != nil {
+		return 1, err
+	}
+
+	// the snapshots of the repository are read in a consistent state
+	if err := repo.RebuildState(); err != nil {
+		return 1, err
+	}
+	done, err := cmd.Lock()
+	if err != nil {
+		return 1, err
+	}
+	defer cmd.Unlock(done)
+
+	snapshotIDs, err := repo.GetSnapshots()
+	if err != nil {
+		return 1, err
+	}
+	copied := make(map[objects.MAC]struct{})
+	dstSnapshotIDs, err := dst.GetSnapshots()
+	if err != nil {
+		return 1, err
+	}
+	for _, snapshotID := range dstSnapshotIDs {
+		copied[snapshotID] = struct{}{}
+	}
+
+	n := 0
+	for _, snapshotID := range snapshotIDs {
+		if _, ok := copied[snapshotID]; ok {
+			continue
+		}
+		if err := copySnapshot(repo, dst, snapshotID); err != nil {
+			return 1, fmt.Errorf("could not copy snapshot %x: %w", snapshotID[:4], err)
+		}
+		n++
+	}
+
+	// every snapshot is copied, the new repository can be used
+	dstConfig.Rekey = nil
+	if err := dst.UpdateConfiguration(dstConfig); err != nil {
+		return 1, fmt.Errorf("failed to update the repository configuration: %w", err)
+	}
+
+	ctx.GetLogger().Info("%s: %d snapshots copied with new keys, %d already were, to %s", cmd.Name(), n, len(snapshotIDs)-n, dst.Location())
+	return 0, nil
+}
+
+// copySnapshot copies a snapshot as plakar sync does, every MAC being
+// computed again with the key of dst.
+func copySnapshot(src, dst *repository.Repository, snapshotID objects.MAC) error {
+	srcSnapshot, err := snapshot.Load(src, snapshotID)
+	if err != nil {
+		return err
+	}
+	defer srcSnapshot.Close()
+
+	dstSnapshot, err := snapshot.New(dst)
+	if err != nil {
+		return err
+	}
+	defer dstSnapshot.Close()
+
+	dstSnapshot.Header = srcSnapshot.Header
+	if err := srcSnapshot.Synchronize(dstSnapshot); err != nil {
+		return err
+	}
+	return dstSnapshot.Commit(nil)
+}
+
+// run rekeys objects concurrently, and returns how many had to be.
+func (cmd *Rekey) run(ctx *appcontext.AppContext, macs []objects.MAC, rekey func(objects.MAC) (bool, error)) (int, error) {
+	var rekeyed atomic.Int64
+
+	var wg errgroup.Group
+	if ctx.MaxConcurrency > 0 {
+		wg.SetLimit(ctx.MaxConcurrency)
+	}
+	for _, mac := range macs {
+		wg.Go(func() error {
+			done, err := rekey(mac)
+			if err != nil {
+				return err
+			}
+			if done {
+				rekeyed.Add(1)
+			}
+			return nil
+		})
+	}
+	err := wg.Wait()
+	return int(rekeyed.Load()), err
+}
+
+// Lock takes an exclusive lock on the repository, which locks written
+// by an interrupted rekey in place may have been encrypted with the new
+// key.
+func (cmd *Rekey) Lock() (chan bool, error) {
+	n, err := rand.Read(cmd.rekeyID[:])
+	if err != nil {
+		return nil, err
+	}
+	if n != len(cmd.rekeyID) {
+		return nil, io.ErrShortWrite
+	}
+
+	getLock := cmd.repository.GetLock
+	if cmd.rekey != nil {
+		getLock = cmd.rekey.GetLock
+	}
+
+	lock := repository.NewExclusiveLock(cmd.repository.AppContext().Hostname)
+
+	buffer := &bytes.Buffer{}
+	err = lock.SerializeToStream(buffer)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cmd.repository.PutLock(cmd.rekeyID, buffer)
+	if err != nil {
+		return nil, err
+	}
+
+	locksID, err := cmd.repository.GetLocks()
+	if err != nil {
+		cmd.repository.DeleteLock(cmd.rekeyID)
+		return nil, err
+	}
+
+	for _, lockID := range locksID {
+		if lockID == cmd.rekeyID {
+			continue
+		}
+
+		version, rd, err := getLock(lockID)
+		if err != nil {
+			cmd.repository.DeleteLock(cmd.rekeyID)
+			return nil, err
+		}
+
+		lock, err := repository.NewLockFromStream(version, rd)
+		if err != nil {
+			cmd.repository.DeleteLock(cmd.rekeyID)
+			return nil, err
+		}
+
+		if lock.IsStale() {
+			if err := cmd.repository.DeleteLock(lockID); err != nil {
+				cmd.repository.DeleteLock(cmd.rekeyID)
+				return nil, err
+			}
+			continue
+		}
+
+		if err := cmd.repository.DeleteLock(cmd.rekeyID); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("Can't take exclusive lock, repository is already locked")
+	}
+
+	lockDone := make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-lockDone:
+				cmd.repository.DeleteLock(cmd.rekeyID)
+				return
+			case <-time.After(repository.LOCK_REFRESH_RATE):
+				lock := repository.NewExclusiveLock(cmd.repository.AppContext().Hostname)
+
+				buffer := &bytes.Buffer{}
+
+				// errors are ignored as in maintenance, a lock that
+				// isn't refreshed gets removed by the watchdog
+				lock.SerializeToStream(buffer)
+				cmd.repository.PutLock(cmd.rekeyID, buffer)
+			}
+		}
+	}()
+
+	return lockDone, nil
+}
+
+func (cmd *Rekey) Unlock(ping chan bool) {
+	close(ping)
+}
@@ -0,0 +1,305 @@
+package rekey
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/caching"
+	"github.com/PlakarKorp/plakar/encryption"
+	"github.com/PlakarKorp/plakar/hashing"
+	"github.com/PlakarKorp/plakar/logging"
+	"github.com/PlakarKorp/plakar/repository"
+	"github.com/PlakarKorp/plakar/resources"
+	"github.com/PlakarKorp/plakar/snapshot"
+	"github.com/PlakarKorp/plakar/snapshot/importer/fs"
+	"github.com/PlakarKorp/plakar/storage"
+	bfs "github.com/PlakarKorp/plakar/storage/backends/fs"
+	"github.com/PlakarKorp/plakar/versioning"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	os.Setenv("TZ", "UTC")
+}
+
+// generateSnapshot backs up a file to a new repository encrypted with
+// passphrase, and returns the repository with the path of the file.
+func generateSnapshot(t *testing.T, bufOut *bytes.Buffer, bufErr *bytes.Buffer, passphrase string) (*repository.Repository, string) {
+	tmpRepoDir := t.TempDir() + "/repo"
+	tmpBackupDir := t.TempDir()
+	require.NoError(t, os.WriteFile(tmpBackupDir+"/dummy.txt", []byte("hello dummy"), 0644))
+
+	r, err := bfs.NewStore(map[string]string{"location": "fs://" + tmpRepoDir})
+	require.NoError(t, err)
+
+	config := storage.NewConfiguration()
+	kdfParams, err := encryption.NewDefaultKDFParams("PBKDF2")
+	require.NoError(t, err)
+	config.Encryption.KDFParams = *kdfParams
+
+	key, err := encryption.NewMasterKey()
+	require.NoError(t, err)
+	config.Encryption.Canary, err = encryption.DeriveCanary(config.Encryption, key)
+	require.NoError(t, err)
+	slot, err := encryption.NewKeySlot(encryption.DEFAULT_KEYSLOT, kdfParams, []byte(passphrase), key)
+	require.NoError(t, err)
+	config.Encryption.KeySlots = []encryption.KeySlot{*slot}
+
+	serialized, err := config.ToBytes()
+	require.NoError(t, err)
+	hasher := hashing.GetMACHasher(storage.DEFAULT_HASHING_ALGORITHM, key)
+	wrappedConfigRd, err := storage.Serialize(hasher, resources.RT_CONFIG, versioning.GetCurrentVersion(resources.RT_CONFIG), bytes.NewReader(serialized))
+	require.NoError(t, err)
+	wrappedConfig, err := io.ReadAll(wrappedConfigRd)
+	require.NoError(t, err)
+	require.NoError(t, r.Create(wrappedConfig))
+
+	ctx := newContext(t, bufOut, bufErr)
+	repo := openRepository(t, ctx, tmpRepoDir, passphrase, false)
+
+	snap, err := snapshot.New(repo)
+	require.NoError(t, err)
+	imp, err := fs.NewFSImporter(map[string]string{"location": tmpBackupDir})
+	require.NoError(t, err)
+	require.NoError(t, snap.Backup(imp, &snapshot.BackupOptions{Name: "test_backup", MaxConcurrency: 1}))
+	snap.Close()
+
+	return repo, tmpBackupDir + "/dummy.txt"
+}
+
+// newContext returns a context with a cache of its own, which doesn't
+// hold the states of previously opened repositories.
+func newContext(t *testing.T, bufOut *bytes.Buffer, bufErr *bytes.Buffer) *appcontext.AppContext {
+	ctx := appcontext.NewAppContext()
+	ctx.Stdout = bufOut
+	ctx.Stderr = bufErr
+	ctx.MaxConcurrency = 2
+	ctx.SetCache(caching.NewManager(t.TempDir()))
+	logger := logging.NewLogger(bufOut, bufErr)
+	logger.EnableInfo()
+	ctx.SetLogger(logger)
+	t.Cleanup(func() {
+		ctx.GetCache().Close()
+	})
+	return ctx
+}
+
+// openRepository opens the repository at location as plakar does,
+// without rebuilding its state for rekey.
+func openRepository(t *testing.T, ctx *appcontext.AppContext, location string, passphrase string, rekey bool) *repository.Repository {
+	store, serializedConfig, err := storage.Open(map[string]string{"location": location})
+	require.NoError(t, err)
+
+	config, err := storage.NewConfigurationFromWrappedBytes(serializedConfig)
+	require.NoError(t, err)
+
+	key, err := config.Encryption.Unlock([]byte(passphrase))
+	require.NoError(t, err)
+	ctx.SetSecret(key)
+
+	var repo *repository.Repository
+	if rekey {
+		repo, err = repository.NewNoRebuild(ctx, store, serializedConfig)
+	} else {
+		repo, err = repository.New(ctx, store, serializedConfig)
+	}
+	require.NoError(t, err)
+	return repo
+}
+
+// readBack checks that the backed up file reads back from repo.
+func readBack(t *testing.T, repo *repository.Repository, pathname string) {
+	snapshots, err := repo.GetSnapshots()
+	require.NoError(t, err)
+	require.Len(t, snapshots, 1)
+
+	snap, err := snapshot.Load(repo, snapshots[0])
+	require.NoError(t, err)
+	defer snap.Close()
+
+	fs, err := snap.Filesystem()
+	require.NoError(t, err)
+	entry, err := fs.GetEntry(pathname)
+	require.NoError(t, err)
+	content, err := io.ReadAll(entry.Open(fs, pathname))
+	require.NoError(t, err)
+	require.Equal(t, "hello dummy", string(content))
+}
+
+func rekey(t *testing.T, ctx *appcontext.AppContext, repo *repository.Repository, passphrase string, args ...string) (int, error) {
+	t.Setenv("PLAKAR_NEW_PASSPHRASE", passphrase)
+	subcommand, err := parse_cmd_rekey(ctx, repo, append([]string{"-kdf", "PBKDF2"}, args...))
+	require.NoError(t, err)
+	require.Equal(t, "rekey", subcommand.(*Rekey).Name())
+	return subcommand.Execute(ctx, repo)
+}
+
+func TestExecuteCmdRekey(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, pathname := generateSnapshot(t, bufOut, bufErr, "old passphrase")
+	location := repo.Location()
+
+	repo = openRepository(t, newContext(t, bufOut, bufErr), location, "old passphrase", true)
+	status, err := rekey(t, repo.AppContext(), repo, "new passphrase")
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	_, serializedConfig, err := storage.Open(map[string]string{"location": location})
+	require.NoError(t, err)
+	config, err := storage.NewConfigurationFromWrappedBytes(serializedConfig)
+	require.NoError(t, err)
+	require.Nil(t, config.Rekey)
+	_, err = config.Encryption.Unlock([]byte("old passphrase"))
+	require.ErrorIs(t, err, encryption.ErrInvalidPassphrase)
+
+	repo = openRepository(t, newContext(t, bufOut, bufErr), location, "new passphrase", false)
+	readBack(t, repo, pathname)
+}
+
+func TestExecuteCmdRekeyTo(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, pathname := generateSnapshot(t, bufOut, bufErr, "old passphrase")
+	location := repo.Location()
+	dest := t.TempDir() + "/rekeyed"
+
+	repo = openRepository(t, newContext(t, bufOut, bufErr), location, "old passphrase", true)
+	status, err := rekey(t, repo.AppContext(), repo, "new passphrase", "-to", dest)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	// the source is left as it was
+	repo = openRepository(t, newContext(t, bufOut, bufErr), location, "old passphrase", false)
+	readBack(t, repo, pathname)
+
+	oldSecret := repo.AppContext().GetSecret()
+	oldPackfiles, err := repo.GetPackfiles()
+	require.NoError(t, err)
+
+	rekeyed := openRepository(t, newContext(t, bufOut, bufErr), dest, "new passphrase", false)
+	readBack(t, rekeyed, pathname)
+
+	// the copy has a MAC key of its own, every object is renamed
+	newSecret := rekeyed.AppContext().GetSecret()
+	require.NotEqual(t, encryption.MACKey(oldSecret), encryption.MACKey(newSecret))
+	require.NotEqual(t, repo.Configuration().RepositoryID, rekeyed.Configuration().RepositoryID)
+	packfiles, err := rekeyed.GetPackfiles()
+	require.NoError(t, err)
+	require.NotEmpty(t, packfiles)
+	for _, mac := range packfiles {
+		require.NotContains(t, oldPackfiles, mac)
+	}
+
+	// states keep the names of their snapshots, but not their MACs
+	states, err := rekeyed.GetStates()
+	require.NoError(t, err)
+	require.NotEmpty(t, states)
+	for _, mac := range states {
+		rd, err := rekeyed.Store().GetState(mac)
+		require.NoError(t, err)
+		_, rd, err = storage.Deserialize(hashing.GetMACHasher(storage.DEFAULT_HASHING_ALGORITHM, encryption.MACKey(oldSecret)), resources.RT_STATE, rd)
+		if err == nil {
+			_, err = io.ReadAll(rd)
+		}
+		require.Error(t, err)
+	}
+
+	// an interrupted copy is resumed, skipping the copied snapshots
+	config := rekeyed.Configuration()
+	config.Rekey = config.Encryption
+	require.NoError(t, rekeyed.UpdateConfiguration(&config))
+
+	bufOut.Reset()
+	status, err = rekey(t, repo.AppContext(), repo, "new passphrase", "-to", dest)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "0 snapshots copied with new keys, 1 already were")
+
+	rekeyed = openRepository(t, newContext(t, bufOut, bufErr), dest, "new passphrase", false)
+	readBack(t, rekeyed, pathname)
+
+	// a repository that isn't being rekeyed is never overwritten
+	repo = openRepository(t, newContext(t, bufOut, bufErr), location, "old passphrase", true)
+	status, err = rekey(t, repo.AppContext(), repo, "other passphrase", "-to", dest)
+	require.Error(t, err)
+	require.Equal(t, 1, status)
+}
+
+func TestExecuteCmdRekeyResume(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, pathname := generateSnapshot(t, bufOut, bufErr, "old passphrase")
+	location := repo.Location()
+	ctx := newContext(t, bufOut, bufErr)
+	repo = openRepository(t, ctx, location, "old passphrase", true)
+
+	// start a rekey and stop it after the first packfile
+	t.Setenv("PLAKAR_NEW_PASSPHRASE", "new passphrase")
+	subcommand, err := parse_cmd_rekey(ctx, repo, []string{"-kdf", "PBKDF2"})
+	require.NoError(t, err)
+	config := repo.Configuration()
+	secret, err := encryption.NewRekeyedSecret(config.Encryption, ctx.GetSecret())
+	require.NoError(t, err)
+	enc, err := subcommand.(*Rekey).pending(&config, secret)
+	require.NoError(t, err)
+	config.Rekey = enc
+	require.NoError(t, repo.UpdateConfiguration(&config))
+
+	rk, err := repo.NewRekey(repo.Store(), enc, secret)
+	require.NoError(t, err)
+	packfiles, err := repo.GetPackfiles()
+	require.NoError(t, err)
+	require.NotEmpty(t, packfiles)
+	done, err := rk.Packfile(packfiles[0])
+	require.NoError(t, err)
+	require.True(t, done)
+	done, err = rk.Packfile(packfiles[0])
+	require.NoError(t, err)
+	require.False(t, done)
+
+	// the repository can only be opened to complete the rekey
+	store, serializedConfig, err := storage.Open(map[string]string{"location": location})
+	require.NoError(t, err)
+	other := newContext(t, bufOut, bufErr)
+	other.SetSecret(ctx.GetSecret())
+	_, err = repository.New(other, store, serializedConfig)
+	require.ErrorIs(t, err, repository.ErrRekeyInProgress)
+
+	repo = openRepository(t, newContext(t, bufOut, bufErr), location, "old passphrase", true)
+	status, err := rekey(t, repo.AppContext(), repo, "wrong passphrase")
+	require.ErrorIs(t, err, encryption.ErrInvalidPassphrase)
+	require.Equal(t, 1, status)
+
+	bufOut.Reset()
+	status, err = rekey(t, repo.AppContext(), repo, "new passphrase")
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "1 already were")
+
+	repo = openRepository(t, newContext(t, bufOut, bufErr), location, "new passphrase", false)
+	readBack(t, repo, pathname)
+}
+
+func TestParseCmdRekeyErrors(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, _ := generateSnapshot(t, bufOut, bufErr, "passphrase")
+	ctx := repo.AppContext()
+
+	t.Setenv("PLAKAR_NEW_PASSPHRASE", "new passphrase")
+	for _, args := range [][]string{
+		{"extra"},
+		{"-kdf", "BCRYPT"},
+	} {
+		_, err := parse_cmd_rekey(ctx, repo, args)
+		require.Error(t, err, args)
+	}
+}
@@ -19,21 +19,58 @@ package restore
 import (
 	"flag"
 	"fmt"
+	"path"
 	"strings"
 	"time"
 
 	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/plakar/cmd/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/cmd/plakar/utils"
+	"github.com/PlakarKorp/plakar/objects"
 	"github.com/PlakarKorp/plakar/repository"
 	"github.com/PlakarKorp/plakar/snapshot"
 	"github.com/PlakarKorp/plakar/snapshot/exporter"
+	"github.com/dustin/go-humanize"
+	"github.com/gobwas/glob"
 )
 
 func init() {
 	subcommands.Register("restore", parse_cmd_restore)
 }
 
+type listFlags []string
+
+func (l *listFlags) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlags) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// compilePatterns compiles the include or exclude lists, as told by
+// kind.  It validates them at parse time and builds them at execution,
+// the compiled patterns can't be sent to the agent.
+func compilePatterns(kind string, globs, regexps []string) ([]glob.Glob, error) {
+	patterns := []glob.Glob{}
+	for _, item := range globs {
+		g, err := glob.Compile(item)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile %s pattern: %s", kind, item)
+		}
+		patterns = append(patterns, g)
+	}
+	for _, item := range regexps {
+		g, err := snapshot.CompileRegexp(item)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile regex: %s: %w", item, err)
+		}
+		patterns = append(patterns, g)
+	}
+	return patterns, nil
+}
+
 func parse_cmd_restore(ctx *appcontext.AppContext, repo *repository.Repository, args []string) (subcommands.Subcommand, error) {
 	var opt_name string
 	var opt_category string
@@ -46,6 +83,22 @@ func parse_cmd_restore(ctx *appcontext.AppContext, repo *repository.Repository,
 	var opt_concurrency uint64
 	var opt_quiet bool
 	var opt_silent bool
+	var opt_noOwner bool
+	var opt_noXattrs bool
+	var opt_mode string
+	var opt_dryRun bool
+	var opt_include listFlags
+	var opt_exclude listFlags
+	var opt_includeRegex listFlags
+	var opt_excludeRegex listFlags
+	var opt_rename listFlags
+	var opt_mime string
+	var opt_minSize string
+	var opt_maxSize string
+	var opt_newer string
+	var opt_older string
+	var opt_asOf string
+	var opt_check bool
 
 	flags := flag.NewFlagSet("restore", flag.ExitOnError)
 	flags.Usage = func() {
@@ -65,14 +118,91 @@ func parse_cmd_restore(ctx *appcontext.AppContext, repo *repository.Repository,
 	flags.StringVar(&pullPath, "to", "", "base directory where pull will restore")
 	flags.BoolVar(&opt_quiet, "quiet", false, "do not print progress")
 	flags.BoolVar(&opt_silent, "silent", false, "do not print ANY progress")
+	flags.BoolVar(&opt_noOwner, "no-owner", false, "do not restore file ownership")
+	flags.BoolVar(&opt_noXattrs, "no-xattrs", false, "do not restore extended attributes")
+	flags.StringVar(&opt_mode, "mode", string(snapshot.RestoreOverwrite), "how to handle existing files: skip, overwrite, update or verify")
+	flags.BoolVar(&opt_dryRun, "dry-run", false, "list what would be restored without writing anything")
+	flags.Var(&opt_include, "include", "glob pattern of files to restore, can be specified multiple times")
+	flags.Var(&opt_exclude, "exclude", "glob pattern of files to skip, can be specified multiple times")
+	flags.Var(&opt_includeRegex, "include-regex", "regex of files to restore, can be specified multiple times")
+	flags.Var(&opt_excludeRegex, "exclude-regex", "regex of files to skip, can be specified multiple times")
+	flags.StringVar(&opt_mime, "mime", "", "only restore files of this mime type")
+	flags.StringVar(&opt_minSize, "min-size", "", "only restore files of at least this size")
+	flags.StringVar(&opt_maxSize, "max-size", "", "only restore files of at most this size")
+	flags.StringVar(&opt_newer, "newer", "", "only restore files modified since this date or duration")
+	flags.StringVar(&opt_older, "older", "", "only restore files modified before this date or duration")
+	flags.StringVar(&opt_asOf, "as-of", "", "restore the newest version of each file at this date, across snapshots")
+	flags.BoolVar(&opt_check, "check", false, "with -as-of, read the chunks of each file and pass over corrupted versions")
+	flags.Var(&opt_rename, "rename", "restore the files under FROM to TO, as FROM=TO, can be specified multiple times")
 	flags.Parse(args)
 
-	if flags.NArg() != 0 {
+	if _, err := compilePatterns("include", opt_include, opt_includeRegex); err != nil {
+		return nil, err
+	}
+	if _, err := compilePatterns("exclude", opt_exclude, opt_excludeRegex); err != nil {
+		return nil, err
+	}
+
+	filters := &snapshot.SearchOpts{Mime: opt_mime}
+	if opt_minSize != "" {
+		size, err := humanize.ParseBytes(opt_minSize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -min-size: %w", err)
+		}
+		filters.MinSize = int64(size)
+	}
+	if opt_maxSize != "" {
+		size, err := humanize.ParseBytes(opt_maxSize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -max-size: %w", err)
+		}
+		filters.MaxSize = int64(size)
+	}
+	if opt_newer != "" {
+		date, err := utils.ParseTimeFlag(opt_newer)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -newer: %w", err)
+		}
+		filters.Since = date
+	}
+	if opt_older != "" {
+		date, err := utils.ParseTimeFlag(opt_older)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -older: %w", err)
+		}
+		filters.Before = date
+	}
+
+	var asOf time.Time
+	if opt_asOf != "" {
+		date, err := utils.ParseTimeFlag(opt_asOf)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -as-of: %w", err)
+		}
+		asOf = date
+	}
+	if opt_check && asOf.IsZero() {
+		return nil, fmt.Errorf("-check only applies to -as-of")
+	}
+
+	rename := make(map[string]string)
+	for _, item := range opt_rename {
+		from, to, found := strings.Cut(item, "=")
+		if !found || from == "" {
+			return nil, fmt.Errorf("invalid -rename, expected FROM=TO: %s", item)
+		}
+		rename[path.Clean("/"+from)] = path.Clean("/" + to)
+	}
+
+	mode, err := snapshot.ParseRestoreMode(opt_mode)
+	if err != nil {
+		return nil, err
+	}
+
+	if flags.NArg() != 0 && asOf.IsZero() {
 		if opt_name != "" || opt_category != "" || opt_environment != "" || opt_perimeter != "" || opt_job != "" || opt_tag != "" {
 			ctx.GetLogger().Warn("snapshot specified, filters will be ignored")
 		}
-	} else if flags.NArg() > 1 {
-		return nil, fmt.Errorf("multiple restore paths specified, please specify only one")
 	}
 
 	if pullPath == "" {
@@ -94,7 +224,21 @@ func parse_cmd_restore(ctx *appcontext.AppContext, repo *repository.Repository,
 		Concurrency: opt_concurrency,
 		Quiet:       opt_quiet,
 		Silent:      opt_silent,
-		Snapshots:   flags.Args(),
+		NoOwner:     opt_noOwner,
+		NoXattrs:    opt_noXattrs,
+		Mode:        mode,
+		DryRun:      opt_dryRun,
+
+		Includes:      opt_include,
+		Excludes:      opt_exclude,
+		IncludeRegexs: opt_includeRegex,
+		ExcludeRegexs: opt_excludeRegex,
+		Filters:       filters,
+		Rename:        rename,
+		AsOf:          asOf,
+		Check:         opt_check,
+
+		Snapshots: flags.Args(),
 	}, nil
 }
 
@@ -114,7 +258,21 @@ type Restore struct {
 	Concurrency uint64
 	Quiet       bool
 	Silent      bool
-	Snapshots   []string
+	NoOwner     bool
+	NoXattrs    bool
+	Mode        snapshot.RestoreMode
+	DryRun      bool
+
+	Includes      []string
+	Excludes      []string
+	IncludeRegexs []string
+	ExcludeRegexs []string
+	Filters       *snapshot.SearchOpts
+	Rename        map[string]string
+	AsOf          time.Time
+	Check         bool
+
+	Snapshots []string
 }
 
 func (cmd *Restore) Name() string {
@@ -125,7 +283,12 @@ func (cmd *Restore) Execute(ctx *appcontext.AppContext, repo *repository.Reposit
 	if !cmd.Silent {
 		go eventsProcessorStdio(ctx, cmd.Quiet)
 	}
+	if !cmd.AsOf.IsZero() {
+		return cmd.executeAsOf(ctx, repo)
+	}
+
 	var snapshots []string
+	var snapshotIDs []objects.MAC
 	if len(cmd.Snapshots) == 0 {
 		locateOptions := utils.NewDefaultLocateOptions()
 		locateOptions.MaxConcurrency = ctx.MaxConcurrency
@@ -139,12 +302,17 @@ func (cmd *Restore) Execute(ctx *appcontext.AppContext, repo *repository.Reposit
 		locateOptions.Job = cmd.OptJob
 		locateOptions.Tag = cmd.OptTag
 
-		snapshotIDs, err := utils.LocateSnapshotIDs(repo, locateOptions)
+		located, err := utils.LocateSnapshotIDs(repo, locateOptions)
 		if err != nil {
 			return 1, fmt.Errorf("ls: could not fetch snapshots list: %w", err)
 		}
-		for _, snapshotID := range snapshotIDs {
-			snapshots = append(snapshots, fmt.Sprintf("%x:", snapshotID))
+		for _, snapshotID := range located {
+			paths, err := utils.SnapshotSourcePaths(repo, snapshotID, "", "")
+			if err != nil {
+				return 1, err
+			}
+			snapshots = append(snapshots, paths...)
+			snapshotIDs = append(snapshotIDs, snapshotID)
 		}
 	} else {
 		for _, snapshotPath := range cmd.Snapshots {
@@ -163,67 +331,199 @@ func (cmd *Restore) Execute(ctx *appcontext.AppContext, repo *repository.Reposit
 			locateOptions.Tag = cmd.OptTag
 			locateOptions.Prefix = prefix
 
-			snapshotIDs, err := utils.LocateSnapshotIDs(repo, locateOptions)
+			located, err := utils.LocateSnapshotIDs(repo, locateOptions)
 			if err != nil {
 				return 1, fmt.Errorf("ls: could not fetch snapshots list: %w", err)
 			}
-			for _, snapshotID := range snapshotIDs {
-				snapshots = append(snapshots, fmt.Sprintf("%x:%s", snapshotID, path))
+			for _, snapshotID := range located {
+				paths, err := utils.SnapshotSourcePaths(repo, snapshotID, prefix, path)
+				if err != nil {
+					return 1, err
+				}
+				snapshots = append(snapshots, paths...)
+				snapshotIDs = append(snapshotIDs, snapshotID)
 			}
 		}
 	}
 
-	if len(snapshots) == 0 {
+	if len(snapshotIDs) == 0 {
 		return 1, fmt.Errorf("no snapshots found")
-	} else if len(snapshots) > 1 {
+	} else if len(snapshotIDs) > 1 {
 		return 1, fmt.Errorf("multiple snapshots found, please specify one")
 	}
 
+	exporterInstance, err := cmd.newExporter(ctx)
+	if err != nil {
+		return 1, err
+	}
+	defer exporterInstance.Close()
+
+	opts, err := cmd.restoreOptions()
+	if err != nil {
+		return 1, err
+	}
+
+	for _, snapPath := range snapshots {
+		snap, pathname, err := utils.OpenSnapshotByPath(repo, snapPath)
+		if err != nil {
+			return 1, err
+		}
+		// several sources are each restored under their full path
+		opts.Strip = snap.Source().Importer.Directory
+		if len(snapshots) > 1 {
+			opts.Strip = ""
+		}
+
+		err = snap.Restore(exporterInstance, exporterInstance.Root(), pathname, opts)
+
+		if err != nil {
+			return 1, err
+		}
+		if cmd.DryRun {
+			snap.Close()
+			continue
+		}
+		ctx.GetLogger().Info("%s: restoration of %s:%s at %s completed successfully",
+			cmd.Name(),
+			utils.SnapshotSourceName(snap),
+			pathname,
+			cmd.Target)
+		snap.Close()
+	}
+	return 0, nil
+}
+
+func (cmd *Restore) newExporter(ctx *appcontext.AppContext) (exporter.Exporter, error) {
 	exporterConfig := map[string]string{
 		"location": cmd.Target,
 	}
 	if strings.HasPrefix(cmd.Target, "@") {
 		remote, ok := ctx.Config.GetRemote(cmd.Target[1:])
 		if !ok {
-			return 1, fmt.Errorf("could not resolve exporter: %s", cmd.Target)
+			return nil, fmt.Errorf("could not resolve exporter: %s", cmd.Target)
 		}
 		if _, ok := remote["location"]; !ok {
-			return 1, fmt.Errorf("could not resolve exporter location: %s", cmd.Target)
+			return nil, fmt.Errorf("could not resolve exporter location: %s", cmd.Target)
 		} else {
 			exporterConfig = remote
 		}
 	}
+	return exporter.NewExporter(exporterConfig)
+}
 
-	var exporterInstance exporter.Exporter
-	var err error
-	exporterInstance, err = exporter.NewExporter(exporterConfig)
+func (cmd *Restore) restoreOptions() (*snapshot.RestoreOptions, error) {
+	includes, err := compilePatterns("include", cmd.Includes, cmd.IncludeRegexs)
 	if err != nil {
-		return 1, err
+		return nil, err
+	}
+	excludes, err := compilePatterns("exclude", cmd.Excludes, cmd.ExcludeRegexs)
+	if err != nil {
+		return nil, err
 	}
-	defer exporterInstance.Close()
 
-	opts := &snapshot.RestoreOptions{
+	return &snapshot.RestoreOptions{
 		MaxConcurrency: cmd.Concurrency,
+		SkipOwnership:  cmd.NoOwner,
+		SkipXattrs:     cmd.NoXattrs,
+		Mode:           cmd.Mode,
+		DryRun:         cmd.DryRun,
+		Includes:       includes,
+		Excludes:       excludes,
+		Filters:        cmd.Filters,
+		Rename:         cmd.Rename,
+		Check:          cmd.Check,
+	}, nil
+}
+
+// executeAsOf restores the paths as they were at cmd.AsOf, merging
+// the snapshots taken until then so that files missing in the latest
+// one, or corrupted with cmd.Check, are picked from older ones.
+func (cmd *Restore) executeAsOf(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	locateOptions := utils.NewDefaultLocateOptions()
+	locateOptions.MaxConcurrency = ctx.MaxConcurrency
+	locateOptions.SortOrder = utils.LocateSortOrderDescending
+	locateOptions.Before = cmd.AsOf
+
+	locateOptions.Name = cmd.OptName
+	locateOptions.Category = cmd.OptCategory
+	locateOptions.Environment = cmd.OptEnvironment
+	locateOptions.Perimeter = cmd.OptPerimeter
+	locateOptions.Job = cmd.OptJob
+	locateOptions.Tag = cmd.OptTag
+
+	snapshotIDs, err := utils.LocateSnapshotIDs(repo, locateOptions)
+	if err != nil {
+		return 1, fmt.Errorf("ls: could not fetch snapshots list: %w", err)
+	}
+	if len(snapshotIDs) == 0 {
+		return 1, fmt.Errorf("no snapshots found before %s", cmd.AsOf.Format(time.RFC3339))
 	}
 
-	for _, snapPath := range snapshots {
-		snap, pathname, err := utils.OpenSnapshotByPath(repo, snapPath)
+	// unless told otherwise, only merge the snapshots sharing the
+	// name and job of the newest one
+	var snapshots []*snapshot.Snapshot
+	defer func() {
+		for _, snap := range snapshots {
+			snap.Close()
+		}
+	}()
+	for _, snapshotID := range snapshotIDs {
+		snap, err := snapshot.Load(repo, snapshotID)
 		if err != nil {
 			return 1, err
 		}
-		opts.Strip = snap.Header.GetSource(0).Importer.Directory
+		if len(snapshots) != 0 && cmd.OptName == "" && cmd.OptJob == "" {
+			if snap.Header.Name != snapshots[0].Header.Name || snap.Header.Job != snapshots[0].Header.Job {
+				snap.Close()
+				continue
+			}
+		}
+		snapshots = append(snapshots, snap)
+	}
 
-		err = snap.Restore(exporterInstance, exporterInstance.Root(), pathname, opts)
+	exporterInstance, err := cmd.newExporter(ctx)
+	if err != nil {
+		return 1, err
+	}
+	defer exporterInstance.Close()
 
+	opts, err := cmd.restoreOptions()
+	if err != nil {
+		return 1, err
+	}
+
+	// every source of every snapshot is merged, relative to its own
+	// directory
+	var views []*snapshot.Snapshot
+	for _, snap := range snapshots {
+		for i := range snap.Header.Sources {
+			view, err := snap.WithSource(i)
+			if err != nil {
+				return 1, err
+			}
+			views = append(views, view)
+		}
+	}
+
+	pathnames := cmd.Snapshots
+	if len(pathnames) == 0 {
+		pathnames = []string{"."}
+	}
+	for _, pathname := range pathnames {
+		pathname = path.Clean(pathname)
+
+		err := snapshot.RestoreAsOf(views, exporterInstance, exporterInstance.Root(), pathname, opts)
 		if err != nil {
 			return 1, err
 		}
-		ctx.GetLogger().Info("%s: restoration of %x:%s at %s completed successfully",
-			cmd.Name(),
-			snap.Header.GetIndexShortID(),
-			pathname,
-			cmd.Target)
-		snap.Close()
+		if !cmd.DryRun {
+			ctx.GetLogger().Info("%s: restoration of %s as of %s from %d snapshots at %s completed successfully",
+				cmd.Name(),
+				pathname,
+				cmd.AsOf.Format(time.RFC3339),
+				len(snapshots),
+				cmd.Target)
+		}
 	}
 	return 0, nil
 }
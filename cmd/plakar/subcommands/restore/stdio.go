@@ -30,6 +30,12 @@ func eventsProcessorStdio(ctx *appcontext.AppContext, quiet bool) chan struct{}
 				if !quiet {
 					ctx.GetLogger().Info("%x: OK %s %s", event.SnapshotID[:4], checkMark, event.Pathname)
 				}
+			case events.FileSkipped:
+				if !quiet {
+					ctx.GetLogger().Info("%x: skipped %s (%s)", event.SnapshotID[:4], event.Pathname, event.Reason)
+				}
+			case events.FileDryRun:
+				ctx.GetLogger().Info("%x: would %s %s", event.SnapshotID[:4], event.Action, event.Pathname)
 			default:
 			}
 		}
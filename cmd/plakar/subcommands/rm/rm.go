@@ -173,10 +173,11 @@ func (cmd *Rm) Execute(ctx *appcontext.AppContext, repo *repository.Repository)
 			if err != nil {
 				ctx.GetLogger().Error("%s", err)
 				errors++
+			} else {
+				ctx.GetLogger().Info("%s: removal of %x completed successfully",
+					cmd.Name(),
+					snapshotID[:4])
 			}
-			ctx.GetLogger().Info("%s: removal of %x completed successfully",
-				cmd.Name(),
-				snapshotID[:4])
 			wg.Done()
 		}(snap)
 	}
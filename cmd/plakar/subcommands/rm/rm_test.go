@@ -28,6 +28,10 @@ func init() {
 }
 
 func generateSnapshot(t *testing.T, bufOut *bytes.Buffer, bufErr *bytes.Buffer) *snapshot.Snapshot {
+	return generateSnapshotWith(t, bufOut, bufErr, storage.NewConfiguration())
+}
+
+func generateSnapshotWith(t *testing.T, bufOut *bytes.Buffer, bufErr *bytes.Buffer, config *storage.Configuration) *snapshot.Snapshot {
 	// init temporary directories
 	tmpRepoDirRoot, err := os.MkdirTemp("", "tmp_repo")
 	require.NoError(t, err)
@@ -60,7 +64,6 @@ func generateSnapshot(t *testing.T, bufOut *bytes.Buffer, bufErr *bytes.Buffer)
 	r, err := bfs.NewStore(map[string]string{"location": "fs://" + tmpRepoDir})
 	require.NotNil(t, r)
 	require.NoError(t, err)
-	config := storage.NewConfiguration()
 	serialized, err := config.ToBytes()
 	require.NoError(t, err)
 
@@ -162,3 +165,53 @@ func TestExecuteCmdRmWithSnapshot(t *testing.T) {
 	output := bufOut.String()
 	require.Contains(t, output, fmt.Sprintf("info: rm: removal of %s completed successfully", hex.EncodeToString(snap.Header.GetIndexShortID())))
 }
+
+func TestExecuteCmdRmImmutable(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	config := storage.NewConfiguration()
+	config.Immutability = storage.NewImmutabilityConfiguration(30)
+	snap := generateSnapshotWith(t, bufOut, bufErr, config)
+	defer snap.Close()
+
+	ctx := snap.AppContext()
+	ctx.MaxConcurrency = 1
+
+	repo := snap.Repository()
+	// override the homedir to avoid having test overwriting existing home configuration
+	ctx.HomeDir = repo.Location()
+	args := []string{hex.EncodeToString(snap.Header.GetIndexShortID())}
+
+	subcommand, err := parse_cmd_rm(ctx, repo, args)
+	require.NoError(t, err)
+
+	status, err := subcommand.Execute(ctx, repo)
+	require.Error(t, err)
+	require.Equal(t, 1, status)
+	require.Contains(t, bufErr.String(), repository.ErrSnapshotProtected.Error())
+	require.NotContains(t, bufOut.String(), "completed successfully")
+	require.ErrorIs(t, repo.CheckRetention(snap.Header.Identifier), repository.ErrSnapshotProtected)
+
+	// the store refuses to alter what was written, whoever asks
+	packfiles, err := repo.GetPackfiles()
+	require.NoError(t, err)
+	require.NotEmpty(t, packfiles)
+	require.ErrorIs(t, repo.Store().DeletePackfile(packfiles[0]), storage.ErrImmutable)
+	require.ErrorIs(t, repo.Store().PutPackfile(packfiles[0], bytes.NewReader(nil)), storage.ErrImmutable)
+
+	states, err := repo.GetStates()
+	require.NoError(t, err)
+	require.NotEmpty(t, states)
+	require.ErrorIs(t, repo.Store().DeleteState(states[0]), storage.ErrImmutable)
+	require.ErrorIs(t, repo.Store().PutState(states[0], bytes.NewReader(nil)), storage.ErrImmutable)
+
+	// retention can be extended but not lowered
+	updated := repo.Configuration()
+	updated.Immutability = storage.NewImmutabilityConfiguration(7)
+	require.ErrorIs(t, repo.UpdateConfiguration(&updated), storage.ErrImmutable)
+	updated.Immutability = nil
+	require.ErrorIs(t, repo.UpdateConfiguration(&updated), storage.ErrImmutable)
+	updated.Immutability = storage.NewImmutabilityConfiguration(60)
+	require.NoError(t, repo.UpdateConfiguration(&updated))
+}
@@ -19,11 +19,14 @@ package server
 import (
 	"flag"
 	"fmt"
+	"path/filepath"
 
 	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/plakar/cmd/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/repository"
+	"github.com/PlakarKorp/plakar/server/audit"
 	"github.com/PlakarKorp/plakar/server/httpd"
+	"github.com/dustin/go-humanize"
 )
 
 func init() {
@@ -33,6 +36,15 @@ func init() {
 func parse_cmd_server(ctx *appcontext.AppContext, repo *repository.Repository, args []string) (subcommands.Subcommand, error) {
 	var opt_listen string
 	var opt_allowdelete bool
+	var opt_tokens string
+	var opt_tlscert string
+	var opt_tlskey string
+	var opt_tlsclientca string
+	var opt_accesslog string
+	var opt_accesslogsize string
+	var opt_accesslogkeep int
+	var opt_ratelimit float64
+	var opt_rateburst int
 
 	flags := flag.NewFlagSet("server", flag.ExitOnError)
 	flags.Usage = func() {
@@ -43,8 +55,55 @@ func parse_cmd_server(ctx *appcontext.AppContext, repo *repository.Repository, a
 
 	flags.StringVar(&opt_listen, "listen", "127.0.0.1:9876", "address to listen on")
 	flags.BoolVar(&opt_allowdelete, "allow-delete", false, "enable delete operations")
+	flags.StringVar(&opt_tokens, "tokens", "", "require clients to present a token listed in `file`")
+	flags.StringVar(&opt_tlscert, "tls-cert", "", "serve TLS with the certificate in `file`")
+	flags.StringVar(&opt_tlskey, "tls-key", "", "serve TLS with the private key in `file`")
+	flags.StringVar(&opt_tlsclientca, "tls-client-ca", "", "require client certificates signed by the CA in `file`")
+	flags.StringVar(&opt_accesslog, "access-log", "", "log every request as JSON to `file`")
+	flags.StringVar(&opt_accesslogsize, "access-log-size", "64MB", "rotate the access log past `size`")
+	flags.IntVar(&opt_accesslogkeep, "access-log-keep", 7, "number of rotated access logs to keep")
+	flags.Float64Var(&opt_ratelimit, "rate-limit", 0, "requests per second allowed to each client")
+	flags.IntVar(&opt_rateburst, "rate-burst", 0, "requests allowed at once to each client")
 	flags.Parse(args)
 
+	if flags.NArg() != 0 {
+		return nil, fmt.Errorf("too many arguments")
+	}
+	if (opt_tlscert == "") != (opt_tlskey == "") {
+		return nil, fmt.Errorf("-tls-cert and -tls-key must be used together")
+	}
+	if opt_tlsclientca != "" && opt_tlscert == "" {
+		return nil, fmt.Errorf("-tls-client-ca requires -tls-cert and -tls-key")
+	}
+
+	accessLogSize, err := humanize.ParseBytes(opt_accesslogsize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid access log size: %w", err)
+	}
+	if opt_accesslog != "" {
+		opt_accesslog, err = filepath.Abs(opt_accesslog)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opt_accesslogkeep < 0 {
+		return nil, fmt.Errorf("invalid number of access logs to keep: %d", opt_accesslogkeep)
+	}
+	if opt_ratelimit < 0 || opt_rateburst < 0 {
+		return nil, fmt.Errorf("invalid rate limit")
+	}
+
+	var tokens map[string]httpd.Token
+	if opt_tokens != "" {
+		tokens, err = httpd.LoadTokens(opt_tokens)
+		if err != nil {
+			return nil, err
+		}
+		if opt_tlscert == "" {
+			ctx.GetLogger().Warn("tokens are sent in the clear without -tls-cert")
+		}
+	}
+
 	noDelete := true
 	if opt_allowdelete {
 		noDelete = false
@@ -53,8 +112,18 @@ func parse_cmd_server(ctx *appcontext.AppContext, repo *repository.Repository, a
 		RepositoryLocation: repo.Location(),
 		RepositorySecret:   ctx.GetSecret(),
 
-		ListenAddr: opt_listen,
-		NoDelete:   noDelete,
+		ListenAddr:  opt_listen,
+		NoDelete:    noDelete,
+		Tokens:      tokens,
+		TLSCert:     opt_tlscert,
+		TLSKey:      opt_tlskey,
+		TLSClientCA: opt_tlsclientca,
+
+		AccessLog:     opt_accesslog,
+		AccessLogSize: int64(accessLogSize),
+		AccessLogKeep: opt_accesslogkeep,
+		RateLimit:     opt_ratelimit,
+		RateBurst:     opt_rateburst,
 	}, nil
 }
 
@@ -62,8 +131,18 @@ type Server struct {
 	RepositoryLocation string
 	RepositorySecret   []byte
 
-	ListenAddr string
-	NoDelete   bool
+	ListenAddr  string
+	NoDelete    bool
+	Tokens      map[string]httpd.Token
+	TLSCert     string
+	TLSKey      string
+	TLSClientCA string
+
+	AccessLog     string
+	AccessLogSize int64
+	AccessLogKeep int
+	RateLimit     float64
+	RateBurst     int
 }
 
 func (cmd *Server) Name() string {
@@ -71,6 +150,32 @@ func (cmd *Server) Name() string {
 }
 
 func (cmd *Server) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
-	httpd.Server(repo, cmd.ListenAddr, cmd.NoDelete)
+	var accessLog *audit.Logger
+	if cmd.AccessLog != "" {
+		fp, err := audit.OpenRotatingFile(cmd.AccessLog, cmd.AccessLogSize, cmd.AccessLogKeep)
+		if err != nil {
+			return 1, err
+		}
+		defer fp.Close()
+		accessLog = audit.NewLogger(fp)
+	}
+
+	var rateLimit *audit.Limiter
+	if cmd.RateLimit > 0 {
+		rateLimit = audit.NewLimiter(cmd.RateLimit, cmd.RateBurst)
+	}
+
+	err := httpd.Server(repo, cmd.ListenAddr, &httpd.Options{
+		NoDelete:    cmd.NoDelete,
+		Tokens:      cmd.Tokens,
+		TLSCert:     cmd.TLSCert,
+		TLSKey:      cmd.TLSKey,
+		TLSClientCA: cmd.TLSClientCA,
+		AccessLog:   accessLog,
+		RateLimit:   rateLimit,
+	})
+	if err != nil {
+		return 1, err
+	}
 	return 0, nil
 }
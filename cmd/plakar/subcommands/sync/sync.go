@@ -19,15 +19,14 @@ package sync
 import (
 	"flag"
 	"fmt"
-	"os"
 	"strings"
 
 	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/plakar/cmd/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/cmd/plakar/utils"
-	"github.com/PlakarKorp/plakar/encryption"
 	"github.com/PlakarKorp/plakar/objects"
 	"github.com/PlakarKorp/plakar/repository"
+	"github.com/PlakarKorp/plakar/secrets"
 	"github.com/PlakarKorp/plakar/snapshot"
 	"github.com/PlakarKorp/plakar/storage"
 )
@@ -92,33 +91,11 @@ func parse_cmd_sync(ctx *appcontext.AppContext, repo *repository.Repository, arg
 
 	var peerSecret []byte
 	if peerStoreConfig.Encryption != nil {
-		if pass, ok := storeConfig["passphrase"]; ok {
-			key, err := encryption.DeriveKey(peerStoreConfig.Encryption.KDFParams, []byte(pass))
-			if err != nil {
-				return nil, err
-			}
-			if !encryption.VerifyCanary(peerStoreConfig.Encryption, key) {
-				return nil, fmt.Errorf("invalid passphrase")
-			}
-			peerSecret = key
-		} else {
-			for {
-				passphrase, err := utils.GetPassphrase("destination repository")
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "%s\n", err)
-					continue
-				}
-
-				key, err := encryption.DeriveKey(peerStoreConfig.Encryption.KDFParams, passphrase)
-				if err != nil {
-					return nil, err
-				}
-				if !encryption.VerifyCanary(peerStoreConfig.Encryption, key) {
-					return nil, fmt.Errorf("invalid passphrase")
-				}
-				peerSecret = key
-				break
-			}
+		peerSecret, err = secrets.Unlock(nil, storeConfig, peerStoreConfig.Encryption, func() ([]byte, error) {
+			return utils.GetPassphrase("destination repository")
+		})
+		if err != nil {
+			return nil, err
 		}
 	}
 
@@ -0,0 +1,140 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package tag
+
+import (
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/cmd/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/cmd/plakar/utils"
+	"github.com/PlakarKorp/plakar/repository"
+	"github.com/PlakarKorp/plakar/snapshot"
+	"github.com/PlakarKorp/plakar/snapshot/header"
+)
+
+func init() {
+	subcommands.Register("tag", parse_cmd_tag)
+}
+
+type listFlags []string
+
+func (l *listFlags) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlags) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func parse_cmd_tag(ctx *appcontext.AppContext, repo *repository.Repository, args []string) (subcommands.Subcommand, error) {
+	var opt_add listFlags
+	var opt_remove listFlags
+
+	flags := flag.NewFlagSet("tag", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS] SNAPSHOT...\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
+		flags.PrintDefaults()
+	}
+
+	flags.Var(&opt_add, "add", "tag to add to the snapshots, can be specified multiple times")
+	flags.Var(&opt_remove, "remove", "tag to remove from the snapshots, can be specified multiple times")
+	flags.Parse(args)
+
+	if len(opt_add) == 0 && len(opt_remove) == 0 {
+		return nil, fmt.Errorf("no tag to add or remove")
+	}
+	if flags.NArg() == 0 {
+		return nil, fmt.Errorf("no snapshot specified")
+	}
+
+	return &Tag{
+		RepositoryLocation: repo.Location(),
+		RepositorySecret:   ctx.GetSecret(),
+
+		Add:    opt_add,
+		Remove: opt_remove,
+
+		Snapshots: flags.Args(),
+	}, nil
+}
+
+type Tag struct {
+	RepositoryLocation string
+	RepositorySecret   []byte
+
+	Add    []string
+	Remove []string
+
+	Snapshots []string
+}
+
+func (cmd *Tag) Name() string {
+	return "tag"
+}
+
+// edit applies the tag changes to a snapshot header.
+func (cmd *Tag) edit(hdr *header.Header) {
+	tags := make([]string, 0, len(hdr.Tags)+len(cmd.Add))
+	for _, tag := range hdr.Tags {
+		if !slices.Contains(cmd.Remove, tag) && !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	for _, tag := range cmd.Add {
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	hdr.Tags = tags
+}
+
+func (cmd *Tag) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	for _, prefix := range cmd.Snapshots {
+		snapshotID, err := utils.LocateSnapshotByPrefix(repo, prefix)
+		if err != nil {
+			return 1, err
+		}
+
+		// the old snapshot must be removable before a new one is made
+		if err := repo.CheckRetention(snapshotID); err != nil {
+			return 1, fmt.Errorf("failed to tag snapshot %x: %w", snapshotID[:4], err)
+		}
+
+		// Snapshots are immutable: the edited header is written as a
+		// new snapshot sharing the same data, then the old one goes.
+		newID, err := snapshot.Amend(repo, snapshotID, cmd.edit)
+		if err != nil {
+			return 1, fmt.Errorf("failed to tag snapshot %x: %w", snapshotID[:4], err)
+		}
+
+		if err := repo.DeleteSnapshot(snapshotID); err != nil {
+			return 1, fmt.Errorf("failed to remove snapshot %x: %w", snapshotID[:4], err)
+		}
+
+		ctx.GetLogger().Info("%s: snapshot %x is now %x",
+			cmd.Name(),
+			snapshotID[:4],
+			newID[:4])
+	}
+	return 0, nil
+}
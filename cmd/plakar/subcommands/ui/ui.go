@@ -23,11 +23,14 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/plakar/cmd/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/repository"
+	"github.com/PlakarKorp/plakar/server/audit"
 	v2 "github.com/PlakarKorp/plakar/ui/v2"
+	"github.com/dustin/go-humanize"
 	"github.com/google/uuid"
 )
 
@@ -40,6 +43,11 @@ func parse_cmd_ui(ctx *appcontext.AppContext, repo *repository.Repository, args
 	var opt_cors bool
 	var opt_noauth bool
 	var opt_nospawn bool
+	var opt_accesslog string
+	var opt_accesslogsize string
+	var opt_accesslogkeep int
+	var opt_ratelimit float64
+	var opt_rateburst int
 
 	flags := flag.NewFlagSet("ui", flag.ExitOnError)
 	flags.Usage = func() {
@@ -52,8 +60,30 @@ func parse_cmd_ui(ctx *appcontext.AppContext, repo *repository.Repository, args
 	flags.BoolVar(&opt_cors, "cors", false, "enable CORS")
 	flags.BoolVar(&opt_noauth, "no-auth", false, "don't use authentication")
 	flags.BoolVar(&opt_nospawn, "no-spawn", false, "don't spawn browser")
+	flags.StringVar(&opt_accesslog, "access-log", "", "log every request as JSON to `file`")
+	flags.StringVar(&opt_accesslogsize, "access-log-size", "64MB", "rotate the access log past `size`")
+	flags.IntVar(&opt_accesslogkeep, "access-log-keep", 7, "number of rotated access logs to keep")
+	flags.Float64Var(&opt_ratelimit, "rate-limit", 0, "requests per second allowed to each client")
+	flags.IntVar(&opt_rateburst, "rate-burst", 0, "requests allowed at once to each client")
 	flags.Parse(args)
 
+	accessLogSize, err := humanize.ParseBytes(opt_accesslogsize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid access log size: %w", err)
+	}
+	if opt_accesslog != "" {
+		opt_accesslog, err = filepath.Abs(opt_accesslog)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opt_accesslogkeep < 0 {
+		return nil, fmt.Errorf("invalid number of access logs to keep: %d", opt_accesslogkeep)
+	}
+	if opt_ratelimit < 0 || opt_rateburst < 0 {
+		return nil, fmt.Errorf("invalid rate limit")
+	}
+
 	return &Ui{
 		RepositoryLocation: repo.Location(),
 		RepositorySecret:   ctx.GetSecret(),
@@ -61,6 +91,11 @@ func parse_cmd_ui(ctx *appcontext.AppContext, repo *repository.Repository, args
 		Cors:               opt_cors,
 		NoAuth:             opt_noauth,
 		NoSpawn:            opt_nospawn,
+		AccessLog:          opt_accesslog,
+		AccessLogSize:      int64(accessLogSize),
+		AccessLogKeep:      opt_accesslogkeep,
+		RateLimit:          opt_ratelimit,
+		RateBurst:          opt_rateburst,
 	}, nil
 }
 
@@ -72,6 +107,12 @@ type Ui struct {
 	Cors    bool
 	NoAuth  bool
 	NoSpawn bool
+
+	AccessLog     string
+	AccessLogSize int64
+	AccessLogKeep int
+	RateLimit     float64
+	RateBurst     int
 }
 
 func (cmd *Ui) Name() string {
@@ -79,10 +120,27 @@ func (cmd *Ui) Name() string {
 }
 
 func (cmd *Ui) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	var accessLog *audit.Logger
+	if cmd.AccessLog != "" {
+		fp, err := audit.OpenRotatingFile(cmd.AccessLog, cmd.AccessLogSize, cmd.AccessLogKeep)
+		if err != nil {
+			return 1, err
+		}
+		defer fp.Close()
+		accessLog = audit.NewLogger(fp)
+	}
+
+	var rateLimit *audit.Limiter
+	if cmd.RateLimit > 0 {
+		rateLimit = audit.NewLimiter(cmd.RateLimit, cmd.RateBurst)
+	}
+
 	ui_opts := v2.UiOptions{
-		NoSpawn: cmd.NoSpawn,
-		Cors:    cmd.Cors,
-		Token:   "",
+		NoSpawn:   cmd.NoSpawn,
+		Cors:      cmd.Cors,
+		Token:     "",
+		AccessLog: accessLog,
+		RateLimit: rateLimit,
 	}
 
 	if !cmd.NoAuth {
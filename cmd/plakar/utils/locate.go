@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"path"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -89,6 +90,11 @@ func LocateSnapshotIDs(repo *repository.Repository, opts *LocateOptions) ([]obje
 		opts = NewDefaultLocateOptions()
 	}
 
+	prefix, _, err := ParseSnapshotSource(opts.Prefix)
+	if err != nil {
+		return nil, err
+	}
+
 	wg := sync.WaitGroup{}
 	maxConcurrency := make(chan struct{}, opts.MaxConcurrency)
 	for snapshotID := range repo.ListSnapshots() {
@@ -106,8 +112,8 @@ func LocateSnapshotIDs(repo *repository.Repository, opts *LocateOptions) ([]obje
 			}
 			defer snap.Close()
 
-			if opts.Prefix != "" {
-				if !strings.HasPrefix(hex.EncodeToString(snapshotID[:]), opts.Prefix) {
+			if prefix != "" {
+				if !strings.HasPrefix(hex.EncodeToString(snapshotID[:]), prefix) {
 					return
 				}
 			}
@@ -209,6 +215,77 @@ func ParseSnapshotPath(snapshotPath string) (string, string) {
 	return prefix, pattern
 }
 
+// ParseSnapshotSource splits a snapshot prefix of the form ID#N into
+// the snapshot ID and the index of the source it designates.  The
+// first source is designated when there is no #N suffix.
+func ParseSnapshotSource(prefix string) (string, int, error) {
+	prefix, index, found := strings.Cut(prefix, "#")
+	if !found {
+		return prefix, 0, nil
+	}
+	source, err := strconv.Atoi(index)
+	if err != nil || source < 0 {
+		return "", 0, fmt.Errorf("invalid snapshot source: %s", index)
+	}
+	return prefix, source, nil
+}
+
+// SnapshotSourcePaths returns the ID#N:PATH designating pathname in the
+// sources of snapshotID.  When prefix has no #N suffix, every source
+// is designated, or those holding pathname if one is given.
+func SnapshotSourcePaths(repo *repository.Repository, snapshotID objects.MAC, prefix string, pathname string) ([]string, error) {
+	if strings.Contains(prefix, "#") {
+		_, source, err := ParseSnapshotSource(prefix)
+		if err != nil {
+			return nil, err
+		}
+		return []string{fmt.Sprintf("%x#%d:%s", snapshotID, source, pathname)}, nil
+	}
+
+	snap, err := snapshot.Load(repo, snapshotID)
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Close()
+
+	ret := make([]string, 0, len(snap.Header.Sources))
+	for i := range snap.Header.Sources {
+		if pathname != "" && len(snap.Header.Sources) > 1 {
+			view, err := snap.WithSource(i)
+			if err != nil {
+				return nil, err
+			}
+			fs, err := view.Filesystem()
+			if err != nil {
+				return nil, err
+			}
+			root := pathname
+			if !strings.HasPrefix(root, "/") {
+				root = path.Join(view.Source().Importer.Directory, root)
+			}
+			if _, err := fs.GetEntry(path.Clean(root)); err != nil {
+				continue
+			}
+		}
+		ret = append(ret, fmt.Sprintf("%x#%d:%s", snapshotID, i, pathname))
+	}
+
+	// the path is nowhere, let the first source report it
+	if len(ret) == 0 {
+		ret = append(ret, fmt.Sprintf("%x#0:%s", snapshotID, pathname))
+	}
+	return ret, nil
+}
+
+// SnapshotSourceName returns the short ID of snap, followed by the
+// source it is viewed on when it has several.
+func SnapshotSourceName(snap *snapshot.Snapshot) string {
+	if len(snap.Header.Sources) > 1 {
+		return fmt.Sprintf("%x#%d", snap.Header.GetIndexShortID(), snap.SourceIndex())
+	}
+	return fmt.Sprintf("%x", snap.Header.GetIndexShortID())
+}
+
 func LookupSnapshotByPrefix(repo *repository.Repository, prefix string) []objects.MAC {
 	ret := make([]objects.MAC, 0)
 	for snapshotID := range repo.ListSnapshots() {
@@ -233,6 +310,11 @@ func LocateSnapshotByPrefix(repo *repository.Repository, prefix string) (objects
 func OpenSnapshotByPath(repo *repository.Repository, snapshotPath string) (*snapshot.Snapshot, string, error) {
 	prefix, pathname := ParseSnapshotPath(snapshotPath)
 
+	prefix, source, err := ParseSnapshotSource(prefix)
+	if err != nil {
+		return nil, "", err
+	}
+
 	snapshotID, err := LocateSnapshotByPrefix(repo, prefix)
 	if err != nil {
 		return nil, "", err
@@ -243,11 +325,16 @@ func OpenSnapshotByPath(repo *repository.Repository, snapshotPath string) (*snap
 		return nil, "", err
 	}
 
+	snap, err = snap.WithSource(source)
+	if err != nil {
+		return nil, "", err
+	}
+
 	var snapRoot string
 	if strings.HasPrefix(pathname, "/") {
 		snapRoot = pathname
 	} else {
-		snapRoot = path.Clean(path.Join(snap.Header.GetSource(0).Importer.Directory, pathname))
+		snapRoot = path.Clean(path.Join(snap.Source().Importer.Directory, pathname))
 	}
 	return snap, path.Clean(snapRoot), err
 }
@@ -53,6 +53,49 @@ func LookupDefaultConfiguration(algorithm string) (*Configuration, error) {
 	}
 }
 
+// Identifiers of the algorithms, recorded with the data they compress
+// so that it remains readable once the compression of a repository
+// changes. ID_DEFAULT stands for the compression of the repository
+// configuration, which data written before identifiers existed uses.
+const (
+	ID_DEFAULT uint8 = iota
+	ID_NONE
+	ID_LZ4
+	ID_GZIP
+)
+
+var algorithmIDs = map[string]uint8{
+	"LZ4":  ID_LZ4,
+	"GZIP": ID_GZIP,
+}
+
+// AlgorithmID returns the identifier of the algorithm of config, a nil
+// configuration standing for no compression.
+func AlgorithmID(config *Configuration) (uint8, error) {
+	if config == nil {
+		return ID_NONE, nil
+	}
+	id, exists := algorithmIDs[config.Algorithm]
+	if !exists {
+		return 0, fmt.Errorf("unsupported compression method %q", config.Algorithm)
+	}
+	return id, nil
+}
+
+// LookupAlgorithmID returns the name of the algorithm identified by id,
+// empty for no compression.
+func LookupAlgorithmID(id uint8) (string, error) {
+	if id == ID_NONE {
+		return "", nil
+	}
+	for name, algorithmID := range algorithmIDs {
+		if algorithmID == id {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("unknown compression identifier %d", id)
+}
+
 func DeflateStream(name string, r io.Reader) (io.Reader, error) {
 	m := map[string]func(io.Reader) (io.Reader, error){
 		"GZIP": DeflateGzipStream,
@@ -177,3 +177,38 @@ func TestLookupNewDefaultConfigurationUnknown(t *testing.T) {
 		t.Errorf("LookupNewDefaultConfiguration(unknown) did not return an error")
 	}
 }
+
+func TestAlgorithmID(t *testing.T) {
+	for _, name := range []string{"LZ4", "GZIP"} {
+		config, err := LookupDefaultConfiguration(name)
+		if err != nil {
+			t.Fatalf("LookupDefaultConfiguration(%s) returned an error: %v", name, err)
+		}
+		id, err := AlgorithmID(config)
+		if err != nil {
+			t.Fatalf("AlgorithmID(%s) returned an error: %v", name, err)
+		}
+		if id == ID_DEFAULT || id == ID_NONE {
+			t.Errorf("AlgorithmID(%s) returned a reserved identifier: %d", name, id)
+		}
+		lookedUp, err := LookupAlgorithmID(id)
+		if err != nil || lookedUp != name {
+			t.Errorf("LookupAlgorithmID(%d) = %q, %v, expected %q", id, lookedUp, err, name)
+		}
+	}
+
+	id, err := AlgorithmID(nil)
+	if err != nil || id != ID_NONE {
+		t.Errorf("AlgorithmID(nil) = %d, %v, expected %d", id, err, ID_NONE)
+	}
+	if name, err := LookupAlgorithmID(ID_NONE); err != nil || name != "" {
+		t.Errorf("LookupAlgorithmID(ID_NONE) = %q, %v, expected no algorithm", name, err)
+	}
+
+	if _, err := AlgorithmID(&Configuration{Algorithm: "unknown"}); err == nil {
+		t.Errorf("AlgorithmID(unknown) did not return an error")
+	}
+	if _, err := LookupAlgorithmID(255); err == nil {
+		t.Errorf("LookupAlgorithmID(255) did not return an error")
+	}
+}
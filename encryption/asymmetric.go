@@ -0,0 +1,175 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+
+	aeskw "github.com/nickball/go-aes-key-wrap"
+	"golang.org/x/crypto/hkdf"
+)
+
+// In the X25519 mode, the subkeys of the encrypted data are wrapped for
+// the public key of the repository, so that clients holding only the
+// MAC key can write to the repository but not read from it.
+//
+// The secret of such a repository is the MAC key followed by the X25519
+// private key, a write-only secret being the MAC key alone.
+const (
+	SUBKEY_X25519 = "X25519"
+
+	macKeySize = 32
+)
+
+var ErrWriteOnly = errors.New("the repository key is write-only")
+
+// NewAsymmetricKey sets up config to wrap subkeys for a new X25519 key
+// pair and returns the secret giving full access to the repository.
+func NewAsymmetricKey(config *Configuration) ([]byte, error) {
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	macKey, err := NewMasterKey()
+	if err != nil {
+		return nil, err
+	}
+
+	config.SubKeyAlgorithm = SUBKEY_X25519
+	config.PublicKey = privateKey.PublicKey().Bytes()
+	return append(macKey, privateKey.Bytes()...), nil
+}
+
+// MACKey returns the part of secret that keys the MACs of the
+// repository, shared by write-only and full access secrets.
+func MACKey(secret []byte) []byte {
+	if len(secret) > macKeySize {
+		return secret[:macKeySize]
+	}
+	return secret
+}
+
+// IsAsymmetric tells whether subkeys are wrapped for a public key.
+func (c *Configuration) IsAsymmetric() bool {
+	return c.SubKeyAlgorithm == SUBKEY_X25519
+}
+
+// IsWriteOnly tells whether secret can only write to the repository.
+func (c *Configuration) IsWriteOnly(secret []byte) bool {
+	return c.IsAsymmetric() && len(secret) <= macKeySize
+}
+
+// privateKey returns the X25519 private key held by secret.
+func (c *Configuration) privateKey(secret []byte) (*ecdh.PrivateKey, error) {
+	if c.IsWriteOnly(secret) {
+		return nil, ErrWriteOnly
+	}
+	privateKey, err := ecdh.X25519().NewPrivateKey(secret[macKeySize:])
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(privateKey.PublicKey().Bytes(), c.PublicKey) {
+		return nil, ErrInvalidPassphrase
+	}
+	return privateKey, nil
+}
+
+// Metadata returns the configuration and key encrypting the metadata
+// of the repository, such as states and locks, which write-only
+// clients read to deduplicate data. It is the data encryption for a
+// symmetric repository.
+func (c *Configuration) Metadata(secret []byte) (*Configuration, []byte, error) {
+	if !c.IsAsymmetric() {
+		return c, c.DataKey(secret), nil
+	}
+
+	key := make([]byte, 32)
+	kdf := hkdf.New(sha256.New, MACKey(secret), nil, []byte("plakar metadata"))
+	if _, err := io.ReadFull(kdf, key); err != nil {
+		return nil, nil, err
+	}
+
+	metadata := *c
+	metadata.SubKeyAlgorithm = "AES256-KW"
+	metadata.PublicKey = nil
+	return &metadata, key, nil
+}
+
+// wrapKey derives the key wrapping a subkey from the X25519 shared
+// secret of the ephemeral and repository keys.
+func wrapKey(shared []byte, ephemeralPublicKey []byte) ([]byte, error) {
+	key := make([]byte, 32)
+	kdf := hkdf.New(sha256.New, shared, ephemeralPublicKey, []byte("plakar subkey"))
+	if _, err := io.ReadFull(kdf, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func encryptSubkey_X25519(publicKey []byte, subkey []byte) ([]byte, error) {
+	peer, err := ecdh.X25519().NewPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := ephemeral.ECDH(peer)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := wrapKey(shared, ephemeral.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := aeskw.Wrap(block, subkey)
+	if err != nil {
+		return nil, err
+	}
+
+	// return block with the ephemeral public key and wrapped subkey
+	return append(ephemeral.PublicKey().Bytes(), wrapped...), nil
+}
+
+func decryptSubkey_X25519(privateKey *ecdh.PrivateKey, r io.Reader) ([]byte, error) {
+	ephemeralPublicKey := make([]byte, 32)
+	if _, err := io.ReadFull(r, ephemeralPublicKey); err != nil {
+		return nil, err
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(ephemeralPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	shared, err := privateKey.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	key, err := wrapKey(shared, ephemeralPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// 40 is the size of the wrapped key
+	subkeyBlock := make([]byte, 40)
+	if _, err := io.ReadFull(r, subkeyBlock); err != nil {
+		return nil, err
+	}
+	return aeskw.Unwrap(block, subkeyBlock)
+}
@@ -1,10 +1,12 @@
 package encryption
 
 import (
+	"bytes"
 	"crypto/rand"
 	"io"
 	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/PlakarKorp/plakar/compression"
 )
@@ -214,3 +216,176 @@ func TestCompressEncryptThenDecryptDecompressStream(t *testing.T) {
 		t.Errorf("Final data does not match original. Got: %q, want: %q", string(finalData), originalData)
 	}
 }
+
+func newTestDataConfiguration(t testing.TB, algorithm string) (*Configuration, []byte) {
+	config := NewDefaultConfiguration()
+	config.DataAlgorithm = algorithm
+
+	key, err := NewMasterKey()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	return config, key
+}
+
+func TestDataAlgorithms(t *testing.T) {
+	for _, algorithm := range DataAlgorithms() {
+		config, key := newTestDataConfiguration(t, algorithm)
+		overhead := dataAlgorithms[algorithm].overhead
+
+		for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3*chunkSize + 17} {
+			originalData := make([]byte, size)
+			if _, err := rand.Read(originalData); err != nil {
+				t.Fatalf("Failed to generate data: %v", err)
+			}
+
+			encryptedReader, err := EncryptStream(config, key, bytes.NewReader(originalData))
+			if err != nil {
+				t.Fatalf("%s: Failed to encrypt data: %v", algorithm, err)
+			}
+			encryptedData, err := io.ReadAll(encryptedReader)
+			if err != nil {
+				t.Fatalf("%s: Failed to read encrypted data: %v", algorithm, err)
+			}
+
+			// 40 is the size of the wrapped subkey
+			chunks := (size + chunkSize - 1) / chunkSize
+			if expected := 40 + chunks*overhead + size; len(encryptedData) != expected {
+				t.Errorf("%s: Unexpected encrypted size for %d bytes. Got %d, want %d", algorithm, size, len(encryptedData), expected)
+			}
+
+			// chunks must be reassembled from short reads
+			decryptedReader, err := DecryptStream(config, key, iotest.HalfReader(bytes.NewReader(encryptedData)))
+			if err != nil {
+				t.Fatalf("%s: Failed to decrypt data: %v", algorithm, err)
+			}
+			decryptedData, err := io.ReadAll(decryptedReader)
+			if err != nil {
+				t.Fatalf("%s: Failed to read decrypted data: %v", algorithm, err)
+			}
+			if !bytes.Equal(decryptedData, originalData) {
+				t.Errorf("%s: Decrypted data of %d bytes does not match original", algorithm, size)
+			}
+		}
+	}
+}
+
+func TestDataAlgorithmMismatch(t *testing.T) {
+	originalData := bytes.Repeat([]byte("plakar"), chunkSize)
+
+	for _, from := range DataAlgorithms() {
+		for _, to := range DataAlgorithms() {
+			if from == to {
+				continue
+			}
+			config, key := newTestDataConfiguration(t, from)
+			encryptedReader, err := EncryptStream(config, key, bytes.NewReader(originalData))
+			if err != nil {
+				t.Fatalf("%s: Failed to encrypt data: %v", from, err)
+			}
+
+			config.DataAlgorithm = to
+			decryptedReader, err := DecryptStream(config, key, encryptedReader)
+			if err != nil {
+				t.Fatalf("%s: Failed to set up decryption: %v", to, err)
+			}
+			if _, err := io.ReadAll(decryptedReader); err == nil {
+				t.Errorf("Expected data encrypted with %s not to decrypt with %s", from, to)
+			}
+		}
+	}
+}
+
+func TestDataAlgorithmAsymmetric(t *testing.T) {
+	config := NewDefaultConfiguration()
+	config.DataAlgorithm = "XCHACHA20-POLY1305"
+	secret, err := NewAsymmetricKey(config)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	originalData := "This is a test data string for encryption and decryption"
+	encryptedReader, err := EncryptStream(config, MACKey(secret), strings.NewReader(originalData))
+	if err != nil {
+		t.Fatalf("Failed to encrypt data: %v", err)
+	}
+	decryptedReader, err := DecryptStream(config, secret, encryptedReader)
+	if err != nil {
+		t.Fatalf("Failed to decrypt data: %v", err)
+	}
+	decryptedData, err := io.ReadAll(decryptedReader)
+	if err != nil {
+		t.Fatalf("Failed to read decrypted data: %v", err)
+	}
+	if string(decryptedData) != originalData {
+		t.Errorf("Decrypted data does not match original. Got: %q, want: %q", string(decryptedData), originalData)
+	}
+}
+
+func TestLookupDataAlgorithm(t *testing.T) {
+	for name, expected := range map[string]string{
+		"xchacha20":          "XCHACHA20-POLY1305",
+		"XChaCha20-Poly1305": "XCHACHA20-POLY1305",
+		"aes":                "AES256-GCM-SIV",
+		"AES256-GCM-SIV":     "AES256-GCM-SIV",
+	} {
+		algorithm, err := LookupDataAlgorithm(name)
+		if err != nil {
+			t.Errorf("Failed to look up %s: %v", name, err)
+		} else if algorithm != expected {
+			t.Errorf("Unexpected algorithm for %s. Got %s, want %s", name, algorithm, expected)
+		}
+	}
+
+	if _, err := LookupDataAlgorithm("chacha20"); err == nil {
+		t.Error("Expected an error for an unsupported algorithm")
+	}
+}
+
+func BenchmarkEncryptStream(b *testing.B) {
+	data := make([]byte, 16*chunkSize)
+	for _, algorithm := range DataAlgorithms() {
+		b.Run(algorithm, func(b *testing.B) {
+			config, key := newTestDataConfiguration(b, algorithm)
+			b.SetBytes(int64(len(data)))
+			for range b.N {
+				rd, err := EncryptStream(config, key, bytes.NewReader(data))
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := io.Copy(io.Discard, rd); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDecryptStream(b *testing.B) {
+	data := make([]byte, 16*chunkSize)
+	for _, algorithm := range DataAlgorithms() {
+		b.Run(algorithm, func(b *testing.B) {
+			config, key := newTestDataConfiguration(b, algorithm)
+			rd, err := EncryptStream(config, key, bytes.NewReader(data))
+			if err != nil {
+				b.Fatal(err)
+			}
+			encrypted, err := io.ReadAll(rd)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.SetBytes(int64(len(data)))
+			b.ResetTimer()
+			for range b.N {
+				rd, err := DecryptStream(config, key, bytes.NewReader(encrypted))
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := io.Copy(io.Discard, rd); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
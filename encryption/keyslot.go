@@ -0,0 +1,180 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	aeskw "github.com/nickball/go-aes-key-wrap"
+)
+
+const DEFAULT_KEYSLOT = "default"
+
+var (
+	ErrInvalidPassphrase = errors.New("invalid passphrase")
+	ErrKeySlotNotFound   = errors.New("key slot not found")
+	ErrKeySlotExists     = errors.New("key slot already exists")
+	ErrLastKeySlot       = errors.New("can't remove the last key slot")
+	ErrKeySlotWriteOnly  = errors.New("a write-only key can't change a full access key slot")
+)
+
+// KeySlot holds the master key of a repository wrapped with a key
+// derived from a passphrase, so that several passphrases can open the
+// repository and one can be changed without re-encrypting the data.
+type KeySlot struct {
+	Name      string
+	Timestamp time.Time
+	KDFParams KDFParams
+
+	// Key is the wrapped master key, unset for the passphrase of a
+	// repository created before key slots, which derives it directly.
+	Key []byte `msgpack:",omitempty"`
+
+	// WriteOnly is set when Key is only the MAC key of an X25519
+	// repository.
+	WriteOnly bool `msgpack:",omitempty"`
+}
+
+// NewMasterKey returns a random key to encrypt a new repository with.
+func NewMasterKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// NewKeySlot wraps masterKey with a key derived from passphrase with
+// kdfParams.
+func NewKeySlot(name string, kdfParams *KDFParams, passphrase []byte, masterKey []byte) (*KeySlot, error) {
+	key, err := DeriveKey(*kdfParams, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, err := EncryptSubkey("AES256-KW", key, masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KeySlot{
+		Name:      name,
+		Timestamp: time.Now(),
+		KDFParams: *kdfParams,
+		Key:       wrapped,
+	}, nil
+}
+
+// Unwrap returns the master key held by the slot if passphrase opens
+// it.
+func (slot *KeySlot) Unwrap(passphrase []byte) ([]byte, error) {
+	key, err := DeriveKey(slot.KDFParams, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if slot.Key == nil {
+		return key, nil
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	masterKey, err := aeskw.Unwrap(block, slot.Key)
+	if err != nil {
+		return nil, ErrInvalidPassphrase
+	}
+	return masterKey, nil
+}
+
+// Slots returns the key slots of the configuration, the passphrase of
+// a repository created before key slots being a slot of its own.
+func (c *Configuration) Slots() []KeySlot {
+	if len(c.KeySlots) != 0 {
+		return c.KeySlots
+	}
+	return []KeySlot{{
+		Name:      DEFAULT_KEYSLOT,
+		KDFParams: c.KDFParams,
+	}}
+}
+
+// Unlock returns the master key if passphrase opens one of the slots.
+func (c *Configuration) Unlock(passphrase []byte) ([]byte, error) {
+	for _, slot := range c.Slots() {
+		masterKey, err := slot.Unwrap(passphrase)
+		if err != nil {
+			continue
+		}
+		if VerifyCanary(c, masterKey) {
+			return masterKey, nil
+		}
+	}
+	return nil, ErrInvalidPassphrase
+}
+
+// AddKeySlot lets passphrase open the repository with masterKey, which
+// is write-only if it is the MAC key of an X25519 repository.
+func (c *Configuration) AddKeySlot(name string, kdfParams *KDFParams, passphrase []byte, masterKey []byte) error {
+	if !VerifyCanary(c, masterKey) {
+		return ErrInvalidPassphrase
+	}
+
+	slots := c.Slots()
+	if slices.ContainsFunc(slots, func(slot KeySlot) bool { return slot.Name == name }) {
+		return fmt.Errorf("%w: %s", ErrKeySlotExists, name)
+	}
+
+	slot, err := NewKeySlot(name, kdfParams, passphrase, masterKey)
+	if err != nil {
+		return err
+	}
+	slot.WriteOnly = c.IsWriteOnly(masterKey)
+	c.KeySlots = append(slices.Clone(slots), *slot)
+	return nil
+}
+
+// RemoveKeySlot removes the slot called name, unless it is the last.
+func (c *Configuration) RemoveKeySlot(name string) error {
+	slots := c.Slots()
+	idx := slices.IndexFunc(slots, func(slot KeySlot) bool { return slot.Name == name })
+	if idx == -1 {
+		return fmt.Errorf("%w: %s", ErrKeySlotNotFound, name)
+	}
+	if len(slots) == 1 {
+		return ErrLastKeySlot
+	}
+	c.KeySlots = slices.Delete(slices.Clone(slots), idx, idx+1)
+	return nil
+}
+
+// ChangeKeySlot replaces the passphrase of the slot called name.
+func (c *Configuration) ChangeKeySlot(name string, kdfParams *KDFParams, passphrase []byte, masterKey []byte) error {
+	if !VerifyCanary(c, masterKey) {
+		return ErrInvalidPassphrase
+	}
+
+	slots := c.Slots()
+	idx := slices.IndexFunc(slots, func(slot KeySlot) bool { return slot.Name == name })
+	if idx == -1 {
+		return fmt.Errorf("%w: %s", ErrKeySlotNotFound, name)
+	}
+	if c.IsWriteOnly(masterKey) && !slots[idx].WriteOnly {
+		return ErrKeySlotWriteOnly
+	}
+	if slots[idx].WriteOnly {
+		masterKey = MACKey(masterKey)
+	}
+
+	slot, err := NewKeySlot(name, kdfParams, passphrase, masterKey)
+	if err != nil {
+		return err
+	}
+	slot.WriteOnly = c.IsWriteOnly(masterKey)
+	c.KeySlots = slices.Clone(slots)
+	c.KeySlots[idx] = *slot
+	return nil
+}
@@ -0,0 +1,159 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func newTestKDFParams(t *testing.T) *KDFParams {
+	params, err := NewDefaultKDFParams("PBKDF2")
+	if err != nil {
+		t.Fatalf("Failed to create KDF parameters: %v", err)
+	}
+	return params
+}
+
+// newLegacyConfiguration returns a configuration whose key is derived
+// directly from passphrase, as before key slots.
+func newLegacyConfiguration(t *testing.T, passphrase []byte) (*Configuration, []byte) {
+	config := NewDefaultConfiguration()
+	config.KDFParams = *newTestKDFParams(t)
+
+	key, err := DeriveKey(config.KDFParams, passphrase)
+	if err != nil {
+		t.Fatalf("Failed to derive key from passphrase: %v", err)
+	}
+	config.Canary, err = DeriveCanary(config, key)
+	if err != nil {
+		t.Fatalf("Failed to derive canary: %v", err)
+	}
+	return config, key
+}
+
+func TestUnlockLegacy(t *testing.T) {
+	config, key := newLegacyConfiguration(t, []byte("old passphrase"))
+
+	unlocked, err := config.Unlock([]byte("old passphrase"))
+	if err != nil {
+		t.Fatalf("Failed to unlock legacy configuration: %v", err)
+	}
+	if !bytes.Equal(unlocked, key) {
+		t.Errorf("Unlocked key does not match the derived key")
+	}
+
+	if _, err := config.Unlock([]byte("wrong passphrase")); !errors.Is(err, ErrInvalidPassphrase) {
+		t.Errorf("Expected ErrInvalidPassphrase, got %v", err)
+	}
+
+	slots := config.Slots()
+	if len(slots) != 1 || slots[0].Name != DEFAULT_KEYSLOT || slots[0].Key != nil {
+		t.Errorf("Unexpected legacy slots: %+v", slots)
+	}
+}
+
+func TestKeySlots(t *testing.T) {
+	config, key := newLegacyConfiguration(t, []byte("old passphrase"))
+
+	if err := config.AddKeySlot("backup", newTestKDFParams(t), []byte("team passphrase"), key); err != nil {
+		t.Fatalf("Failed to add key slot: %v", err)
+	}
+	if len(config.KeySlots) != 2 {
+		t.Fatalf("Expected the legacy and the new slot, got %d slots", len(config.KeySlots))
+	}
+
+	for _, passphrase := range []string{"old passphrase", "team passphrase"} {
+		unlocked, err := config.Unlock([]byte(passphrase))
+		if err != nil {
+			t.Fatalf("Failed to unlock with %q: %v", passphrase, err)
+		}
+		if !bytes.Equal(unlocked, key) {
+			t.Errorf("Key unlocked with %q does not match", passphrase)
+		}
+	}
+
+	if err := config.AddKeySlot("backup", newTestKDFParams(t), []byte("other"), key); !errors.Is(err, ErrKeySlotExists) {
+		t.Errorf("Expected ErrKeySlotExists, got %v", err)
+	}
+	if err := config.AddKeySlot("other", newTestKDFParams(t), []byte("other"), make([]byte, 32)); !errors.Is(err, ErrInvalidPassphrase) {
+		t.Errorf("Expected ErrInvalidPassphrase for a wrong master key, got %v", err)
+	}
+
+	if err := config.ChangeKeySlot(DEFAULT_KEYSLOT, newTestKDFParams(t), []byte("new passphrase"), key); err != nil {
+		t.Fatalf("Failed to change key slot: %v", err)
+	}
+	if _, err := config.Unlock([]byte("old passphrase")); err == nil {
+		t.Errorf("The old passphrase still unlocks the configuration")
+	}
+	if unlocked, err := config.Unlock([]byte("new passphrase")); err != nil || !bytes.Equal(unlocked, key) {
+		t.Errorf("Failed to unlock with the new passphrase: %v", err)
+	}
+
+	if err := config.RemoveKeySlot("unknown"); !errors.Is(err, ErrKeySlotNotFound) {
+		t.Errorf("Expected ErrKeySlotNotFound, got %v", err)
+	}
+	if err := config.RemoveKeySlot(DEFAULT_KEYSLOT); err != nil {
+		t.Fatalf("Failed to remove key slot: %v", err)
+	}
+	if _, err := config.Unlock([]byte("new passphrase")); err == nil {
+		t.Errorf("A removed passphrase still unlocks the configuration")
+	}
+	if err := config.RemoveKeySlot("backup"); !errors.Is(err, ErrLastKeySlot) {
+		t.Errorf("Expected ErrLastKeySlot, got %v", err)
+	}
+}
+
+func TestRekeyedSecret(t *testing.T) {
+	config := NewDefaultConfiguration()
+	secret, err := NewMasterKey()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	rekeyed, err := NewRekeyedSecret(config, secret)
+	if err != nil {
+		t.Fatalf("Failed to rekey secret: %v", err)
+	}
+	if !bytes.Equal(MACKey(rekeyed), secret) {
+		t.Errorf("Rekeyed secret does not keep the MAC key")
+	}
+	if bytes.Equal(config.DataKey(rekeyed), secret) {
+		t.Errorf("Rekeyed secret keeps the data key")
+	}
+	if !bytes.Equal(config.DataKey(secret), secret) {
+		t.Errorf("Data key of a secret that was never rekeyed is not the secret")
+	}
+
+	// the wider secret goes through key slots and the canary
+	config.Canary, err = DeriveCanary(config, rekeyed)
+	if err != nil {
+		t.Fatalf("Failed to derive canary: %v", err)
+	}
+	slot, err := NewKeySlot(DEFAULT_KEYSLOT, newTestKDFParams(t), []byte("passphrase"), rekeyed)
+	if err != nil {
+		t.Fatalf("Failed to create key slot: %v", err)
+	}
+	config.KeySlots = []KeySlot{*slot}
+	unlocked, err := config.Unlock([]byte("passphrase"))
+	if err != nil {
+		t.Fatalf("Failed to unlock: %v", err)
+	}
+	if !bytes.Equal(unlocked, rekeyed) {
+		t.Errorf("Unlocked secret does not match the rekeyed one")
+	}
+	if VerifyCanary(config, secret) {
+		t.Errorf("Canary verified with the former secret")
+	}
+
+	asymmetric := NewDefaultConfiguration()
+	secret, err = NewAsymmetricKey(asymmetric)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	if _, err := NewRekeyedSecret(asymmetric, secret); !errors.Is(err, ErrRekeyAsymmetric) {
+		t.Errorf("Expected ErrRekeyAsymmetric, got %v", err)
+	}
+	if !bytes.Equal(asymmetric.DataKey(secret), secret) {
+		t.Errorf("Data key of an X25519 secret is not the secret")
+	}
+}
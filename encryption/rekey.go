@@ -0,0 +1,38 @@
+package encryption
+
+import "errors"
+
+// A repository rekeyed in place keeps its MAC key, which the blobs,
+// packfiles and states are addressed with, and encrypts with a new data
+// key: its secret is the MAC key followed by the data key, like an
+// X25519 one.  A rekeyed copy is given a new master key instead, its
+// snapshots being rewritten with new MACs.
+
+var ErrRekeyAsymmetric = errors.New("X25519 repositories can't be rekeyed")
+
+// NewRekeyedSecret returns a secret keeping the MAC key of secret with
+// a new random data key.
+func NewRekeyedSecret(config *Configuration, secret []byte) ([]byte, error) {
+	if config.IsAsymmetric() {
+		return nil, ErrRekeyAsymmetric
+	}
+
+	dataKey, err := NewMasterKey()
+	if err != nil {
+		return nil, err
+	}
+
+	rekeyed := make([]byte, 0, macKeySize+len(dataKey))
+	rekeyed = append(rekeyed, MACKey(secret)...)
+	return append(rekeyed, dataKey...), nil
+}
+
+// DataKey returns the part of secret that encrypts the data of a
+// symmetric repository, which is the whole secret until it is rekeyed.
+// The secret of an X25519 repository is returned as is.
+func (c *Configuration) DataKey(secret []byte) []byte {
+	if !c.IsAsymmetric() && len(secret) > macKeySize {
+		return secret[macKeySize:]
+	}
+	return secret
+}
@@ -8,27 +8,108 @@ import (
 	"fmt"
 	"hash"
 	"io"
+	"slices"
+	"strings"
 
 	"github.com/PlakarKorp/plakar/hashing"
 	aeskw "github.com/nickball/go-aes-key-wrap"
 	"github.com/tink-crypto/tink-go/v2/aead/subtle"
 	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
 	"golang.org/x/crypto/pbkdf2"
 	"golang.org/x/crypto/scrypt"
 )
 
 const (
-	chunkSize         = 64 * 1024 // Size of each chunk for encryption/decryption
-	DEFAULT_KDF       = "ARGON2ID"
-	AESGMSIV_OVERHEAD = subtle.AESGCMSIVNonceSize + aes.BlockSize
+	chunkSize          = 64 * 1024 // Size of each chunk for encryption/decryption
+	DEFAULT_KDF        = "ARGON2ID"
+	AESGMSIV_OVERHEAD  = subtle.AESGCMSIVNonceSize + aes.BlockSize
+	XCHACHA20_OVERHEAD = chacha20poly1305.NonceSizeX + chacha20poly1305.Overhead
+
+	DEFAULT_DATA_ALGORITHM = "AES256-GCM-SIV"
 )
 
+// dataCipher encrypts the chunks of a stream, prepending the nonce to
+// each of them.
+type dataCipher interface {
+	Encrypt(plaintext []byte, associatedData []byte) ([]byte, error)
+	Decrypt(ciphertext []byte, associatedData []byte) ([]byte, error)
+}
+
+type dataAlgorithm struct {
+	overhead  int
+	newCipher func(key []byte) (dataCipher, error)
+}
+
+var dataAlgorithms = map[string]dataAlgorithm{
+	"AES256-GCM-SIV": {
+		overhead:  AESGMSIV_OVERHEAD,
+		newCipher: func(key []byte) (dataCipher, error) { return subtle.NewAESGCMSIV(key) },
+	},
+	"XCHACHA20-POLY1305": {
+		overhead:  XCHACHA20_OVERHEAD,
+		newCipher: func(key []byte) (dataCipher, error) { return subtle.NewXChaCha20Poly1305(key) },
+	},
+}
+
+// dataAlgorithmAliases are the short names accepted by
+// LookupDataAlgorithm.
+var dataAlgorithmAliases = map[string]string{
+	"AES":       "AES256-GCM-SIV",
+	"AES256":    "AES256-GCM-SIV",
+	"XCHACHA20": "XCHACHA20-POLY1305",
+}
+
+// DataAlgorithms returns the names of the supported data encryption
+// algorithms.
+func DataAlgorithms() []string {
+	ret := make([]string, 0, len(dataAlgorithms))
+	for name := range dataAlgorithms {
+		ret = append(ret, name)
+	}
+	slices.Sort(ret)
+	return ret
+}
+
+// LookupDataAlgorithm returns the data encryption algorithm called name
+// or one of its short names, case-insensitively.
+func LookupDataAlgorithm(name string) (string, error) {
+	name = strings.ToUpper(name)
+	if alias, ok := dataAlgorithmAliases[name]; ok {
+		name = alias
+	}
+	if _, ok := dataAlgorithms[name]; !ok {
+		return "", fmt.Errorf("unsupported data encryption algorithm: %s", name)
+	}
+	return name, nil
+}
+
+func newDataCipher(config *Configuration, subkey []byte) (dataCipher, int, error) {
+	algorithm, ok := dataAlgorithms[config.DataAlgorithm]
+	if !ok {
+		return nil, 0, fmt.Errorf("unsupported data encryption algorithm: %s", config.DataAlgorithm)
+	}
+	aead, err := algorithm.newCipher(subkey)
+	if err != nil {
+		return nil, 0, err
+	}
+	return aead, algorithm.overhead, nil
+}
+
 type Configuration struct {
 	SubKeyAlgorithm string
 	DataAlgorithm   string
 	ChunkSize       int
 	KDFParams       KDFParams
 	Canary          []byte
+
+	// KeySlots wrap the master key for each passphrase, a repository
+	// created before them deriving the key from KDFParams.
+	KeySlots []KeySlot `msgpack:",omitempty"`
+
+	// PublicKey is the X25519 key subkeys are wrapped for, when
+	// SubKeyAlgorithm is X25519.
+	PublicKey []byte `msgpack:",omitempty"`
 }
 
 type KDFParams struct {
@@ -120,7 +201,7 @@ func NewDefaultConfiguration() *Configuration {
 
 	return &Configuration{
 		SubKeyAlgorithm: "AES256-KW",
-		DataAlgorithm:   "AES256-GCM-SIV",
+		DataAlgorithm:   DEFAULT_DATA_ALGORITHM,
 		ChunkSize:       chunkSize,
 		KDFParams:       *kdfParams,
 	}
@@ -144,12 +225,20 @@ func DeriveKey(params KDFParams, passphrase []byte) ([]byte, error) {
 	return nil, fmt.Errorf("unsupported KDF: %s", params.KDF)
 }
 
+// DeriveCanary returns a random block encrypted with key, which only
+// the same key decrypts. The canary of an X25519 repository is
+// encrypted as metadata, for write-only keys to be checked as well.
 func DeriveCanary(config *Configuration, key []byte) ([]byte, error) {
 	canary := make([]byte, 32)
 	if _, err := rand.Read(canary); err != nil {
 		return nil, err
 	}
 
+	config, key, err := config.Metadata(key)
+	if err != nil {
+		return nil, err
+	}
+
 	rd, err := EncryptStream(config, key, bytes.NewReader(canary))
 	if err != nil {
 		return nil, err
@@ -158,6 +247,17 @@ func DeriveCanary(config *Configuration, key []byte) ([]byte, error) {
 }
 
 func VerifyCanary(config *Configuration, key []byte) bool {
+	if config.IsAsymmetric() && !config.IsWriteOnly(key) {
+		if _, err := config.privateKey(key); err != nil {
+			return false
+		}
+	}
+
+	config, key, err := config.Metadata(key)
+	if err != nil {
+		return false
+	}
+
 	rd, err := DecryptStream(config, key, bytes.NewReader(config.Canary))
 	if err != nil {
 		return false
@@ -260,9 +360,9 @@ func DecryptSubkey(algorithm string, key []byte, r io.Reader) ([]byte, error) {
 	return nil, fmt.Errorf("not implemented")
 }
 
-// EncryptStream encrypts a stream using AES-GCM with a random session-specific subkey
+// EncryptStream encrypts a stream with the data algorithm of config and a random session-specific subkey
 func EncryptStream(config *Configuration, key []byte, r io.Reader) (io.Reader, error) {
-	if config.DataAlgorithm != "AES256-GCM-SIV" {
+	if _, ok := dataAlgorithms[config.DataAlgorithm]; !ok {
 		return nil, fmt.Errorf("unsupported data encryption algorithm: %s", config.DataAlgorithm)
 	}
 
@@ -272,12 +372,18 @@ func EncryptStream(config *Configuration, key []byte, r io.Reader) (io.Reader, e
 		return nil, err
 	}
 
-	subkeyBlock, err := EncryptSubkey(config.SubKeyAlgorithm, key, subkey)
+	var subkeyBlock []byte
+	var err error
+	if config.IsAsymmetric() {
+		subkeyBlock, err = encryptSubkey_X25519(config.PublicKey, subkey)
+	} else {
+		subkeyBlock, err = EncryptSubkey(config.SubKeyAlgorithm, key, subkey)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	dataGCM, err := subtle.NewAESGCMSIV(subkey)
+	aead, _, err := newDataCipher(config, subkey)
 	if err != nil {
 		return nil, err
 	}
@@ -306,7 +412,7 @@ func EncryptStream(config *Configuration, key []byte, r io.Reader) (io.Reader, e
 			}
 
 			if n > 0 {
-				encryptedChunk, err := dataGCM.Encrypt(chunk[:n], nil)
+				encryptedChunk, err := aead.Encrypt(chunk[:n], nil)
 				if err != nil {
 					pw.CloseWithError(err)
 					return
@@ -327,19 +433,32 @@ func EncryptStream(config *Configuration, key []byte, r io.Reader) (io.Reader, e
 	return pr, nil
 }
 
-// DecryptStream decrypts a stream using AES-GCM with a random session-specific subkey
+// DecryptStream decrypts a stream with the data algorithm of config and a random session-specific subkey
 func DecryptStream(config *Configuration, key []byte, r io.Reader) (io.Reader, error) {
-	if config.DataAlgorithm != "AES256-GCM-SIV" {
+	if _, ok := dataAlgorithms[config.DataAlgorithm]; !ok {
 		return nil, fmt.Errorf("unsupported data encryption algorithm: %s", config.DataAlgorithm)
 	}
 
-	subkey, err := DecryptSubkey(config.SubKeyAlgorithm, key, r)
-	if err != nil {
-		return nil, err
+	var subkey []byte
+	if config.IsAsymmetric() {
+		privateKey, err := config.privateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		subkey, err = decryptSubkey_X25519(privateKey, r)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		subkey, err = DecryptSubkey(config.SubKeyAlgorithm, key, r)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	// Set up AES-GCM for actual data decryption using the subkey
-	dataGCM, err := subtle.NewAESGCMSIV(subkey)
+	// Set up the actual data decryption using the subkey
+	aead, overhead, err := newDataCipher(config, subkey)
 	if err != nil {
 		return nil, err
 	}
@@ -350,14 +469,13 @@ func DecryptStream(config *Configuration, key []byte, r io.Reader) (io.Reader, e
 	go func() {
 		defer pw.Close()
 
-		buffer := make([]byte, config.ChunkSize+AESGMSIV_OVERHEAD)
+		buffer := make([]byte, config.ChunkSize+overhead)
 		for {
-			n, err := r.Read(buffer)
-			if err != nil {
-				if err != io.EOF {
-					pw.CloseWithError(err)
-					return
-				}
+			// chunks are read whole, only the last one being shorter
+			n, err := io.ReadFull(r, buffer)
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				pw.CloseWithError(err)
+				return
 			}
 
 			if n == 0 {
@@ -365,7 +483,7 @@ func DecryptStream(config *Configuration, key []byte, r io.Reader) (io.Reader, e
 			}
 
 			// Decrypt each chunk and write it to the pipe
-			decryptedChunk, err := dataGCM.Decrypt(buffer[:n], nil)
+			decryptedChunk, err := aead.Decrypt(buffer[:n], nil)
 			if err != nil {
 				pw.CloseWithError(err)
 				return
@@ -64,6 +64,15 @@ func Serialize(event Event) ([]byte, error) {
 	case FileError:
 		serialized.Type = "FileError"
 		serialized.Data, err = msgpack.Marshal(e)
+	case FileSkipped:
+		serialized.Type = "FileSkipped"
+		serialized.Data, err = msgpack.Marshal(e)
+	case FileDryRun:
+		serialized.Type = "FileDryRun"
+		serialized.Data, err = msgpack.Marshal(e)
+	case FileChange:
+		serialized.Type = "FileChange"
+		serialized.Data, err = msgpack.Marshal(e)
 	case FileMissing:
 		serialized.Type = "FileMissing"
 		serialized.Data, err = msgpack.Marshal(e)
@@ -205,6 +214,24 @@ func Deserialize(data []byte) (Event, error) {
 			return nil, err
 		}
 		return e, nil
+	case "FileSkipped":
+		var e FileSkipped
+		if err := msgpack.Unmarshal(serialized.Data, &e); err != nil {
+			return nil, err
+		}
+		return e, nil
+	case "FileDryRun":
+		var e FileDryRun
+		if err := msgpack.Unmarshal(serialized.Data, &e); err != nil {
+			return nil, err
+		}
+		return e, nil
+	case "FileChange":
+		var e FileChange
+		if err := msgpack.Unmarshal(serialized.Data, &e); err != nil {
+			return nil, err
+		}
+		return e, nil
 	case "FileMissing":
 		var e FileMissing
 		if err := msgpack.Unmarshal(serialized.Data, &e); err != nil {
@@ -463,6 +490,45 @@ func FileErrorEvent(snapshotID [32]byte, pathname string, message string) FileEr
 	return FileError{Timestamp: time.Now(), SnapshotID: snapshotID, Pathname: pathname, Message: message}
 }
 
+/**/
+type FileSkipped struct {
+	Timestamp time.Time
+
+	SnapshotID [32]byte
+	Pathname   string
+	Reason     string
+}
+
+func FileSkippedEvent(snapshotID [32]byte, pathname string, reason string) FileSkipped {
+	return FileSkipped{Timestamp: time.Now(), SnapshotID: snapshotID, Pathname: pathname, Reason: reason}
+}
+
+/**/
+type FileChange struct {
+	Timestamp time.Time
+
+	SnapshotID [32]byte
+	Pathname   string
+	Reason     string
+}
+
+func FileChangeEvent(snapshotID [32]byte, pathname string, reason string) FileChange {
+	return FileChange{Timestamp: time.Now(), SnapshotID: snapshotID, Pathname: pathname, Reason: reason}
+}
+
+/**/
+type FileDryRun struct {
+	Timestamp time.Time
+
+	SnapshotID [32]byte
+	Pathname   string
+	Action     string
+}
+
+func FileDryRunEvent(snapshotID [32]byte, pathname string, action string) FileDryRun {
+	return FileDryRun{Timestamp: time.Now(), SnapshotID: snapshotID, Pathname: pathname, Action: action}
+}
+
 /**/
 type FileMissing struct {
 	Timestamp time.Time
@@ -29,6 +29,14 @@ type ResOpen struct {
 	Err           string
 }
 
+type ReqPutConfig struct {
+	Data []byte
+}
+
+type ResPutConfig struct {
+	Err string
+}
+
 // states
 type ReqGetStates struct {
 }
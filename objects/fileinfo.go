@@ -25,6 +25,15 @@ type FileInfo struct {
 	Lusername  string      `json:"username" msgpack:"username"`   // local addition
 	Lgroupname string      `json:"groupname" msgpack:"groupname"` // local addition
 
+	// Only needed to restore files faithfully, absent from older
+	// snapshots.
+	LaccessTime time.Time `json:"access_time" msgpack:"access_time,omitempty"`
+	Lrdev       uint64    `json:"rdev" msgpack:"rdev,omitempty"`
+
+	// Only needed to detect changes preserving the modification
+	// time, absent from older snapshots.
+	LchangeTime time.Time `json:"change_time" msgpack:"change_time,omitempty"`
+
 	// Just in case we need something special to handle special
 	// OSes.
 	Flags uint32 `json:"flags" msgpack:"flags"`
@@ -53,6 +62,14 @@ func (f FileInfo) ModTime() time.Time {
 	return f.LmodTime
 }
 
+func (f FileInfo) AccessTime() time.Time {
+	return f.LaccessTime
+}
+
+func (f FileInfo) ChangeTime() time.Time {
+	return f.LchangeTime
+}
+
 func (f FileInfo) Dev() uint64 {
 	return f.Ldev
 }
@@ -61,6 +78,10 @@ func (f FileInfo) Ino() uint64 {
 	return f.Lino
 }
 
+func (f FileInfo) Rdev() uint64 {
+	return f.Lrdev
+}
+
 func (f FileInfo) Uid() uint64 {
 	return f.Luid
 }
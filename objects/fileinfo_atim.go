@@ -0,0 +1,17 @@
+//go:build linux || openbsd || dragonfly || solaris || illumos
+// +build linux openbsd dragonfly solaris illumos
+
+package objects
+
+import (
+	"syscall"
+	"time"
+)
+
+func accessTime(sys *syscall.Stat_t) time.Time {
+	return time.Unix(sys.Atim.Unix())
+}
+
+func changeTime(sys *syscall.Stat_t) time.Time {
+	return time.Unix(sys.Ctim.Unix())
+}
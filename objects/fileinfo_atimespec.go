@@ -0,0 +1,17 @@
+//go:build darwin || freebsd || netbsd
+// +build darwin freebsd netbsd
+
+package objects
+
+import (
+	"syscall"
+	"time"
+)
+
+func accessTime(sys *syscall.Stat_t) time.Time {
+	return time.Unix(sys.Atimespec.Unix())
+}
+
+func changeTime(sys *syscall.Stat_t) time.Time {
+	return time.Unix(sys.Ctimespec.Unix())
+}
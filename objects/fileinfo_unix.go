@@ -6,6 +6,7 @@ package objects
 import (
 	"io/fs"
 	"syscall"
+	"time"
 )
 
 func FileInfoFromStat(stat fs.FileInfo) FileInfo {
@@ -14,8 +15,14 @@ func FileInfoFromStat(stat fs.FileInfo) FileInfo {
 	Luid := uint64(0)
 	Lgid := uint64(0)
 	Lnlink := uint16(0)
+	Lrdev := uint64(0)
+	LaccessTime := time.Time{}
+	LchangeTime := time.Time{}
 
-	if _, ok := stat.Sys().(*syscall.Stat_t); ok {
+	if sys, ok := stat.Sys().(*syscall.Stat_t); ok {
+		Lrdev = uint64(sys.Rdev)
+		LaccessTime = accessTime(sys)
+		LchangeTime = changeTime(sys)
 		Ldev = uint64(stat.Sys().(*syscall.Stat_t).Dev)
 		Lino = uint64(stat.Sys().(*syscall.Stat_t).Ino)
 		Luid = uint64(stat.Sys().(*syscall.Stat_t).Uid)
@@ -33,6 +40,9 @@ func FileInfoFromStat(stat fs.FileInfo) FileInfo {
 		Luid:     Luid,
 		Lgid:     Lgid,
 		Lnlink:   Lnlink,
+
+		LaccessTime: LaccessTime,
+		Lrdev:       Lrdev,
+		LchangeTime: LchangeTime,
 	}
 }
-
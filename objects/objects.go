@@ -52,6 +52,40 @@ type Object struct {
 	Flags       uint32             `msgpack:"flags" json:"flags"`
 }
 
+// Extent is a region of the content of a file.
+type Extent struct {
+	Offset int64
+	Length int64
+}
+
+// DataExtents returns, in order, the regions of the object content
+// which are not holes.
+func (o *Object) DataExtents() []Extent {
+	var extents []Extent
+	var offset int64
+	for _, chunk := range o.Chunks {
+		if !chunk.IsHole() {
+			if n := len(extents); n != 0 && extents[n-1].Offset+extents[n-1].Length == offset {
+				extents[n-1].Length += int64(chunk.Length)
+			} else {
+				extents = append(extents, Extent{Offset: offset, Length: int64(chunk.Length)})
+			}
+		}
+		offset += int64(chunk.Length)
+	}
+	return extents
+}
+
+// Sparse tells whether some of the object content is made of holes.
+func (o *Object) Sparse() bool {
+	for _, chunk := range o.Chunks {
+		if chunk.IsHole() {
+			return true
+		}
+	}
+	return false
+}
+
 func (o *Object) Size() int64 {
 	var size int64
 	for _, chunk := range o.Chunks {
@@ -91,6 +125,12 @@ func (o *Object) Serialize() ([]byte, error) {
 	return msgpack.Marshal(o)
 }
 
+const (
+	// ChunkFlagHole marks a run of Length zero bytes, a hole of a
+	// sparse file, which has no blob in the repository.
+	ChunkFlagHole uint32 = 1 << iota
+)
+
 type Chunk struct {
 	Version    versioning.Version `msgpack:"version" json:"version"`
 	ContentMAC MAC                `msgpack:"contentMAC" json:"contentMAC"`
@@ -105,6 +145,10 @@ func NewChunk() *Chunk {
 	}
 }
 
+func (c *Chunk) IsHole() bool {
+	return c.Flags&ChunkFlagHole != 0
+}
+
 func NewChunkFromBytes(serialized []byte) (*Chunk, error) {
 	var c Chunk
 	if err := msgpack.Unmarshal(serialized, &c); err != nil {
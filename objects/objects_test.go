@@ -82,3 +82,17 @@ func TestObjectSerialize(t *testing.T) {
 
 	require.Equal(t, *object, deserialized)
 }
+
+func TestObjectDataExtents(t *testing.T) {
+	hole := Chunk{Length: 100, Flags: ChunkFlagHole}
+	data := Chunk{Length: 10}
+
+	object := &Object{Chunks: []Chunk{hole, data, data, hole, data, hole}}
+	require.True(t, object.Sparse())
+	require.Equal(t, int64(330), object.Size())
+	require.Equal(t, []Extent{{Offset: 100, Length: 20}, {Offset: 220, Length: 10}}, object.DataExtents())
+
+	object = &Object{Chunks: []Chunk{data, data}}
+	require.False(t, object.Sparse())
+	require.Equal(t, []Extent{{Offset: 0, Length: 20}}, object.DataExtents())
+}
@@ -30,6 +30,10 @@ type Blob struct {
 
 const BLOB_RECORD_SIZE = 56
 
+// The low byte of the flags of a blob holds the compression identifier
+// it was encoded with, see the compression package.
+const BLOB_FLAG_COMPRESSION_MASK = 0x000000ff
+
 type PackFile struct {
 	hasher hash.Hash
 	Blobs  []byte
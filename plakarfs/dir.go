@@ -62,7 +62,7 @@ func (d *Dir) Attr(ctx context.Context, a *fuse.Attr) error {
 		a.Ctime = snap.Header.Timestamp
 		a.Mtime = snap.Header.Timestamp
 		a.Atime = snap.Header.Timestamp
-		a.Size = snap.Header.GetSource(0).Summary.Directory.Size + snap.Header.GetSource(0).Summary.Below.Size
+		a.Size = snap.Header.GetSize()
 	} else {
 		d.snap = d.parent.snap
 		d.repo = d.parent.repo
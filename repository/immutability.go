@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/PlakarKorp/plakar/objects"
+	"github.com/PlakarKorp/plakar/resources"
+	"github.com/PlakarKorp/plakar/storage"
+)
+
+var ErrSnapshotProtected = errors.New("snapshot is protected by the retention policy of the repository")
+
+// protect wraps store so that it enforces the immutability policy of
+// config, if it has one.
+func protect(store storage.Store, config *storage.Configuration) (storage.Store, error) {
+	if config.Immutability == nil {
+		return store, nil
+	}
+	if err := config.Immutability.Validate(); err != nil {
+		return nil, err
+	}
+	return storage.NewImmutableStore(store, config.Immutability)
+}
+
+// PackfileWrittenAt returns when the packfile mac was added to the
+// repository, as recorded in its state.
+func (r *Repository) PackfileWrittenAt(mac objects.MAC) (time.Time, bool, error) {
+	if r.state == nil {
+		return time.Time{}, false, ErrNoState
+	}
+
+	pe, exists, err := r.state.GetPackfile(mac)
+	if err != nil || !exists {
+		return time.Time{}, false, err
+	}
+	return pe.Timestamp, true, nil
+}
+
+// PackfileProtected reports whether the packfile mac is still within
+// the retention period of the repository.
+func (r *Repository) PackfileProtected(mac objects.MAC) (bool, error) {
+	immutability := r.configuration.Immutability
+	if immutability == nil {
+		return false, nil
+	}
+
+	writtenAt, exists, err := r.PackfileWrittenAt(mac)
+	if err != nil || !exists {
+		return false, err
+	}
+	return immutability.Protects(writtenAt), nil
+}
+
+// CheckRetention fails with ErrSnapshotProtected if snapshotID cannot be
+// deleted yet. A snapshot is as old as the packfile holding its header.
+func (r *Repository) CheckRetention(snapshotID objects.MAC) error {
+	immutability := r.configuration.Immutability
+	if immutability == nil {
+		return nil
+	}
+	if r.state == nil {
+		return ErrNoState
+	}
+
+	delta, exists, err := r.state.GetDeltaForBlob(resources.RT_SNAPSHOT, snapshotID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrBlobNotFound
+	}
+
+	writtenAt, exists, err := r.PackfileWrittenAt(delta.Location.Packfile)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrPackfileNotFound
+	}
+
+	if immutability.Protects(writtenAt) {
+		return fmt.Errorf("%w: %x is protected until %s", ErrSnapshotProtected,
+			snapshotID[:4], immutability.ProtectedUntil(writtenAt).Format(time.RFC3339))
+	}
+	return nil
+}
@@ -0,0 +1,351 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/PlakarKorp/plakar/encryption"
+	"github.com/PlakarKorp/plakar/objects"
+	"github.com/PlakarKorp/plakar/packfile"
+	"github.com/PlakarKorp/plakar/resources"
+	"github.com/PlakarKorp/plakar/storage"
+	"github.com/PlakarKorp/plakar/versioning"
+)
+
+var (
+	ErrNotEncrypted    = errors.New("repository is not encrypted")
+	ErrRekeyAlgorithm  = errors.New("a rekey keeps the encryption algorithms of the repository")
+	ErrRekeyMACKey     = errors.New("a rekey keeps the MAC key of the repository")
+	ErrRekeyMismatch   = errors.New("rekeyed object does not match the original")
+	ErrInvalidPackfile = errors.New("invalid packfile")
+	ErrRekeyInProgress = errors.New("a rekey of the repository is in progress, run plakar rekey to complete it")
+)
+
+// Rekey re-encrypts the packfiles, states and locks of a repository with
+// a new data key, to the same store or to another one.
+//
+// Only the encryption layer of the objects changes: the MAC key is kept
+// so that they keep their names, and the encoded blobs keep their
+// lengths so that the locations recorded in the states and the packfile
+// indexes remain valid. Objects the destination already holds with the
+// new key are left as they are, which lets an interrupted rekey resume.
+//
+// As the former key can still compute the MACs, a rekey doesn't recover
+// from a leaked key: that takes copying the snapshots to a repository
+// with a new master key, which computes them all again.
+type Rekey struct {
+	repo   *Repository
+	dst    storage.Store
+	config *encryption.Configuration
+	secret []byte
+}
+
+// NewRekey prepares the rekey of the repository to dst, with the
+// encryption config keyed by secret, as returned by
+// encryption.NewRekeyedSecret.
+func (r *Repository) NewRekey(dst storage.Store, config *encryption.Configuration, secret []byte) (*Rekey, error) {
+	current := r.configuration.Encryption
+	if current == nil || r.AppContext().GetSecret() == nil {
+		return nil, ErrNotEncrypted
+	}
+	if current.IsAsymmetric() || config.IsAsymmetric() {
+		return nil, encryption.ErrRekeyAsymmetric
+	}
+	if config.DataAlgorithm != current.DataAlgorithm ||
+		config.SubKeyAlgorithm != current.SubKeyAlgorithm ||
+		config.ChunkSize != current.ChunkSize {
+		return nil, ErrRekeyAlgorithm
+	}
+	if !bytes.Equal(encryption.MACKey(secret), encryption.MACKey(r.AppContext().GetSecret())) {
+		return nil, ErrRekeyMACKey
+	}
+
+	return &Rekey{
+		repo:   r,
+		dst:    dst,
+		config: config,
+		secret: secret,
+	}, nil
+}
+
+// reencrypt decrypts data with the current key and encrypts it with the
+// new one, which must not change its length.
+func (rk *Rekey) reencrypt(data []byte) ([]byte, error) {
+	rd, err := encryption.DecryptStream(rk.repo.configuration.Encryption, rk.repo.dataKey(), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	rd, err = encryption.EncryptStream(rk.config, rk.config.DataKey(rk.secret), rd)
+	if err != nil {
+		return nil, err
+	}
+
+	rekeyed, err := io.ReadAll(rd)
+	if err != nil {
+		return nil, err
+	}
+	if len(rekeyed) != len(data) {
+		return nil, fmt.Errorf("%w: %d bytes instead of %d", ErrRekeyMismatch, len(rekeyed), len(data))
+	}
+	return rekeyed, nil
+}
+
+// decode decodes data encrypted with the new key.
+func (rk *Rekey) decode(data []byte, algorithm string) ([]byte, error) {
+	rd, err := rk.repo.decode(bytes.NewReader(data), rk.config, rk.config.DataKey(rk.secret), algorithm)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(rd)
+}
+
+// Packfile rekeys the packfile mac, and tells whether it had to.
+func (rk *Rekey) Packfile(mac objects.MAC) (bool, error) {
+	t0 := time.Now()
+	defer func() {
+		rk.repo.Logger().Trace("repository", "Rekey.Packfile(%x): %s", mac, time.Since(t0))
+	}()
+
+	return rk.rekey(resources.RT_PACKFILE, mac, rk.repo.store.GetPackfile, rk.dst.GetPackfile, rk.dst.PutPackfile,
+		rk.rekeyPackfile, rk.verifyPackfile)
+}
+
+// State rekeys the state mac, and tells whether it had to.
+func (rk *Rekey) State(mac objects.MAC) (bool, error) {
+	t0 := time.Now()
+	defer func() {
+		rk.repo.Logger().Trace("repository", "Rekey.State(%x): %s", mac, time.Since(t0))
+	}()
+
+	return rk.rekey(resources.RT_STATE, mac, rk.repo.store.GetState, rk.dst.GetState, rk.dst.PutState,
+		rk.rekeyMetadata, rk.verifyMetadata)
+}
+
+// Lock rekeys the lock mac, and tells whether it had to.
+func (rk *Rekey) Lock(mac objects.MAC) (bool, error) {
+	t0 := time.Now()
+	defer func() {
+		rk.repo.Logger().Trace("repository", "Rekey.Lock(%x): %s", mac, time.Since(t0))
+	}()
+
+	return rk.rekey(resources.RT_LOCK, mac, rk.repo.store.GetLock, rk.dst.GetLock, rk.dst.PutLock,
+		rk.rekeyMetadata, rk.verifyMetadata)
+}
+
+// GetLock returns the lock mac of the repository, which an interrupted
+// rekey may have encrypted with the new key already.
+func (rk *Rekey) GetLock(mac objects.MAC) (versioning.Version, io.Reader, error) {
+	version, rd, err := rk.repo.GetLock(mac)
+	if err == nil {
+		return version, rd, nil
+	}
+
+	version, data, rerr := rk.read(resources.RT_LOCK, mac, rk.repo.store.GetLock)
+	if rerr != nil {
+		return versioning.Version(0), nil, err
+	}
+	decoded, rerr := rk.decode(data, rk.repo.compressionAlgorithm())
+	if rerr != nil {
+		return versioning.Version(0), nil, err
+	}
+	return version, bytes.NewReader(decoded), nil
+}
+
+type rekeyFunc func(version versioning.Version, data []byte) ([]byte, error)
+type verifyFunc func(version versioning.Version, data []byte) error
+
+// rekey writes the object mac to the destination with the new key
+// unless it is already there, and verifies what it wrote.
+func (rk *Rekey) rekey(resourceType resources.Type, mac objects.MAC,
+	get, getDst func(objects.MAC) (io.Reader, error), putDst func(objects.MAC, io.Reader) error,
+	rekey rekeyFunc, verify verifyFunc) (bool, error) {
+
+	if rk.verify(resourceType, mac, getDst, verify) == nil {
+		return false, nil
+	}
+
+	version, data, err := rk.read(resourceType, mac, get)
+	if err != nil {
+		return false, err
+	}
+
+	rekeyed, err := rekey(version, data)
+	if err != nil {
+		return false, fmt.Errorf("%s %x: %w", resourceType, mac, err)
+	}
+
+	rd, err := storage.Serialize(rk.repo.GetMACHasher(), resourceType, version, bytes.NewReader(rekeyed))
+	if err != nil {
+		return false, err
+	}
+	if err := putDst(mac, rd); err != nil {
+		return false, err
+	}
+
+	if err := rk.verify(resourceType, mac, getDst, verify); err != nil {
+		return false, fmt.Errorf("%s %x: verification failed: %w", resourceType, mac, err)
+	}
+	return true, nil
+}
+
+// read returns the version and the unwrapped content of an object.
+func (rk *Rekey) read(resourceType resources.Type, mac objects.MAC, get func(objects.MAC) (io.Reader, error)) (versioning.Version, []byte, error) {
+	rd, err := get(mac)
+	if err != nil {
+		return versioning.Version(0), nil, err
+	}
+
+	version, rd, err := storage.Deserialize(rk.repo.GetMACHasher(), resourceType, rd)
+	if err != nil {
+		return versioning.Version(0), nil, err
+	}
+
+	data, err := io.ReadAll(rd)
+	if err != nil {
+		return versioning.Version(0), nil, err
+	}
+	return version, data, nil
+}
+
+func (rk *Rekey) verify(resourceType resources.Type, mac objects.MAC, get func(objects.MAC) (io.Reader, error), verify verifyFunc) error {
+	version, data, err := rk.read(resourceType, mac, get)
+	if err != nil {
+		return err
+	}
+	return verify(version, data)
+}
+
+// States and locks are encrypted as a whole, with the data key as the
+// repository is symmetric.
+func (rk *Rekey) rekeyMetadata(version versioning.Version, data []byte) ([]byte, error) {
+	return rk.reencrypt(data)
+}
+
+func (rk *Rekey) verifyMetadata(version versioning.Version, data []byte) error {
+	_, err := rk.decode(data, rk.repo.compressionAlgorithm())
+	return err
+}
+
+// splitPackfile returns the footer of a raw packfile, decoded with
+// decode, and the offset of its encoded footer.
+func splitPackfile(version versioning.Version, raw []byte, decode func([]byte) ([]byte, error)) (packfile.PackFileFooter, int, error) {
+	if len(raw) < 4 {
+		return packfile.PackFileFooter{}, 0, ErrInvalidPackfile
+	}
+	footerLength := int(binary.LittleEndian.Uint32(raw[len(raw)-4:]))
+	footerOffset := len(raw) - 4 - footerLength
+	if footerOffset < 0 {
+		return packfile.PackFileFooter{}, 0, ErrInvalidPackfile
+	}
+
+	footerbuf, err := decode(raw[footerOffset : len(raw)-4])
+	if err != nil {
+		return packfile.PackFileFooter{}, 0, err
+	}
+
+	footer, err := packfile.NewFooterFromBytes(version, footerbuf)
+	if err != nil {
+		return packfile.PackFileFooter{}, 0, err
+	}
+	if footer.IndexOffset > uint64(footerOffset) {
+		return packfile.PackFileFooter{}, 0, ErrInvalidPackfile
+	}
+	return footer, footerOffset, nil
+}
+
+// packfileIndex returns the index of a raw packfile, decoded with decode
+// and checked against the MAC recorded in its footer.
+func (rk *Rekey) packfileIndex(version versioning.Version, raw []byte, footer packfile.PackFileFooter, footerOffset int, decode func([]byte) ([]byte, error)) ([]packfile.Blob, error) {
+	indexbuf, err := decode(raw[footer.IndexOffset:footerOffset])
+	if err != nil {
+		return nil, err
+	}
+
+	hasher := rk.repo.GetMACHasher()
+	hasher.Write(indexbuf)
+	if !bytes.Equal(hasher.Sum(nil), footer.IndexMAC[:]) {
+		return nil, fmt.Errorf("%w: index MAC mismatch", ErrInvalidPackfile)
+	}
+
+	index, err := packfile.NewIndexFromBytes(version, indexbuf)
+	if err != nil {
+		return nil, err
+	}
+	for _, blob := range index {
+		if blob.Offset+uint64(blob.Length) > footer.IndexOffset {
+			return nil, fmt.Errorf("%w: blob %x out of bounds", ErrInvalidPackfile, blob.MAC)
+		}
+	}
+	return index, nil
+}
+
+// rekeyPackfile re-encrypts the blobs, index and footer of a packfile in
+// place.
+func (rk *Rekey) rekeyPackfile(version versioning.Version, raw []byte) ([]byte, error) {
+	decode := func(data []byte) ([]byte, error) {
+		rd, err := rk.repo.decode(bytes.NewReader(data), rk.repo.configuration.Encryption, rk.repo.dataKey(), rk.repo.compressionAlgorithm())
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(rd)
+	}
+
+	footer, footerOffset, err := splitPackfile(version, raw, decode)
+	if err != nil {
+		return nil, err
+	}
+	index, err := rk.packfileIndex(version, raw, footer, footerOffset, decode)
+	if err != nil {
+		return nil, err
+	}
+
+	rekeyed := bytes.Clone(raw)
+	sections := make([][2]uint64, 0, len(index)+2)
+	for _, blob := range index {
+		sections = append(sections, [2]uint64{blob.Offset, blob.Offset + uint64(blob.Length)})
+	}
+	sections = append(sections, [2]uint64{footer.IndexOffset, uint64(footerOffset)})
+	sections = append(sections, [2]uint64{uint64(footerOffset), uint64(len(raw) - 4)})
+
+	for _, section := range sections {
+		data, err := rk.reencrypt(raw[section[0]:section[1]])
+		if err != nil {
+			return nil, err
+		}
+		copy(rekeyed[section[0]:], data)
+	}
+	return rekeyed, nil
+}
+
+// verifyPackfile checks that the footer, index and blobs of a packfile
+// all decrypt with the new key.
+func (rk *Rekey) verifyPackfile(version versioning.Version, raw []byte) error {
+	decode := func(data []byte) ([]byte, error) {
+		return rk.decode(data, rk.repo.compressionAlgorithm())
+	}
+
+	footer, footerOffset, err := splitPackfile(version, raw, decode)
+	if err != nil {
+		return err
+	}
+	index, err := rk.packfileIndex(version, raw, footer, footerOffset, decode)
+	if err != nil {
+		return err
+	}
+
+	for _, blob := range index {
+		rd, err := encryption.DecryptStream(rk.config, rk.config.DataKey(rk.secret), bytes.NewReader(raw[blob.Offset:blob.Offset+uint64(blob.Length)]))
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(io.Discard, rd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -9,14 +9,13 @@ import (
 	"hash"
 	"io"
 	"iter"
-	"strings"
+	"sync"
 	"time"
 
 	chunkers "github.com/PlakarKorp/go-cdc-chunkers"
-	_ "github.com/PlakarKorp/go-cdc-chunkers/chunkers/fastcdc"
-	_ "github.com/PlakarKorp/go-cdc-chunkers/chunkers/ultracdc"
 	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/plakar/caching"
+	"github.com/PlakarKorp/plakar/chunking"
 	"github.com/PlakarKorp/plakar/compression"
 	"github.com/PlakarKorp/plakar/encryption"
 	"github.com/PlakarKorp/plakar/hashing"
@@ -39,6 +38,10 @@ type Repository struct {
 	store         storage.Store
 	state         *state.LocalState
 	configuration storage.Configuration
+	upgrade       *Upgrade
+
+	chunkingOnce     sync.Once
+	chunkingSelector *chunking.Selector
 
 	appContext *appcontext.AppContext
 }
@@ -65,7 +68,7 @@ func New(ctx *appcontext.AppContext, store storage.Store, config []byte) (*Repos
 
 	var hasher hash.Hash
 	if ctx.GetSecret() != nil {
-		hasher = hashing.GetMACHasher(storage.DEFAULT_HASHING_ALGORITHM, ctx.GetSecret())
+		hasher = hashing.GetMACHasher(storage.DEFAULT_HASHING_ALGORITHM, encryption.MACKey(ctx.GetSecret()))
 	} else {
 		hasher = hashing.GetHasher(storage.DEFAULT_HASHING_ALGORITHM)
 	}
@@ -85,6 +88,17 @@ func New(ctx *appcontext.AppContext, store storage.Store, config []byte) (*Repos
 		return nil, err
 	}
 
+	// the states may be encrypted with either key until the rekey
+	// completes
+	if configInstance.Rekey != nil {
+		return nil, ErrRekeyInProgress
+	}
+
+	store, err = protect(store, configInstance)
+	if err != nil {
+		return nil, err
+	}
+
 	r := &Repository{
 		store:         store,
 		configuration: *configInstance,
@@ -125,7 +139,7 @@ func NewNoRebuild(ctx *appcontext.AppContext, store storage.Store, config []byte
 
 	var hasher hash.Hash
 	if ctx.GetSecret() != nil {
-		hasher = hashing.GetMACHasher(storage.DEFAULT_HASHING_ALGORITHM, ctx.GetSecret())
+		hasher = hashing.GetMACHasher(storage.DEFAULT_HASHING_ALGORITHM, encryption.MACKey(ctx.GetSecret()))
 	} else {
 		hasher = hashing.GetHasher(storage.DEFAULT_HASHING_ALGORITHM)
 	}
@@ -145,6 +159,11 @@ func NewNoRebuild(ctx *appcontext.AppContext, store storage.Store, config []byte
 		return nil, err
 	}
 
+	store, err = protect(store, configInstance)
+	if err != nil {
+		return nil, err
+	}
+
 	r := &Repository{
 		store:         store,
 		configuration: *configInstance,
@@ -239,6 +258,12 @@ func (r *Repository) RebuildState() error {
 	// naturally with concurrent first backups.
 	r.state.UpdateSerialOr(r.configuration.RepositoryID)
 
+	upgrade, _, err := r.LastUpgrade()
+	if err != nil {
+		return err
+	}
+	r.upgrade = upgrade
+
 	return nil
 }
 
@@ -264,17 +289,23 @@ func (r *Repository) Decode(input io.Reader) (io.Reader, error) {
 		r.Logger().Trace("repository", "Decode: %s", time.Since(t0))
 	}()
 
+	return r.decode(input, r.configuration.Encryption, r.dataKey(), r.compressionAlgorithm())
+}
+
+// decode decrypts input with key and inflates it with the compression
+// algorithm, none if empty.
+func (r *Repository) decode(input io.Reader, config *encryption.Configuration, key []byte, algorithm string) (io.Reader, error) {
 	stream := input
-	if r.AppContext().GetSecret() != nil {
-		tmp, err := encryption.DecryptStream(r.configuration.Encryption, r.AppContext().GetSecret(), stream)
+	if key != nil {
+		tmp, err := encryption.DecryptStream(config, key, stream)
 		if err != nil {
 			return nil, err
 		}
 		stream = tmp
 	}
 
-	if r.configuration.Compression != nil {
-		tmp, err := compression.InflateStream(r.configuration.Compression.Algorithm, stream)
+	if algorithm != "" {
+		tmp, err := compression.InflateStream(algorithm, stream)
 		if err != nil {
 			return nil, err
 		}
@@ -290,17 +321,21 @@ func (r *Repository) Encode(input io.Reader) (io.Reader, error) {
 		r.Logger().Trace("repository", "Encode: %s", time.Since(t0))
 	}()
 
+	return r.encode(input, r.configuration.Encryption, r.dataKey(), r.compressionAlgorithm())
+}
+
+func (r *Repository) encode(input io.Reader, config *encryption.Configuration, key []byte, algorithm string) (io.Reader, error) {
 	stream := input
-	if r.configuration.Compression != nil {
-		tmp, err := compression.DeflateStream(r.configuration.Compression.Algorithm, stream)
+	if algorithm != "" {
+		tmp, err := compression.DeflateStream(algorithm, stream)
 		if err != nil {
 			return nil, err
 		}
 		stream = tmp
 	}
 
-	if r.AppContext().GetSecret() != nil {
-		tmp, err := encryption.EncryptStream(r.configuration.Encryption, r.AppContext().GetSecret(), stream)
+	if key != nil {
+		tmp, err := encryption.EncryptStream(config, key, stream)
 		if err != nil {
 			return nil, err
 		}
@@ -310,6 +345,111 @@ func (r *Repository) Encode(input io.Reader) (io.Reader, error) {
 	return stream, nil
 }
 
+// dataKey returns the key encrypting the data of the repository, nil
+// if it is not encrypted.
+func (r *Repository) dataKey() []byte {
+	secret := r.AppContext().GetSecret()
+	if secret == nil || r.configuration.Encryption == nil {
+		return secret
+	}
+	return r.configuration.Encryption.DataKey(secret)
+}
+
+// decodeMetadata decodes states and locks, which write-only clients of
+// an X25519 repository can read.
+func (r *Repository) decodeMetadata(input io.Reader) (io.Reader, error) {
+	secret := r.AppContext().GetSecret()
+	if secret == nil {
+		return r.decode(input, nil, nil, r.compressionAlgorithm())
+	}
+
+	config, key, err := r.configuration.Encryption.Metadata(secret)
+	if err != nil {
+		return nil, err
+	}
+	return r.decode(input, config, key, r.compressionAlgorithm())
+}
+
+func (r *Repository) encodeMetadata(input io.Reader) (io.Reader, error) {
+	secret := r.AppContext().GetSecret()
+	if secret == nil {
+		return r.encode(input, nil, nil, r.compressionAlgorithm())
+	}
+
+	config, key, err := r.configuration.Encryption.Metadata(secret)
+	if err != nil {
+		return nil, err
+	}
+	return r.encode(input, config, key, r.compressionAlgorithm())
+}
+
+// compressionAlgorithm returns the compression of the repository
+// configuration, which states, locks and the packfile indexes use.
+func (r *Repository) compressionAlgorithm() string {
+	if r.configuration.Compression == nil {
+		return ""
+	}
+	return r.configuration.Compression.Algorithm
+}
+
+// EncodeBlob encodes a blob with the compression of new snapshots, and
+// returns the flags recording it for DecodeBlob.
+func (r *Repository) EncodeBlob(input io.Reader) (io.Reader, uint32, error) {
+	t0 := time.Now()
+	defer func() {
+		r.Logger().Trace("repository", "EncodeBlob: %s", time.Since(t0))
+	}()
+
+	config := r.EffectiveConfiguration()
+
+	// blobs compressed as configured keep the flags older clients expect
+	var flags uint32
+	if !sameCompression(config.Compression, r.configuration.Compression) {
+		id, err := compression.AlgorithmID(config.Compression)
+		if err != nil {
+			return nil, 0, err
+		}
+		flags = uint32(id)
+	}
+
+	algorithm := ""
+	if config.Compression != nil {
+		algorithm = config.Compression.Algorithm
+	}
+
+	rd, err := r.encode(input, r.configuration.Encryption, r.dataKey(), algorithm)
+	if err != nil {
+		return nil, 0, err
+	}
+	return rd, flags, nil
+}
+
+// DecodeBlob decodes a blob written with flags.
+func (r *Repository) DecodeBlob(input io.Reader, flags uint32) (io.Reader, error) {
+	t0 := time.Now()
+	defer func() {
+		r.Logger().Trace("repository", "DecodeBlob: %s", time.Since(t0))
+	}()
+
+	algorithm := r.compressionAlgorithm()
+	if id := uint8(flags & packfile.BLOB_FLAG_COMPRESSION_MASK); id != compression.ID_DEFAULT {
+		var err error
+		algorithm, err = compression.LookupAlgorithmID(id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return r.decode(input, r.configuration.Encryption, r.dataKey(), algorithm)
+}
+
+func sameCompression(a, b *compression.Configuration) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Algorithm == b.Algorithm
+}
+
 func (r *Repository) DecodeBuffer(buffer []byte) ([]byte, error) {
 	t0 := time.Now()
 	defer func() {
@@ -337,7 +477,7 @@ func (r *Repository) EncodeBuffer(buffer []byte) ([]byte, error) {
 }
 
 func (r *Repository) GetMACHasher() hash.Hash {
-	secret := r.AppContext().GetSecret()
+	secret := encryption.MACKey(r.AppContext().GetSecret())
 	if secret == nil {
 		// unencrypted repo, derive 32-bytes "secret" from RepositoryID
 		// so ComputeMAC can be used similarly to encrypted repos
@@ -364,16 +504,29 @@ func (r *Repository) ComputeMAC(data []byte) objects.MAC {
 }
 
 func (r *Repository) Chunker(rd io.ReadCloser) (*chunkers.Chunker, error) {
-	chunkingAlgorithm := r.configuration.Chunking.Algorithm
-	chunkingMinSize := r.configuration.Chunking.MinSize
-	chunkingNormalSize := r.configuration.Chunking.NormalSize
-	chunkingMaxSize := r.configuration.Chunking.MaxSize
-
-	return chunkers.NewChunker(strings.ToLower(chunkingAlgorithm), rd, &chunkers.ChunkerOpts{
-		MinSize:    int(chunkingMinSize),
-		NormalSize: int(chunkingNormalSize),
-		MaxSize:    int(chunkingMaxSize),
+	config := r.EffectiveConfiguration()
+	return config.Chunking.NewChunker(rd)
+}
+
+// ChunkingFor returns the chunking configuration of a file, as chosen
+// by the chunking policies of the repository.
+func (r *Repository) ChunkingFor(pathname, contentType string) *chunking.Configuration {
+	r.chunkingOnce.Do(func() {
+		config := r.EffectiveConfiguration()
+		selector, err := chunking.NewSelector(&config.Chunking)
+		if err != nil {
+			r.Logger().Warn("ignoring the chunking policies: %s", err)
+			selector, _ = chunking.NewSelector(&chunking.Configuration{
+				Algorithm:  config.Chunking.Algorithm,
+				MinSize:    config.Chunking.MinSize,
+				NormalSize: config.Chunking.NormalSize,
+				MaxSize:    config.Chunking.MaxSize,
+				Threshold:  config.Chunking.Threshold,
+			})
+		}
+		r.chunkingSelector = selector
 	})
+	return r.chunkingSelector.Select(pathname, contentType)
 }
 
 func (r *Repository) NewStateDelta(cache *caching.ScanCache) *state.LocalState {
@@ -388,6 +541,54 @@ func (r *Repository) Configuration() storage.Configuration {
 	return r.configuration
 }
 
+// UpdateConfiguration replaces the configuration in the store, for
+// changes such as key slots that leave the stored data readable.
+// WrapConfiguration serializes config the way it is stored, with the
+// MAC key of the repository.
+func (r *Repository) WrapConfiguration(config *storage.Configuration) ([]byte, error) {
+	return WrapConfiguration(config, r.AppContext().GetSecret())
+}
+
+// WrapConfiguration serializes config for a repository keyed by secret,
+// which may be a new one.
+func WrapConfiguration(config *storage.Configuration, secret []byte) ([]byte, error) {
+	var hasher hash.Hash
+	if secret != nil {
+		hasher = hashing.GetMACHasher(storage.DEFAULT_HASHING_ALGORITHM, encryption.MACKey(secret))
+	} else {
+		hasher = hashing.GetHasher(storage.DEFAULT_HASHING_ALGORITHM)
+	}
+
+	serializedConfig, err := config.ToBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	rd, err := storage.Serialize(hasher, resources.RT_CONFIG, versioning.GetCurrentVersion(resources.RT_CONFIG), bytes.NewReader(serializedConfig))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(rd)
+}
+
+func (r *Repository) UpdateConfiguration(config *storage.Configuration) error {
+	t0 := time.Now()
+	defer func() {
+		r.Logger().Trace("repository", "UpdateConfiguration(): %s", time.Since(t0))
+	}()
+
+	wrappedConfig, err := r.WrapConfiguration(config)
+	if err != nil {
+		return err
+	}
+
+	if err := r.store.PutConfig(wrappedConfig); err != nil {
+		return err
+	}
+	r.configuration = *config
+	return nil
+}
+
 func (r *Repository) GetSnapshots() ([]objects.MAC, error) {
 	t0 := time.Now()
 	defer func() {
@@ -407,6 +608,10 @@ func (r *Repository) DeleteSnapshot(snapshotID objects.MAC) error {
 		r.Logger().Trace("repository", "DeleteSnapshot(%x): %s", snapshotID, time.Since(t0))
 	}()
 
+	if err := r.CheckRetention(snapshotID); err != nil {
+		return err
+	}
+
 	var identifier objects.MAC
 	n, err := rand.Read(identifier[:])
 	if err != nil {
@@ -466,7 +671,7 @@ func (r *Repository) GetState(mac objects.MAC) (versioning.Version, io.Reader, e
 		return versioning.Version(0), nil, err
 	}
 
-	rd, err = r.Decode(rd)
+	rd, err = r.decodeMetadata(rd)
 	if err != nil {
 		return versioning.Version(0), nil, err
 	}
@@ -479,7 +684,7 @@ func (r *Repository) PutState(mac objects.MAC, rd io.Reader) error {
 		r.Logger().Trace("repository", "PutState(%x, ...): %s", mac, time.Since(t0))
 	}()
 
-	rd, err := r.Encode(rd)
+	rd, err := r.encodeMetadata(rd)
 	if err != nil {
 		return err
 	}
@@ -576,10 +781,10 @@ func (r *Repository) GetPackfile(mac objects.MAC) (*packfile.PackFile, error) {
 	return p, nil
 }
 
-func (r *Repository) GetPackfileBlob(loc state.Location) (io.ReadSeeker, error) {
+func (r *Repository) GetPackfileBlob(loc state.Location, flags uint32) (io.ReadSeeker, error) {
 	t0 := time.Now()
 	defer func() {
-		r.Logger().Trace("repository", "GetPackfileBlob(%x, %d, %d): %s", loc.Packfile, loc.Offset, loc.Length, time.Since(t0))
+		r.Logger().Trace("repository", "GetPackfileBlob(%x, %d, %d, %x): %s", loc.Packfile, loc.Offset, loc.Length, flags, time.Since(t0))
 	}()
 
 	rd, err := r.store.GetPackfileBlob(loc.Packfile, loc.Offset+uint64(storage.STORAGE_HEADER_SIZE), loc.Length)
@@ -592,7 +797,12 @@ func (r *Repository) GetPackfileBlob(loc state.Location) (io.ReadSeeker, error)
 		return nil, err
 	}
 
-	decoded, err := r.DecodeBuffer(data)
+	rd, err = r.DecodeBlob(bytes.NewReader(data), flags)
+	if err != nil {
+		return nil, err
+	}
+
+	decoded, err := io.ReadAll(rd)
 	if err != nil {
 		return nil, err
 	}
@@ -708,7 +918,7 @@ func (r *Repository) GetBlob(Type resources.Type, mac objects.MAC) (io.ReadSeeke
 		r.Logger().Trace("repository", "GetBlob(%s, %x): %s", Type, mac, time.Since(t0))
 	}()
 
-	loc, exists, err := r.state.GetSubpartForBlob(Type, mac)
+	delta, exists, err := r.state.GetDeltaForBlob(Type, mac)
 	if err != nil {
 		return nil, err
 	}
@@ -717,7 +927,7 @@ func (r *Repository) GetBlob(Type resources.Type, mac objects.MAC) (io.ReadSeeke
 		return nil, ErrPackfileNotFound
 	}
 
-	rd, err := r.GetPackfileBlob(loc)
+	rd, err := r.GetPackfileBlob(delta.Location, delta.Flags)
 	if err != nil {
 		return nil, err
 	}
@@ -832,7 +1042,7 @@ func (r *Repository) GetLock(lockID objects.MAC) (versioning.Version, io.Reader,
 		return versioning.Version(0), nil, err
 	}
 
-	rd, err = r.Decode(rd)
+	rd, err = r.decodeMetadata(rd)
 	if err != nil {
 		return versioning.Version(0), nil, err
 	}
@@ -845,7 +1055,7 @@ func (r *Repository) PutLock(lockID objects.MAC, rd io.Reader) error {
 		r.Logger().Trace("repository", "PutLock(%x, ...): %s", lockID, time.Since(t0))
 	}()
 
-	rd, err := r.Encode(rd)
+	rd, err := r.encodeMetadata(rd)
 	if err != nil {
 		return err
 	}
@@ -652,17 +652,24 @@ func (ls *LocalState) BlobExists(Type resources.Type, blobMAC objects.MAC) bool
 }
 
 func (ls *LocalState) GetSubpartForBlob(Type resources.Type, blobMAC objects.MAC) (Location, bool, error) {
+	delta, exists, err := ls.GetDeltaForBlob(Type, blobMAC)
+	return delta.Location, exists, err
+}
+
+// GetDeltaForBlob returns the delta entry locating a blob in a live
+// packfile, along with the flags it was written with.
+func (ls *LocalState) GetDeltaForBlob(Type resources.Type, blobMAC objects.MAC) (DeltaEntry, bool, error) {
 	var delta *DeltaEntry
 	for _, buf := range ls.cache.GetDelta(Type, blobMAC) {
 		de, err := DeltaEntryFromBytes(buf)
 
 		if err != nil {
-			return Location{}, false, err
+			return DeltaEntry{}, false, err
 		}
 
 		ok, err := ls.cache.HasPackfile(de.Location.Packfile)
 		if err != nil {
-			return Location{}, false, err
+			return DeltaEntry{}, false, err
 		}
 
 		deleted, _ := ls.HasDeletedResource(resources.RT_PACKFILE, de.Location.Packfile)
@@ -673,9 +680,9 @@ func (ls *LocalState) GetSubpartForBlob(Type resources.Type, blobMAC objects.MAC
 	}
 
 	if delta == nil {
-		return Location{}, false, nil
+		return DeltaEntry{}, false, nil
 	} else {
-		return delta.Location, true, nil
+		return *delta, true, nil
 	}
 }
 
@@ -689,6 +696,19 @@ func (ls *LocalState) PutPackfile(stateId, packfile objects.MAC) error {
 	return ls.cache.PutPackfile(pe.Packfile, pe.ToBytes())
 }
 
+func (ls *LocalState) GetPackfile(packfile objects.MAC) (PackfileEntry, bool, error) {
+	buf, err := ls.cache.GetPackfile(packfile)
+	if err != nil || buf == nil {
+		return PackfileEntry{}, false, err
+	}
+
+	pe, err := PackfileEntryFromBytes(buf)
+	if err != nil {
+		return PackfileEntry{}, false, err
+	}
+	return pe, true, nil
+}
+
 func (ls *LocalState) DelPackfile(packfile objects.MAC) error {
 	return ls.cache.DelPackfile(packfile)
 }
@@ -806,6 +826,23 @@ func (ls *LocalState) SetConfiguration(key string, value []byte) error {
 	return ls.insertOrUpdateConfiguration(ce)
 }
 
+// GetConfiguration returns the most recent configuration entry for key.
+func (ls *LocalState) GetConfiguration(key string) (ConfigurationEntry, bool, error) {
+	value, err := ls.cache.GetConfiguration(key)
+	if err != nil && err != leveldb.ErrNotFound {
+		return ConfigurationEntry{}, false, err
+	}
+	if err != nil || value == nil {
+		return ConfigurationEntry{}, false, nil
+	}
+
+	ce, err := ConfigurationEntryFromBytes(value)
+	if err != nil {
+		return ConfigurationEntry{}, false, err
+	}
+	return ce, true, nil
+}
+
 // Internal function used by deserialization that only updates our local on
 // disk state if the provided configuration is more recent than the stored one
 func (ls *LocalState) insertOrUpdateConfiguration(ce ConfigurationEntry) error {
@@ -815,7 +852,8 @@ func (ls *LocalState) insertOrUpdateConfiguration(ce ConfigurationEntry) error {
 		return err
 	}
 
-	if err != nil && err == leveldb.ErrNotFound {
+	// the caches report a missing key as a nil value
+	if err == nil && value != nil {
 		oldCe, err := ConfigurationEntryFromBytes(value)
 		if err != nil {
 			return err
@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/PlakarKorp/plakar/chunking"
+	"github.com/PlakarKorp/plakar/compression"
+	"github.com/PlakarKorp/plakar/objects"
+	"github.com/PlakarKorp/plakar/packfile"
+	"github.com/PlakarKorp/plakar/resources"
+	"github.com/PlakarKorp/plakar/storage"
+	"github.com/PlakarKorp/plakar/versioning"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// UPGRADE_CONFIGURATION_KEY is the state configuration entry holding
+// the latest upgrade of a repository.
+const UPGRADE_CONFIGURATION_KEY = "upgrade"
+
+var ErrNoState = errors.New("repository state not loaded")
+
+// Upgrade holds the settings of the snapshots made after it. They are
+// recorded in the state rather than in the configuration of the
+// repository, which keeps describing how its states, locks and older
+// packfiles are encoded; each blob records its own compression.
+type Upgrade struct {
+	Packfile    packfile.Configuration
+	Chunking    chunking.Configuration
+	Compression *compression.Configuration
+}
+
+// NewUpgrade returns an upgrade holding the settings of config.
+func NewUpgrade(config *storage.Configuration) *Upgrade {
+	return &Upgrade{
+		Packfile:    config.Packfile,
+		Chunking:    config.Chunking,
+		Compression: config.Compression,
+	}
+}
+
+func (u *Upgrade) Validate() error {
+	if u.Packfile.MaxSize == 0 {
+		return fmt.Errorf("invalid packfile max size: 0")
+	}
+	if err := u.Chunking.Validate(); err != nil {
+		return err
+	}
+	if _, err := compression.AlgorithmID(u.Compression); err != nil {
+		return err
+	}
+	return nil
+}
+
+// EffectiveConfiguration returns the configuration new snapshots are
+// made with: the one of the repository with its latest upgrade applied.
+func (r *Repository) EffectiveConfiguration() storage.Configuration {
+	config := r.configuration
+	if r.upgrade != nil {
+		config.Packfile = r.upgrade.Packfile
+		config.Chunking = r.upgrade.Chunking
+		config.Compression = r.upgrade.Compression
+	}
+	return config
+}
+
+// LastUpgrade returns the latest upgrade of the repository and when it
+// happened, or nil if there was none.
+func (r *Repository) LastUpgrade() (*Upgrade, time.Time, error) {
+	if r.state == nil {
+		return nil, time.Time{}, ErrNoState
+	}
+
+	ce, exists, err := r.state.GetConfiguration(UPGRADE_CONFIGURATION_KEY)
+	if err != nil || !exists {
+		return nil, time.Time{}, err
+	}
+
+	var upgrade Upgrade
+	if err := msgpack.Unmarshal(ce.Value, &upgrade); err != nil {
+		return nil, time.Time{}, fmt.Errorf("invalid repository upgrade: %w", err)
+	}
+	return &upgrade, ce.CreatedAt, nil
+}
+
+// Upgrade records new settings for the snapshots made after it, in a
+// state pushed to the repository.
+func (r *Repository) Upgrade(upgrade *Upgrade) error {
+	t0 := time.Now()
+	defer func() {
+		r.Logger().Trace("repository", "Upgrade(): %s", time.Since(t0))
+	}()
+
+	if r.state == nil {
+		return ErrNoState
+	}
+	if err := upgrade.Validate(); err != nil {
+		return err
+	}
+
+	value, err := msgpack.Marshal(upgrade)
+	if err != nil {
+		return err
+	}
+	if len(value) > math.MaxUint16 {
+		return fmt.Errorf("repository upgrade too large: %d bytes", len(value))
+	}
+
+	var identifier objects.MAC
+	n, err := rand.Read(identifier[:])
+	if err != nil {
+		return err
+	}
+	if n != len(identifier) {
+		return io.ErrShortWrite
+	}
+
+	sc, err := r.AppContext().GetCache().Scan(identifier)
+	if err != nil {
+		return err
+	}
+	defer sc.Close()
+
+	deltaState := r.state.Derive(sc)
+	if err := deltaState.SetConfiguration(UPGRADE_CONFIGURATION_KEY, value); err != nil {
+		return err
+	}
+
+	buffer := &bytes.Buffer{}
+	if err := deltaState.SerializeToStream(buffer); err != nil {
+		return err
+	}
+	serialized := buffer.Bytes()
+
+	mac := r.ComputeMAC(serialized)
+	if err := r.PutState(mac, bytes.NewReader(serialized)); err != nil {
+		return err
+	}
+
+	// merge it right away, as the next rebuild would
+	version := versioning.GetCurrentVersion(resources.RT_STATE)
+	if err := r.state.InsertState(version, mac, bytes.NewReader(serialized)); err != nil {
+		return err
+	}
+
+	r.upgrade = upgrade
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"reflect"
 	"strings"
 
+	"github.com/PlakarKorp/plakar/secrets"
 	"github.com/go-playground/validator/v10"
 	"github.com/go-viper/mapstructure/v2"
 
@@ -19,6 +20,30 @@ type RepositoryConfig struct {
 	Name       string
 	Location   string
 	Passphrase string
+
+	// Providers of the passphrase, rather than writing it down.
+	PassphraseCmd     string `mapstructure:"passphrase_cmd"`
+	PassphraseFile    string `mapstructure:"passphrase_file"`
+	PassphraseEnv     string `mapstructure:"passphrase_env"`
+	PassphraseKeyring string `mapstructure:"passphrase_keyring"`
+}
+
+// StoreConfig returns the repository configuration as plakar config
+// stores it, for the passphrase to be resolved the same way.
+func (r RepositoryConfig) StoreConfig() map[string]string {
+	storeConfig := map[string]string{"location": r.Location}
+	for key, value := range map[string]string{
+		secrets.KEY_PASSPHRASE:         r.Passphrase,
+		secrets.KEY_PASSPHRASE_CMD:     r.PassphraseCmd,
+		secrets.KEY_PASSPHRASE_FILE:    r.PassphraseFile,
+		secrets.KEY_PASSPHRASE_ENV:     r.PassphraseEnv,
+		secrets.KEY_PASSPHRASE_KEYRING: r.PassphraseKeyring,
+	} {
+		if value != "" {
+			storeConfig[key] = value
+		}
+	}
+	return storeConfig
 }
 
 type AgentConfig struct {
@@ -56,12 +81,35 @@ type Task struct {
 }
 
 type BackupConfig struct {
-	Name      string
-	Tags      []string
-	Path      string `validate:"required"`
-	Interval  string `validate:"required"`
-	Check     BackupConfigCheck
-	Retention string
+	Name        string
+	Category    string
+	Environment string
+	Perimeter   string
+	Tags        []string
+	Meta        map[string]string
+	Path        string `validate:"required"`
+	Interval    string `validate:"required"`
+	Check       BackupConfigCheck
+	Retention   string
+
+	ChangeDetection string `mapstructure:"change_detection"`
+
+	// Rehash is the interval at which a backup reads all files again
+	// rather than trusting the change detection.
+	Rehash string
+
+	// ScanSecrets scans the files read for secrets and personal data.
+	ScanSecrets bool `mapstructure:"scan_secrets"`
+
+	// Commands run around the backup, killed after HookTimeout.
+	PreBackup   string `mapstructure:"pre_backup"`
+	PostBackup  string `mapstructure:"post_backup"`
+	OnFailure   string `mapstructure:"on_failure"`
+	HookTimeout string `mapstructure:"hook_timeout"`
+
+	// Error budget past which the snapshot is failed, unset for none.
+	MaxErrors     *int64  `mapstructure:"max_errors"`
+	MaxErrorRatio float64 `mapstructure:"max_error_ratio"`
 }
 
 // CheckDecodeHook is a mapstructure decode hook to allow users to specify
@@ -1,10 +1,15 @@
 package scheduler
 
 import (
+	"fmt"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/repository"
+	"github.com/PlakarKorp/plakar/secrets"
+	"github.com/PlakarKorp/plakar/storage"
 )
 
 type Scheduler struct {
@@ -21,6 +26,66 @@ func stringToDuration(s string) (time.Duration, error) {
 	return d, nil
 }
 
+// unlock returns the secret of the repository configured with
+// storeConfig, nil if it isn't encrypted.
+func unlock(storeConfig map[string]string, serializedConfig []byte) ([]byte, error) {
+	config, err := storage.NewConfigurationFromWrappedBytes(serializedConfig)
+	if err != nil {
+		return nil, err
+	}
+	if config.Encryption == nil {
+		return nil, nil
+	}
+	// there is no one to prompt, the passphrase must be configured
+	return secrets.Unlock(nil, storeConfig, config.Encryption, nil)
+}
+
+// openRepository opens the repository of a task with a context of its
+// own holding the secret.
+func (s *Scheduler) openRepository(repoConfig RepositoryConfig) (*appcontext.AppContext, storage.Store, *repository.Repository, error) {
+	storeConfig := repoConfig.StoreConfig()
+	store, serializedConfig, err := storage.Open(storeConfig)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	secret, err := unlock(storeConfig, serializedConfig)
+	if err != nil {
+		store.Close()
+		return nil, nil, nil, err
+	}
+
+	ctx := appcontext.NewAppContextFrom(s.ctx)
+	ctx.SetSecret(secret)
+	repo, err := repository.New(ctx, store, serializedConfig)
+	if err != nil {
+		ctx.Close()
+		store.Close()
+		return nil, nil, nil, err
+	}
+	return ctx, store, repo, nil
+}
+
+// peerSecret returns the secret of the peer of a sync task, either a
+// location or the name of a configured repository prefixed with @.
+func (s *Scheduler) peerSecret(peer string) ([]byte, error) {
+	storeConfig := map[string]string{"location": peer}
+	if strings.HasPrefix(peer, "@") {
+		remote, ok := s.ctx.Config.GetRepository(peer[1:])
+		if !ok {
+			return nil, fmt.Errorf("could not resolve repository: %s", peer)
+		}
+		storeConfig = remote
+	}
+
+	store, serializedConfig, err := storage.Open(storeConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+	return unlock(storeConfig, serializedConfig)
+}
+
 func NewScheduler(ctx *appcontext.AppContext, config *Configuration) *Scheduler {
 	return &Scheduler{
 		ctx:    ctx,
@@ -2,6 +2,8 @@ package scheduler
 
 import (
 	"fmt"
+	"maps"
+	"slices"
 	"time"
 
 	"github.com/PlakarKorp/plakar/appcontext"
@@ -11,8 +13,8 @@ import (
 	"github.com/PlakarKorp/plakar/cmd/plakar/subcommands/restore"
 	"github.com/PlakarKorp/plakar/cmd/plakar/subcommands/rm"
 	"github.com/PlakarKorp/plakar/cmd/plakar/subcommands/sync"
-	"github.com/PlakarKorp/plakar/repository"
-	"github.com/PlakarKorp/plakar/storage"
+	"github.com/PlakarKorp/plakar/snapshot"
+	"github.com/PlakarKorp/plakar/snapshot/header"
 )
 
 func (s *Scheduler) backupTask(taskset Task, task BackupConfig) error {
@@ -29,15 +31,64 @@ func (s *Scheduler) backupTask(taskset Task, task BackupConfig) error {
 		}
 	}
 
+	var rehash time.Duration
+	if task.Rehash != "" {
+		rehash, err = stringToDuration(task.Rehash)
+		if err != nil {
+			return err
+		}
+	}
+
+	hookTimeout := backup.DefaultHookTimeout
+	if task.HookTimeout != "" {
+		hookTimeout, err = stringToDuration(task.HookTimeout)
+		if err != nil {
+			return err
+		}
+	}
+
+	var errorBudget *snapshot.ErrorBudget
+	if task.MaxErrors != nil || task.MaxErrorRatio != 0 {
+		if task.MaxErrorRatio < 0 || task.MaxErrorRatio > 1 {
+			return fmt.Errorf("invalid max error ratio: %g", task.MaxErrorRatio)
+		}
+		errorBudget = &snapshot.ErrorBudget{MaxErrors: -1, MaxRatio: task.MaxErrorRatio}
+		if task.MaxErrors != nil {
+			errorBudget.MaxErrors = *task.MaxErrors
+		}
+	}
+
+	var changeDetection snapshot.ChangeDetection
+	if task.ChangeDetection != "" {
+		changeDetection, err = snapshot.ParseChangeDetection(task.ChangeDetection)
+		if err != nil {
+			return err
+		}
+	}
+
 	backupSubcommand := &backup.Backup{}
 	backupSubcommand.RepositoryLocation = taskset.Repository.Location
-	if taskset.Repository.Passphrase != "" {
-		backupSubcommand.RepositorySecret = []byte(taskset.Repository.Passphrase)
-		_ = backupSubcommand.RepositorySecret
-	}
 	backupSubcommand.Silent = true
 	backupSubcommand.Job = taskset.Name
-	backupSubcommand.Path = task.Path
+	backupSubcommand.Paths = []string{task.Path}
+	backupSubcommand.OptName = "default"
+	if task.Name != "" {
+		backupSubcommand.OptName = task.Name
+	}
+	backupSubcommand.OptCategory = task.Category
+	backupSubcommand.OptEnvironment = task.Environment
+	backupSubcommand.OptPerimeter = task.Perimeter
+	backupSubcommand.Tags = task.Tags
+	for _, key := range slices.Sorted(maps.Keys(task.Meta)) {
+		backupSubcommand.Meta = append(backupSubcommand.Meta, header.KeyValue{Key: key, Value: task.Meta[key]})
+	}
+	backupSubcommand.ChangeDetection = changeDetection
+	backupSubcommand.ScanSecrets = task.ScanSecrets
+	backupSubcommand.PreHook = task.PreBackup
+	backupSubcommand.PostHook = task.PostBackup
+	backupSubcommand.FailureHook = task.OnFailure
+	backupSubcommand.HookTimeout = hookTimeout
+	backupSubcommand.ErrorBudget = errorBudget
 	backupSubcommand.Quiet = true
 	if task.Check.Enabled {
 		backupSubcommand.OptCheck = true
@@ -45,16 +96,13 @@ func (s *Scheduler) backupTask(taskset Task, task BackupConfig) error {
 
 	rmSubcommand := &rm.Rm{}
 	rmSubcommand.RepositoryLocation = taskset.Repository.Location
-	if taskset.Repository.Passphrase != "" {
-		rmSubcommand.RepositorySecret = []byte(taskset.Repository.Passphrase)
-		_ = rmSubcommand.RepositorySecret
-	}
 	rmSubcommand.OptJob = task.Name
 
 	s.wg.Add(1)
 	go func() {
 		defer s.wg.Done()
 		firstRun := true
+		lastRehash := time.Now()
 		for {
 			if firstRun {
 				firstRun = false
@@ -62,18 +110,13 @@ func (s *Scheduler) backupTask(taskset Task, task BackupConfig) error {
 				time.Sleep(interval)
 			}
 
-			store, config, err := storage.Open(map[string]string{"location": backupSubcommand.RepositoryLocation})
-			if err != nil {
-				s.ctx.GetLogger().Error("Error opening storage: %s", err)
-				continue
-			}
-
-			newCtx := appcontext.NewAppContextFrom(s.ctx)
+			// periodically read everything again, in case some change
+			// went unnoticed
+			backupSubcommand.ForceRehash = rehash != 0 && time.Since(lastRehash) >= rehash
 
-			repo, err := repository.New(newCtx, store, config)
+			newCtx, store, repo, err := s.openRepository(taskset.Repository)
 			if err != nil {
 				s.ctx.GetLogger().Error("Error opening repository: %s", err)
-				store.Close()
 				continue
 			}
 
@@ -85,6 +128,9 @@ func (s *Scheduler) backupTask(taskset Task, task BackupConfig) error {
 				goto close
 			}
 			backupCtx.Close()
+			if backupSubcommand.ForceRehash {
+				lastRehash = time.Now()
+			}
 
 			if task.Retention != "" {
 				rmCtx := appcontext.NewAppContextFrom(newCtx)
@@ -114,10 +160,6 @@ func (s *Scheduler) checkTask(taskset Task, task CheckConfig) error {
 
 	checkSubcommand := &check.Check{}
 	checkSubcommand.RepositoryLocation = taskset.Repository.Location
-	if taskset.Repository.Passphrase != "" {
-		checkSubcommand.RepositorySecret = []byte(taskset.Repository.Passphrase)
-		_ = checkSubcommand.RepositorySecret
-	}
 	checkSubcommand.OptJob = taskset.Name
 	checkSubcommand.OptLatest = task.Latest
 	checkSubcommand.Silent = true
@@ -136,18 +178,9 @@ func (s *Scheduler) checkTask(taskset Task, task CheckConfig) error {
 				time.Sleep(interval)
 			}
 
-			store, config, err := storage.Open(map[string]string{"location": checkSubcommand.RepositoryLocation})
-			if err != nil {
-				s.ctx.GetLogger().Error("Error opening storage: %s", err)
-				continue
-			}
-
-			newCtx := appcontext.NewAppContextFrom(s.ctx)
-
-			repo, err := repository.New(newCtx, store, config)
+			newCtx, store, repo, err := s.openRepository(taskset.Repository)
 			if err != nil {
 				s.ctx.GetLogger().Error("Error opening repository: %s", err)
-				store.Close()
 				continue
 			}
 
@@ -173,10 +206,6 @@ func (s *Scheduler) restoreTask(taskset Task, task RestoreConfig) error {
 
 	restoreSubcommand := &restore.Restore{}
 	restoreSubcommand.RepositoryLocation = taskset.Repository.Location
-	if taskset.Repository.Passphrase != "" {
-		restoreSubcommand.RepositorySecret = []byte(taskset.Repository.Passphrase)
-		_ = restoreSubcommand.RepositorySecret
-	}
 	restoreSubcommand.OptJob = taskset.Name
 	restoreSubcommand.Target = task.Target
 	restoreSubcommand.Silent = true
@@ -195,18 +224,9 @@ func (s *Scheduler) restoreTask(taskset Task, task RestoreConfig) error {
 				time.Sleep(interval)
 			}
 
-			store, config, err := storage.Open(map[string]string{"location": restoreSubcommand.RepositoryLocation})
-			if err != nil {
-				s.ctx.GetLogger().Error("Error opening storage: %s", err)
-				continue
-			}
-
-			newCtx := appcontext.NewAppContextFrom(s.ctx)
-
-			repo, err := repository.New(newCtx, store, config)
+			newCtx, store, repo, err := s.openRepository(taskset.Repository)
 			if err != nil {
 				s.ctx.GetLogger().Error("Error opening repository: %s", err)
-				store.Close()
 				continue
 			}
 
@@ -232,10 +252,6 @@ func (s *Scheduler) syncTask(taskset Task, task SyncConfig) error {
 
 	syncSubcommand := &sync.Sync{}
 	syncSubcommand.SourceRepositoryLocation = taskset.Repository.Location
-	if taskset.Repository.Passphrase != "" {
-		syncSubcommand.SourceRepositorySecret = []byte(taskset.Repository.Passphrase)
-		_ = syncSubcommand.SourceRepositorySecret
-	}
 
 	syncSubcommand.PeerRepositoryLocation = task.Peer
 	if task.Direction == SyncDirectionTo {
@@ -247,10 +263,6 @@ func (s *Scheduler) syncTask(taskset Task, task SyncConfig) error {
 	} else {
 		return fmt.Errorf("invalid sync direction: %s", task.Direction)
 	}
-	//	if taskset.Repository.Passphrase != "" {
-	//		syncSubcommand.DestinationRepositorySecret = []byte(taskset.Repository.Passphrase)
-	//		_ = syncSubcommand.DestinationRepositorySecret
-
 	//	syncSubcommand.OptJob = taskset.Name
 	//	syncSubcommand.Target = task.Target
 	//	syncSubcommand.Silent = true
@@ -266,17 +278,17 @@ func (s *Scheduler) syncTask(taskset Task, task SyncConfig) error {
 				time.Sleep(interval)
 			}
 
-			store, config, err := storage.Open(map[string]string{"location": syncSubcommand.SourceRepositoryLocation})
+			newCtx, store, repo, err := s.openRepository(taskset.Repository)
 			if err != nil {
-				s.ctx.GetLogger().Error("sync: error opening storage: %s", err)
+				s.ctx.GetLogger().Error("sync: error opening repository: %s", err)
 				continue
 			}
 
-			newCtx := appcontext.NewAppContextFrom(s.ctx)
-
-			repo, err := repository.New(newCtx, store, config)
+			syncSubcommand.PeerRepositorySecret, err = s.peerSecret(task.Peer)
 			if err != nil {
-				s.ctx.GetLogger().Error("sync: error opening repository: %s", err)
+				s.ctx.GetLogger().Error("sync: error unlocking peer repository: %s", err)
+				newCtx.Close()
+				repo.Close()
 				store.Close()
 				continue
 			}
@@ -305,17 +317,9 @@ func (s *Scheduler) maintenanceTask(task MaintenanceConfig) error {
 
 	maintenanceSubcommand := &maintenance.Maintenance{}
 	maintenanceSubcommand.RepositoryLocation = task.Repository.Location
-	if task.Repository.Passphrase != "" {
-		maintenanceSubcommand.RepositorySecret = []byte(task.Repository.Passphrase)
-		_ = maintenanceSubcommand.RepositorySecret
-	}
 
 	rmSubcommand := &rm.Rm{}
 	rmSubcommand.RepositoryLocation = task.Repository.Location
-	if task.Repository.Passphrase != "" {
-		rmSubcommand.RepositorySecret = []byte(task.Repository.Passphrase)
-		_ = rmSubcommand.RepositorySecret
-	}
 
 	var retention time.Duration
 	if task.Retention != "" {
@@ -336,18 +340,9 @@ func (s *Scheduler) maintenanceTask(task MaintenanceConfig) error {
 				time.Sleep(interval)
 			}
 
-			store, config, err := storage.Open(map[string]string{"location": maintenanceSubcommand.RepositoryLocation})
-			if err != nil {
-				s.ctx.GetLogger().Error("Error opening storage: %s", err)
-				continue
-			}
-
-			newCtx := appcontext.NewAppContextFrom(s.ctx)
-
-			repo, err := repository.New(newCtx, store, config)
+			newCtx, store, repo, err := s.openRepository(task.Repository)
 			if err != nil {
 				s.ctx.GetLogger().Error("Error opening repository: %s", err)
-				store.Close()
 				continue
 			}
 
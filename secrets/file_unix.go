@@ -0,0 +1,21 @@
+//go:build !windows
+
+package secrets
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// checkPermissions refuses files other users may read or write, or
+// that belong to someone else.
+func checkPermissions(info os.FileInfo) error {
+	if info.Mode().Perm()&0o077 != 0 {
+		return fmt.Errorf("%w (mode %#o)", ErrInsecurePermissions, info.Mode().Perm())
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("%w (owned by uid %d)", ErrInsecurePermissions, stat.Uid)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+var ErrKeyringUnavailable = errors.New("no system keyring available")
+
+// KeyringBackend looks secrets up in a keyring by service and account.
+type KeyringBackend interface {
+	Get(service, account string) ([]byte, error)
+}
+
+// DefaultKeyring is the keyring of the system: the Secret Service over
+// D-Bus through secret-tool, or the macOS keychain through security.
+// A stand-in Secret Service is reached by pointing
+// DBUS_SESSION_BUS_ADDRESS to it.
+var DefaultKeyring KeyringBackend = &commandKeyring{}
+
+type commandKeyring struct{}
+
+func (k *commandKeyring) Get(service, account string) ([]byte, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", service, "-a", account, "-w")
+	case "windows":
+		return nil, ErrKeyringUnavailable
+	default:
+		cmd = exec.Command("secret-tool", "lookup", "service", service, "account", account)
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, fmt.Errorf("%w: %s not found", ErrKeyringUnavailable, cmd.Args[0])
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("keyring lookup of %s/%s failed: %s", service, account, msg)
+		}
+		return nil, fmt.Errorf("keyring lookup of %s/%s failed: %w", service, account, err)
+	}
+
+	// secret-tool exits successfully without output for a missing item
+	if len(out) == 0 {
+		return nil, fmt.Errorf("%w: no keyring item for %s/%s", ErrNoSecret, service, account)
+	}
+	return out, nil
+}
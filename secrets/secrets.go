@@ -0,0 +1,236 @@
+// Package secrets resolves the passphrases of repositories from the
+// sources a user may configure, so that they need not be stored in
+// plaintext in the configuration.
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/encryption"
+	"github.com/PlakarKorp/plakar/shell"
+)
+
+var (
+	ErrNoSecret            = errors.New("no passphrase available")
+	ErrConflictingSecrets  = errors.New("more than one passphrase source configured")
+	ErrInsecurePermissions = errors.New("passphrase file is accessible to other users")
+	ErrEmptySecret         = errors.New("empty passphrase")
+)
+
+// Provider returns a secret from a source outside of the configuration.
+type Provider interface {
+	Secret() ([]byte, error)
+}
+
+// Command runs a command with the shell and reads the secret from its
+// standard output. It inherits the standard input and error, so that
+// it may prompt the user itself.
+type Command struct {
+	Command string
+}
+
+func (p *Command) Secret() ([]byte, error) {
+	cmd := shell.Command(context.Background(), p.Command)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("passphrase command failed: %w", err)
+	}
+	return trimSecret(out)
+}
+
+// File reads the secret from a file only its owner can access.
+type File struct {
+	Path string
+}
+
+func (p *File) Secret() ([]byte, error) {
+	fp, err := os.Open(p.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	info, err := fp.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("passphrase file is not a regular file: %s", p.Path)
+	}
+	if err := checkPermissions(info); err != nil {
+		return nil, fmt.Errorf("%w: %s", err, p.Path)
+	}
+
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(fp); err != nil {
+		return nil, err
+	}
+	return trimSecret(buf.Bytes())
+}
+
+// Env reads the secret from an environment variable.
+type Env struct {
+	Name string
+}
+
+func (p *Env) Secret() ([]byte, error) {
+	value, ok := os.LookupEnv(p.Name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s is not set", ErrNoSecret, p.Name)
+	}
+	return trimSecret([]byte(value))
+}
+
+// Keyring reads the secret from the system keyring.
+type Keyring struct {
+	Service string
+	Account string
+	Backend KeyringBackend
+}
+
+func (p *Keyring) Secret() ([]byte, error) {
+	backend := p.Backend
+	if backend == nil {
+		backend = DefaultKeyring
+	}
+	secret, err := backend.Get(p.Service, p.Account)
+	if err != nil {
+		return nil, err
+	}
+	return trimSecret(secret)
+}
+
+// Static is a secret written in the configuration itself.
+type Static struct {
+	Value string
+}
+
+func (p *Static) Secret() ([]byte, error) {
+	return trimSecret([]byte(p.Value))
+}
+
+// trimSecret strips the newline commands and files usually end with.
+func trimSecret(secret []byte) ([]byte, error) {
+	secret = bytes.TrimSuffix(secret, []byte("\n"))
+	secret = bytes.TrimSuffix(secret, []byte("\r"))
+	if len(secret) == 0 {
+		return nil, ErrEmptySecret
+	}
+	return secret, nil
+}
+
+// The repository configuration keys selecting a provider.
+const (
+	KEY_PASSPHRASE         = "passphrase"
+	KEY_PASSPHRASE_CMD     = "passphrase_cmd"
+	KEY_PASSPHRASE_FILE    = "passphrase_file"
+	KEY_PASSPHRASE_ENV     = "passphrase_env"
+	KEY_PASSPHRASE_KEYRING = "passphrase_keyring"
+
+	DEFAULT_KEYRING_SERVICE = "plakar"
+)
+
+// FromConfig returns the provider configured for a repository, or nil
+// if there is none.
+func FromConfig(config map[string]string) (Provider, error) {
+	var providers []Provider
+	if value, ok := config[KEY_PASSPHRASE_CMD]; ok {
+		providers = append(providers, &Command{Command: value})
+	}
+	if value, ok := config[KEY_PASSPHRASE_FILE]; ok {
+		providers = append(providers, &File{Path: value})
+	}
+	if value, ok := config[KEY_PASSPHRASE_ENV]; ok {
+		providers = append(providers, &Env{Name: value})
+	}
+	if value, ok := config[KEY_PASSPHRASE_KEYRING]; ok {
+		// either ACCOUNT or SERVICE/ACCOUNT
+		service, account, found := strings.Cut(value, "/")
+		if !found {
+			service, account = DEFAULT_KEYRING_SERVICE, value
+		}
+		providers = append(providers, &Keyring{Service: service, Account: account})
+	}
+	if value, ok := config[KEY_PASSPHRASE]; ok {
+		providers = append(providers, &Static{Value: value})
+	}
+
+	switch len(providers) {
+	case 0:
+		return nil, nil
+	case 1:
+		return providers[0], nil
+	default:
+		return nil, ErrConflictingSecrets
+	}
+}
+
+// Prompt asks the user for a passphrase.
+type Prompt func() ([]byte, error)
+
+// Passphrase returns the passphrase of the repository configured with
+// config, from the first of the key file, the provider set in config,
+// PLAKAR_PASSPHRASE and prompt, which is nil when there is no one to
+// ask. The key file and PLAKAR_PASSPHRASE only apply to the repository
+// plakar runs on, ctx being nil for another one such as a sync peer.
+// It tells whether the passphrase was typed in, so that callers may ask
+// again for a mistyped one.
+func Passphrase(ctx *appcontext.AppContext, config map[string]string, prompt Prompt) ([]byte, bool, error) {
+	if ctx != nil && ctx.KeyFromFile != "" {
+		return []byte(ctx.KeyFromFile), false, nil
+	}
+
+	provider, err := FromConfig(config)
+	if err != nil {
+		return nil, false, err
+	}
+	if provider != nil {
+		secret, err := provider.Secret()
+		return secret, false, err
+	}
+
+	if ctx != nil {
+		if envPassphrase := os.Getenv("PLAKAR_PASSPHRASE"); envPassphrase != "" {
+			return []byte(envPassphrase), false, nil
+		}
+	}
+
+	if prompt == nil {
+		return nil, false, ErrNoSecret
+	}
+	secret, err := prompt()
+	return secret, true, err
+}
+
+// Unlock returns the key of the repository encrypted with encConfig,
+// resolving its passphrase as Passphrase does. A typed in passphrase
+// is asked up to three times.
+func Unlock(ctx *appcontext.AppContext, config map[string]string, encConfig *encryption.Configuration, prompt Prompt) ([]byte, error) {
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		var passphrase []byte
+		var prompted bool
+		passphrase, prompted, err = Passphrase(ctx, config, prompt)
+		if err != nil {
+			return nil, err
+		}
+
+		var key []byte
+		key, err = encConfig.Unlock(passphrase)
+		if err == nil {
+			return key, nil
+		}
+		if !prompted {
+			break
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,161 @@
+package secrets
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/encryption"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeKeyring map[string]string
+
+func (k fakeKeyring) Get(service, account string) ([]byte, error) {
+	secret, ok := k[service+"/"+account]
+	if !ok {
+		return nil, ErrNoSecret
+	}
+	return []byte(secret), nil
+}
+
+func TestProviders(t *testing.T) {
+	secret, err := (&Command{Command: "echo command secret"}).Secret()
+	require.NoError(t, err)
+	require.Equal(t, "command secret", string(secret))
+
+	_, err = (&Command{Command: "exit 1"}).Secret()
+	require.Error(t, err)
+
+	_, err = (&Command{Command: "true"}).Secret()
+	require.ErrorIs(t, err, ErrEmptySecret)
+
+	t.Setenv("TEST_PLAKAR_SECRET", "env secret")
+	secret, err = (&Env{Name: "TEST_PLAKAR_SECRET"}).Secret()
+	require.NoError(t, err)
+	require.Equal(t, "env secret", string(secret))
+
+	_, err = (&Env{Name: "TEST_PLAKAR_UNSET"}).Secret()
+	require.ErrorIs(t, err, ErrNoSecret)
+
+	keyring := fakeKeyring{"plakar/nas": "keyring secret"}
+	secret, err = (&Keyring{Service: "plakar", Account: "nas", Backend: keyring}).Secret()
+	require.NoError(t, err)
+	require.Equal(t, "keyring secret", string(secret))
+
+	_, err = (&Keyring{Service: "plakar", Account: "other", Backend: keyring}).Secret()
+	require.ErrorIs(t, err, ErrNoSecret)
+}
+
+func TestFileProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "passphrase")
+	require.NoError(t, os.WriteFile(path, []byte("file secret\n"), 0600))
+
+	secret, err := (&File{Path: path}).Secret()
+	require.NoError(t, err)
+	require.Equal(t, "file secret", string(secret))
+
+	require.NoError(t, os.Chmod(path, 0644))
+	_, err = (&File{Path: path}).Secret()
+	require.ErrorIs(t, err, ErrInsecurePermissions)
+
+	_, err = (&File{Path: filepath.Dir(path)}).Secret()
+	require.Error(t, err)
+}
+
+func TestFromConfig(t *testing.T) {
+	provider, err := FromConfig(map[string]string{"location": "/tmp/repo"})
+	require.NoError(t, err)
+	require.Nil(t, provider)
+
+	provider, err = FromConfig(map[string]string{KEY_PASSPHRASE_KEYRING: "nas"})
+	require.NoError(t, err)
+	require.Equal(t, &Keyring{Service: DEFAULT_KEYRING_SERVICE, Account: "nas"}, provider)
+
+	provider, err = FromConfig(map[string]string{KEY_PASSPHRASE_KEYRING: "backups/nas"})
+	require.NoError(t, err)
+	require.Equal(t, &Keyring{Service: "backups", Account: "nas"}, provider)
+
+	provider, err = FromConfig(map[string]string{KEY_PASSPHRASE_CMD: "pass show plakar"})
+	require.NoError(t, err)
+	require.Equal(t, &Command{Command: "pass show plakar"}, provider)
+
+	_, err = FromConfig(map[string]string{KEY_PASSPHRASE: "secret", KEY_PASSPHRASE_ENV: "SECRET"})
+	require.ErrorIs(t, err, ErrConflictingSecrets)
+}
+
+func TestPassphrase(t *testing.T) {
+	ctx := appcontext.NewAppContext()
+	config := map[string]string{KEY_PASSPHRASE_CMD: "echo configured"}
+	prompt := func() ([]byte, error) { return []byte("typed"), nil }
+
+	for _, tc := range []struct {
+		keyFile  string
+		env      string
+		config   map[string]string
+		ctx      *appcontext.AppContext
+		expected string
+		prompted bool
+	}{
+		{keyFile: "keyfile", env: "env", config: config, ctx: ctx, expected: "keyfile"},
+		{env: "env", config: config, ctx: ctx, expected: "configured"},
+		{env: "env", ctx: ctx, expected: "env"},
+		{ctx: ctx, expected: "typed", prompted: true},
+		// neither apply to a peer repository
+		{keyFile: "keyfile", env: "env", expected: "typed", prompted: true},
+	} {
+		ctx.KeyFromFile = tc.keyFile
+		t.Setenv("PLAKAR_PASSPHRASE", tc.env)
+
+		secret, prompted, err := Passphrase(tc.ctx, tc.config, prompt)
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, string(secret))
+		require.Equal(t, tc.prompted, prompted)
+	}
+
+	ctx.KeyFromFile = ""
+	t.Setenv("PLAKAR_PASSPHRASE", "")
+	_, _, err := Passphrase(ctx, nil, nil)
+	require.ErrorIs(t, err, ErrNoSecret)
+}
+
+func TestUnlock(t *testing.T) {
+	encConfig := encryption.NewDefaultConfiguration()
+	kdfParams, err := encryption.NewDefaultKDFParams("PBKDF2")
+	require.NoError(t, err)
+	encConfig.KDFParams = *kdfParams
+
+	key, err := encryption.NewMasterKey()
+	require.NoError(t, err)
+	encConfig.Canary, err = encryption.DeriveCanary(encConfig, key)
+	require.NoError(t, err)
+	slot, err := encryption.NewKeySlot(encryption.DEFAULT_KEYSLOT, kdfParams, []byte("passphrase"), key)
+	require.NoError(t, err)
+	encConfig.KeySlots = []encryption.KeySlot{*slot}
+
+	// a mistyped passphrase is asked again
+	attempts := 0
+	prompt := func() ([]byte, error) {
+		attempts++
+		if attempts < 3 {
+			return []byte("wrong"), nil
+		}
+		return []byte("passphrase"), nil
+	}
+	unlocked, err := Unlock(nil, nil, encConfig, prompt)
+	require.NoError(t, err)
+	require.Equal(t, key, unlocked)
+	require.Equal(t, 3, attempts)
+
+	// a configured one isn't
+	attempts = 0
+	_, err = Unlock(nil, map[string]string{KEY_PASSPHRASE: "wrong"}, encConfig, prompt)
+	require.ErrorIs(t, err, encryption.ErrInvalidPassphrase)
+	require.Equal(t, 0, attempts)
+
+	failure := errors.New("no terminal")
+	_, err = Unlock(nil, nil, encConfig, func() ([]byte, error) { return nil, failure })
+	require.ErrorIs(t, err, failure)
+}
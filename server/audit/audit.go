@@ -0,0 +1,163 @@
+// Package audit logs the requests served over HTTP as JSON lines and
+// limits the rate at which each client may send them.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Entry is the line logged for a request.
+type Entry struct {
+	Time     time.Time `json:"time"`
+	Client   string    `json:"client"`
+	Identity string    `json:"identity,omitempty"`
+	Method   string    `json:"method"`
+	Route    string    `json:"route,omitempty"`
+	Path     string    `json:"path"`
+	Resource string    `json:"resource,omitempty"`
+	Status   int       `json:"status"`
+	BytesIn  int64     `json:"bytes_in"`
+	BytesOut int64     `json:"bytes_out"`
+	Duration float64   `json:"duration_ms"`
+}
+
+// Logger writes entries to w, one JSON object per line.
+type Logger struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func NewLogger(w io.Writer) *Logger {
+	return &Logger{enc: json.NewEncoder(w)}
+}
+
+func (l *Logger) Log(entry *Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.enc.Encode(entry)
+}
+
+type entryKey struct{}
+
+func entryFrom(r *http.Request) *Entry {
+	entry, _ := r.Context().Value(entryKey{}).(*Entry)
+	return entry
+}
+
+// SetIdentity records who the client of r authenticated as.
+func SetIdentity(r *http.Request, identity string) {
+	if entry := entryFrom(r); entry != nil {
+		entry.Identity = identity
+	}
+}
+
+// SetResource records the object r accesses, such as a MAC or a
+// snapshot path.
+func SetResource(r *http.Request, resource string) {
+	if entry := entryFrom(r); entry != nil {
+		entry.Resource = resource
+	}
+}
+
+// Middleware logs the requests served by next to logger and refuses
+// those of clients exceeding limiter, either of which may be nil.
+func Middleware(logger *Logger, limiter *Limiter, next http.Handler) http.Handler {
+	if logger == nil && limiter == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		entry := &Entry{
+			Time:   start.UTC(),
+			Client: clientAddress(r),
+			Method: r.Method,
+			Path:   r.URL.Path,
+		}
+		r = r.WithContext(context.WithValue(r.Context(), entryKey{}, entry))
+		body := &countingReader{rd: r.Body}
+		r.Body = body
+		rw := &responseWriter{ResponseWriter: w}
+
+		if ok, wait := limiter.Allow(entry.Client); ok {
+			next.ServeHTTP(rw, r)
+		} else {
+			rw.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(rw, "too many requests", http.StatusTooManyRequests)
+		}
+
+		if logger == nil {
+			return
+		}
+		entry.Route = r.Pattern
+		entry.Status = rw.status
+		if entry.Status == 0 {
+			entry.Status = http.StatusOK
+		}
+		entry.BytesIn = body.n
+		entry.BytesOut = rw.n
+		entry.Duration = float64(time.Since(start).Microseconds()) / 1000
+		if err := logger.Log(entry); err != nil {
+			log.Printf("failed to write the access log: %v", err)
+		}
+	})
+}
+
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+type countingReader struct {
+	rd io.ReadCloser
+	n  int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.rd.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) Close() error {
+	return c.rd.Close()
+}
+
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	n      int64
+}
+
+func (rw *responseWriter) WriteHeader(status int) {
+	if rw.status == 0 {
+		rw.status = status
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	n, err := rw.ResponseWriter.Write(p)
+	rw.n += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -0,0 +1,138 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware(t *testing.T) {
+	var log bytes.Buffer
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /object/{id}", func(w http.ResponseWriter, r *http.Request) {
+		SetIdentity(r, "alice")
+		SetResource(r, r.PathValue("id"))
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("stored"))
+	})
+	handler := Middleware(NewLogger(&log), NewLimiter(1, 1), mux)
+
+	req := httptest.NewRequest("POST", "/object/42", strings.NewReader("payload"))
+	req.RemoteAddr = "192.0.2.1:4242"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	var entry Entry
+	require.NoError(t, json.Unmarshal(log.Bytes(), &entry))
+	require.Equal(t, "192.0.2.1", entry.Client)
+	require.Equal(t, "alice", entry.Identity)
+	require.Equal(t, "POST", entry.Method)
+	require.Equal(t, "POST /object/{id}", entry.Route)
+	require.Equal(t, "/object/42", entry.Path)
+	require.Equal(t, "42", entry.Resource)
+	require.Equal(t, http.StatusCreated, entry.Status)
+	require.Equal(t, int64(len("payload")), entry.BytesIn)
+	require.Equal(t, int64(len("stored")), entry.BytesOut)
+
+	// the burst is spent
+	log.Reset()
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	require.Equal(t, "1", rec.Header().Get("Retry-After"))
+
+	entry = Entry{}
+	require.NoError(t, json.Unmarshal(log.Bytes(), &entry))
+	require.Equal(t, http.StatusTooManyRequests, entry.Status)
+	require.Empty(t, entry.Identity)
+}
+
+func TestLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	limiter := NewLimiter(2, 3)
+	limiter.now = func() time.Time { return now }
+
+	for range 3 {
+		ok, _ := limiter.Allow("a")
+		require.True(t, ok)
+	}
+	ok, wait := limiter.Allow("a")
+	require.False(t, ok)
+	require.Equal(t, 500*time.Millisecond, wait)
+
+	// clients are limited separately
+	ok, _ = limiter.Allow("b")
+	require.True(t, ok)
+
+	now = now.Add(500 * time.Millisecond)
+	ok, _ = limiter.Allow("a")
+	require.True(t, ok)
+	ok, _ = limiter.Allow("a")
+	require.False(t, ok)
+
+	var nilLimiter *Limiter
+	ok, _ = nilLimiter.Allow("a")
+	require.True(t, ok)
+}
+
+func TestLimiterSweep(t *testing.T) {
+	now := time.Unix(0, 0)
+	limiter := NewLimiter(1, 1)
+	limiter.now = func() time.Time { return now }
+
+	for i := range LIMITER_SWEEP_SIZE {
+		limiter.Allow(string(rune(i)))
+	}
+	now = now.Add(time.Second)
+	limiter.Allow("new")
+	require.Len(t, limiter.clients, 1)
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f, err := OpenRotatingFile(path, 10, 2)
+	require.NoError(t, err)
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err := f.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	require.NoError(t, f.Close())
+
+	for suffix, expected := range map[string]string{
+		"":   "fourth\n",
+		".1": "third\n",
+		".2": "second\n",
+	} {
+		data, err := os.ReadFile(path + suffix)
+		require.NoError(t, err)
+		require.Equal(t, expected, string(data))
+	}
+	_, err = os.Stat(path + ".3")
+	require.True(t, os.IsNotExist(err))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// reopening appends
+	f, err = OpenRotatingFile(path, 100, 2)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("fifth\n"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "fourth\nfifth\n", string(data))
+}
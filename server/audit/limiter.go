@@ -0,0 +1,75 @@
+package audit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// LIMITER_SWEEP_SIZE is the number of clients past which those idle
+// long enough to be back to a full burst are forgotten.
+const LIMITER_SWEEP_SIZE = 1024
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter allows each client rate requests per second on average, and
+// up to burst at once, or a second worth of requests if burst is 0.
+type Limiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	clients map[string]*bucket
+	now     func() time.Time
+}
+
+func NewLimiter(rate float64, burst int) *Limiter {
+	if burst <= 0 {
+		burst = int(math.Ceil(rate))
+	}
+	return &Limiter{
+		rate:    rate,
+		burst:   float64(max(burst, 1)),
+		clients: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Allow reports whether client may send a request now and otherwise,
+// how long until it may. A nil limiter allows everything.
+func (l *Limiter) Allow(client string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.clients[client]
+	if !ok {
+		if len(l.clients) >= LIMITER_SWEEP_SIZE {
+			l.sweep(now)
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.clients[client] = b
+	}
+
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+func (l *Limiter) sweep(now time.Time) {
+	for client, b := range l.clients {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.clients, client)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package audit
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// RotatingFile is a log file renamed to path.1 once it grows past
+// maxSize, the former path.1 becoming path.2 and so on up to keep
+// files.
+type RotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	keep    int
+	fp      *os.File
+	size    int64
+}
+
+func OpenRotatingFile(path string, maxSize int64, keep int) (*RotatingFile, error) {
+	f := &RotatingFile{
+		path:    path,
+		maxSize: maxSize,
+		keep:    keep,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	fp, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := fp.Stat()
+	if err != nil {
+		fp.Close()
+		return err
+	}
+	f.fp = fp
+	f.size = info.Size()
+	return nil
+}
+
+// rotate shifts the files and reopens path, which keeps growing if it
+// couldn't be renamed.
+func (f *RotatingFile) rotate() error {
+	f.fp.Close()
+	f.fp = nil
+
+	err := f.shift()
+	if err := f.open(); err != nil {
+		return err
+	}
+	return err
+}
+
+func (f *RotatingFile) shift() error {
+	if f.keep == 0 {
+		return os.Remove(f.path)
+	}
+	for i := f.keep - 1; i > 0; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(f.path, f.path+".1")
+}
+
+// Write appends p to the file, rotating it first if p would make it
+// grow past its maximum size.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var rotateErr error
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		rotateErr = f.rotate()
+		if f.fp == nil {
+			return 0, rotateErr
+		}
+	}
+	n, err := f.fp.Write(p)
+	f.size += int64(n)
+	if err == nil {
+		err = rotateErr
+	}
+	return n, err
+}
+
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fp == nil {
+		return nil
+	}
+	return f.fp.Close()
+}
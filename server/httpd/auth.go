@@ -0,0 +1,217 @@
+package httpd
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/PlakarKorp/plakar/secrets"
+	"github.com/PlakarKorp/plakar/server/audit"
+)
+
+// Permission is the set of operations a token allows.
+type Permission uint8
+
+const (
+	PERM_READ Permission = 1 << iota
+	PERM_WRITE
+	PERM_DELETE
+
+	PERM_ALL = PERM_READ | PERM_WRITE | PERM_DELETE
+)
+
+var permissionNames = []struct {
+	name       string
+	permission Permission
+}{
+	{"read", PERM_READ},
+	{"write", PERM_WRITE},
+	{"delete", PERM_DELETE},
+}
+
+var ErrInvalidPermission = errors.New("invalid permission")
+
+// ParsePermission parses a comma-separated list of read, write and
+// delete.
+func ParsePermission(value string) (Permission, error) {
+	var permission Permission
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		found := false
+		for _, p := range permissionNames {
+			if strings.EqualFold(field, p.name) {
+				permission |= p.permission
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("%w: %q", ErrInvalidPermission, field)
+		}
+	}
+	return permission, nil
+}
+
+func (p Permission) String() string {
+	names := make([]string, 0, len(permissionNames))
+	for _, name := range permissionNames {
+		if p&name.permission != 0 {
+			names = append(names, name.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// Token is what a bearer token allows, and who presents it.
+type Token struct {
+	Name       string
+	Permission Permission
+}
+
+// Options configures who may access the server.
+type Options struct {
+	// NoDelete refuses deletions and configuration updates to every
+	// client, whatever its token allows.
+	NoDelete bool
+
+	// Tokens maps the bearer tokens clients must present to what they
+	// allow. The server is open to any client when there are none.
+	Tokens map[string]Token
+
+	// TLSCert and TLSKey make the server listen with TLS, and clients
+	// then have to present a certificate signed by TLSClientCA if set.
+	TLSCert     string
+	TLSKey      string
+	TLSClientCA string
+
+	// AccessLog, if set, logs every request, and RateLimit refuses the
+	// requests of clients sending too many.
+	AccessLog *audit.Logger
+	RateLimit *audit.Limiter
+}
+
+// LoadTokens reads the tokens file at path, which only its owner may
+// access. Each line holds a token followed by its permissions and
+// optionally, the name it is logged as, blank lines and lines starting
+// with # being ignored:
+//
+//	4f6c2d9e...  read,write  backups
+//
+// Unnamed tokens are logged as the start of their SHA-256 digest.
+func LoadTokens(path string) (map[string]Token, error) {
+	data, err := (&secrets.File{Path: path}).Secret()
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make(map[string]Token)
+	for lineno, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 && len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: expected a token, its permissions and an optional name", path, lineno+1)
+		}
+		permission, err := ParsePermission(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineno+1, err)
+		}
+
+		name := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(fields[0])))[:15]
+		if len(fields) == 3 {
+			name = fields[2]
+		}
+		tokens[fields[0]] = Token{Name: name, Permission: permission}
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("%s: no token found", path)
+	}
+	return tokens, nil
+}
+
+func (o *Options) tlsConfig() (*tls.Config, error) {
+	if o.TLSCert == "" && o.TLSKey == "" && o.TLSClientCA == "" {
+		return nil, nil
+	}
+	if o.TLSCert == "" || o.TLSKey == "" {
+		return nil, fmt.Errorf("TLS requires both a certificate and a key")
+	}
+
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if o.TLSClientCA != "" {
+		pool, err := LoadCertPool(o.TLSClientCA)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// LoadCertPool returns the certificates of the PEM file at path.
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate found in %s", path)
+	}
+	return pool, nil
+}
+
+// authorize serves the requests presenting a token that allows
+// required, or any request without tokens.
+func authorize(tokens map[string]Token, required Permission, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && len(r.TLS.PeerCertificates) != 0 {
+			audit.SetIdentity(r, r.TLS.PeerCertificates[0].Subject.CommonName)
+		}
+
+		if len(tokens) != 0 {
+			token, ok := authenticate(tokens, r)
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="plakar"`)
+				http.Error(w, "missing or invalid token", http.StatusUnauthorized)
+				return
+			}
+			audit.SetIdentity(r, token.Name)
+			if token.Permission&required != required {
+				http.Error(w, fmt.Sprintf("token does not allow %s", required), http.StatusForbidden)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// authenticate returns the bearer token of r, comparing it to every
+// token in constant time.
+func authenticate(tokens map[string]Token, r *http.Request) (Token, bool) {
+	bearer, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || bearer == "" {
+		return Token{}, false
+	}
+
+	var token Token
+	ok := false
+	for candidate, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(bearer)) == 1 {
+			token = t
+			ok = true
+		}
+	}
+	return token, ok
+}
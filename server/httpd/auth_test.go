@@ -0,0 +1,217 @@
+package httpd
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/plakar/objects"
+	"github.com/PlakarKorp/plakar/storage"
+	bfs "github.com/PlakarKorp/plakar/storage/backends/fs"
+	bhttp "github.com/PlakarKorp/plakar/storage/backends/http"
+	"github.com/stretchr/testify/require"
+)
+
+func newStore(t *testing.T) storage.Store {
+	store, err := bfs.NewStore(map[string]string{"location": "fs://" + t.TempDir() + "/repo"})
+	require.NoError(t, err)
+	config, err := storage.NewConfiguration().ToBytes()
+	require.NoError(t, err)
+	require.NoError(t, store.Create(config))
+	return store
+}
+
+func newClient(t *testing.T, storeConfig map[string]string) storage.Store {
+	client, err := bhttp.NewStore(storeConfig)
+	require.NoError(t, err)
+	return client
+}
+
+func TestParsePermission(t *testing.T) {
+	p, err := ParsePermission("read, write")
+	require.NoError(t, err)
+	require.Equal(t, PERM_READ|PERM_WRITE, p)
+	require.Equal(t, "read,write", p.String())
+
+	p, err = ParsePermission("read,write,delete")
+	require.NoError(t, err)
+	require.Equal(t, PERM_ALL, p)
+
+	_, err = ParsePermission("read,admin")
+	require.ErrorIs(t, err, ErrInvalidPermission)
+}
+
+func TestLoadTokens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens")
+	content := "# backups\nbackup-token read,write backups\n\nadmin-token read,write,delete\n"
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+
+	tokens, err := LoadTokens(path)
+	require.NoError(t, err)
+	require.Equal(t, map[string]Token{
+		"backup-token": {Name: "backups", Permission: PERM_READ | PERM_WRITE},
+		"admin-token":  {Name: "sha256:10a4c7c9", Permission: PERM_ALL},
+	}, tokens)
+
+	require.NoError(t, os.WriteFile(path, []byte("lonely-token\n"), 0600))
+	_, err = LoadTokens(path)
+	require.Error(t, err)
+
+	require.NoError(t, os.WriteFile(path, []byte("token read\n"), 0600))
+	require.NoError(t, os.Chmod(path, 0644))
+	_, err = LoadTokens(path)
+	require.Error(t, err)
+}
+
+func TestTokens(t *testing.T) {
+	ts := httptest.NewServer(NewHandler(newStore(t), &Options{
+		Tokens: map[string]Token{
+			"reader": {Name: "reader", Permission: PERM_READ},
+			"writer": {Name: "writer", Permission: PERM_READ | PERM_WRITE},
+			"admin":  {Name: "admin", Permission: PERM_ALL},
+		},
+	}))
+	defer ts.Close()
+
+	mac := objects.MAC{0x01}
+
+	for _, token := range []string{"", "unknown"} {
+		_, err := newClient(t, map[string]string{"location": ts.URL, "token": token}).Open()
+		require.ErrorContains(t, err, "Unauthorized")
+	}
+
+	reader := newClient(t, map[string]string{"location": ts.URL, "token": "reader"})
+	_, err := reader.Open()
+	require.NoError(t, err)
+	_, err = reader.GetStates()
+	require.NoError(t, err)
+	err = reader.PutState(mac, bytes.NewReader([]byte("state")))
+	require.ErrorContains(t, err, "Forbidden")
+
+	writer := newClient(t, map[string]string{"location": ts.URL, "token": "writer"})
+	require.NoError(t, writer.PutState(mac, bytes.NewReader([]byte("state"))))
+	require.ErrorContains(t, writer.DeleteState(mac), "Forbidden")
+	require.ErrorContains(t, writer.PutConfig([]byte("config")), "Forbidden")
+
+	admin := newClient(t, map[string]string{"location": ts.URL, "token": "admin"})
+	require.NoError(t, admin.DeleteState(mac))
+	states, err := admin.GetStates()
+	require.NoError(t, err)
+	require.Empty(t, states)
+}
+
+func TestNoDeleteOverridesTokens(t *testing.T) {
+	ts := httptest.NewServer(NewHandler(newStore(t), &Options{
+		NoDelete: true,
+		Tokens:   map[string]Token{"admin": {Name: "admin", Permission: PERM_ALL}},
+	}))
+	defer ts.Close()
+
+	admin := newClient(t, map[string]string{"location": ts.URL, "token": "admin"})
+	mac := objects.MAC{0x01}
+	require.NoError(t, admin.PutState(mac, bytes.NewReader([]byte("state"))))
+	require.Error(t, admin.DeleteState(mac))
+}
+
+// writeCertificate writes a certificate for name, signed by parent or
+// self-signed, and its key as PEM files in dir.
+func writeCertificate(t *testing.T, dir string, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name+".pem"),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name+".key"),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return cert, key
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := writeCertificate(t, dir, "ca", nil, nil)
+	writeCertificate(t, dir, "client", ca, caKey)
+	writeCertificate(t, dir, "intruder", nil, nil)
+
+	opts := &Options{
+		TLSCert:     "unused",
+		TLSKey:      "unused",
+		TLSClientCA: filepath.Join(dir, "ca.pem"),
+	}
+	config, err := opts.tlsConfig()
+	require.NoError(t, err)
+	require.Equal(t, tls.RequireAndVerifyClientCert, config.ClientAuth)
+
+	ts := httptest.NewUnstartedServer(NewHandler(newStore(t), opts))
+	ts.TLS = config
+	ts.Config.ErrorLog = log.New(io.Discard, "", 0)
+	ts.StartTLS()
+	defer ts.Close()
+
+	serverCA := filepath.Join(dir, "server.pem")
+	require.NoError(t, os.WriteFile(serverCA,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0600))
+
+	client := newClient(t, map[string]string{
+		"location": ts.URL,
+		"tls_ca":   serverCA,
+		"tls_cert": filepath.Join(dir, "client.pem"),
+		"tls_key":  filepath.Join(dir, "client.key"),
+	})
+	_, err = client.Open()
+	require.NoError(t, err)
+
+	for _, storeConfig := range []map[string]string{
+		{"location": ts.URL, "tls_ca": serverCA},
+		{
+			"location": ts.URL,
+			"tls_ca":   serverCA,
+			"tls_cert": filepath.Join(dir, "intruder.pem"),
+			"tls_key":  filepath.Join(dir, "intruder.key"),
+		},
+	} {
+		_, err = newClient(t, storeConfig).Open()
+		require.Error(t, err)
+	}
+
+	// the server isn't trusted without its certificate
+	_, err = newClient(t, map[string]string{
+		"location": ts.URL,
+		"tls_cert": filepath.Join(dir, "client.pem"),
+		"tls_key":  filepath.Join(dir, "client.key"),
+	}).Open()
+	require.Error(t, err)
+}
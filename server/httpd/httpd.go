@@ -9,6 +9,7 @@ import (
 
 	"github.com/PlakarKorp/plakar/network"
 	"github.com/PlakarKorp/plakar/repository"
+	"github.com/PlakarKorp/plakar/server/audit"
 	"github.com/PlakarKorp/plakar/storage"
 )
 
@@ -37,6 +38,30 @@ func openRepository(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func putConfig(w http.ResponseWriter, r *http.Request) {
+	// replacing the configuration can lock clients out, as removing
+	// data would
+	if lNoDelete {
+		http.Error(w, fmt.Errorf("not allowed to update the configuration").Error(), http.StatusForbidden)
+		return
+	}
+
+	var reqPutConfig network.ReqPutConfig
+	if err := json.NewDecoder(r.Body).Decode(&reqPutConfig); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var resPutConfig network.ResPutConfig
+	if err := store.PutConfig(reqPutConfig.Data); err != nil {
+		resPutConfig.Err = err.Error()
+	}
+	if err := json.NewEncoder(w).Encode(resPutConfig); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
 // states
 func getStates(w http.ResponseWriter, r *http.Request) {
 	var reqGetIndexes network.ReqGetStates
@@ -64,6 +89,7 @@ func putState(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	audit.SetResource(r, fmt.Sprintf("%x", reqPutState.MAC))
 
 	var resPutIndex network.ResPutState
 	data := reqPutState.Data
@@ -83,6 +109,7 @@ func getState(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	audit.SetResource(r, fmt.Sprintf("%x", reqGetState.MAC))
 
 	var resGetState network.ResGetState
 	rd, err := store.GetState(reqGetState.MAC)
@@ -113,6 +140,7 @@ func deleteState(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	audit.SetResource(r, fmt.Sprintf("%x", reqDeleteState.MAC))
 
 	var resDeleteState network.ResDeleteState
 	err := store.DeleteState(reqDeleteState.MAC)
@@ -152,6 +180,7 @@ func putPackfile(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	audit.SetResource(r, fmt.Sprintf("%x", reqPutPackfile.MAC))
 
 	var resPutPackfile network.ResPutPackfile
 	err := store.PutPackfile(reqPutPackfile.MAC, bytes.NewBuffer(reqPutPackfile.Data))
@@ -170,6 +199,7 @@ func getPackfile(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	audit.SetResource(r, fmt.Sprintf("%x", reqGetPackfile.MAC))
 
 	var resGetPackfile network.ResGetPackfile
 	rd, err := store.GetPackfile(reqGetPackfile.MAC)
@@ -195,6 +225,7 @@ func GetPackfileBlob(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	audit.SetResource(r, fmt.Sprintf("%x", reqGetPackfileBlob.MAC))
 
 	var resGetPackfileBlob network.ResGetPackfileBlob
 	rd, err := store.GetPackfileBlob(reqGetPackfileBlob.MAC, reqGetPackfileBlob.Offset, reqGetPackfileBlob.Length)
@@ -225,6 +256,7 @@ func deletePackfile(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	audit.SetResource(r, fmt.Sprintf("%x", reqDeletePackfile.MAC))
 
 	var resDeletePackfile network.ResDeletePackfile
 	err := store.DeletePackfile(reqDeletePackfile.MAC)
@@ -263,6 +295,7 @@ func putLock(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	audit.SetResource(r, fmt.Sprintf("%x", req.Mac))
 
 	var res network.ResPutLock
 	if err := store.PutLock(req.Mac, bytes.NewReader(req.Data)); err != nil {
@@ -281,6 +314,7 @@ func getLock(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	audit.SetResource(r, fmt.Sprintf("%x", req.Mac))
 
 	var res network.ResGetLock
 	rd, err := store.GetLock(req.Mac)
@@ -307,6 +341,7 @@ func deleteLock(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	audit.SetResource(r, fmt.Sprintf("%x", req.Mac))
 
 	var res network.ResDeleteLock
 	if err := store.DeleteLock(req.Mac); err != nil {
@@ -319,27 +354,53 @@ func deleteLock(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func Server(repo *repository.Repository, addr string, noDelete bool) error {
-	lNoDelete = noDelete
-	store = repo.Store()
+// NewHandler returns the handler serving store to the clients opts
+// allows.
+func NewHandler(st storage.Store, opts *Options) http.Handler {
+	lNoDelete = opts.NoDelete
+	store = st
+
+	mux := http.NewServeMux()
+	handle := func(pattern string, required Permission, handler http.HandlerFunc) {
+		mux.HandleFunc(pattern, authorize(opts.Tokens, required, handler))
+	}
+
+	handle("GET /", PERM_READ, openRepository)
+	handle("PUT /config", PERM_DELETE, putConfig)
 
-	http.HandleFunc("GET /", openRepository)
+	handle("GET /states", PERM_READ, getStates)
+	handle("PUT /state", PERM_WRITE, putState)
+	handle("GET /state", PERM_READ, getState)
+	handle("DELETE /state", PERM_DELETE, deleteState)
 
-	http.HandleFunc("GET /states", getStates)
-	http.HandleFunc("PUT /state", putState)
-	http.HandleFunc("GET /state", getState)
-	http.HandleFunc("DELETE /state", deleteState)
+	handle("GET /packfiles", PERM_READ, getPackfiles)
+	handle("PUT /packfile", PERM_WRITE, putPackfile)
+	handle("GET /packfile", PERM_READ, getPackfile)
+	handle("GET /packfile/blob", PERM_READ, GetPackfileBlob)
+	handle("DELETE /packfile", PERM_DELETE, deletePackfile)
 
-	http.HandleFunc("GET /packfiles", getPackfiles)
-	http.HandleFunc("PUT /packfile", putPackfile)
-	http.HandleFunc("GET /packfile", getPackfile)
-	http.HandleFunc("GET /packfile/blob", GetPackfileBlob)
-	http.HandleFunc("DELETE /packfile", deletePackfile)
+	// clients remove the locks they put once done
+	handle("GET /locks", PERM_READ, getLocks)
+	handle("PUT /lock", PERM_WRITE, putLock)
+	handle("GET /lock", PERM_READ, getLock)
+	handle("DELETE /lock", PERM_WRITE, deleteLock)
 
-	http.HandleFunc("GET /locks", getLocks)
-	http.HandleFunc("PUT /lock", putLock)
-	http.HandleFunc("GET /lock", getLock)
-	http.HandleFunc("DELETE /lock", deleteLock)
+	return audit.Middleware(opts.AccessLog, opts.RateLimit, mux)
+}
 
-	return http.ListenAndServe(addr, nil)
+func Server(repo *repository.Repository, addr string, opts *Options) error {
+	tlsConfig, err := opts.tlsConfig()
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Addr:      addr,
+		Handler:   NewHandler(repo.Store(), opts),
+		TLSConfig: tlsConfig,
+	}
+	if opts.TLSCert != "" {
+		return server.ListenAndServeTLS(opts.TLSCert, opts.TLSKey)
+	}
+	return server.ListenAndServe()
 }
@@ -0,0 +1,61 @@
+package httpd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/PlakarKorp/plakar/objects"
+	"github.com/PlakarKorp/plakar/server/audit"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessLog(t *testing.T) {
+	var log bytes.Buffer
+	ts := httptest.NewServer(NewHandler(newStore(t), &Options{
+		Tokens: map[string]Token{
+			"secret": {Name: "backups", Permission: PERM_READ | PERM_WRITE},
+		},
+		AccessLog: audit.NewLogger(&log),
+	}))
+	defer ts.Close()
+
+	client := newClient(t, map[string]string{"location": ts.URL, "token": "secret"})
+	mac := objects.MAC{0x01}
+	require.NoError(t, client.PutState(mac, bytes.NewReader([]byte("state"))))
+	require.Error(t, client.DeleteState(mac))
+
+	dec := json.NewDecoder(&log)
+	var entry audit.Entry
+	require.NoError(t, dec.Decode(&entry))
+	require.Equal(t, "127.0.0.1", entry.Client)
+	require.Equal(t, "backups", entry.Identity)
+	require.Equal(t, "PUT /state", entry.Route)
+	require.Equal(t, fmt.Sprintf("%x", mac), entry.Resource)
+	require.Equal(t, 200, entry.Status)
+	require.NotZero(t, entry.BytesIn)
+	require.NotZero(t, entry.BytesOut)
+
+	entry = audit.Entry{}
+	require.NoError(t, dec.Decode(&entry))
+	require.Equal(t, "backups", entry.Identity)
+	require.Equal(t, "DELETE /state", entry.Route)
+	require.Equal(t, 403, entry.Status)
+}
+
+func TestRateLimit(t *testing.T) {
+	ts := httptest.NewServer(NewHandler(newStore(t), &Options{
+		RateLimit: audit.NewLimiter(0.001, 2),
+	}))
+	defer ts.Close()
+
+	client := newClient(t, map[string]string{"location": ts.URL})
+	for range 2 {
+		_, err := client.GetStates()
+		require.NoError(t, err)
+	}
+	_, err := client.GetStates()
+	require.Error(t, err)
+}
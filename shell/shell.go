@@ -0,0 +1,20 @@
+// Package shell runs the commands users configure, such as hooks and
+// passphrase commands, with the shell of the system.
+package shell
+
+import (
+	"context"
+	"os/exec"
+	"runtime"
+)
+
+// Command returns the command running command with cmd on Windows and
+// /bin/sh elsewhere.
+func Command(ctx context.Context, command string) *exec.Cmd {
+	switch runtime.GOOS {
+	case "windows":
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	default:
+		return exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	}
+}
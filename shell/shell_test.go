@@ -0,0 +1,17 @@
+package shell
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommand(t *testing.T) {
+	out, err := Command(context.Background(), "echo hello").Output()
+	require.NoError(t, err)
+	require.Equal(t, "hello", strings.TrimSpace(string(out)))
+
+	require.Error(t, Command(context.Background(), "exit 3").Run())
+}
@@ -0,0 +1,45 @@
+package snapshot
+
+import (
+	"github.com/PlakarKorp/plakar/objects"
+	"github.com/PlakarKorp/plakar/repository"
+	"github.com/PlakarKorp/plakar/snapshot/header"
+)
+
+// Amend writes a copy of the header of snapshot snapshotID, edited by
+// amend, as a new snapshot and returns its identifier.  The new
+// snapshot shares all of its data with the original one, which is left
+// untouched: it is up to the caller to remove it.  The copy is signed
+// with the current identity, if any, as the original signature no
+// longer applies.
+func Amend(repo *repository.Repository, snapshotID objects.MAC, amend func(hdr *header.Header)) (objects.MAC, error) {
+	hdr, _, err := GetSnapshot(repo, snapshotID)
+	if err != nil {
+		return objects.MAC{}, err
+	}
+
+	snap, err := New(repo)
+	if err != nil {
+		return objects.MAC{}, err
+	}
+	defer snap.Close()
+
+	done, err := snap.Lock()
+	if err != nil {
+		return objects.MAC{}, err
+	}
+	defer snap.Unlock(done)
+
+	identifier := snap.Header.Identifier
+	identity := snap.Header.Identity
+
+	*snap.Header = *hdr
+	snap.Header.Identifier = identifier
+	snap.Header.Identity = identity
+	amend(snap.Header)
+
+	if err := snap.Commit(nil); err != nil {
+		return objects.MAC{}, err
+	}
+	return identifier, nil
+}
@@ -0,0 +1,39 @@
+package snapshot
+
+import (
+	"testing"
+
+	"github.com/PlakarKorp/plakar/snapshot/header"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAmend(t *testing.T) {
+	snap := generateSnapshot(t, nil)
+	defer snap.Close()
+
+	require.NoError(t, snap.repository.RebuildState())
+
+	amendedID, err := Amend(snap.repository, snap.Header.Identifier, func(hdr *header.Header) {
+		hdr.Tags = append(hdr.Tags, "amended")
+	})
+	require.NoError(t, err)
+	require.NotEqual(t, snap.Header.Identifier, amendedID)
+
+	require.NoError(t, snap.repository.RebuildState())
+
+	amended, err := Load(snap.repository, amendedID)
+	require.NoError(t, err)
+	require.True(t, amended.Header.HasTag("amended"))
+	require.Equal(t, snap.Header.Name, amended.Header.Name)
+	require.Equal(t, snap.Header.Timestamp.Unix(), amended.Header.Timestamp.Unix())
+	require.Equal(t, snap.Header.GetSource(0).VFS, amended.Header.GetSource(0).VFS)
+
+	original, err := Load(snap.repository, snap.Header.Identifier)
+	require.NoError(t, err)
+	require.False(t, original.Header.HasTag("amended"))
+
+	pvfs, err := amended.Filesystem()
+	require.NoError(t, err)
+	_, err = pvfs.GetEntry(amended.Header.GetSource(0).Importer.Directory + "/dummy.txt")
+	require.NoError(t, err)
+}
@@ -1,17 +1,12 @@
 package snapshot
 
 import (
-	"archive/tar"
-	"archive/zip"
-	"compress/gzip"
 	"errors"
-	"fmt"
 	"io"
-	"io/fs"
 	"path"
-	"strings"
 
-	"github.com/PlakarKorp/plakar/snapshot/vfs"
+	"github.com/PlakarKorp/plakar/snapshot/exporter"
+	"github.com/PlakarKorp/plakar/snapshot/exporter/archive"
 )
 
 type ArchiveFormat = string
@@ -24,7 +19,7 @@ const (
 
 var (
 	ErrInvalidArchiveFormat = errors.New("unknown archive format")
-	ErrNotADirectory = errors.New("is not a directory")
+	ErrNotADirectory        = errors.New("is not a directory")
 )
 
 func (snap *Snapshot) Archive(w io.Writer, format ArchiveFormat, paths []string, rebase bool) error {
@@ -33,93 +28,44 @@ func (snap *Snapshot) Archive(w io.Writer, format ArchiveFormat, paths []string,
 		return err
 	}
 
-	var outw io.Closer
-	var archiveEntry func(string, *vfs.Entry) (io.Writer, error)
+	var exp exporter.Exporter
 	switch format {
-	case ArchiveTarball:
-		// wrap the outer writer with gzip
-		gzipWriter := gzip.NewWriter(w)
-		defer gzipWriter.Close()
-		w = gzipWriter
-		fallthrough
 	case ArchiveTar:
-		tarWriter := tar.NewWriter(w)
-		outw = tarWriter
-		archiveEntry = func(path string, entry *vfs.Entry) (io.Writer, error) {
-			// ignore symlinks
-			if entry.FileInfo.Mode()&fs.ModeSymlink != 0 {
-				return nil, nil
-			}
-			header, err := tar.FileInfoHeader(entry.Stat(), "")
-			if err != nil {
-				return nil, err
-			}
-			header.Name = path
-			if err := tarWriter.WriteHeader(header); err != nil {
-				return nil, err
-			}
-			if !entry.FileInfo.Lmode.IsRegular() {
-				return nil, nil
-			}
-			return tarWriter, nil
-		}
-
+		exp = archive.NewTarWriter(w, false)
+	case ArchiveTarball:
+		exp = archive.NewTarWriter(w, true)
 	case ArchiveZip:
-		zipWriter := zip.NewWriter(w)
-		outw = zipWriter
-		archiveEntry = func(path string, entry *vfs.Entry) (io.Writer, error) {
-			if !entry.FileInfo.Lmode.IsRegular() {
-				return nil, nil
-			}
-			header, err := zip.FileInfoHeader(entry.Stat())
-			if err != nil {
-				return nil, err
-			}
-			header.Name = path
-			header.Method = zip.Deflate
-			return zipWriter.CreateHeader(header)
-		}
-
+		exp = archive.NewZipWriter(w)
 	default:
 		return ErrInvalidArchiveFormat
 	}
 
 	for _, p := range paths {
-		err := fsc.WalkDir(p, func(entrypath string, e *vfs.Entry, err error) error {
-			if err != nil {
-				return err
-			}
-
-			outpath := entrypath
-			if rebase {
-				outpath = strings.TrimPrefix(outpath, p)
-			}
-			outpath = strings.TrimLeft(outpath, "/")
-			if outpath == "" {
-				if e.IsDir() {
-					outpath = "."
-				} else {
-					outpath = path.Base(entrypath)
-				}
-			}
+		p = path.Clean("/" + p)
 
-			writer, err := archiveEntry(outpath, e)
+		var strip string
+		if rebase {
+			e, err := fsc.GetEntry(p)
 			if err != nil {
-				return fmt.Errorf("Failed to archive %s: %w", entrypath, err)
+				exp.Close()
+				return err
 			}
-			if writer == nil {
-				return nil
+			if e.IsDir() {
+				strip = p
+			} else {
+				strip = path.Dir(p)
 			}
+		}
 
-			fp := e.Open(fsc, e.Path())
-			_, err = io.Copy(writer, fp)
-			fp.Close()
-			return err
-		})
-		if err != nil {
+		opts := &RestoreOptions{
+			MaxConcurrency: 1,
+			Strip:          strip,
+		}
+		if err := snap.restore(exp, exp.Root(), p, opts); err != nil {
+			exp.Close()
 			return err
 		}
 	}
 
-	return outw.Close()
+	return exp.Close()
 }
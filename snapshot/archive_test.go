@@ -1,7 +1,9 @@
 package snapshot
 
 import (
+	"archive/tar"
 	"bytes"
+	"io"
 	"strings"
 	"testing"
 
@@ -33,4 +35,21 @@ func TestArchive(t *testing.T) {
 		err = snap.Archive(bufOut, format, []string{filepath}, true)
 		require.NoError(t, err)
 	}
+
+	bufOut := bytes.NewBuffer(nil)
+	err = snap.Archive(bufOut, ArchiveTar, []string{filepath}, true)
+	require.NoError(t, err)
+
+	tr := tar.NewReader(bufOut)
+	hdr, err := tr.Next()
+	require.NoError(t, err)
+	require.Equal(t, "dummy.txt", hdr.Name)
+	content, err := io.ReadAll(tr)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(content))
+	_, err = tr.Next()
+	require.Equal(t, io.EOF, err)
+
+	err = snap.Archive(bytes.NewBuffer(nil), "rar", []string{filepath}, true)
+	require.ErrorIs(t, err, ErrInvalidArchiveFormat)
 }
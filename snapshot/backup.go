@@ -3,12 +3,15 @@ package snapshot
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"math"
 	"mime"
 	"path"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -32,6 +35,7 @@ type BackupContext struct {
 	aborted        atomic.Bool
 	abortedReason  error
 	imp            importer.Importer
+	source         *header.Source
 	maxConcurrency uint64
 	scanCache      *caching.ScanCache
 
@@ -43,13 +47,147 @@ type BackupContext struct {
 
 	erridx   *btree.BTree[string, int, []byte]
 	xattridx *btree.BTree[string, int, []byte]
+
+	// across all sources, for the error budget
+	nPaths  atomic.Uint64
+	nErrors atomic.Uint64
+
+	// files per class and analyzer across all sources
+	muClasses sync.Mutex
+	classes   map[string]map[string]uint64
+	nFiles    atomic.Uint64
+}
+
+// ErrorBudget bounds the errors a backup may meet before its snapshot
+// is failed rather than partial.
+type ErrorBudget struct {
+	// MaxErrors is the number of errors allowed, negative for no limit.
+	MaxErrors int64
+
+	// MaxRatio is the ratio of the paths met which may be errors, 0 for
+	// no limit.
+	MaxRatio float64
+}
+
+// Exceeded tells whether errors, out of paths, is over budget.
+func (budget *ErrorBudget) Exceeded(errors, paths uint64) bool {
+	if budget == nil {
+		return false
+	}
+	if budget.MaxErrors >= 0 && errors > uint64(budget.MaxErrors) {
+		return true
+	}
+	return budget.MaxRatio > 0 && paths > 0 && float64(errors)/float64(paths) > budget.MaxRatio
+}
+
+// ChangeDetection selects how a backup decides that a file did not
+// change since a previous one and can reuse its cached entry.
+type ChangeDetection string
+
+const (
+	// ChangeDetectionMtime compares the size, mode, owner and
+	// modification time.
+	ChangeDetectionMtime ChangeDetection = "mtime"
+
+	// ChangeDetectionCtime also compares the inode change time, which
+	// catches files modified with their modification time preserved.
+	ChangeDetectionCtime ChangeDetection = "ctime"
+
+	// ChangeDetectionInode also compares the device, inode number and
+	// link count, this is the default.
+	ChangeDetectionInode ChangeDetection = "inode"
+
+	// ChangeDetectionContent never trusts metadata and reads every
+	// file again.
+	ChangeDetectionContent ChangeDetection = "content"
+)
+
+var ErrInvalidChangeDetection = errors.New("invalid change detection")
+
+func ParseChangeDetection(mode string) (ChangeDetection, error) {
+	switch ChangeDetection(mode) {
+	case ChangeDetectionMtime, ChangeDetectionCtime, ChangeDetectionInode, ChangeDetectionContent:
+		return ChangeDetection(mode), nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrInvalidChangeDetection, mode)
 }
 
+// Unchanged tells whether a file described by cached in a previous
+// backup is known to be the same as the one described by current.
+func (mode ChangeDetection) Unchanged(cached, current *objects.FileInfo) bool {
+	if mode == ChangeDetectionContent {
+		return false
+	}
+
+	if cached.Lname != current.Lname ||
+		cached.Lsize != current.Lsize ||
+		cached.Lmode != current.Lmode ||
+		!cached.LmodTime.Equal(current.LmodTime) ||
+		cached.Luid != current.Luid ||
+		cached.Lgid != current.Lgid {
+		return false
+	}
+	if mode == ChangeDetectionMtime {
+		return true
+	}
+
+	if !cached.LchangeTime.Equal(current.LchangeTime) {
+		return false
+	}
+	if mode == ChangeDetectionCtime {
+		return true
+	}
+
+	return cached.Ldev == current.Ldev &&
+		cached.Lino == current.Lino &&
+		cached.Lnlink == current.Lnlink
+}
+
+// Reasons reported by the FileChange events of a backup.
+const (
+	backupChangeNew       = "new"
+	backupChangeUnchanged = "unchanged"
+	backupChangeModified  = "modified"
+	backupChangeRehash    = "rehash"
+)
+
 type BackupOptions struct {
 	MaxConcurrency uint64
 	Name           string
+	Category       string
+	Environment    string
+	Perimeter      string
 	Tags           []string
+	Context        []header.KeyValue
 	Excludes       []glob.Glob
+
+	// ChangeDetection defaults to ChangeDetectionInode.
+	ChangeDetection ChangeDetection
+
+	// ForceRehash reads every file again, as ChangeDetectionContent.
+	ForceRehash bool
+
+	// ErrorBudget, if set, fails the snapshot when too many paths
+	// could not be backed up.
+	ErrorBudget *ErrorBudget
+
+	// Analyzers names the classifier backends scanning the files backed
+	// up, such as "secrets". None run by default.
+	Analyzers []string
+
+	// PostBackup is called once all sources are backed up, before the
+	// snapshot is committed, and may still amend its header.
+	PostBackup func(hdr *header.Header)
+}
+
+func (options *BackupOptions) changeDetection() ChangeDetection {
+	if options.ForceRehash {
+		return ChangeDetectionContent
+	}
+	if options.ChangeDetection == "" {
+		return ChangeDetectionInode
+	}
+	return options.ChangeDetection
 }
 
 func (bc *BackupContext) recordEntry(entry *vfs.Entry) error {
@@ -66,7 +204,65 @@ func (bc *BackupContext) recordEntry(entry *vfs.Entry) error {
 	return bc.scanCache.PutFile(path, bytes)
 }
 
+func (bc *BackupContext) recordClassifications(entry *vfs.Entry) {
+	if entry.FileInfo.Mode().IsRegular() {
+		bc.nFiles.Add(1)
+	}
+	if len(entry.Classifications) == 0 {
+		return
+	}
+
+	bc.muClasses.Lock()
+	defer bc.muClasses.Unlock()
+
+	if bc.classes == nil {
+		bc.classes = make(map[string]map[string]uint64)
+	}
+	for _, classification := range entry.Classifications {
+		if bc.classes[classification.Analyzer] == nil {
+			bc.classes[classification.Analyzer] = make(map[string]uint64)
+		}
+		for _, class := range classification.Classes {
+			bc.classes[classification.Analyzer][class]++
+		}
+	}
+}
+
+// classifications aggregates the classes of the files for the header.
+func (bc *BackupContext) classifications() []header.Classification {
+	bc.muClasses.Lock()
+	defer bc.muClasses.Unlock()
+
+	ret := []header.Classification{}
+	nFiles := bc.nFiles.Load()
+	for analyzer, classes := range bc.classes {
+		classification := header.Classification{
+			Analyzer: analyzer,
+		}
+		for name, count := range classes {
+			class := header.Class{
+				Name:  name,
+				Count: count,
+			}
+			if nFiles != 0 {
+				class.Probability = float64(count) / float64(nFiles)
+			}
+			classification.Classes = append(classification.Classes, class)
+		}
+		slices.SortFunc(classification.Classes, func(a, b header.Class) int {
+			return strings.Compare(a.Name, b.Name)
+		})
+		ret = append(ret, classification)
+	}
+	slices.SortFunc(ret, func(a, b header.Classification) int {
+		return strings.Compare(a.Analyzer, b.Analyzer)
+	})
+	return ret
+}
+
 func (bc *BackupContext) recordError(path string, err error) error {
+	bc.nErrors.Add(1)
+
 	entry := vfs.NewErrorItem(path, err.Error())
 	serialized, e := entry.ToBytes()
 	if e != nil {
@@ -95,18 +291,20 @@ func (snapshot *Snapshot) skipExcludedPathname(options *BackupOptions, record *i
 		pathname = record.Error.Pathname
 	}
 
+	return excludedPathname(options, pathname)
+}
+
+func excludedPathname(options *BackupOptions, pathname string) bool {
 	if pathname == "/" {
 		return false
 	}
 
-	doExclude := false
 	for _, exclude := range options.Excludes {
 		if exclude.Match(pathname) {
-			doExclude = true
-			break
+			return true
 		}
 	}
-	return doExclude
+	return false
 }
 
 func (snap *Snapshot) importerJob(backupCtx *BackupContext, options *BackupOptions) (chan *importer.ScanRecord, error) {
@@ -138,6 +336,10 @@ func (snap *Snapshot) importerJob(backupCtx *BackupContext, options *BackupOptio
 				continue
 			}
 
+			if _record.Record == nil || !_record.Record.IsXattr {
+				backupCtx.nPaths.Add(1)
+			}
+
 			concurrencyChan <- struct{}{}
 			wg.Add(1)
 			go func(record *importer.ScanResult) {
@@ -175,8 +377,8 @@ func (snap *Snapshot) importerJob(backupCtx *BackupContext, options *BackupOptio
 								atomic.AddUint64(&size, uint64(record.FileInfo.Size()))
 							}
 							// if snapshot root is a file, then reset to the parent directory
-							if snap.Header.GetSource(0).Importer.Directory == record.Pathname {
-								snap.Header.GetSource(0).Importer.Directory = filepath.Dir(record.Pathname)
+							if backupCtx.source.Importer.Directory == record.Pathname {
+								backupCtx.source.Importer.Directory = filepath.Dir(record.Pathname)
 							}
 						}
 					} else {
@@ -272,36 +474,58 @@ func (snap *Snapshot) flushDeltaState(bc *BackupContext) {
 }
 
 func (snap *Snapshot) Backup(imp importer.Importer, options *BackupOptions) error {
+	return snap.BackupSources([]importer.Importer{imp}, options)
+}
+
+// BackupSources backs up every importer into the snapshot, each one
+// recorded as a source of its own, in the order they are given.
+func (snap *Snapshot) BackupSources(imps []importer.Importer, options *BackupOptions) error {
 	snap.Event(events.StartEvent())
 	defer snap.Event(events.DoneEvent())
 
+	if len(imps) == 0 {
+		return fmt.Errorf("no source to backup")
+	}
+
 	done, err := snap.Lock()
 	if err != nil {
 		return err
 	}
 	defer snap.Unlock(done)
 
-	vfsCache, err := snap.AppContext().GetCache().VFS(imp.Type(), imp.Origin())
-	if err != nil {
-		return err
-	}
-	cf, err := classifier.NewClassifier(snap.AppContext())
+	cf, err := classifier.NewClassifier(snap.AppContext(), options.Analyzers...)
 	if err != nil {
 		return err
 	}
 	defer cf.Close()
 
-	snap.Header.GetSource(0).Importer.Origin = imp.Origin()
-	snap.Header.GetSource(0).Importer.Type = imp.Type()
+	for len(snap.Header.Sources) < len(imps) {
+		snap.Header.Sources = append(snap.Header.Sources, header.NewSource())
+	}
 	snap.Header.Tags = append(snap.Header.Tags, options.Tags...)
 
 	if options.Name == "" {
-		snap.Header.Name = imp.Root() + " @ " + snap.Header.GetSource(0).Importer.Origin
+		names := make([]string, 0, len(imps))
+		for _, imp := range imps {
+			names = append(names, imp.Root()+" @ "+imp.Origin())
+		}
+		snap.Header.Name = strings.Join(names, ", ")
 	} else {
 		snap.Header.Name = options.Name
 	}
 
-	snap.Header.GetSource(0).Importer.Directory = imp.Root()
+	if options.Category != "" {
+		snap.Header.Category = options.Category
+	}
+	if options.Environment != "" {
+		snap.Header.Environment = options.Environment
+	}
+	if options.Perimeter != "" {
+		snap.Header.Perimeter = options.Perimeter
+	}
+	for _, kv := range options.Context {
+		snap.Header.SetContext(kv.Key, kv.Value)
+	}
 
 	maxConcurrency := options.MaxConcurrency
 	if maxConcurrency == 0 {
@@ -309,9 +533,7 @@ func (snap *Snapshot) Backup(imp importer.Importer, options *BackupOptions) erro
 	}
 
 	backupCtx := &BackupContext{
-		imp:            imp,
 		maxConcurrency: maxConcurrency,
-		scanCache:      snap.scanCache,
 		flushTick:      time.NewTicker(1 * time.Hour),
 		flushEnd:       make(chan bool),
 		flushEnded:     make(chan bool),
@@ -320,9 +542,69 @@ func (snap *Snapshot) Backup(imp importer.Importer, options *BackupOptions) erro
 
 	go snap.flushDeltaState(backupCtx)
 
+	/* backup starts now */
+	beginTime := time.Now()
+
+	for i, imp := range imps {
+		if err := snap.backupSource(backupCtx, i, imp, cf, options); err != nil {
+			return err
+		}
+	}
+
+	snap.Header.Duration = time.Since(beginTime)
+	snap.Header.Classifications = backupCtx.classifications()
+
+	nErrors := backupCtx.nErrors.Load()
+	switch {
+	case options.ErrorBudget.Exceeded(nErrors, backupCtx.nPaths.Load()):
+		snap.Header.Status = header.StatusFailed
+	case nErrors != 0:
+		snap.Header.Status = header.StatusPartial
+	default:
+		snap.Header.Status = header.StatusComplete
+	}
+
+	if options.PostBackup != nil {
+		options.PostBackup(snap.Header)
+	}
+
+	return snap.Commit(backupCtx)
+}
+
+// backupSource scans a single importer and records the result as
+// the idx-th source of the snapshot.  Every source gets a scan cache
+// of its own, as the indexes built there are per-source.
+func (snap *Snapshot) backupSource(backupCtx *BackupContext, idx int, imp importer.Importer, cf *classifier.Classifier, options *BackupOptions) error {
+	vfsCache, err := snap.AppContext().GetCache().VFS(imp.Type(), imp.Origin())
+	if err != nil {
+		return err
+	}
+
+	scanCache := snap.scanCache
+	if idx != 0 {
+		identifier, err := MakeSnapIdentifier()
+		if err != nil {
+			return err
+		}
+		scanCache, err = snap.AppContext().GetCache().Scan(identifier)
+		if err != nil {
+			return err
+		}
+		defer scanCache.Close()
+	}
+
+	source := snap.Header.GetSource(idx)
+	source.Importer.Origin = imp.Origin()
+	source.Importer.Type = imp.Type()
+	source.Importer.Directory = imp.Root()
+
+	backupCtx.imp = imp
+	backupCtx.source = source
+	backupCtx.scanCache = scanCache
+
 	errstore := caching.DBStore[string, []byte]{
 		Prefix: "__error__",
-		Cache:  snap.scanCache,
+		Cache:  scanCache,
 	}
 	backupCtx.erridx, err = btree.New(&errstore, strings.Compare, 50)
 	if err != nil {
@@ -331,7 +613,7 @@ func (snap *Snapshot) Backup(imp importer.Importer, options *BackupOptions) erro
 
 	xattrstore := caching.DBStore[string, []byte]{
 		Prefix: "__xattr__",
-		Cache:  snap.scanCache,
+		Cache:  scanCache,
 	}
 	backupCtx.xattridx, err = btree.New(&xattrstore, vfs.PathCmp, 50)
 	if err != nil {
@@ -340,23 +622,26 @@ func (snap *Snapshot) Backup(imp importer.Importer, options *BackupOptions) erro
 
 	ctstore := caching.DBStore[string, objects.MAC]{
 		Prefix: "__contenttype__",
-		Cache:  snap.scanCache,
+		Cache:  scanCache,
 	}
 	ctidx, err := btree.New(&ctstore, strings.Compare, 50)
 	if err != nil {
 		return err
 	}
 
-	/* backup starts now */
-	beginTime := time.Now()
-
 	/* importer */
+	if excluder, ok := imp.(importer.Excluder); ok && len(options.Excludes) > 0 {
+		excluder.SetExcludes(func(pathname string) bool {
+			return excludedPathname(options, pathname)
+		})
+	}
 	filesChannel, err := snap.importerJob(backupCtx, options)
 	if err != nil {
 		return err
 	}
 
-	concurrencyChan := make(chan struct{}, maxConcurrency)
+	concurrencyChan := make(chan struct{}, backupCtx.maxConcurrency)
+	changeDetection := options.changeDetection()
 
 	/* scanner */
 	scannerWg := sync.WaitGroup{}
@@ -385,6 +670,11 @@ func (snap *Snapshot) Backup(imp importer.Importer, options *BackupOptions) erro
 			var cachedFileEntry *vfs.Entry
 			var cachedFileEntryMAC objects.MAC
 
+			// set when the content is read, xattrs are not classified
+			var processor *classifier.Processor
+
+			change := backupChangeNew
+
 			// Check if the file entry and underlying objects are already in the cache
 			if data, err := vfsCache.GetFilename(record.Pathname); err != nil {
 				snap.Logger().Warn("VFS CACHE: Error getting filename: %v", err)
@@ -394,7 +684,12 @@ func (snap *Snapshot) Backup(imp importer.Importer, options *BackupOptions) erro
 					snap.Logger().Warn("VFS CACHE: Error unmarshaling filename: %v", err)
 				} else {
 					cachedFileEntryMAC = snap.repository.ComputeMAC(data)
-					if cachedFileEntry.Stat().Equal(&record.FileInfo) {
+					if changeDetection == ChangeDetectionContent {
+						change = backupChangeRehash
+					} else if !changeDetection.Unchanged(cachedFileEntry.Stat(), &record.FileInfo) {
+						change = backupChangeModified
+					} else {
+						change = backupChangeUnchanged
 						fileEntry = cachedFileEntry
 						if fileEntry.FileInfo.Mode().IsRegular() {
 							data, err := vfsCache.GetObject(cachedFileEntry.Object)
@@ -415,6 +710,10 @@ func (snap *Snapshot) Backup(imp importer.Importer, options *BackupOptions) erro
 				}
 			}
 
+			if !record.IsXattr {
+				snap.Event(events.FileChangeEvent(snap.Header.Identifier, record.Pathname, change))
+			}
+
 			if object != nil {
 				err = snap.PutBlobIfNotExists(resources.RT_OBJECT, objectMAC, objectSerialized)
 				if err != nil {
@@ -426,7 +725,10 @@ func (snap *Snapshot) Backup(imp importer.Importer, options *BackupOptions) erro
 			// Chunkify the file if it is a regular file and we don't have a cached object
 			if record.FileInfo.Mode().IsRegular() {
 				if object == nil || !snap.BlobExists(resources.RT_OBJECT, objectMAC) {
-					object, err = snap.chunkify(imp, cf, record)
+					if !record.IsXattr {
+						processor = cf.Processor(record.Pathname)
+					}
+					object, err = snap.chunkify(imp, processor, record)
 					if err != nil {
 						backupCtx.recordError(record.Pathname, err)
 						return
@@ -462,9 +764,23 @@ func (snap *Snapshot) Backup(imp importer.Importer, options *BackupOptions) erro
 					fileEntry.Object = objectMAC
 				}
 
-				classifications := cf.Processor(record.Pathname).File(fileEntry)
-				for _, result := range classifications {
-					fileEntry.AddClassification(result.Analyzer, result.Classes)
+				switch {
+				case processor != nil:
+					for _, result := range processor.Classify(fileEntry) {
+						fileEntry.AddClassification(result.Analyzer, result.Classes)
+					}
+				case object != nil && cachedFileEntry != nil && cachedFileEntry.Object == objectMAC:
+					// the content was not read again, keep what the
+					// analyzers still enabled found in it
+					for _, classification := range cachedFileEntry.Classifications {
+						if slices.Contains(options.Analyzers, classification.Analyzer) {
+							fileEntry.Classifications = append(fileEntry.Classifications, classification)
+						}
+					}
+				default:
+					for _, result := range cf.Processor(record.Pathname).File(fileEntry) {
+						fileEntry.AddClassification(result.Analyzer, result.Classes)
+					}
 				}
 
 				serialized, err := fileEntry.ToBytes()
@@ -533,6 +849,7 @@ func (snap *Snapshot) Backup(imp importer.Importer, options *BackupOptions) erro
 				backupCtx.recordError(record.Pathname, err)
 				return
 			}
+			backupCtx.recordClassifications(fileEntry)
 
 			snap.Event(events.FileOKEvent(snap.Header.Identifier, record.Pathname, record.FileInfo.Size()))
 		}(_record)
@@ -547,7 +864,7 @@ func (snap *Snapshot) Backup(imp importer.Importer, options *BackupOptions) erro
 
 	filestore := caching.DBStore[string, []byte]{
 		Prefix: "__path__",
-		Cache:  snap.scanCache,
+		Cache:  scanCache,
 	}
 	fileidx, err := btree.New(&filestore, vfs.PathCmp, 50)
 	if err != nil {
@@ -556,7 +873,7 @@ func (snap *Snapshot) Backup(imp importer.Importer, options *BackupOptions) erro
 
 	var rootSummary *vfs.Summary
 
-	diriter := backupCtx.scanCache.EnumerateKeysWithPrefix("__directory__:", true)
+	diriter := scanCache.EnumerateKeysWithPrefix("__directory__:", true)
 	for dirPath, bytes := range diriter {
 		select {
 		case <-snap.AppContext().GetContext().Done():
@@ -574,7 +891,7 @@ func (snap *Snapshot) Backup(imp importer.Importer, options *BackupOptions) erro
 			prefix += "/"
 		}
 
-		childiter := backupCtx.scanCache.EnumerateKeysWithPrefix("__file__:"+prefix, false)
+		childiter := scanCache.EnumerateKeysWithPrefix("__file__:"+prefix, false)
 
 		for relpath, bytes := range childiter {
 			if strings.Contains(relpath, "/") {
@@ -606,14 +923,14 @@ func (snap *Snapshot) Backup(imp importer.Importer, options *BackupOptions) erro
 			dirEntry.Summary.UpdateWithFileSummary(fileSummary)
 		}
 
-		subDirIter := backupCtx.scanCache.EnumerateKeysWithPrefix("__directory__:"+prefix, false)
+		subDirIter := scanCache.EnumerateKeysWithPrefix("__directory__:"+prefix, false)
 		for relpath := range subDirIter {
 			if relpath == "" || strings.Contains(relpath, "/") {
 				continue
 			}
 
 			childPath := prefix + relpath
-			data, err := snap.scanCache.GetSummary(childPath)
+			data, err := scanCache.GetSummary(childPath)
 			if err != nil {
 				continue
 			}
@@ -636,8 +953,9 @@ func (snap *Snapshot) Backup(imp importer.Importer, options *BackupOptions) erro
 			if !strings.HasPrefix(path, prefix) {
 				break
 			}
+			// errors below are counted by the subdirectories
 			if strings.Contains(path[len(prefix):], "/") {
-				break
+				continue
 			}
 			dirEntry.Summary.Below.Errors++
 		}
@@ -658,7 +976,7 @@ func (snap *Snapshot) Backup(imp importer.Importer, options *BackupOptions) erro
 			return err
 		}
 
-		err = snap.scanCache.PutSummary(dirPath, serializedSummary)
+		err = scanCache.PutSummary(dirPath, serializedSummary)
 		if err != nil {
 			backupCtx.recordError(dirPath, err)
 			return err
@@ -728,14 +1046,13 @@ func (snap *Snapshot) Backup(imp importer.Importer, options *BackupOptions) erro
 		return backupCtx.abortedReason
 	}
 
-	snap.Header.GetSource(0).VFS = header.VFS{
+	source.VFS = header.VFS{
 		Root:   rootcsum,
 		Xattrs: xattrcsum,
 		Errors: errcsum,
 	}
-	snap.Header.Duration = time.Since(beginTime)
-	snap.Header.GetSource(0).Summary = *rootSummary
-	snap.Header.GetSource(0).Indexes = []header.Index{
+	source.Summary = *rootSummary
+	source.Indexes = []header.Index{
 		{
 			Name:  "content-type",
 			Type:  "btree",
@@ -743,7 +1060,7 @@ func (snap *Snapshot) Backup(imp importer.Importer, options *BackupOptions) erro
 		},
 	}
 
-	return snap.Commit(backupCtx)
+	return nil
 }
 
 func entropy(data []byte) (float64, [256]float64) {
@@ -769,7 +1086,26 @@ func entropy(data []byte) (float64, [256]float64) {
 	return entropy, freq
 }
 
-func (snap *Snapshot) chunkify(imp importer.Importer, cf *classifier.Classifier, record *importer.ScanRecord) (*objects.Object, error) {
+// maxHoleChunk is the length of the longest chunk a hole is split in.
+const maxHoleChunk = 1 << 30
+
+// sparseMAC returns the MAC of the content of a sparse object.  Rather
+// than over the content, it is computed over the chunks, each described
+// by its flags, length and MAC, so that holes are never read nor hashed.
+func sparseMAC(hasher hash.Hash, chunks []objects.Chunk) objects.MAC {
+	var buf [8]byte
+	for _, chunk := range chunks {
+		binary.BigEndian.PutUint32(buf[0:], chunk.Flags)
+		binary.BigEndian.PutUint32(buf[4:], chunk.Length)
+		hasher.Write(buf[:])
+		if !chunk.IsHole() {
+			hasher.Write(chunk.ContentMAC[:])
+		}
+	}
+	return objects.MAC(hasher.Sum(nil))
+}
+
+func (snap *Snapshot) chunkify(imp importer.Importer, processor *classifier.Processor, record *importer.ScanRecord) (*objects.Object, error) {
 	var rd io.ReadCloser
 	var err error
 
@@ -789,6 +1125,10 @@ func (snap *Snapshot) chunkify(imp importer.Importer, cf *classifier.Classifier,
 
 	objectHasher := snap.repository.GetMACHasher()
 
+	// the type guessed from the name, the content is not read yet
+	chunking := snap.repository.ChunkingFor(record.Pathname, object.ContentType)
+	threshold := int64(chunking.GetThreshold())
+
 	var firstChunk = true
 	var cdcOffset uint64
 	var object_t32 objects.MAC
@@ -809,6 +1149,9 @@ func (snap *Snapshot) chunkify(imp importer.Importer, cf *classifier.Classifier,
 			firstChunk = false
 		}
 		objectHasher.Write(data)
+		if processor != nil {
+			processor.Write(data)
+		}
 
 		chunkHasher.Reset()
 		chunkHasher.Write(data)
@@ -834,41 +1177,82 @@ func (snap *Snapshot) chunkify(imp importer.Importer, cf *classifier.Classifier,
 		return snap.PutBlobIfNotExists(resources.RT_CHUNK, chunk.ContentMAC, data)
 	}
 
-	if record.FileInfo.Size() == 0 {
-		// Produce an empty chunk for empty file
-		if err := processChunk([]byte{}); err != nil {
-			return nil, err
-		}
-	} else if record.FileInfo.Size() < int64(snap.repository.Configuration().Chunking.MinSize) {
-		// Small file case: read entire file into memory
-		buf, err := io.ReadAll(rd)
-		if err != nil {
-			return nil, err
-		}
-		if err := processChunk(buf); err != nil {
-			return nil, err
+	// a hole is recorded as runs of zeros which have no blob
+	processHole := func(length int64) {
+		for length > 0 {
+			n := min(length, maxHoleChunk)
+			chunk := objects.NewChunk()
+			chunk.Length = uint32(n)
+			chunk.Flags = objects.ChunkFlagHole
+			object.Chunks = append(object.Chunks, *chunk)
+			cdcOffset += uint64(n)
+			length -= n
 		}
-	} else {
-		// Large file case: chunk file with chunker
-		chk, err := snap.repository.Chunker(rd)
+	}
+
+	processStream := func(r io.ReadCloser) error {
+		chk, err := chunking.NewChunker(r)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		for {
 			cdcChunk, err := chk.Next()
 			if err != nil && err != io.EOF {
-				return nil, err
+				return err
 			}
 			if cdcChunk == nil {
 				break
 			}
 			if err := processChunk(cdcChunk); err != nil {
-				return nil, err
+				return err
 			}
 			if err == io.EOF {
 				break
 			}
 		}
+		return nil
+	}
+
+	var extents []objects.Extent
+	sparse, isSparse := rd.(importer.SparseReader)
+	if isSparse && !record.IsXattr && record.FileInfo.Size() >= threshold {
+		// files whose holes can't be found are read as a whole
+		if extents, err = sparse.DataExtents(); err != nil {
+			isSparse = false
+		}
+	}
+
+	if record.FileInfo.Size() == 0 && !record.IsXattr {
+		// Produce an empty chunk for empty file
+		if err := processChunk([]byte{}); err != nil {
+			return nil, err
+		}
+	} else if record.IsXattr || record.FileInfo.Size() < threshold {
+		// Small file case: read entire file into memory, xattrs
+		// have no size in their record but are always small
+		buf, err := io.ReadAll(rd)
+		if err != nil {
+			return nil, err
+		}
+		if err := processChunk(buf); err != nil {
+			return nil, err
+		}
+	} else if isSparse {
+		// Sparse file case: chunk the data extents only
+		var offset int64
+		for _, extent := range extents {
+			processHole(extent.Offset - offset)
+			if err := processStream(io.NopCloser(io.NewSectionReader(sparse, extent.Offset, extent.Length))); err != nil {
+				return nil, err
+			}
+			offset = extent.Offset + extent.Length
+		}
+		processHole(record.FileInfo.Size() - offset)
+	} else {
+		// Large file case: chunk file with chunker
+		if err := processStream(rd); err != nil {
+			return nil, err
+		}
 	}
 
 	if totalDataSize > 0 {
@@ -877,8 +1261,12 @@ func (snap *Snapshot) chunkify(imp importer.Importer, cf *classifier.Classifier,
 		object.Entropy = 0.0
 	}
 
-	copy(object_t32[:], objectHasher.Sum(nil))
-	object.ContentMAC = object_t32
+	if object.Sparse() {
+		object.ContentMAC = sparseMAC(snap.repository.GetMACHasher(), object.Chunks)
+	} else {
+		copy(object_t32[:], objectHasher.Sum(nil))
+		object.ContentMAC = object_t32
+	}
 	return object, nil
 }
 
@@ -940,6 +1328,7 @@ func (snap *Snapshot) PutPackfile(packer *Packer) error {
 							Offset:   packer.Packfile.Index[idx].Offset,
 							Length:   packer.Packfile.Index[idx].Length,
 						},
+						Flags: packer.Packfile.Index[idx].Flags,
 					}
 
 					if err := snap.deltaState.PutDelta(delta); err != nil {
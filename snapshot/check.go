@@ -3,8 +3,10 @@ package snapshot
 import (
 	"bytes"
 	"sync"
+	"sync/atomic"
 
 	"github.com/PlakarKorp/plakar/events"
+	"github.com/PlakarKorp/plakar/objects"
 	"github.com/PlakarKorp/plakar/resources"
 	"github.com/PlakarKorp/plakar/snapshot/vfs"
 )
@@ -14,7 +16,7 @@ type CheckOptions struct {
 	FastCheck      bool
 }
 
-func snapshotCheckPath(snap *Snapshot, opts *CheckOptions, concurrency chan bool, wg *sync.WaitGroup) func(entrypath string, e *vfs.Entry, err error) error {
+func snapshotCheckPath(snap *Snapshot, opts *CheckOptions, concurrency chan bool, wg *sync.WaitGroup, failed *atomic.Bool) func(entrypath string, e *vfs.Entry, err error) error {
 	return func(entrypath string, e *vfs.Entry, err error) error {
 
 		if err != nil {
@@ -44,14 +46,21 @@ func snapshotCheckPath(snap *Snapshot, opts *CheckOptions, concurrency chan bool
 			object, err := snap.LookupObject(_fileEntry.Object)
 			if err != nil {
 				snap.Event(events.ObjectMissingEvent(snap.Header.Identifier, _fileEntry.Object))
+				failed.Store(true)
 				return
 			}
 
 			hasher := snap.repository.GetMACHasher()
 			snap.Event(events.ObjectEvent(snap.Header.Identifier, object.ContentMAC))
 			complete := true
+			sparse := object.Sparse()
 
 			for _, chunk := range object.Chunks {
+				// holes have no blob to check
+				if chunk.IsHole() {
+					continue
+				}
+
 				snap.Event(events.ChunkEvent(snap.Header.Identifier, chunk.ContentMAC))
 				if opts.FastCheck {
 					if !snap.BlobExists(resources.RT_CHUNK, chunk.ContentMAC) {
@@ -74,7 +83,9 @@ func snapshotCheckPath(snap *Snapshot, opts *CheckOptions, concurrency chan bool
 					}
 					snap.Event(events.ChunkOKEvent(snap.Header.Identifier, chunk.ContentMAC))
 
-					hasher.Write(data)
+					if !sparse {
+						hasher.Write(data)
+					}
 
 					mac := snap.repository.ComputeMAC(data)
 					if !bytes.Equal(mac[:], chunk.ContentMAC[:]) {
@@ -87,14 +98,23 @@ func snapshotCheckPath(snap *Snapshot, opts *CheckOptions, concurrency chan bool
 
 			if !complete {
 				snap.Event(events.ObjectCorruptedEvent(snap.Header.Identifier, object.ContentMAC))
-			} else {
-				snap.Event(events.ObjectOKEvent(snap.Header.Identifier, object.ContentMAC))
+				snap.Event(events.FileCorruptedEvent(snap.Header.Identifier, path))
+				failed.Store(true)
+				return
 			}
+			snap.Event(events.ObjectOKEvent(snap.Header.Identifier, object.ContentMAC))
 
 			if !opts.FastCheck {
-				if !bytes.Equal(hasher.Sum(nil), object.ContentMAC[:]) {
+				// the MAC of a sparse object covers the chunk
+				// MACs, each checked above, not the content
+				mac := objects.MAC(hasher.Sum(nil))
+				if sparse {
+					mac = sparseMAC(hasher, object.Chunks)
+				}
+				if mac != object.ContentMAC {
 					snap.Event(events.ObjectCorruptedEvent(snap.Header.Identifier, object.ContentMAC))
 					snap.Event(events.FileCorruptedEvent(snap.Header.Identifier, path))
+					failed.Store(true)
 					return
 				}
 			}
@@ -123,11 +143,12 @@ func (snap *Snapshot) Check(pathname string, opts *CheckOptions) (bool, error) {
 	defer wg.Wait()
 	defer close(maxConcurrencyChan)
 
-	err = fs.WalkDir(pathname, snapshotCheckPath(snap, opts, maxConcurrencyChan, &wg))
+	var failed atomic.Bool
+	err = fs.WalkDir(pathname, snapshotCheckPath(snap, opts, maxConcurrencyChan, &wg, &failed))
 	if err != nil {
 		return false, err
 	}
 	wg.Wait()
 
-	return true, nil
+	return !failed.Load(), nil
 }
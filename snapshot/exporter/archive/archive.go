@@ -0,0 +1,475 @@
+package archive
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PlakarKorp/plakar/objects"
+	"github.com/PlakarKorp/plakar/snapshot/exporter"
+)
+
+// file contents up to this size are spooled in memory, larger ones
+// are spooled to a temporary file until their metadata is known.
+const spoolMemoryThreshold = 1 << 20
+
+type entryKind int
+
+const (
+	kindDirectory entryKind = iota
+	kindFile
+	kindSymlink
+	kindHardlink
+	kindSpecial
+)
+
+type xattr struct {
+	name  string
+	value []byte
+}
+
+type entry struct {
+	kind     entryKind
+	name     string
+	linkname string
+	xattrs   []xattr
+	data     *spool
+	owner    *objects.FileInfo
+	atime    time.Time
+	mtime    time.Time
+	fileinfo *objects.FileInfo
+}
+
+// modTime returns the modification time set by SetTimes, if any.
+func (e *entry) modTime() time.Time {
+	if !e.mtime.IsZero() {
+		return e.mtime
+	}
+	return e.fileinfo.ModTime()
+}
+
+// format writes finalized entries to the underlying archive.
+type format interface {
+	writeEntry(e *entry) error
+	close() error
+
+	// hasHardlinks reports whether the format can represent hardlinks,
+	// otherwise they are stored as copies of their target.
+	hasHardlinks() bool
+
+	// hasSpecialFiles reports whether the format can represent FIFOs
+	// and device nodes.
+	hasSpecialFiles() bool
+}
+
+// ArchiveExporter restores a snapshot into a tar or zip stream.
+//
+// Archive formats require an entry's metadata to be written before
+// its content, while the Exporter interface provides the content
+// first and the permissions last: entries are kept pending until
+// SetPermissions is called, or until Close for those that never see
+// one.
+type ArchiveExporter struct {
+	rootDir string
+	file    *archiveFile
+	format  format
+
+	mu        sync.Mutex
+	pending   map[string]*entry
+	written   map[string]struct{}
+	hardlinks []*entry
+	linked    map[string]*entry
+	wrote     bool
+	err       error
+}
+
+func init() {
+	exporter.Register("tar", NewTarExporter)
+	exporter.Register("tgz", NewTgzExporter)
+	exporter.Register("zip", NewZipExporter)
+}
+
+// archiveFile is the file an archive is written to.  It is only
+// created on the first write, so that an exporter closed without
+// writing anything, as in a dry-run restore, leaves it untouched.
+type archiveFile struct {
+	pathname string
+	fp       *os.File
+}
+
+func (f *archiveFile) Write(p []byte) (int, error) {
+	if f.fp == nil {
+		fp, err := os.Create(f.pathname)
+		if err != nil {
+			return 0, err
+		}
+		f.fp = fp
+	}
+	return f.fp.Write(p)
+}
+
+func (f *archiveFile) Close() error {
+	if f.fp == nil {
+		return nil
+	}
+	err := f.fp.Close()
+	f.fp = nil
+	return err
+}
+
+func openLocation(config map[string]string, scheme string) (*archiveFile, error) {
+	location := strings.TrimPrefix(config["location"], scheme+"://")
+	if location == "" {
+		return nil, fmt.Errorf("missing archive pathname")
+	}
+	return &archiveFile{pathname: location}, nil
+}
+
+func newArchiveExporter(file *archiveFile, f format) *ArchiveExporter {
+	return &ArchiveExporter{
+		rootDir: "/",
+		file:    file,
+		format:  f,
+		pending: make(map[string]*entry),
+		written: make(map[string]struct{}),
+		linked:  make(map[string]*entry),
+	}
+}
+
+func entryName(pathname string) string {
+	return strings.TrimLeft(path.Clean("/"+pathname), "/")
+}
+
+func (p *ArchiveExporter) Root() string {
+	return p.rootDir
+}
+
+// Stat always reports that nothing exists, archives are written from
+// scratch.
+func (p *ArchiveExporter) Stat(pathname string) (*objects.FileInfo, error) {
+	return nil, fmt.Errorf("%s: %w", pathname, fs.ErrNotExist)
+}
+
+func (p *ArchiveExporter) Open(pathname string) (io.ReadCloser, error) {
+	return nil, fmt.Errorf("%s: %w", pathname, fs.ErrNotExist)
+}
+
+func (p *ArchiveExporter) CreateDirectory(pathname string) error {
+	name := entryName(pathname)
+	if name == "" {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.written[name]; ok {
+		return nil
+	}
+	if _, ok := p.pending[name]; ok {
+		return nil
+	}
+	p.pending[name] = &entry{kind: kindDirectory, name: name}
+	return nil
+}
+
+func (p *ArchiveExporter) StoreFile(pathname string, fp io.Reader) error {
+	data, err := newSpool(fp)
+	if err != nil {
+		return err
+	}
+
+	name := entryName(pathname)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if prev, ok := p.pending[name]; ok && prev.data != nil {
+		prev.data.Close()
+	}
+	p.pending[name] = &entry{kind: kindFile, name: name, data: data}
+	return nil
+}
+
+func (p *ArchiveExporter) CreateLink(oldname string, newname string, ltype exporter.LinkType) error {
+	name := entryName(newname)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if ltype == exporter.SYMLINK {
+		p.pending[name] = &entry{kind: kindSymlink, name: name, linkname: oldname}
+	} else {
+		// hardlinks must follow their target in the archive, they
+		// are all written when the exporter is closed.
+		p.hardlinks = append(p.hardlinks, &entry{kind: kindHardlink, name: name, linkname: entryName(oldname)})
+	}
+	return nil
+}
+
+func (p *ArchiveExporter) CreateSpecialFile(pathname string, fileinfo *objects.FileInfo) error {
+	if !p.format.hasSpecialFiles() {
+		return fmt.Errorf("%s: %s files are not supported by this archive format", pathname, fileinfo.Type())
+	}
+
+	name := entryName(pathname)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.pending[name] = &entry{kind: kindSpecial, name: name, fileinfo: fileinfo}
+	return nil
+}
+
+// lookup returns the pending entry for pathname.  Must be called with
+// p.mu held.
+func (p *ArchiveExporter) lookup(pathname string) (*entry, error) {
+	e, ok := p.pending[entryName(pathname)]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", pathname, fs.ErrNotExist)
+	}
+	return e, nil
+}
+
+func (p *ArchiveExporter) SetXattr(pathname string, name string, value []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e, err := p.lookup(pathname)
+	if err != nil {
+		return err
+	}
+	e.xattrs = append(e.xattrs, xattr{name: name, value: value})
+	return nil
+}
+
+func (p *ArchiveExporter) SetOwnership(pathname string, fileinfo *objects.FileInfo) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e, err := p.lookup(pathname)
+	if err != nil {
+		return err
+	}
+	e.owner = fileinfo
+	return nil
+}
+
+func (p *ArchiveExporter) SetTimes(pathname string, atime time.Time, mtime time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e, err := p.lookup(pathname)
+	if err != nil {
+		return err
+	}
+	e.atime = atime
+	e.mtime = mtime
+	return nil
+}
+
+func (p *ArchiveExporter) SetPermissions(pathname string, fileinfo *objects.FileInfo) error {
+	name := entryName(pathname)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e, ok := p.pending[name]
+	if !ok {
+		return nil
+	}
+	e.fileinfo = fileinfo
+	return p.finalize(e)
+}
+
+// finalize writes a pending entry.  Directories are completed after
+// their content, so they follow it in the archive as extractors then
+// apply their permissions and times last.  Must be called with p.mu
+// held.
+func (p *ArchiveExporter) finalize(e *entry) error {
+	delete(p.pending, e.name)
+	if e.kind == kindDirectory {
+		p.written[e.name] = struct{}{}
+	}
+
+	if e.fileinfo == nil {
+		e.fileinfo = defaultFileInfo(e)
+	}
+
+	p.wrote = true
+	err := p.format.writeEntry(e)
+	if e.data != nil && (e.fileinfo.Nlink() <= 1 || p.format.hasHardlinks()) {
+		e.data.Close()
+		e.data = nil
+	}
+	if e.kind == kindFile && e.fileinfo.Nlink() > 1 {
+		p.linked[e.name] = e
+	}
+	if err != nil && p.err == nil {
+		p.err = err
+	}
+	return err
+}
+
+func defaultFileInfo(e *entry) *objects.FileInfo {
+	var mode fs.FileMode
+	var size int64
+	switch e.kind {
+	case kindDirectory:
+		mode = fs.ModeDir | 0755
+	case kindSymlink:
+		mode = fs.ModeSymlink | 0777
+	default:
+		mode = 0644
+	}
+	if e.data != nil {
+		size = e.data.Size()
+	}
+	fileinfo := objects.NewFileInfo(path.Base(e.name), size, mode, time.Now(), 0, 0, 0, 0, 1)
+	return &fileinfo
+}
+
+func (p *ArchiveExporter) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	names := make([]string, 0, len(p.pending))
+	for name := range p.pending {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if e, ok := p.pending[name]; ok {
+			p.finalize(e)
+		}
+	}
+
+	for _, e := range p.hardlinks {
+		target, ok := p.linked[e.linkname]
+		if !ok {
+			continue
+		}
+		if p.format.hasHardlinks() {
+			e.fileinfo = target.fileinfo
+			e.owner = target.owner
+			e.atime = target.atime
+			e.mtime = target.mtime
+		} else {
+			if err := target.data.Rewind(); err != nil {
+				if p.err == nil {
+					p.err = err
+				}
+				continue
+			}
+			e = &entry{
+				kind:     kindFile,
+				name:     e.name,
+				xattrs:   target.xattrs,
+				data:     target.data,
+				owner:    target.owner,
+				atime:    target.atime,
+				mtime:    target.mtime,
+				fileinfo: target.fileinfo,
+			}
+		}
+		p.wrote = true
+		if err := p.format.writeEntry(e); err != nil && p.err == nil {
+			p.err = err
+		}
+	}
+	p.hardlinks = nil
+
+	for _, e := range p.linked {
+		if e.data != nil {
+			e.data.Close()
+		}
+	}
+	p.linked = nil
+
+	// an archive file nothing was restored to is not created
+	if p.file == nil || p.wrote {
+		if err := p.format.close(); err != nil && p.err == nil {
+			p.err = err
+		}
+	}
+	if p.file != nil {
+		if err := p.file.Close(); err != nil && p.err == nil {
+			p.err = err
+		}
+		p.file = nil
+	}
+	return p.err
+}
+
+// spool holds the content of a file until its entry is written.
+type spool struct {
+	buf  *bytes.Reader
+	file *os.File
+	size int64
+}
+
+func newSpool(rd io.Reader) (*spool, error) {
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, rd, spoolMemoryThreshold+1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if n <= spoolMemoryThreshold {
+		return &spool{buf: bytes.NewReader(buf.Bytes()), size: n}, nil
+	}
+
+	file, err := os.CreateTemp("", "plakar-spool-")
+	if err != nil {
+		return nil, err
+	}
+	sp := &spool{file: file}
+
+	if sp.size, err = io.Copy(file, io.MultiReader(&buf, rd)); err != nil {
+		sp.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		sp.Close()
+		return nil, err
+	}
+	return sp, nil
+}
+
+func (sp *spool) Size() int64 {
+	return sp.size
+}
+
+func (sp *spool) Read(p []byte) (int, error) {
+	if sp.file != nil {
+		return sp.file.Read(p)
+	}
+	return sp.buf.Read(p)
+}
+
+// Rewind allows the content to be read again, which is how formats
+// lacking hardlinks store additional copies.
+func (sp *spool) Rewind() error {
+	if sp.file != nil {
+		_, err := sp.file.Seek(0, io.SeekStart)
+		return err
+	}
+	_, err := sp.buf.Seek(0, io.SeekStart)
+	return err
+}
+
+func (sp *spool) Close() error {
+	if sp.file == nil {
+		return nil
+	}
+	err := sp.file.Close()
+	os.Remove(sp.file.Name())
+	sp.file = nil
+	return err
+}
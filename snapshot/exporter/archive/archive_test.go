@@ -0,0 +1,219 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/plakar/objects"
+	"github.com/PlakarKorp/plakar/snapshot/exporter"
+	"github.com/stretchr/testify/require"
+)
+
+func populate(t *testing.T, exp exporter.Exporter) {
+	mtime := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	dirinfo := objects.NewFileInfo("dir", 0, fs.ModeDir|0750, mtime, 0, 0, 1000, 1000, 2)
+	require.NoError(t, exp.CreateDirectory("/dir"))
+	require.NoError(t, exp.SetPermissions("/dir", &dirinfo))
+
+	fileinfo := objects.NewFileInfo("file.txt", 5, 0640, mtime, 1, 42, 1000, 1000, 2)
+	fileinfo.Lusername = "alice"
+	require.NoError(t, exp.StoreFile("/dir/file.txt", strings.NewReader("hello")))
+	require.NoError(t, exp.SetXattr("/dir/file.txt", "user.comment", []byte("greeting")))
+	require.NoError(t, exp.SetOwnership("/dir/file.txt", &fileinfo))
+	require.NoError(t, exp.SetTimes("/dir/file.txt", mtime.Add(time.Hour), mtime))
+	require.NoError(t, exp.SetPermissions("/dir/file.txt", &fileinfo))
+
+	require.NoError(t, exp.CreateLink("/dir/file.txt", "/dir/hardlink.txt", exporter.HARDLINK))
+
+	symlinkinfo := objects.NewFileInfo("symlink", 0, fs.ModeSymlink|0777, mtime, 0, 0, 1000, 1000, 1)
+	require.NoError(t, exp.CreateLink("file.txt", "/dir/symlink", exporter.SYMLINK))
+	require.NoError(t, exp.SetPermissions("/dir/symlink", &symlinkinfo))
+
+	// never receives permissions, must still be archived
+	require.NoError(t, exp.CreateDirectory("/other"))
+	require.NoError(t, exp.StoreFile("/other/data", strings.NewReader("data")))
+
+	require.NoError(t, exp.Close())
+}
+
+func readTar(t *testing.T, rd io.Reader) map[string]*tar.Header {
+	headers := make(map[string]*tar.Header)
+	tr := tar.NewReader(rd)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if hdr.Typeflag == tar.TypeReg {
+			content, err := io.ReadAll(tr)
+			require.NoError(t, err)
+			require.Equal(t, hdr.Size, int64(len(content)))
+		}
+		headers[hdr.Name] = hdr
+	}
+	return headers
+}
+
+func TestTarWriter(t *testing.T) {
+	var buf bytes.Buffer
+	populate(t, NewTarWriter(&buf, false))
+
+	headers := readTar(t, &buf)
+
+	require.Contains(t, headers, "dir/")
+	require.Equal(t, byte(tar.TypeDir), headers["dir/"].Typeflag)
+	require.Equal(t, int64(0750), headers["dir/"].Mode)
+
+	file := headers["dir/file.txt"]
+	require.NotNil(t, file)
+	require.Equal(t, int64(5), file.Size)
+	require.Equal(t, 1000, file.Uid)
+	require.Equal(t, "alice", file.Uname)
+	require.Equal(t, "greeting", file.PAXRecords["SCHILY.xattr.user.comment"])
+	require.True(t, file.ModTime.Equal(time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)))
+	require.True(t, file.AccessTime.Equal(time.Date(2025, 1, 2, 4, 4, 5, 0, time.UTC)))
+
+	require.Equal(t, byte(tar.TypeLink), headers["dir/hardlink.txt"].Typeflag)
+	require.Equal(t, "dir/file.txt", headers["dir/hardlink.txt"].Linkname)
+
+	require.Equal(t, byte(tar.TypeSymlink), headers["dir/symlink"].Typeflag)
+	require.Equal(t, "file.txt", headers["dir/symlink"].Linkname)
+
+	require.Contains(t, headers, "other/")
+	require.Contains(t, headers, "other/data")
+}
+
+func TestTgzExporter(t *testing.T) {
+	tmpDir := t.TempDir()
+	target := filepath.Join(tmpDir, "out.tgz")
+
+	exp, err := NewTgzExporter(map[string]string{"location": "tgz://" + target})
+	require.NoError(t, err)
+	require.Equal(t, "/", exp.Root())
+	populate(t, exp)
+
+	fp, err := os.Open(target)
+	require.NoError(t, err)
+	defer fp.Close()
+
+	gz, err := gzip.NewReader(fp)
+	require.NoError(t, err)
+	headers := readTar(t, gz)
+	require.Contains(t, headers, "dir/file.txt")
+}
+
+func TestZipWriter(t *testing.T) {
+	var buf bytes.Buffer
+	populate(t, NewZipWriter(&buf))
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+
+	files := make(map[string]string)
+	modes := make(map[string]fs.FileMode)
+	for _, f := range zr.File {
+		rd, err := f.Open()
+		require.NoError(t, err)
+		content, err := io.ReadAll(rd)
+		require.NoError(t, err)
+		rd.Close()
+		files[f.Name] = string(content)
+		modes[f.Name] = f.Mode()
+	}
+
+	require.Contains(t, files, "dir/")
+	require.Equal(t, "hello", files["dir/file.txt"])
+	require.Equal(t, "hello", files["dir/hardlink.txt"])
+	require.Equal(t, "file.txt", files["dir/symlink"])
+	require.NotZero(t, modes["dir/symlink"]&fs.ModeSymlink)
+	require.Equal(t, "data", files["other/data"])
+}
+
+func TestSpoolLarge(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), spoolMemoryThreshold+10)
+
+	sp, err := newSpool(bytes.NewReader(data))
+	require.NoError(t, err)
+	defer sp.Close()
+
+	require.NotNil(t, sp.file)
+	require.Equal(t, int64(len(data)), sp.Size())
+
+	content, err := io.ReadAll(sp)
+	require.NoError(t, err)
+	require.Equal(t, data, content)
+
+	require.NoError(t, sp.Rewind())
+	content, err = io.ReadAll(sp)
+	require.NoError(t, err)
+	require.Equal(t, data, content)
+}
+
+func TestTarSpecialFiles(t *testing.T) {
+	var buf bytes.Buffer
+	exp := NewTarWriter(&buf, false)
+
+	fifoinfo := objects.NewFileInfo("fifo", 0, fs.ModeNamedPipe|0600, time.Now(), 0, 0, 0, 0, 1)
+	require.NoError(t, exp.CreateSpecialFile("/fifo", &fifoinfo))
+	require.NoError(t, exp.SetPermissions("/fifo", &fifoinfo))
+
+	devinfo := objects.NewFileInfo("null", 0, fs.ModeDevice|fs.ModeCharDevice|0666, time.Now(), 0, 0, 0, 0, 1)
+	require.NoError(t, exp.CreateSpecialFile("/null", &devinfo))
+	require.NoError(t, exp.SetPermissions("/null", &devinfo))
+	require.NoError(t, exp.Close())
+
+	headers := readTar(t, &buf)
+	require.Equal(t, byte(tar.TypeFifo), headers["fifo"].Typeflag)
+	require.Equal(t, int64(0600), headers["fifo"].Mode)
+	require.Equal(t, byte(tar.TypeChar), headers["null"].Typeflag)
+}
+
+func TestZipSpecialFiles(t *testing.T) {
+	var buf bytes.Buffer
+	exp := NewZipWriter(&buf)
+
+	fifoinfo := objects.NewFileInfo("fifo", 0, fs.ModeNamedPipe|0600, time.Now(), 0, 0, 0, 0, 1)
+	require.Error(t, exp.CreateSpecialFile("/fifo", &fifoinfo))
+	require.NoError(t, exp.Close())
+}
+
+func TestArchiveUntouched(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// an exporter closed without writing anything, as in a dry-run
+	// restore, must leave an existing archive alone
+	for scheme, constructor := range map[string]func(map[string]string) (exporter.Exporter, error){
+		"tar": NewTarExporter,
+		"tgz": NewTgzExporter,
+		"zip": NewZipExporter,
+	} {
+		target := filepath.Join(tmpDir, "existing."+scheme)
+		require.NoError(t, os.WriteFile(target, []byte("existing"), 0644))
+
+		exp, err := constructor(map[string]string{"location": scheme + "://" + target})
+		require.NoError(t, err)
+		require.NoError(t, exp.Close())
+
+		content, err := os.ReadFile(target)
+		require.NoError(t, err)
+		require.Equal(t, "existing", string(content), scheme)
+
+		missing := filepath.Join(tmpDir, "missing."+scheme)
+		exp, err = constructor(map[string]string{"location": scheme + "://" + missing})
+		require.NoError(t, err)
+		require.NoError(t, exp.Close())
+		_, err = os.Stat(missing)
+		require.ErrorIs(t, err, fs.ErrNotExist, scheme)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+// +build !windows
+
+package archive
+
+import "golang.org/x/sys/unix"
+
+func splitRdev(rdev uint64) (int64, int64) {
+	return int64(unix.Major(rdev)), int64(unix.Minor(rdev))
+}
@@ -0,0 +1,5 @@
+package archive
+
+func splitRdev(rdev uint64) (int64, int64) {
+	return 0, 0
+}
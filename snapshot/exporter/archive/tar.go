@@ -0,0 +1,135 @@
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+
+	"github.com/PlakarKorp/plakar/snapshot/exporter"
+)
+
+type tarFormat struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func NewTarExporter(config map[string]string) (exporter.Exporter, error) {
+	file, err := openLocation(config, "tar")
+	if err != nil {
+		return nil, err
+	}
+	return newArchiveExporter(file, newTarFormat(file, false)), nil
+}
+
+func NewTgzExporter(config map[string]string) (exporter.Exporter, error) {
+	file, err := openLocation(config, "tgz")
+	if err != nil {
+		return nil, err
+	}
+	return newArchiveExporter(file, newTarFormat(file, true)), nil
+}
+
+// NewTarWriter returns an exporter producing a tar stream, optionally
+// gzip-compressed, on w.  Closing the exporter does not close w.
+func NewTarWriter(w io.Writer, compress bool) *ArchiveExporter {
+	return newArchiveExporter(nil, newTarFormat(w, compress))
+}
+
+func newTarFormat(w io.Writer, compress bool) *tarFormat {
+	f := &tarFormat{}
+	if compress {
+		f.gz = gzip.NewWriter(w)
+		w = f.gz
+	}
+	f.tw = tar.NewWriter(w)
+	return f
+}
+
+func (f *tarFormat) hasHardlinks() bool {
+	return true
+}
+
+func (f *tarFormat) hasSpecialFiles() bool {
+	return true
+}
+
+func (f *tarFormat) writeEntry(e *entry) error {
+	hdr := &tar.Header{
+		Name:       e.name,
+		Mode:       int64(e.fileinfo.Mode().Perm()),
+		ModTime:    e.modTime(),
+		AccessTime: e.atime,
+	}
+
+	if e.owner != nil {
+		hdr.Uname = e.owner.Username()
+		hdr.Gname = e.owner.Groupname()
+		hdr.Uid = int(e.owner.Uid())
+		hdr.Gid = int(e.owner.Gid())
+	}
+
+	mode := e.fileinfo.Mode()
+	if mode&os.ModeSetuid != 0 {
+		hdr.Mode |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		hdr.Mode |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		hdr.Mode |= 01000
+	}
+
+	switch e.kind {
+	case kindDirectory:
+		hdr.Typeflag = tar.TypeDir
+		hdr.Name += "/"
+	case kindSymlink:
+		hdr.Typeflag = tar.TypeSymlink
+		hdr.Linkname = e.linkname
+	case kindHardlink:
+		hdr.Typeflag = tar.TypeLink
+		hdr.Linkname = e.linkname
+	case kindSpecial:
+		switch {
+		case mode&os.ModeNamedPipe != 0:
+			hdr.Typeflag = tar.TypeFifo
+		case mode&os.ModeCharDevice != 0:
+			hdr.Typeflag = tar.TypeChar
+		default:
+			hdr.Typeflag = tar.TypeBlock
+		}
+		hdr.Devmajor, hdr.Devminor = splitRdev(e.fileinfo.Rdev())
+	default:
+		hdr.Typeflag = tar.TypeReg
+		hdr.Size = e.data.Size()
+	}
+
+	if len(e.xattrs) != 0 {
+		hdr.Format = tar.FormatPAX
+		hdr.PAXRecords = make(map[string]string, len(e.xattrs))
+		for _, x := range e.xattrs {
+			hdr.PAXRecords["SCHILY.xattr."+x.name] = string(x.value)
+		}
+	}
+
+	if err := f.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if e.kind == kindFile {
+		if _, err := io.Copy(f.tw, e.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *tarFormat) close() error {
+	err := f.tw.Close()
+	if f.gz != nil {
+		if gzerr := f.gz.Close(); err == nil {
+			err = gzerr
+		}
+	}
+	return err
+}
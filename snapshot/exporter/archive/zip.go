@@ -0,0 +1,77 @@
+package archive
+
+import (
+	"archive/zip"
+	"io"
+	"strings"
+
+	"github.com/PlakarKorp/plakar/snapshot/exporter"
+)
+
+// zipFormat stores symlinks as their target, the way Info-ZIP does,
+// but has no room for ownership or extended attributes.
+type zipFormat struct {
+	zw *zip.Writer
+}
+
+func NewZipExporter(config map[string]string) (exporter.Exporter, error) {
+	file, err := openLocation(config, "zip")
+	if err != nil {
+		return nil, err
+	}
+	return newArchiveExporter(file, newZipFormat(file)), nil
+}
+
+// NewZipWriter returns an exporter producing a zip stream on w.
+// Closing the exporter does not close w.
+func NewZipWriter(w io.Writer) *ArchiveExporter {
+	return newArchiveExporter(nil, newZipFormat(w))
+}
+
+func newZipFormat(w io.Writer) *zipFormat {
+	return &zipFormat{zw: zip.NewWriter(w)}
+}
+
+func (f *zipFormat) hasHardlinks() bool {
+	return false
+}
+
+func (f *zipFormat) hasSpecialFiles() bool {
+	return false
+}
+
+func (f *zipFormat) writeEntry(e *entry) error {
+	hdr, err := zip.FileInfoHeader(e.fileinfo)
+	if err != nil {
+		return err
+	}
+	hdr.Name = e.name
+	hdr.Modified = e.modTime()
+
+	switch e.kind {
+	case kindDirectory:
+		hdr.Name += "/"
+		hdr.Method = zip.Store
+	case kindSymlink:
+		hdr.Method = zip.Store
+	default:
+		hdr.Method = zip.Deflate
+	}
+
+	w, err := f.zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+
+	switch e.kind {
+	case kindSymlink:
+		_, err = io.Copy(w, strings.NewReader(e.linkname))
+	case kindFile:
+		_, err = io.Copy(w, e.data)
+	}
+	return err
+}
+
+func (f *zipFormat) close() error {
+	return f.zw.Close()
+}
@@ -7,18 +7,48 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/PlakarKorp/plakar/objects"
 )
 
+type LinkType int
+
+const (
+	HARDLINK LinkType = iota
+	SYMLINK
+)
+
+// Exporter is the destination of a restore.  Once an entry is created,
+// its metadata is applied in order by SetXattr, SetOwnership, SetTimes
+// and lastly SetPermissions, which completes the entry.
+//
+// Stat and Open inspect what already exists at the destination, they
+// return an error wrapping fs.ErrNotExist when there is nothing.
 type Exporter interface {
 	Root() string
+	Stat(pathname string) (*objects.FileInfo, error)
+	Open(pathname string) (io.ReadCloser, error)
 	CreateDirectory(pathname string) error
 	StoreFile(pathname string, fp io.Reader) error
+	CreateLink(oldname string, newname string, ltype LinkType) error
+	CreateSpecialFile(pathname string, fileinfo *objects.FileInfo) error
+	SetXattr(pathname string, name string, value []byte) error
+	SetOwnership(pathname string, fileinfo *objects.FileInfo) error
+	SetTimes(pathname string, atime time.Time, mtime time.Time) error
 	SetPermissions(pathname string, fileinfo *objects.FileInfo) error
 	Close() error
 }
 
+// SparseReader is implemented by the readers given to StoreFile for
+// files which may have holes, that exporters can recreate sparsely by
+// only writing the data extents.
+type SparseReader interface {
+	io.ReadSeeker
+	Size() int64
+	DataExtents() []objects.Extent
+}
+
 var muBackends sync.Mutex
 var backends map[string]func(config map[string]string) (Exporter, error) = make(map[string]func(config map[string]string) (Exporter, error))
 
@@ -64,6 +94,12 @@ func NewExporter(config map[string]string) (Exporter, error) {
 			backendName = "fs"
 		} else if strings.HasPrefix(location, "sftp://") {
 			backendName = "sftp"
+		} else if strings.HasPrefix(location, "tar://") {
+			backendName = "tar"
+		} else if strings.HasPrefix(location, "tgz://") {
+			backendName = "tgz"
+		} else if strings.HasPrefix(location, "zip://") {
+			backendName = "zip"
 		} else {
 			if strings.Contains(location, "://") {
 				return nil, fmt.Errorf("unsupported importer protocol")
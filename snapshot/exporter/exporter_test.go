@@ -2,7 +2,9 @@ package exporter
 
 import (
 	"io"
+	"io/fs"
 	"testing"
+	"time"
 
 	"github.com/PlakarKorp/plakar/objects"
 	"github.com/stretchr/testify/require"
@@ -14,6 +16,14 @@ func (m MockedExporter) Root() string {
 	return ""
 }
 
+func (m MockedExporter) Stat(pathname string) (*objects.FileInfo, error) {
+	return nil, fs.ErrNotExist
+}
+
+func (m MockedExporter) Open(pathname string) (io.ReadCloser, error) {
+	return nil, fs.ErrNotExist
+}
+
 func (m MockedExporter) CreateDirectory(pathname string) error {
 	return nil
 }
@@ -22,6 +32,26 @@ func (m MockedExporter) StoreFile(pathname string, fp io.Reader) error {
 	return nil
 }
 
+func (m MockedExporter) CreateLink(oldname string, newname string, ltype LinkType) error {
+	return nil
+}
+
+func (m MockedExporter) CreateSpecialFile(pathname string, fileinfo *objects.FileInfo) error {
+	return nil
+}
+
+func (m MockedExporter) SetXattr(pathname string, name string, value []byte) error {
+	return nil
+}
+
+func (m MockedExporter) SetOwnership(pathname string, fileinfo *objects.FileInfo) error {
+	return nil
+}
+
+func (m MockedExporter) SetTimes(pathname string, atime time.Time, mtime time.Time) error {
+	return nil
+}
+
 func (m MockedExporter) SetPermissions(pathname string, fileinfo *objects.FileInfo) error {
 	return nil
 }
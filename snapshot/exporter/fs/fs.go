@@ -20,9 +20,11 @@ import (
 	"io"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/PlakarKorp/plakar/objects"
 	"github.com/PlakarKorp/plakar/snapshot/exporter"
+	"github.com/pkg/xattr"
 )
 
 type FSExporter struct {
@@ -57,17 +59,50 @@ func (p *FSExporter) Root() string {
 	return p.rootDir
 }
 
+func (p *FSExporter) Stat(pathname string) (*objects.FileInfo, error) {
+	fi, err := os.Lstat(pathname)
+	if err != nil {
+		return nil, err
+	}
+	fileinfo := objects.FileInfoFromStat(fi)
+	return &fileinfo, nil
+}
+
+func (p *FSExporter) Open(pathname string) (io.ReadCloser, error) {
+	return os.Open(pathname)
+}
+
+// removeExisting makes room for a new entry at pathname, so that an
+// overwrite neither follows a symlink nor alters the other links of
+// an existing file.  Directories are left alone.
+func removeExisting(pathname string) error {
+	fi, err := os.Lstat(pathname)
+	if err != nil || fi.IsDir() {
+		return nil
+	}
+	return os.Remove(pathname)
+}
+
 func (p *FSExporter) CreateDirectory(pathname string) error {
 	return os.MkdirAll(pathname, 0700)
 }
 
 func (p *FSExporter) StoreFile(pathname string, fp io.Reader) error {
+	if err := removeExisting(pathname); err != nil {
+		return err
+	}
+
 	f, err := os.Create(pathname)
 	if err != nil {
 		return err
 	}
 
-	if _, err := io.Copy(f, fp); err != nil {
+	if sparse, ok := fp.(exporter.SparseReader); ok && isSparse(sparse) {
+		err = storeSparse(f, sparse)
+	} else {
+		_, err = io.Copy(f, fp)
+	}
+	if err != nil {
 		//logging.Warn("copy failure: %s: %s", pathname, err)
 		f.Close()
 		return err
@@ -81,16 +116,68 @@ func (p *FSExporter) StoreFile(pathname string, fp io.Reader) error {
 	return nil
 }
 
-func (p *FSExporter) SetPermissions(pathname string, fileinfo *objects.FileInfo) error {
-	if err := os.Chmod(pathname, fileinfo.Mode()); err != nil {
-		return err
+func isSparse(rd exporter.SparseReader) bool {
+	var length int64
+	for _, extent := range rd.DataExtents() {
+		length += extent.Length
 	}
-	if os.Getuid() == 0 {
-		if err := os.Chown(pathname, int(fileinfo.Uid()), int(fileinfo.Gid())); err != nil {
+	return length < rd.Size()
+}
+
+// storeSparse only writes the data extents of rd, leaving holes in f.
+func storeSparse(f *os.File, rd exporter.SparseReader) error {
+	for _, extent := range rd.DataExtents() {
+		if _, err := rd.Seek(extent.Offset, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := f.Seek(extent.Offset, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(f, rd, extent.Length); err != nil {
 			return err
 		}
 	}
-	return nil
+	return f.Truncate(rd.Size())
+}
+
+func (p *FSExporter) CreateLink(oldname string, newname string, ltype exporter.LinkType) error {
+	if err := removeExisting(newname); err != nil {
+		return err
+	}
+	if ltype == exporter.SYMLINK {
+		return os.Symlink(oldname, newname)
+	}
+	return os.Link(oldname, newname)
+}
+
+func (p *FSExporter) CreateSpecialFile(pathname string, fileinfo *objects.FileInfo) error {
+	if err := removeExisting(pathname); err != nil {
+		return err
+	}
+	return createSpecialFile(pathname, fileinfo)
+}
+
+func (p *FSExporter) SetXattr(pathname string, name string, value []byte) error {
+	return xattr.LSet(pathname, name, value)
+}
+
+func (p *FSExporter) SetOwnership(pathname string, fileinfo *objects.FileInfo) error {
+	if os.Getuid() != 0 {
+		return nil
+	}
+	return os.Lchown(pathname, int(fileinfo.Uid()), int(fileinfo.Gid()))
+}
+
+func (p *FSExporter) SetTimes(pathname string, atime time.Time, mtime time.Time) error {
+	return setTimes(pathname, atime, mtime)
+}
+
+func (p *FSExporter) SetPermissions(pathname string, fileinfo *objects.FileInfo) error {
+	// chmod would follow the link and alter its target
+	if fileinfo.Mode()&os.ModeSymlink != 0 {
+		return nil
+	}
+	return os.Chmod(pathname, fileinfo.Mode())
 }
 
 func (p *FSExporter) Close() error {
@@ -0,0 +1,41 @@
+//go:build !windows
+// +build !windows
+
+package fs
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/PlakarKorp/plakar/objects"
+	"golang.org/x/sys/unix"
+)
+
+func createSpecialFile(pathname string, fileinfo *objects.FileInfo) error {
+	mode := uint32(fileinfo.Mode().Perm())
+
+	switch {
+	case fileinfo.Mode()&os.ModeNamedPipe != 0:
+		return unix.Mkfifo(pathname, mode)
+	case fileinfo.Mode()&os.ModeDevice != 0:
+		if os.Getuid() != 0 {
+			return fmt.Errorf("%s: device nodes can only be restored by root", pathname)
+		}
+		if fileinfo.Mode()&os.ModeCharDevice != 0 {
+			mode |= unix.S_IFCHR
+		} else {
+			mode |= unix.S_IFBLK
+		}
+		return mknod(pathname, mode, fileinfo.Rdev())
+	default:
+		return fmt.Errorf("%s: unsupported file type %s", pathname, fileinfo.Type())
+	}
+}
+
+func setTimes(pathname string, atime time.Time, mtime time.Time) error {
+	return unix.Lutimes(pathname, []unix.Timeval{
+		unix.NsecToTimeval(atime.UnixNano()),
+		unix.NsecToTimeval(mtime.UnixNano()),
+	})
+}
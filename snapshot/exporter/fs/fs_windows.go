@@ -0,0 +1,22 @@
+package fs
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/PlakarKorp/plakar/objects"
+)
+
+func createSpecialFile(pathname string, fileinfo *objects.FileInfo) error {
+	return fmt.Errorf("%s: unsupported file type %s", pathname, fileinfo.Type())
+}
+
+func setTimes(pathname string, atime time.Time, mtime time.Time) error {
+	if fi, err := os.Lstat(pathname); err != nil {
+		return err
+	} else if fi.Mode()&os.ModeSymlink != 0 {
+		return nil
+	}
+	return os.Chtimes(pathname, atime, mtime)
+}
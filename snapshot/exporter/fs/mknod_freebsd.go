@@ -0,0 +1,7 @@
+package fs
+
+import "golang.org/x/sys/unix"
+
+func mknod(pathname string, mode uint32, dev uint64) error {
+	return unix.Mknod(pathname, mode, dev)
+}
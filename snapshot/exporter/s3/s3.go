@@ -20,9 +20,12 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/url"
+	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/PlakarKorp/plakar/objects"
 	"github.com/PlakarKorp/plakar/snapshot/exporter"
@@ -101,6 +104,32 @@ func (p *S3Exporter) Root() string {
 	return p.rootDir
 }
 
+func (p *S3Exporter) objectName(pathname string) string {
+	return strings.TrimPrefix(pathname, p.rootDir+"/")
+}
+
+func (p *S3Exporter) Stat(pathname string) (*objects.FileInfo, error) {
+	info, err := p.minioClient.StatObject(context.Background(),
+		strings.TrimPrefix(p.rootDir, "/"),
+		p.objectName(pathname),
+		minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, fmt.Errorf("%s: %w", pathname, fs.ErrNotExist)
+		}
+		return nil, err
+	}
+	fileinfo := objects.NewFileInfo(path.Base(pathname), info.Size, 0644, info.LastModified, 0, 0, 0, 0, 1)
+	return &fileinfo, nil
+}
+
+func (p *S3Exporter) Open(pathname string) (io.ReadCloser, error) {
+	return p.minioClient.GetObject(context.Background(),
+		strings.TrimPrefix(p.rootDir, "/"),
+		p.objectName(pathname),
+		minio.GetObjectOptions{})
+}
+
 func (p *S3Exporter) CreateDirectory(pathname string) error {
 	return nil
 }
@@ -108,11 +137,31 @@ func (p *S3Exporter) CreateDirectory(pathname string) error {
 func (p *S3Exporter) StoreFile(pathname string, fp io.Reader) error {
 	_, err := p.minioClient.PutObject(context.Background(),
 		strings.TrimPrefix(p.rootDir, "/"),
-		strings.TrimPrefix(pathname, p.rootDir+"/"),
+		p.objectName(pathname),
 		fp, -1, minio.PutObjectOptions{})
 	return err
 }
 
+func (p *S3Exporter) CreateLink(oldname string, newname string, ltype exporter.LinkType) error {
+	return nil
+}
+
+func (p *S3Exporter) CreateSpecialFile(pathname string, fileinfo *objects.FileInfo) error {
+	return nil
+}
+
+func (p *S3Exporter) SetXattr(pathname string, name string, value []byte) error {
+	return nil
+}
+
+func (p *S3Exporter) SetOwnership(pathname string, fileinfo *objects.FileInfo) error {
+	return nil
+}
+
+func (p *S3Exporter) SetTimes(pathname string, atime time.Time, mtime time.Time) error {
+	return nil
+}
+
 func (p *S3Exporter) SetPermissions(pathname string, fileinfo *objects.FileInfo) error {
 	return nil
 }
@@ -24,6 +24,7 @@ import (
 	"os"
 	"os/user"
 	"path"
+	"time"
 
 	"github.com/PlakarKorp/plakar/objects"
 	"github.com/PlakarKorp/plakar/snapshot/exporter"
@@ -162,11 +163,38 @@ func (p *SFTPExporter) Root() string {
 	return p.location
 }
 
+func (p *SFTPExporter) Stat(pathname string) (*objects.FileInfo, error) {
+	fi, err := p.client.Lstat(pathname)
+	if err != nil {
+		return nil, err
+	}
+	fileinfo := objects.FileInfoFromStat(fi)
+	return &fileinfo, nil
+}
+
+func (p *SFTPExporter) Open(pathname string) (io.ReadCloser, error) {
+	return p.client.Open(pathname)
+}
+
+// removeExisting makes room for a new entry at pathname when
+// overwriting, directories are left alone.
+func (p *SFTPExporter) removeExisting(pathname string) error {
+	fi, err := p.client.Lstat(pathname)
+	if err != nil || fi.IsDir() {
+		return nil
+	}
+	return p.client.Remove(pathname)
+}
+
 func (p *SFTPExporter) CreateDirectory(pathname string) error {
 	return p.client.MkdirAll(pathname)
 }
 
 func (p *SFTPExporter) StoreFile(pathname string, fp io.Reader) error {
+	if err := p.removeExisting(pathname); err != nil {
+		return err
+	}
+
 	f, err := p.client.Create(pathname)
 	if err != nil {
 		return err
@@ -186,18 +214,48 @@ func (p *SFTPExporter) StoreFile(pathname string, fp io.Reader) error {
 	return nil
 }
 
-func (p *SFTPExporter) SetPermissions(pathname string, fileinfo *objects.FileInfo) error {
-	if err := p.client.Chmod(pathname, fileinfo.Mode()); err != nil {
+func (p *SFTPExporter) CreateLink(oldname string, newname string, ltype exporter.LinkType) error {
+	if err := p.removeExisting(newname); err != nil {
 		return err
 	}
-	if os.Getuid() == 0 {
-		if err := p.client.Chown(pathname, int(fileinfo.Uid()), int(fileinfo.Gid())); err != nil {
-			return err
-		}
+	if ltype == exporter.SYMLINK {
+		return p.client.Symlink(oldname, newname)
 	}
+	return p.client.Link(oldname, newname)
+}
+
+func (p *SFTPExporter) CreateSpecialFile(pathname string, fileinfo *objects.FileInfo) error {
+	return fmt.Errorf("%s: special files are not supported over sftp", pathname)
+}
+
+func (p *SFTPExporter) SetXattr(pathname string, name string, value []byte) error {
 	return nil
 }
 
+func (p *SFTPExporter) SetOwnership(pathname string, fileinfo *objects.FileInfo) error {
+	if fileinfo.Mode()&os.ModeSymlink != 0 || os.Getuid() != 0 {
+		return nil
+	}
+	return p.client.Chown(pathname, int(fileinfo.Uid()), int(fileinfo.Gid()))
+}
+
+func (p *SFTPExporter) SetTimes(pathname string, atime time.Time, mtime time.Time) error {
+	// the protocol has no way to not follow symlinks
+	if fi, err := p.client.Lstat(pathname); err != nil {
+		return err
+	} else if fi.Mode()&os.ModeSymlink != 0 {
+		return nil
+	}
+	return p.client.Chtimes(pathname, atime, mtime)
+}
+
+func (p *SFTPExporter) SetPermissions(pathname string, fileinfo *objects.FileInfo) error {
+	if fileinfo.Mode()&os.ModeSymlink != 0 {
+		return nil
+	}
+	return p.client.Chmod(pathname, fileinfo.Mode())
+}
+
 func (p *SFTPExporter) Close() error {
 	return p.client.Close()
 }
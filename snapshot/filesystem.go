@@ -5,7 +5,7 @@ import (
 )
 
 func (s *Snapshot) Filesystem() (*vfs.Filesystem, error) {
-	v := s.Header.GetSource(0).VFS
+	v := s.Source().VFS
 
 	if s.filesystem != nil {
 		return s.filesystem, nil
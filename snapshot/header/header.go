@@ -35,6 +35,10 @@ type Identity struct {
 type Class struct {
 	Name        string
 	Probability float64
+
+	// Count is the number of files of the snapshot in the class,
+	// Probability their share of its files.
+	Count uint64
 }
 
 type Classification struct {
@@ -77,11 +81,19 @@ func NewSource() Source {
 	}
 }
 
+// Status of a snapshot, telling whether all the data was backed up.
+const (
+	StatusComplete = "complete" // no error was met
+	StatusPartial  = "partial"  // some paths could not be backed up
+	StatusFailed   = "failed"   // too many paths could not be backed up
+)
+
 type Header struct {
 	Version         versioning.Version `msgpack:"version" json:"version"`
 	Identifier      objects.MAC        `msgpack:"identifier" json:"identifier"`
 	Timestamp       time.Time          `msgpack:"timestamp" json:"timestamp"`
 	Duration        time.Duration      `msgpack:"duration" json:"duration"`
+	Status          string             `msgpack:"status,omitempty" json:"status"`
 	Identity        Identity           `msgpack:"identity" json:"identity"`
 	Name            string             `msgpack:"name" json:"name"`
 	Category        string             `msgpack:"category" json:"category"`
@@ -152,6 +164,33 @@ func (h *Header) GetSource(idx int) *Source {
 	return &h.Sources[idx]
 }
 
+// GetStatus returns the status of the snapshot, those created before
+// it was recorded being complete.
+func (h *Header) GetStatus() string {
+	if h.Status == "" {
+		return StatusComplete
+	}
+	return h.Status
+}
+
+// GetErrors returns the number of errors met across all sources.
+func (h *Header) GetErrors() uint64 {
+	var errors uint64
+	for i := range h.Sources {
+		errors += h.Sources[i].Summary.Directory.Errors + h.Sources[i].Summary.Below.Errors
+	}
+	return errors
+}
+
+// GetSize returns the size of the data backed up across all sources.
+func (h *Header) GetSize() uint64 {
+	var size uint64
+	for i := range h.Sources {
+		size += h.Sources[i].Summary.Directory.Size + h.Sources[i].Summary.Below.Size
+	}
+	return size
+}
+
 func (h *Header) GetIndexID() [32]byte {
 	return h.Identifier
 }
@@ -160,6 +199,19 @@ func (h *Header) GetIndexShortID() []byte {
 	return h.Identifier[:4]
 }
 
+// HasClass reports whether files of the snapshot were classified as
+// class, see vfs.MatchClass.
+func (h *Header) HasClass(class string) bool {
+	for _, classification := range h.Classifications {
+		for _, c := range classification.Classes {
+			if vfs.MatchClass(class, c.Name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (h *Header) HasTag(tag string) bool {
 	for _, t := range h.Tags {
 		if t == tag {
@@ -225,3 +225,13 @@ func TestHeaderMethods(t *testing.T) {
 
 	require.Equal(t, NewSource(), *header.GetSource(0))
 }
+
+func TestHeaderGetSize(t *testing.T) {
+	header := NewHeader("test", [32]byte{})
+	header.GetSource(0).Summary.Directory.Size = 10
+	header.GetSource(0).Summary.Below.Size = 20
+	header.Sources = append(header.Sources, NewSource())
+	header.GetSource(1).Summary.Below.Size = 12
+
+	require.Equal(t, uint64(42), header.GetSize())
+}
@@ -0,0 +1,200 @@
+package fs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/PlakarKorp/plakar/snapshot/importer"
+)
+
+var errFilesystemLoop = errors.New("filesystem loop detected")
+
+const specialFileModes = fs.ModeDevice | fs.ModeCharDevice | fs.ModeNamedPipe | fs.ModeSocket | fs.ModeIrregular
+
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// walkDir is a directory to descend into.  It is linked to its parent
+// so that the state inherited from the ancestors is shared, without
+// locking, by the goroutines walking the tree in parallel.
+type walkDir struct {
+	path   string
+	parent *walkDir
+
+	id     fileID
+	haveID bool
+
+	// rules in effect in this directory
+	ignore *importer.Ignore
+}
+
+// walkFilter decides which paths the walker leaves out, so that
+// excluded directories are never traversed.  It is safe for concurrent
+// use once the root has been checked.
+type walkFilter struct {
+	ignoreFile    string
+	excludeCaches bool
+	excluded      func(pathname string) bool
+
+	skipSpecial    bool
+	maxFileSize    int64
+	oneFileSystem  bool
+	excludeFSTypes []string
+	followSymlinks bool
+
+	// set when checking the root
+	rootDev     uint64
+	haveRootDev bool
+
+	mu      sync.Mutex
+	fsTypes map[uint64]string
+}
+
+func (f *walkFilter) needsDevice() bool {
+	return f.oneFileSystem || len(f.excludeFSTypes) != 0 || f.followSymlinks
+}
+
+// check tells whether pathname, found in the parent directory, is to
+// be recorded and, for directories, returns the walkDir to descend
+// into, if any.  The root is checked with a nil parent.  An error is
+// reported along the decision, which still holds.
+func (f *walkFilter) check(parent *walkDir, pathname string, d fs.DirEntry) (bool, *walkDir, error) {
+	if f == nil {
+		if d.IsDir() {
+			return true, &walkDir{path: pathname, parent: parent}, nil
+		}
+		return true, nil, nil
+	}
+
+	unixPath := toUnixPath(pathname)
+	if f.excluded != nil && f.excluded(unixPath) {
+		return false, nil, nil
+	}
+
+	var ign *importer.Ignore
+	if parent != nil {
+		ign = parent.ignore
+	}
+	if ign.Match(unixPath, d.IsDir()) {
+		return false, nil, nil
+	}
+
+	var info fs.FileInfo
+	var err error
+
+	mode := d.Type()
+	if mode&fs.ModeSymlink != 0 && f.followSymlinks {
+		// dangling symlinks are recorded as such
+		if info, err = os.Stat(pathname); err == nil {
+			mode = info.Mode().Type()
+		}
+	}
+
+	if f.skipSpecial && mode&specialFileModes != 0 {
+		return false, nil, nil
+	}
+
+	if f.maxFileSize > 0 && mode.IsRegular() {
+		if info == nil {
+			if info, err = d.Info(); err != nil {
+				return true, nil, err
+			}
+		}
+		if info.Size() > f.maxFileSize {
+			return false, nil, nil
+		}
+	}
+
+	if !mode.IsDir() {
+		return true, nil, nil
+	}
+
+	if f.excludeCaches && isCacheDir(pathname) {
+		return false, nil, nil
+	}
+
+	dir := &walkDir{path: pathname, parent: parent, ignore: ign}
+
+	if f.needsDevice() {
+		if info == nil {
+			if info, err = d.Info(); err != nil {
+				return true, nil, err
+			}
+		}
+		dir.id, dir.haveID = getFileID(info)
+		keep, descend, err := f.checkDevice(dir)
+		if !descend || err != nil {
+			return keep, nil, err
+		}
+	}
+
+	if f.ignoreFile != "" {
+		fp, err := os.Open(filepath.Join(pathname, f.ignoreFile))
+		if err == nil {
+			dir.ignore, err = ign.With(fp, unixPath)
+			fp.Close()
+			if err != nil {
+				return true, nil, err
+			}
+		} else if !os.IsNotExist(err) {
+			return true, nil, err
+		}
+	}
+	return true, dir, nil
+}
+
+// checkDevice tells whether dir is to be recorded and descended into,
+// given the filesystem it lies on and whether it is one of its own
+// ancestors reached through a symlink.
+func (f *walkFilter) checkDevice(dir *walkDir) (bool, bool, error) {
+	if !dir.haveID {
+		return true, true, nil
+	}
+
+	if f.followSymlinks {
+		for ancestor := dir.parent; ancestor != nil; ancestor = ancestor.parent {
+			if ancestor.haveID && ancestor.id == dir.id {
+				return false, false, errFilesystemLoop
+			}
+		}
+	}
+
+	if dir.parent == nil {
+		f.rootDev = dir.id.dev
+		f.haveRootDev = true
+		return true, true, nil
+	}
+	if !f.haveRootDev || dir.id.dev == f.rootDev {
+		return true, true, nil
+	}
+
+	// mount points are recorded, not their content
+	if f.oneFileSystem {
+		return true, false, nil
+	}
+
+	if len(f.excludeFSTypes) != 0 {
+		f.mu.Lock()
+		fstype, ok := f.fsTypes[dir.id.dev]
+		f.mu.Unlock()
+		if !ok {
+			var err error
+			if fstype, err = getFSType(dir.path); err != nil {
+				return true, true, err
+			}
+			f.mu.Lock()
+			f.fsTypes[dir.id.dev] = fstype
+			f.mu.Unlock()
+		}
+		if slices.Contains(f.excludeFSTypes, fstype) {
+			return true, false, nil
+		}
+	}
+	return true, true, nil
+}
@@ -21,17 +21,33 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"os"
 	"path"
 	"runtime"
+	"strconv"
 	"strings"
 
 	"github.com/PlakarKorp/plakar/snapshot/importer"
+	"github.com/dustin/go-humanize"
 	"github.com/pkg/xattr"
 )
 
 type FSImporter struct {
 	rootDir string
+
+	ignoreFile    string
+	excludeCaches bool
+	excluded      func(pathname string) bool
+
+	oneFileSystem  bool
+	excludeFSTypes []string
+	maxFileSize    int64
+	skipSpecial    bool
+	followSymlinks bool
+
+	walkers int
+	ordered bool
 }
 
 func init() {
@@ -51,11 +67,89 @@ func NewFSImporter(config map[string]string) (importer.Importer, error) {
 
 	location = path.Clean(location)
 
+	ignoreFile := importer.IgnoreFile
+	if value, ok := config["ignore_file"]; ok {
+		ignoreFile = value
+	}
+
+	excludeCaches, err := parseBoolOption(config, "exclude_caches")
+	if err != nil {
+		return nil, err
+	}
+	oneFileSystem, err := parseBoolOption(config, "one_file_system")
+	if err != nil {
+		return nil, err
+	}
+	skipSpecial, err := parseBoolOption(config, "skip_special")
+	if err != nil {
+		return nil, err
+	}
+	followSymlinks, err := parseBoolOption(config, "follow_symlinks")
+	if err != nil {
+		return nil, err
+	}
+
+	var excludeFSTypes []string
+	if value, ok := config["exclude_fstypes"]; ok {
+		for _, fstype := range strings.Split(value, ",") {
+			if fstype = strings.TrimSpace(fstype); fstype != "" {
+				excludeFSTypes = append(excludeFSTypes, fstype)
+			}
+		}
+	}
+
+	var maxFileSize int64
+	if value, ok := config["max_file_size"]; ok {
+		tmp, err := humanize.ParseBytes(value)
+		if err != nil || tmp > math.MaxInt64 {
+			return nil, fmt.Errorf("invalid max_file_size value")
+		}
+		maxFileSize = int64(tmp)
+	}
+
+	walkers := max(4, runtime.NumCPU())
+	if value, ok := config["walkers"]; ok {
+		tmp, err := strconv.Atoi(value)
+		if err != nil || tmp < 1 {
+			return nil, fmt.Errorf("invalid walkers value")
+		}
+		walkers = tmp
+	}
+	ordered, err := parseBoolOption(config, "ordered")
+	if err != nil {
+		return nil, err
+	}
+
+	if runtime.GOOS == "windows" && (oneFileSystem || len(excludeFSTypes) != 0 || followSymlinks) {
+		return nil, fmt.Errorf("one_file_system, exclude_fstypes and follow_symlinks are not supported on windows")
+	}
+
 	return &FSImporter{
-		rootDir: location,
+		rootDir:        location,
+		ignoreFile:     ignoreFile,
+		excludeCaches:  excludeCaches,
+		oneFileSystem:  oneFileSystem,
+		excludeFSTypes: excludeFSTypes,
+		maxFileSize:    maxFileSize,
+		skipSpecial:    skipSpecial,
+		followSymlinks: followSymlinks,
+		walkers:        walkers,
+		ordered:        ordered,
 	}, nil
 }
 
+func parseBoolOption(config map[string]string, key string) (bool, error) {
+	value, ok := config[key]
+	if !ok {
+		return false, nil
+	}
+	ret, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s value", key)
+	}
+	return ret, nil
+}
+
 func (p *FSImporter) Origin() string {
 	hostname, err := os.Hostname()
 	if err != nil {
@@ -68,15 +162,38 @@ func (p *FSImporter) Type() string {
 	return "fs"
 }
 
+func (p *FSImporter) SetExcludes(excluded func(pathname string) bool) {
+	p.excluded = excluded
+}
+
 func (p *FSImporter) Scan() (<-chan *importer.ScanResult, error) {
-	return walkDir_walker(p.rootDir, 256)
+	filter := &walkFilter{
+		ignoreFile:    p.ignoreFile,
+		excludeCaches: p.excludeCaches,
+		excluded:      p.excluded,
+
+		skipSpecial:    p.skipSpecial,
+		maxFileSize:    p.maxFileSize,
+		oneFileSystem:  p.oneFileSystem,
+		excludeFSTypes: p.excludeFSTypes,
+		followSymlinks: p.followSymlinks,
+
+		fsTypes: make(map[uint64]string),
+	}
+	options := walkOptions{
+		walkers:  p.walkers,
+		statters: 256,
+		maxQueue: 4096,
+		ordered:  p.ordered,
+	}
+	return walkDir_walker(p.rootDir, options, filter)
 }
 
 func (p *FSImporter) NewReader(pathname string) (io.ReadCloser, error) {
 	if pathname[0] == '/' && runtime.GOOS == "windows" {
 		pathname = pathname[1:]
 	}
-	return os.Open(pathname)
+	return openFile(pathname)
 }
 
 func (p *FSImporter) NewExtendedAttributeReader(pathname string, attribute string) (io.ReadCloser, error) {
@@ -84,7 +201,15 @@ func (p *FSImporter) NewExtendedAttributeReader(pathname string, attribute strin
 		pathname = pathname[1:]
 	}
 
-	data, err := xattr.Get(pathname, attribute)
+	var data []byte
+	var err error
+	if p.followSymlinks {
+		// dangling symlinks are recorded as such
+		data, err = xattr.Get(pathname, attribute)
+	}
+	if !p.followSymlinks || err != nil {
+		data, err = xattr.LGet(pathname, attribute)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -1,6 +1,7 @@
 package fs
 
 import (
+	"net"
 	"os"
 	"sort"
 	"testing"
@@ -68,3 +69,143 @@ func TestFSImporter(t *testing.T) {
 	err = importer.Close()
 	require.NoError(t, err)
 }
+
+func TestFSImporterExclusions(t *testing.T) {
+	tmpImportDir, err := os.MkdirTemp("/tmp", "tmp_import*")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		os.RemoveAll(tmpImportDir)
+	})
+
+	for _, dir := range []string{"/cache", "/excluded", "/sub/build"} {
+		require.NoError(t, os.MkdirAll(tmpImportDir+dir, 0755))
+	}
+	files := map[string]string{
+		"/.plakarignore":      "*.log\nbuild/\n",
+		"/a.log":              "",
+		"/a.txt":              "",
+		"/sub/.plakarignore":  "!keep.log\n",
+		"/sub/keep.log":       "",
+		"/sub/b.log":          "",
+		"/sub/build/c.txt":    "",
+		"/cache/CACHEDIR.TAG": "Signature: 8a477f597d28d172789f06886806bc55\n",
+		"/cache/d.txt":        "",
+		"/excluded/e.txt":     "",
+	}
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(tmpImportDir+name, []byte(content), 0644))
+	}
+
+	scan := func(config map[string]string) []string {
+		config["location"] = tmpImportDir
+		imp, err := NewFSImporter(config)
+		require.NoError(t, err)
+		defer imp.Close()
+
+		imp.(*FSImporter).SetExcludes(func(pathname string) bool {
+			return pathname == tmpImportDir+"/excluded"
+		})
+
+		scanChan, err := imp.Scan()
+		require.NoError(t, err)
+
+		paths := []string{}
+		for record := range scanChan {
+			require.Nil(t, record.Error)
+			if record.Record.IsXattr || len(record.Record.Pathname) <= len(tmpImportDir) {
+				continue
+			}
+			paths = append(paths, record.Record.Pathname[len(tmpImportDir):])
+		}
+		sort.Strings(paths)
+		return paths
+	}
+
+	require.Equal(t, []string{
+		"/.plakarignore",
+		"/a.txt",
+		"/sub",
+		"/sub/.plakarignore",
+		"/sub/keep.log",
+	}, scan(map[string]string{"exclude_caches": "true"}))
+
+	require.Equal(t, []string{
+		"/.plakarignore",
+		"/a.log",
+		"/a.txt",
+		"/cache",
+		"/cache/CACHEDIR.TAG",
+		"/cache/d.txt",
+		"/sub",
+		"/sub/.plakarignore",
+		"/sub/b.log",
+		"/sub/build",
+		"/sub/build/c.txt",
+		"/sub/keep.log",
+	}, scan(map[string]string{"ignore_file": ""}))
+
+	_, err = NewFSImporter(map[string]string{"location": tmpImportDir, "exclude_caches": "maybe"})
+	require.Error(t, err)
+}
+
+func TestFSImporterFileTypes(t *testing.T) {
+	tmpImportDir, err := os.MkdirTemp("/tmp", "tmp_import*")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		os.RemoveAll(tmpImportDir)
+	})
+
+	require.NoError(t, os.MkdirAll(tmpImportDir+"/dir", 0755))
+	require.NoError(t, os.WriteFile(tmpImportDir+"/dir/small.txt", []byte("small"), 0644))
+	require.NoError(t, os.WriteFile(tmpImportDir+"/big.txt", make([]byte, 4096), 0644))
+	require.NoError(t, os.Symlink(tmpImportDir+"/dir", tmpImportDir+"/link"))
+	require.NoError(t, os.Symlink(tmpImportDir, tmpImportDir+"/dir/loop"))
+
+	listener, err := net.Listen("unix", tmpImportDir+"/socket")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	scan := func(config map[string]string) ([]string, int) {
+		config["location"] = tmpImportDir
+		imp, err := NewFSImporter(config)
+		require.NoError(t, err)
+		defer imp.Close()
+
+		scanChan, err := imp.Scan()
+		require.NoError(t, err)
+
+		paths := []string{}
+		nErrors := 0
+		for record := range scanChan {
+			if record.Error != nil {
+				nErrors++
+				continue
+			}
+			if record.Record.IsXattr || len(record.Record.Pathname) <= len(tmpImportDir) {
+				continue
+			}
+			paths = append(paths, record.Record.Pathname[len(tmpImportDir):])
+		}
+		sort.Strings(paths)
+		return paths, nErrors
+	}
+
+	paths, nErrors := scan(map[string]string{"skip_special": "true", "max_file_size": "1KiB"})
+	require.Equal(t, 0, nErrors)
+	require.Equal(t, []string{"/dir", "/dir/loop", "/dir/small.txt", "/link"}, paths)
+
+	// the loop back to the root is reported and left out
+	paths, nErrors = scan(map[string]string{"follow_symlinks": "true", "skip_special": "true"})
+	require.Equal(t, 2, nErrors)
+	require.Equal(t, []string{"/big.txt", "/dir", "/dir/small.txt", "/link", "/link/small.txt"}, paths)
+
+	for _, config := range []map[string]string{
+		{"max_file_size": "lots"},
+		{"one_file_system": "maybe"},
+		{"follow_symlinks": "maybe"},
+	} {
+		config["location"] = tmpImportDir
+		_, err := NewFSImporter(config)
+		require.Error(t, err)
+	}
+}
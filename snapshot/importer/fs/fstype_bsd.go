@@ -0,0 +1,24 @@
+//go:build darwin || freebsd
+
+package fs
+
+import (
+	"syscall"
+)
+
+// getFSType returns the type of the filesystem holding pathname.
+func getFSType(pathname string) (string, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(pathname, &st); err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, 0, len(st.Fstypename))
+	for _, c := range st.Fstypename {
+		if c == 0 {
+			break
+		}
+		buf = append(buf, byte(c))
+	}
+	return string(buf), nil
+}
@@ -0,0 +1,77 @@
+package fs
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// getFSType returns the type of the filesystem holding pathname, as
+// listed in /proc/self/mountinfo.
+func getFSType(pathname string) (string, error) {
+	realpath, err := filepath.EvalSymlinks(pathname)
+	if err != nil {
+		return "", err
+	}
+
+	fp, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	defer fp.Close()
+
+	var mountpoint, fstype string
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw
+		fields := strings.Fields(scanner.Text())
+		sep := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep == -1 || sep+1 >= len(fields) {
+			continue
+		}
+
+		// later entries are mounted over earlier ones
+		mnt := unescapeMountinfo(fields[4])
+		if isBelow(realpath, mnt) && len(mnt) >= len(mountpoint) {
+			mountpoint = mnt
+			fstype = fields[sep+1]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	if mountpoint == "" {
+		return "", fmt.Errorf("no mount point found for %s", pathname)
+	}
+	return fstype, nil
+}
+
+func isBelow(pathname, dir string) bool {
+	return dir == "/" || pathname == dir || strings.HasPrefix(pathname, dir+"/")
+}
+
+// unescapeMountinfo decodes the octal escapes used for spaces, tabs,
+// newlines and backslashes in mount points.
+func unescapeMountinfo(s string) string {
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				buf.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		buf.WriteByte(s[i])
+	}
+	return buf.String()
+}
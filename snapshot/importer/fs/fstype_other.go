@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !freebsd
+
+package fs
+
+import (
+	"errors"
+)
+
+// getFSType is not implemented on this platform.
+func getFSType(pathname string) (string, error) {
+	return "", errors.ErrUnsupported
+}
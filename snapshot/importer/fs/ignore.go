@@ -0,0 +1,28 @@
+package fs
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// CACHEDIR.TAG marks directories holding data that can be regenerated,
+// see https://bford.info/cachedir/
+const cacheDirTag = "CACHEDIR.TAG"
+
+var cacheDirSignature = []byte("Signature: 8a477f597d28d172789f06886806bc55")
+
+func isCacheDir(dir string) bool {
+	fp, err := os.Open(filepath.Join(dir, cacheDirTag))
+	if err != nil {
+		return false
+	}
+	defer fp.Close()
+
+	buf := make([]byte, len(cacheDirSignature))
+	if _, err := io.ReadFull(fp, buf); err != nil {
+		return false
+	}
+	return bytes.Equal(buf, cacheDirSignature)
+}
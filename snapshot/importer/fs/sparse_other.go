@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd
+
+package fs
+
+import (
+	"io"
+	"os"
+)
+
+// holes of sparse files are not detected on this platform
+func openFile(pathname string) (io.ReadCloser, error) {
+	return os.Open(pathname)
+}
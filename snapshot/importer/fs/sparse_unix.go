@@ -0,0 +1,68 @@
+//go:build linux || darwin || freebsd
+
+package fs
+
+import (
+	"io"
+	"os"
+	"syscall"
+
+	"github.com/PlakarKorp/plakar/objects"
+	"golang.org/x/sys/unix"
+)
+
+// sparseFile is a file with fewer blocks allocated than its size
+// requires, whose holes are found with SEEK_DATA and SEEK_HOLE.
+type sparseFile struct {
+	*os.File
+	size int64
+}
+
+func openFile(pathname string) (io.ReadCloser, error) {
+	fp, err := os.Open(pathname)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := fp.Stat()
+	if err != nil {
+		fp.Close()
+		return nil, err
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && info.Mode().IsRegular() && int64(st.Blocks)*512 < info.Size() {
+		return &sparseFile{File: fp, size: info.Size()}, nil
+	}
+	return fp, nil
+}
+
+func (f *sparseFile) DataExtents() ([]objects.Extent, error) {
+	fd := int(f.Fd())
+
+	var extents []objects.Extent
+	for offset := int64(0); offset < f.size; {
+		data, err := unix.Seek(fd, offset, unix.SEEK_DATA)
+		if err == unix.ENXIO {
+			// only a hole up to the end
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if data >= f.size {
+			break
+		}
+
+		hole, err := unix.Seek(fd, data, unix.SEEK_HOLE)
+		if err != nil {
+			return nil, err
+		}
+		hole = min(hole, f.size)
+
+		extents = append(extents, objects.Extent{Offset: data, Length: hole - data})
+		offset = hole
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return extents, nil
+}
@@ -27,6 +27,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"syscall"
 
 	"github.com/PlakarKorp/plakar/objects"
 	"github.com/PlakarKorp/plakar/snapshot/importer"
@@ -40,21 +41,51 @@ type namecache struct {
 	mu sync.RWMutex
 }
 
-// Worker pool to handle file scanning in parallel
-func walkDir_worker(jobs <-chan string, results chan<- *importer.ScanResult, wg *sync.WaitGroup, namecache *namecache) {
-	defer wg.Done()
+func toUnixPath(pathname string) string {
+	return filepath.ToSlash(pathname)
+}
+
+func getFileID(info fs.FileInfo) (fileID, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, false
+	}
+	return fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
+
+// walkDir_scanner returns the function turning a path into its scan
+// records, called from several goroutines.
+func walkDir_scanner(filter *walkFilter) func(path string) []*importer.ScanResult {
+	namecache := &namecache{
+		uidToName: make(map[uint64]string),
+		gidToName: make(map[uint64]string),
+	}
+	followSymlinks := filter != nil && filter.followSymlinks
+
+	return func(path string) []*importer.ScanResult {
+		var info fs.FileInfo
+		var err error
 
-	for path := range jobs {
-		info, err := os.Lstat(path)
+		followed := false
+		if followSymlinks {
+			info, err = os.Stat(path)
+			followed = err == nil
+		}
+		if !followed {
+			info, err = os.Lstat(path)
+		}
 		if err != nil {
-			results <- importer.NewScanError(path, err)
-			continue
+			return []*importer.ScanResult{importer.NewScanError(path, err)}
 		}
 
-		extendedAttributes, err := xattr.List(path)
+		var extendedAttributes []string
+		if followed {
+			extendedAttributes, err = xattr.List(path)
+		} else {
+			extendedAttributes, err = xattr.LList(path)
+		}
 		if err != nil {
-			results <- importer.NewScanError(path, err)
-			continue
+			return []*importer.ScanResult{importer.NewScanError(path, err)}
 		}
 
 		fileinfo := objects.FileInfoFromStat(info)
@@ -93,18 +124,20 @@ func walkDir_worker(jobs <-chan string, results chan<- *importer.ScanResult, wg
 		if fileinfo.Mode()&os.ModeSymlink != 0 {
 			originFile, err = os.Readlink(path)
 			if err != nil {
-				results <- importer.NewScanError(path, err)
-				continue
+				return []*importer.ScanResult{importer.NewScanError(path, err)}
 			}
 		}
-		results <- importer.NewScanRecord(filepath.ToSlash(path), originFile, fileinfo, extendedAttributes)
+
+		batch := make([]*importer.ScanResult, 0, 1+len(extendedAttributes))
+		batch = append(batch, importer.NewScanRecord(filepath.ToSlash(path), originFile, fileinfo, extendedAttributes))
 		for _, attr := range extendedAttributes {
-			results <- importer.NewScanXattr(filepath.ToSlash(path), attr, objects.AttributeExtended)
+			batch = append(batch, importer.NewScanXattr(filepath.ToSlash(path), attr, objects.AttributeExtended))
 		}
+		return batch
 	}
 }
 
-func walkDir_addPrefixDirectories(rootDir string, jobs chan<- string, results chan<- *importer.ScanResult) {
+func walkDir_addPrefixDirectories(rootDir string, send func(string, error)) {
 	atoms := strings.Split(rootDir, string(os.PathSeparator))
 
 	for i := 0; i < len(atoms)-1; i++ {
@@ -115,74 +148,34 @@ func walkDir_addPrefixDirectories(rootDir string, jobs chan<- string, results ch
 		}
 
 		if _, err := os.Stat(path); err != nil {
-			results <- importer.NewScanError(path, err)
+			send(path, err)
 			continue
 		}
 
-		jobs <- path
+		send(path, nil)
 	}
 }
 
-func walkDir_walker(rootDir string, numWorkers int) (<-chan *importer.ScanResult, error) {
-	results := make(chan *importer.ScanResult, 1000) // Larger buffer for results
-	jobs := make(chan string, 1000)                  // Buffered channel to feed paths to workers
-	namecache := &namecache{
-		uidToName: make(map[uint64]string),
-		gidToName: make(map[uint64]string),
+// walkDir_resolveRoot records rootDir when it is a symlink and returns
+// the directory to walk.
+func walkDir_resolveRoot(rootDir string, send func(string, error)) (string, bool) {
+	info, err := os.Lstat(rootDir)
+	if err != nil {
+		send(rootDir, err)
+		return "", false
 	}
-
-	var wg sync.WaitGroup
-
-	// Launch worker pool
-	for w := 1; w <= numWorkers; w++ {
-		wg.Add(1)
-		go walkDir_worker(jobs, results, &wg, namecache)
-	}
-
-	// Start walking the directory and sending file paths to workers
-	go func() {
-		defer close(jobs)
-
-		info, err := os.Lstat(rootDir)
+	if info.Mode()&os.ModeSymlink != 0 {
+		originFile, err := os.Readlink(rootDir)
 		if err != nil {
-			results <- importer.NewScanError(rootDir, err)
-			return
-		}
-		if info.Mode()&os.ModeSymlink != 0 {
-			originFile, err := os.Readlink(rootDir)
-			if err != nil {
-				results <- importer.NewScanError(rootDir, err)
-				return
-			}
-
-			if !filepath.IsAbs(originFile) {
-				originFile = filepath.Join(filepath.Dir(rootDir), originFile)
-			}
-			jobs <- rootDir
-			rootDir = originFile
+			send(rootDir, err)
+			return "", false
 		}
 
-		// Add prefix directories first
-		walkDir_addPrefixDirectories(rootDir, jobs, results)
-
-		err = filepath.WalkDir(rootDir, func(path string, d fs.DirEntry, err error) error {
-			if err != nil {
-				results <- importer.NewScanError(path, err)
-				return nil
-			}
-			jobs <- path
-			return nil
-		})
-		if err != nil {
-			results <- importer.NewScanError(rootDir, err)
+		if !filepath.IsAbs(originFile) {
+			originFile = filepath.Join(filepath.Dir(rootDir), originFile)
 		}
-	}()
-
-	// Close the results channel when all workers are done
-	go func() {
-		wg.Wait()
-		close(results)
-	}()
-
-	return results, nil
+		send(rootDir, nil)
+		rootDir = originFile
+	}
+	return rootDir, true
 }
@@ -23,7 +23,6 @@ import (
 	"os/user"
 	"path/filepath"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/PlakarKorp/plakar/objects"
@@ -43,11 +42,16 @@ func toUnixPath(pathname string) string {
 	return unixPath
 }
 
-// Worker pool to handle file scanning in parallel
-func walkDir_worker(jobs <-chan string, results chan<- *importer.ScanResult, wg *sync.WaitGroup) {
-	defer wg.Done()
+// getFileID is not implemented on Windows, the options needing it are
+// rejected by NewFSImporter.
+func getFileID(info fs.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
 
-	for pathname := range jobs {
+// walkDir_scanner returns the function turning a path into its scan
+// records, called from several goroutines.
+func walkDir_scanner(filter *walkFilter) func(pathname string) []*importer.ScanResult {
+	return func(pathname string) []*importer.ScanResult {
 		unixPath := toUnixPath(pathname)
 
 		var fileinfo objects.FileInfo
@@ -58,8 +62,7 @@ func walkDir_worker(jobs <-chan string, results chan<- *importer.ScanResult, wg
 		} else {
 			info, err := os.Lstat(pathname)
 			if err != nil {
-				results <- importer.NewScanError(unixPath, err)
-				continue
+				return []*importer.ScanResult{importer.NewScanError(unixPath, err)}
 			}
 			fileinfo = objects.FileInfoFromStat(info)
 			if info.Name() == "\\" {
@@ -69,8 +72,7 @@ func walkDir_worker(jobs <-chan string, results chan<- *importer.ScanResult, wg
 
 		extendedAttributes, err := xattr.List(pathname)
 		if err != nil {
-			results <- importer.NewScanError(unixPath, err)
-			continue
+			return []*importer.ScanResult{importer.NewScanError(unixPath, err)}
 		}
 
 		if u, err := user.LookupId(fmt.Sprintf("%d", fileinfo.Uid())); err == nil {
@@ -84,88 +86,55 @@ func walkDir_worker(jobs <-chan string, results chan<- *importer.ScanResult, wg
 		if fileinfo.Mode()&os.ModeSymlink != 0 {
 			originFile, err = os.Readlink(pathname)
 			if err != nil {
-				results <- importer.NewScanError(unixPath, err)
-				continue
+				return []*importer.ScanResult{importer.NewScanError(unixPath, err)}
 			}
 		}
-		results <- importer.NewScanRecord(unixPath, originFile, fileinfo, extendedAttributes)
+
+		batch := make([]*importer.ScanResult, 0, 1+len(extendedAttributes))
+		batch = append(batch, importer.NewScanRecord(unixPath, originFile, fileinfo, extendedAttributes))
 		for _, attr := range extendedAttributes {
-			results <- importer.NewScanXattr(filepath.ToSlash(pathname), attr, objects.AttributeExtended)
+			batch = append(batch, importer.NewScanXattr(filepath.ToSlash(pathname), attr, objects.AttributeExtended))
 		}
+		return batch
 	}
 }
 
-func walkDir_addPrefixDirectories(rootDir string, jobs chan<- string, results chan<- *importer.ScanResult) {
+func walkDir_addPrefixDirectories(rootDir string, send func(string, error)) {
 	atoms := strings.Split(rootDir, string(os.PathSeparator))
 
-	jobs <- "/"
+	send("/", nil)
 	for i := 0; i < len(atoms)-1; i++ {
 		pathname := strings.Join(atoms[0:i+1], string(os.PathSeparator))
 
 		if _, err := os.Stat(pathname); err != nil {
-			results <- importer.NewScanError(pathname, err)
+			send(pathname, err)
 			continue
 		}
 
-		jobs <- pathname
+		send(pathname, nil)
 	}
 }
 
-func walkDir_walker(rootDir string, numWorkers int) (<-chan *importer.ScanResult, error) {
-	results := make(chan *importer.ScanResult, 1000) // Larger buffer for results
-	jobs := make(chan string, 1000)                  // Buffered channel to feed paths to workers
-	var wg sync.WaitGroup
-
-	// Launch worker pool
-	for w := 1; w <= numWorkers; w++ {
-		wg.Add(1)
-		go walkDir_worker(jobs, results, &wg)
+// walkDir_resolveRoot returns the directory to walk, the target of
+// rootDir if it is a symlink.
+func walkDir_resolveRoot(rootDir string, send func(string, error)) (string, bool) {
+	info, err := os.Lstat(rootDir)
+	if err != nil {
+		send(rootDir, err)
+		return "", false
 	}
-
-	// Start walking the directory and sending file paths to workers
-	go func() {
-		defer close(jobs)
-
-		info, err := os.Lstat(rootDir)
+	if info.Mode()&os.ModeSymlink != 0 {
+		originFile, err := os.Readlink(rootDir)
 		if err != nil {
-			results <- importer.NewScanError(rootDir, err)
-			return
-		}
-		if info.Mode()&os.ModeSymlink != 0 {
-			originFile, err := os.Readlink(rootDir)
-			if err != nil {
-				results <- importer.NewScanError(rootDir, err)
-				return
-			}
-
-			if !filepath.IsAbs(originFile) {
-				originFile = filepath.Join(filepath.Dir(rootDir), originFile)
-			}
-
-			rootDir = originFile
+			send(rootDir, err)
+			return "", false
 		}
 
-		// Add prefix directories first
-		walkDir_addPrefixDirectories(rootDir, jobs, results)
-
-		err = filepath.WalkDir(rootDir, func(pathname string, d fs.DirEntry, err error) error {
-			if err != nil {
-				results <- importer.NewScanError(pathname, err)
-				return nil
-			}
-			jobs <- pathname
-			return nil
-		})
-		if err != nil {
-			results <- importer.NewScanError(rootDir, err)
+		if !filepath.IsAbs(originFile) {
+			originFile = filepath.Join(filepath.Dir(rootDir), originFile)
 		}
-	}()
 
-	// Close the results channel when all workers are done
-	go func() {
-		wg.Wait()
-		close(results)
-	}()
-
-	return results, nil
+		rootDir = originFile
+	}
+	return rootDir, true
 }
@@ -0,0 +1,375 @@
+package fs
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/PlakarKorp/plakar/snapshot/importer"
+)
+
+// walkOptions tune the parallel walk of a tree.
+type walkOptions struct {
+	walkers  int  // goroutines reading directories
+	statters int  // goroutines turning paths into scan records
+	maxQueue int  // directories queued before walkers descend inline
+	ordered  bool // emit records in lexical depth-first order
+}
+
+// walkJob is a path to turn into scan records, or an error to report.
+// Jobs are numbered in ordered walks so that records can be put back
+// in order after being produced in parallel.
+type walkJob struct {
+	seq      uint64
+	pathname string
+	err      error
+}
+
+// walkDir_walker scans rootDir, reading directories from several
+// goroutines while others stat the paths found.
+func walkDir_walker(rootDir string, options walkOptions, filter *walkFilter) (<-chan *importer.ScanResult, error) {
+	results := make(chan *importer.ScanResult, 1000) // Larger buffer for results
+	jobs := make(chan walkJob, 1000)                 // Buffered channel to feed paths to workers
+	scan := walkDir_scanner(filter)
+
+	sink := func(job walkJob, batch []*importer.ScanResult) {
+		for _, result := range batch {
+			results <- result
+		}
+	}
+	if options.ordered {
+		sink = newWalkReorder(results).put
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < options.statters; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				if job.err != nil {
+					sink(job, []*importer.ScanResult{importer.NewScanError(toUnixPath(job.pathname), job.err)})
+				} else {
+					sink(job, scan(job.pathname))
+				}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+
+		// jobs are only numbered in ordered walks, where send is called
+		// from a single goroutine
+		var seq uint64
+		send := func(pathname string, err error) {
+			jobs <- walkJob{seq: seq, pathname: pathname, err: err}
+			if options.ordered {
+				seq++
+			}
+		}
+
+		rootDir, ok := walkDir_resolveRoot(rootDir, send)
+		if !ok {
+			return
+		}
+
+		// Add prefix directories first
+		walkDir_addPrefixDirectories(rootDir, send)
+
+		info, err := os.Lstat(rootDir)
+		if err != nil {
+			send(rootDir, err)
+			return
+		}
+		keep, root, err := filter.check(nil, rootDir, fs.FileInfoToDirEntry(info))
+		if err != nil {
+			send(rootDir, err)
+		}
+		if !keep {
+			return
+		}
+		send(rootDir, nil)
+		if root == nil {
+			return
+		}
+
+		if options.ordered {
+			walkOrdered(root, options, filter, send)
+		} else {
+			walkUnordered(root, options, filter, send)
+		}
+	}()
+
+	// Close the results channel when all workers are done
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	return results, nil
+}
+
+// walkQueue holds the directories left to read, in a deque per walker.
+// Walkers take the directories they found last, depth-first, and steal
+// the oldest ones of the others when idle.
+type walkQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	deques [][]*walkDir
+	queued int
+	active int // directories queued or being read
+	max    int
+}
+
+func newWalkQueue(walkers, max int) *walkQueue {
+	q := &walkQueue{
+		deques: make([][]*walkDir, walkers),
+		max:    max,
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// push queues dir unless the queue is full, in which case the caller
+// reads it itself.
+func (q *walkQueue) push(walker int, dir *walkDir, force bool) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.queued >= q.max && !force {
+		return false
+	}
+	q.deques[walker] = append(q.deques[walker], dir)
+	q.queued++
+	q.active++
+	q.cond.Signal()
+	return true
+}
+
+// pop returns the next directory for walker to read, or nil once the
+// whole tree was read.
+func (q *walkQueue) pop(walker int) *walkDir {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		if deque := q.deques[walker]; len(deque) != 0 {
+			dir := deque[len(deque)-1]
+			q.deques[walker] = deque[:len(deque)-1]
+			q.queued--
+			return dir
+		}
+		for i := 1; i < len(q.deques); i++ {
+			victim := (walker + i) % len(q.deques)
+			if deque := q.deques[victim]; len(deque) != 0 {
+				dir := deque[0]
+				q.deques[victim] = deque[1:]
+				q.queued--
+				return dir
+			}
+		}
+		if q.active == 0 {
+			return nil
+		}
+		q.cond.Wait()
+	}
+}
+
+func (q *walkQueue) done() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.active--
+	if q.active == 0 {
+		q.cond.Broadcast()
+	}
+}
+
+func walkUnordered(root *walkDir, options walkOptions, filter *walkFilter, send func(string, error)) {
+	queue := newWalkQueue(options.walkers, options.maxQueue)
+	queue.push(0, root, true)
+
+	var readDir func(walker int, dir *walkDir)
+	readDir = func(walker int, dir *walkDir) {
+		entries, err := os.ReadDir(dir.path)
+		if err != nil {
+			send(dir.path, err)
+		}
+		for _, entry := range entries {
+			pathname := filepath.Join(dir.path, entry.Name())
+			keep, child, err := filter.check(dir, pathname, entry)
+			if err != nil {
+				send(pathname, err)
+			}
+			if !keep {
+				continue
+			}
+			send(pathname, nil)
+			if child != nil && !queue.push(walker, child, false) {
+				readDir(walker, child)
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < options.walkers; w++ {
+		wg.Add(1)
+		go func(walker int) {
+			defer wg.Done()
+			for dir := queue.pop(walker); dir != nil; dir = queue.pop(walker) {
+				readDir(walker, dir)
+				queue.done()
+			}
+		}(w)
+	}
+	wg.Wait()
+}
+
+// walkListing is the content of a directory in an ordered walk, read
+// ahead by the walkers or, if none got to it first, by the emitter.
+type walkListing struct {
+	dir        *walkDir
+	claimed    atomic.Bool
+	prefetched bool
+	done       chan struct{}
+
+	err     error
+	entries []walkEntry
+}
+
+type walkEntry struct {
+	pathname string
+	err      error
+	keep     bool
+	child    *walkListing
+}
+
+func newWalkListing(dir *walkDir) *walkListing {
+	return &walkListing{dir: dir, done: make(chan struct{})}
+}
+
+func (l *walkListing) read(filter *walkFilter, prefetch func(*walkListing)) {
+	defer close(l.done)
+
+	entries, err := os.ReadDir(l.dir.path)
+	l.err = err
+	l.entries = make([]walkEntry, 0, len(entries))
+	for _, entry := range entries {
+		pathname := filepath.Join(l.dir.path, entry.Name())
+		keep, child, err := filter.check(l.dir, pathname, entry)
+
+		e := walkEntry{pathname: pathname, err: err, keep: keep}
+		if child != nil {
+			e.child = newWalkListing(child)
+		}
+		l.entries = append(l.entries, e)
+	}
+	for _, e := range l.entries {
+		if e.child != nil {
+			prefetch(e.child)
+		}
+	}
+}
+
+// walkOrdered emits the tree in lexical depth-first order, as
+// filepath.WalkDir would, while up to maxQueue directories are read
+// ahead in parallel.
+func walkOrdered(root *walkDir, options walkOptions, filter *walkFilter, send func(string, error)) {
+	tasks := make(chan *walkListing, options.maxQueue)
+	tokens := make(chan struct{}, options.maxQueue)
+
+	prefetch := func(l *walkListing) {
+		select {
+		case tasks <- l:
+		default:
+			// the emitter reads it when it gets there
+		}
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < options.walkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for l := range tasks {
+				tokens <- struct{}{}
+				if !l.claimed.CompareAndSwap(false, true) {
+					<-tokens
+					continue
+				}
+				l.prefetched = true
+				l.read(filter, prefetch)
+			}
+		}()
+	}
+
+	var emit func(l *walkListing)
+	emit = func(l *walkListing) {
+		if l.claimed.CompareAndSwap(false, true) {
+			l.read(filter, prefetch)
+		} else {
+			<-l.done
+		}
+		if l.prefetched {
+			<-tokens
+		}
+
+		if l.err != nil {
+			send(l.dir.path, l.err)
+		}
+		for _, e := range l.entries {
+			if e.err != nil {
+				send(e.pathname, e.err)
+			}
+			if !e.keep {
+				continue
+			}
+			send(e.pathname, nil)
+			if e.child != nil {
+				emit(e.child)
+			}
+		}
+		l.entries = nil
+	}
+	emit(newWalkListing(root))
+
+	close(tasks)
+	wg.Wait()
+}
+
+// walkReorder puts back in order the records produced by parallel
+// workers for the numbered jobs of an ordered walk.
+type walkReorder struct {
+	mu      sync.Mutex
+	next    uint64
+	pending map[uint64][]*importer.ScanResult
+	results chan<- *importer.ScanResult
+}
+
+func newWalkReorder(results chan<- *importer.ScanResult) *walkReorder {
+	return &walkReorder{
+		pending: make(map[uint64][]*importer.ScanResult),
+		results: results,
+	}
+}
+
+func (r *walkReorder) put(job walkJob, batch []*importer.ScanResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.pending[job.seq] = batch
+	for {
+		batch, ok := r.pending[r.next]
+		if !ok {
+			return
+		}
+		delete(r.pending, r.next)
+		for _, result := range batch {
+			r.results <- result
+		}
+		r.next++
+	}
+}
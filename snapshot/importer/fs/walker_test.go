@@ -0,0 +1,107 @@
+package fs
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// generateTree creates a synthetic tree of the given depth, with fanout
+// subdirectories and files in each directory.
+func generateTree(t testing.TB, dir string, depth, fanout, files int) {
+	for i := 0; i < files; i++ {
+		err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("file%03d", i)), []byte("x"), 0644)
+		require.NoError(t, err)
+	}
+	if depth == 0 {
+		return
+	}
+	for i := 0; i < fanout; i++ {
+		subdir := filepath.Join(dir, fmt.Sprintf("dir%03d", i))
+		require.NoError(t, os.Mkdir(subdir, 0755))
+		generateTree(t, subdir, depth-1, fanout, files)
+	}
+}
+
+func walkPaths(t testing.TB, rootDir string, options walkOptions) []string {
+	scanChan, err := walkDir_walker(rootDir, options, nil)
+	require.NoError(t, err)
+
+	paths := []string{}
+	for record := range scanChan {
+		require.Nil(t, record.Error)
+		if record.Record.IsXattr {
+			continue
+		}
+		paths = append(paths, record.Record.Pathname)
+	}
+	return paths
+}
+
+func TestWalkerOrdered(t *testing.T) {
+	rootDir := t.TempDir()
+	generateTree(t, rootDir, 3, 4, 3)
+
+	expected := []string{}
+	walkDir_addPrefixDirectories(rootDir, func(pathname string, err error) {
+		require.NoError(t, err)
+		expected = append(expected, pathname)
+	})
+	err := filepath.WalkDir(rootDir, func(pathname string, d fs.DirEntry, err error) error {
+		expected = append(expected, pathname)
+		return err
+	})
+	require.NoError(t, err)
+
+	for _, maxQueue := range []int{1, 4, 1024} {
+		paths := walkPaths(t, rootDir, walkOptions{walkers: 8, statters: 16, maxQueue: maxQueue, ordered: true})
+		require.Equal(t, expected, paths)
+	}
+}
+
+func TestWalkerUnordered(t *testing.T) {
+	rootDir := t.TempDir()
+	generateTree(t, rootDir, 3, 4, 3)
+
+	expected := walkPaths(t, rootDir, walkOptions{walkers: 1, statters: 1, maxQueue: 1, ordered: true})
+	sort.Strings(expected)
+
+	for _, walkers := range []int{1, 3, 16} {
+		for _, maxQueue := range []int{1, 1024} {
+			paths := walkPaths(t, rootDir, walkOptions{walkers: walkers, statters: 16, maxQueue: maxQueue})
+			sort.Strings(paths)
+			require.Equal(t, expected, paths)
+		}
+	}
+}
+
+func BenchmarkWalker(b *testing.B) {
+	rootDir := b.TempDir()
+	generateTree(b, rootDir, 3, 8, 16)
+
+	for _, ordered := range []bool{false, true} {
+		for _, walkers := range []int{1, 4, 16} {
+			b.Run(fmt.Sprintf("ordered=%t/walkers=%d", ordered, walkers), func(b *testing.B) {
+				options := walkOptions{
+					walkers:  walkers,
+					statters: 256,
+					maxQueue: 4096,
+					ordered:  ordered,
+				}
+				for i := 0; i < b.N; i++ {
+					scanChan, err := walkDir_walker(rootDir, options, nil)
+					if err != nil {
+						b.Fatal(err)
+					}
+					for range scanChan {
+					}
+				}
+			})
+		}
+	}
+}
@@ -28,14 +28,14 @@ func getExtendedAttributes(path string) ([]importer.ExtendedAttributes, error) {
 	attrs := make([]importer.ExtendedAttributes, 0)
 
 	// Get the list of attribute names
-	attributes, err := xattr.List(path)
+	attributes, err := xattr.LList(path)
 	if err != nil {
 		return nil, err
 	}
 
 	// Iterate over each attribute and retrieve its value
 	for _, attr := range attributes {
-		value, err := xattr.Get(path, attr)
+		value, err := xattr.LGet(path, attr)
 		if err != nil {
 			// Log the error and continue instead of failing
 			if os.IsPermission(err) {
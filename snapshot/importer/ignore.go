@@ -0,0 +1,179 @@
+package importer
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// IgnoreFile is the name of the per-directory files listing, with the
+// gitignore syntax, the paths to leave out of a backup.
+const IgnoreFile = ".plakarignore"
+
+type ignoreRule struct {
+	base    string
+	pattern *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// Ignore is a set of gitignore-style rules, each relative to the
+// directory holding the file it was read from.  Rules are matched in
+// order and the last matching one wins, so that rules from deeper
+// directories override those of their parents.  A nil *Ignore ignores
+// nothing.
+type Ignore struct {
+	rules []ignoreRule
+}
+
+// With returns the rules of ign followed by those read from rd, which
+// apply to the paths below the base directory.  ign is not modified.
+func (ign *Ignore) With(rd io.Reader, base string) (*Ignore, error) {
+	ret := &Ignore{}
+	if ign != nil {
+		ret.rules = append(ret.rules, ign.rules...)
+	}
+
+	scanner := bufio.NewScanner(rd)
+	for scanner.Scan() {
+		if rule, ok := compileIgnoreRule(scanner.Text()); ok {
+			rule.base = base
+			ret.rules = append(ret.rules, rule)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Match tells whether pathname, a slash-separated absolute path, is
+// ignored.
+func (ign *Ignore) Match(pathname string, isDir bool) bool {
+	if ign == nil {
+		return false
+	}
+
+	ignored := false
+	for _, rule := range ign.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		rel, ok := relativeTo(rule.base, pathname)
+		if !ok {
+			continue
+		}
+		if rule.pattern.MatchString(rel) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+func relativeTo(base, pathname string) (string, bool) {
+	if base != "/" {
+		base += "/"
+	}
+	if !strings.HasPrefix(pathname, base) || len(pathname) == len(base) {
+		return "", false
+	}
+	return pathname[len(base):], true
+}
+
+func compileIgnoreRule(line string) (ignoreRule, bool) {
+	var rule ignoreRule
+
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || line[0] == '#' {
+		return rule, false
+	}
+
+	if line[0] == '!' {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return rule, false
+	}
+
+	// A pattern with a slash other than a trailing one is anchored to
+	// the directory of the ignore file, otherwise it matches at any
+	// depth below it.
+	expr := ignoreGlobToRegexp(strings.TrimPrefix(line, "/"))
+	if !strings.Contains(line, "/") {
+		expr = "(?:.*/)?" + expr
+	}
+
+	pattern, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return rule, false
+	}
+	rule.pattern = pattern
+	return rule, true
+}
+
+func ignoreGlobToRegexp(glob string) string {
+	var buf strings.Builder
+
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				leading := i == 0 || glob[i-1] == '/'
+				if leading && i+2 < len(glob) && glob[i+2] == '/' {
+					// "**/" matches zero or more directories
+					buf.WriteString("(?:.*/)?")
+					i += 2
+					continue
+				}
+				if leading && i+2 == len(glob) {
+					// a trailing "/**" matches everything inside
+					buf.WriteString(".*")
+					i++
+					continue
+				}
+				i++
+			}
+			buf.WriteString("[^/]*")
+		case '?':
+			buf.WriteString("[^/]")
+		case '[':
+			end := i + 1
+			if end < len(glob) && (glob[end] == '!' || glob[end] == '^') {
+				end++
+			}
+			if end < len(glob) && glob[end] == ']' {
+				end++
+			}
+			for end < len(glob) && glob[end] != ']' {
+				end++
+			}
+			if end >= len(glob) {
+				buf.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : end]
+			if class[0] == '!' {
+				class = "^" + class[1:]
+			}
+			buf.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i = end
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+			}
+			buf.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return buf.String()
+}
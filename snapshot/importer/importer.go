@@ -64,6 +64,23 @@ type Importer interface {
 	Close() error
 }
 
+// Excluder is implemented by importers able to leave out the paths a
+// backup excludes while scanning, so that excluded directories are not
+// even walked.  It must be called before Scan.
+type Excluder interface {
+	SetExcludes(excluded func(pathname string) bool)
+}
+
+// SparseReader is implemented by the readers NewReader returns for
+// sparse files, so that their holes are neither read nor stored.
+type SparseReader interface {
+	io.ReaderAt
+
+	// DataExtents returns, in order, the regions of the file holding
+	// data, anything else being a hole.
+	DataExtents() ([]objects.Extent, error)
+}
+
 var muBackends sync.Mutex
 var backends map[string]func(config map[string]string) (Importer, error) = make(map[string]func(config map[string]string) (Importer, error))
 
@@ -3,6 +3,7 @@ package importer
 import (
 	"fmt"
 	"io"
+	"strings"
 	"testing"
 	"time"
 
@@ -136,3 +137,46 @@ func TestNewScanError(t *testing.T) {
 	require.Equal(t, pathname, record.Error.Pathname)
 	require.Equal(t, err, record.Error.Err)
 }
+
+func TestIgnore(t *testing.T) {
+	var nilIgnore *Ignore
+	require.False(t, nilIgnore.Match("/a", false))
+
+	ign, err := nilIgnore.With(strings.NewReader(`# comment
+*.log
+!keep.log
+/build
+tmp/
+docs/**/*.pdf
+\#hash
+`), "/root")
+	require.NoError(t, err)
+
+	ign, err = ign.With(strings.NewReader("!*.log\n"), "/root/sub")
+	require.NoError(t, err)
+
+	tests := []struct {
+		pathname string
+		isDir    bool
+		ignored  bool
+	}{
+		{"/root/a.log", false, true},
+		{"/root/x/y/a.log", false, true},
+		{"/root/keep.log", false, false},
+		{"/root/sub/a.log", false, false},
+		{"/root/build", true, true},
+		{"/root/x/build", true, false},
+		{"/root/tmp", true, true},
+		{"/root/x/tmp", true, true},
+		{"/root/tmp", false, false},
+		{"/root/docs/a.pdf", false, true},
+		{"/root/docs/x/y/a.pdf", false, true},
+		{"/root/other/a.pdf", false, false},
+		{"/root/#hash", false, true},
+		{"/other/a.log", false, false},
+		{"/root", true, false},
+	}
+	for _, test := range tests {
+		require.Equal(t, test.ignored, ign.Match(test.pathname, test.isDir), test.pathname)
+	}
+}
@@ -10,7 +10,7 @@ import (
 )
 
 func (snap *Snapshot) getidx(name, kind string) (objects.MAC, bool) {
-	source := snap.Header.GetSource(0)
+	source := snap.Source()
 	for i := range source.Indexes {
 		if source.Indexes[i].Name == name && source.Indexes[i].Type == kind {
 			return source.Indexes[i].Value, true
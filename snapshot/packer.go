@@ -62,6 +62,8 @@ func (mgr *PackerManager) Run() {
 		return nil
 	})
 
+	maxSize := mgr.snapshot.repository.EffectiveConfiguration().Packfile.MaxSize
+
 	workerGroup, workerCtx := errgroup.WithContext(ctx)
 	for i := 0; i < runtime.NumCPU(); i++ {
 		workerGroup.Go(func() error {
@@ -99,7 +101,7 @@ func (mgr *PackerManager) Run() {
 						continue
 					}
 
-					if packer.Size() > uint32(mgr.snapshot.repository.Configuration().Packfile.MaxSize) {
+					if packer.Size() > uint32(maxSize) {
 						packerResultChan <- packer
 						packer = nil
 					}
@@ -188,7 +190,7 @@ func (snap *Snapshot) PutBlob(Type resources.Type, mac [32]byte, data []byte) er
 		}
 	}
 
-	encodedReader, err := snap.repository.Encode(bytes.NewReader(data))
+	encodedReader, flags, err := snap.repository.EncodeBlob(bytes.NewReader(data))
 	if err != nil {
 		return err
 	}
@@ -198,7 +200,7 @@ func (snap *Snapshot) PutBlob(Type resources.Type, mac [32]byte, data []byte) er
 		return err
 	}
 
-	snap.packerManager.packerChan <- &PackerMsg{Type: Type, Version: versioning.GetCurrentVersion(Type), Timestamp: time.Now(), MAC: mac, Data: encoded}
+	snap.packerManager.packerChan <- &PackerMsg{Type: Type, Version: versioning.GetCurrentVersion(Type), Timestamp: time.Now(), MAC: mac, Data: encoded, Flags: flags}
 	return nil
 }
 
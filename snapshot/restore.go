@@ -1,65 +1,450 @@
 package snapshot
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	iofs "io/fs"
 	"os"
 	"path"
+	"slices"
 	"strings"
 	"sync"
 
 	"github.com/PlakarKorp/plakar/events"
+	"github.com/PlakarKorp/plakar/objects"
 	"github.com/PlakarKorp/plakar/snapshot/exporter"
 	"github.com/PlakarKorp/plakar/snapshot/vfs"
+	"github.com/gobwas/glob"
 )
 
+// RestoreMode selects what happens to entries that already exist at
+// the destination.
+type RestoreMode string
+
+const (
+	// RestoreOverwrite replaces existing entries, this is the default.
+	RestoreOverwrite RestoreMode = "overwrite"
+
+	// RestoreSkip leaves existing entries untouched.
+	RestoreSkip RestoreMode = "skip"
+
+	// RestoreUpdate replaces existing entries unless they have the
+	// same size and modification time, or the same content.
+	RestoreUpdate RestoreMode = "update"
+
+	// RestoreVerify replaces existing files unless their content
+	// matches the snapshot, regardless of their modification time.
+	RestoreVerify RestoreMode = "verify"
+)
+
+var ErrInvalidRestoreMode = errors.New("invalid restore mode")
+
+func ParseRestoreMode(mode string) (RestoreMode, error) {
+	switch RestoreMode(mode) {
+	case RestoreOverwrite, RestoreSkip, RestoreUpdate, RestoreVerify:
+		return RestoreMode(mode), nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrInvalidRestoreMode, mode)
+}
+
 type RestoreOptions struct {
 	MaxConcurrency uint64
 	Strip          string
+	SkipOwnership  bool
+	SkipXattrs     bool
+
+	// Mode defaults to RestoreOverwrite.
+	Mode RestoreMode
+
+	// DryRun reports what would be created or overwritten without
+	// modifying the destination.
+	DryRun bool
+
+	// Excludes skip entries, and everything below excluded
+	// directories.  When Includes are set, only the files matching
+	// one of them are restored.  Patterns match snapshot pathnames.
+	Includes []glob.Glob
+	Excludes []glob.Glob
+
+	// Filters further select files by type, size and modification
+	// time, its prefix and pagination are ignored.
+	Filters *SearchOpts
+
+	// Rename restores the snapshot pathnames below a key under its
+	// value, relative to the restore target, instead of stripping
+	// them.
+	Rename map[string]string
+
+	// Check has RestoreAsOf read the chunks of the files it picks a
+	// snapshot for, so that corrupted versions are passed over like
+	// missing ones.
+	Check bool
+
+	// pick restricts the files restored from one of the snapshots
+	// merged by RestoreAsOf.
+	pick func(entrypath string) bool
+}
+
+// Actions reported by dry-run events, and reasons for skipping an entry.
+const (
+	restoreActionCreate    = "create"
+	restoreActionOverwrite = "overwrite"
+
+	restoreSkipExists    = "exists"
+	restoreSkipUnchanged = "unchanged"
+)
+
+type restoreHardlink struct {
+	dest string
+	done chan struct{}
+}
+
+type restoreDirectory struct {
+	entrypath string
+	dest      string
+	entry     *vfs.Entry
+
+	// exists is set when the directory was already at the destination
+	// before the restore, only checked if it matters to the mode.
+	exists bool
 }
 
 type restoreContext struct {
-	hardlinks      map[string]string
+	hardlinks      map[string]*restoreHardlink
 	hardlinksMutex sync.Mutex
+	directories    []restoreDirectory
 	maxConcurrency chan bool
+
+	// when filtering, directories are created once they have
+	// content, needed tracks those.
+	filtering bool
+	needed    map[string]struct{}
+}
+
+// need records that the parents of entrypath are to be restored.
+func (rc *restoreContext) need(entrypath string) {
+	for dir := path.Dir(entrypath); ; dir = path.Dir(dir) {
+		if _, ok := rc.needed[dir]; ok || dir == "/" {
+			return
+		}
+		rc.needed[dir] = struct{}{}
+	}
+}
+
+func restoreXattrs(fs *vfs.Filesystem, exp exporter.Exporter, dest string, e *vfs.Entry) error {
+	for _, name := range e.ExtendedAttributes {
+		rd, err := e.Xattr(fs, name)
+		if err != nil {
+			return err
+		}
+		value, err := io.ReadAll(rd)
+		if err != nil {
+			return err
+		}
+		if err := exp.SetXattr(dest, name, value); err != nil {
+			return fmt.Errorf("xattr %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// restoreMetadata applies the metadata of an entry once it has been
+// created.  Extended attributes are best effort: failing to restore
+// one is reported but does not prevent the rest from being applied.
+func restoreMetadata(fs *vfs.Filesystem, exp exporter.Exporter, dest string, e *vfs.Entry, opts *RestoreOptions) error {
+	var xattrErr error
+	if !opts.SkipXattrs {
+		xattrErr = restoreXattrs(fs, exp, dest, e)
+	}
+
+	fileinfo := e.Stat()
+	if !opts.SkipOwnership {
+		if err := exp.SetOwnership(dest, fileinfo); err != nil {
+			return err
+		}
+	}
+
+	// snapshots predating atime tracking only have the mtime
+	atime := fileinfo.AccessTime()
+	if atime.IsZero() {
+		atime = fileinfo.ModTime()
+	}
+	if err := exp.SetTimes(dest, atime, fileinfo.ModTime()); err != nil {
+		return err
+	}
+
+	if err := exp.SetPermissions(dest, fileinfo); err != nil {
+		return err
+	}
+	return xattrErr
+}
+
+// sameContent tells whether the file at dest has the content of the
+// entry, by computing its MAC with the repository hasher.  As the MAC
+// of a sparse object is not computed over its content, such a file is
+// compared chunk by chunk instead.
+func (snap *Snapshot) sameContent(exp exporter.Exporter, dest string, e *vfs.Entry) (bool, error) {
+	if e.ResolvedObject == nil {
+		return false, nil
+	}
+
+	rd, err := exp.Open(dest)
+	if err != nil {
+		return false, err
+	}
+	defer rd.Close()
+
+	if e.ResolvedObject.Sparse() {
+		return snap.sameChunks(rd, e.ResolvedObject)
+	}
+
+	hasher := snap.repository.GetMACHasher()
+	if _, err := io.Copy(hasher, rd); err != nil {
+		return false, err
+	}
+	return bytes.Equal(hasher.Sum(nil), e.ResolvedObject.ContentMAC[:]), nil
+}
+
+// sameChunks tells whether rd has the content of the chunks of object,
+// holes reading as zeros.
+func (snap *Snapshot) sameChunks(rd io.Reader, object *objects.Object) (bool, error) {
+	buf := make([]byte, 64*1024)
+	for _, chunk := range object.Chunks {
+		if chunk.IsHole() {
+			for n := int64(chunk.Length); n > 0; {
+				m, err := io.ReadFull(rd, buf[:min(n, int64(len(buf)))])
+				if err != nil {
+					return false, ignoreEOF(err)
+				}
+				if slices.ContainsFunc(buf[:m], func(b byte) bool { return b != 0 }) {
+					return false, nil
+				}
+				n -= int64(m)
+			}
+			continue
+		}
+
+		data := make([]byte, chunk.Length)
+		if _, err := io.ReadFull(rd, data); err != nil {
+			return false, ignoreEOF(err)
+		}
+		if snap.repository.ComputeMAC(data) != chunk.ContentMAC {
+			return false, nil
+		}
+	}
+
+	// the file must not be longer than the object
+	n, err := rd.Read(buf[:1])
+	if n != 0 {
+		return false, nil
+	}
+	return true, ignoreEOF(err)
+}
+
+// ignoreEOF returns nil for the errors of a reader ending early, which
+// only tell the content differs.
+func ignoreEOF(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil
+	}
+	return err
+}
+
+// checkDestination looks at what exists at dest and decides, according
+// to the restore mode, whether the entry must be restored.  It returns
+// whether something exists, and the reason to skip the entry if it must
+// not be restored.
+func (snap *Snapshot) checkDestination(exp exporter.Exporter, dest string, e *vfs.Entry, mode RestoreMode) (bool, string, error) {
+	current, err := exp.Stat(dest)
+	if errors.Is(err, iofs.ErrNotExist) {
+		return false, "", nil
+	} else if err != nil {
+		return false, "", err
+	}
+
+	switch mode {
+	case RestoreSkip:
+		return true, restoreSkipExists, nil
+	case RestoreUpdate, RestoreVerify:
+	default:
+		return true, "", nil
+	}
+
+	fileinfo := e.Stat()
+	if current.Mode().Type() != fileinfo.Mode().Type() || current.Size() != fileinfo.Size() {
+		return true, "", nil
+	}
+	if mode == RestoreUpdate && current.ModTime().Equal(fileinfo.ModTime()) {
+		return true, restoreSkipUnchanged, nil
+	}
+
+	// entries other than regular files have no content to compare
+	if !fileinfo.Mode().IsRegular() {
+		if current.ModTime().Equal(fileinfo.ModTime()) {
+			return true, restoreSkipUnchanged, nil
+		}
+		return true, "", nil
+	}
+
+	same, err := snap.sameContent(exp, dest, e)
+	if err != nil {
+		return true, "", err
+	}
+	if same {
+		return true, restoreSkipUnchanged, nil
+	}
+	return true, "", nil
+}
+
+// skipOrDryRun handles the entries that must not be restored, either
+// because of the restore mode or because this is a dry run, and tells
+// whether the caller is done with the entry.  Unchanged entries still
+// get their metadata refreshed.
+func (snap *Snapshot) skipOrDryRun(fs *vfs.Filesystem, exp exporter.Exporter, entrypath string, dest string, e *vfs.Entry, opts *RestoreOptions, exists bool, reason string) bool {
+	if reason != "" {
+		if reason == restoreSkipUnchanged && !opts.DryRun {
+			if err := restoreMetadata(fs, exp, dest, e, opts); err != nil {
+				snap.Event(events.FileErrorEvent(snap.Header.Identifier, entrypath, err.Error()))
+				return true
+			}
+		}
+		snap.Event(events.FileSkippedEvent(snap.Header.Identifier, entrypath, reason))
+		return true
+	}
+
+	if opts.DryRun {
+		action := restoreActionCreate
+		if exists {
+			action = restoreActionOverwrite
+		}
+		snap.Event(events.FileDryRunEvent(snap.Header.Identifier, entrypath, action))
+		return true
+	}
+	return false
 }
 
-func snapshotRestorePath(snap *Snapshot, exp exporter.Exporter, target string, opts *RestoreOptions, restoreContext *restoreContext, wg *sync.WaitGroup) func(entrypath string, e *vfs.Entry, err error) error {
+func snapshotRestorePath(snap *Snapshot, fs *vfs.Filesystem, exp exporter.Exporter, target string, opts *RestoreOptions, restoreContext *restoreContext, wg *sync.WaitGroup) func(entrypath string, e *vfs.Entry, err error) error {
 	return func(entrypath string, e *vfs.Entry, err error) error {
 		if err != nil {
 			snap.Event(events.PathErrorEvent(snap.Header.Identifier, entrypath, err.Error()))
 			return err
 		}
 
+		if entrypath != "/" && opts.excluded(entrypath) {
+			if e.IsDir() {
+				return iofs.SkipDir
+			}
+			return nil
+		}
+		if !e.IsDir() && !opts.selected(entrypath, e) {
+			return nil
+		}
+
 		snap.Event(events.PathEvent(snap.Header.Identifier, entrypath))
 
-		// Determine destination path by stripping the prefix.
-		dest := path.Join(target, strings.TrimPrefix(entrypath, opts.Strip))
+		dest := opts.destination(target, entrypath)
 
 		// Directory processing.
 		if e.IsDir() {
 			snap.Event(events.DirectoryEvent(snap.Header.Identifier, entrypath))
-			// Create directory if not root.
-			if entrypath != "/" {
-				if err := exp.CreateDirectory(dest); err != nil {
-					snap.Event(events.DirectoryErrorEvent(snap.Header.Identifier, entrypath, err.Error()))
-					return err
+			if entrypath == "/" {
+				snap.Event(events.DirectoryOKEvent(snap.Header.Identifier, entrypath))
+				return nil
+			}
+
+			if restoreContext.filtering {
+				// whether it is needed is only known once the walk
+				// is over, by then restored files may have created it
+				exists := false
+				if opts.Mode == RestoreSkip || opts.DryRun {
+					_, err := exp.Stat(dest)
+					exists = err == nil
 				}
+				restoreContext.directories = append(restoreContext.directories, restoreDirectory{
+					entrypath: entrypath,
+					dest:      dest,
+					entry:     e,
+					exists:    exists,
+				})
+				return nil
 			}
 
-			// WalkDir handles recursion so we don’t need to iterate children manually.
-			if entrypath != "/" {
-				if err := exp.SetPermissions(dest, e.Stat()); err != nil {
-					snap.Event(events.DirectoryErrorEvent(snap.Header.Identifier, entrypath, err.Error()))
-					return err
+			if opts.Mode == RestoreSkip || opts.DryRun {
+				_, err := exp.Stat(dest)
+				if err == nil && opts.Mode == RestoreSkip {
+					snap.Event(events.FileSkippedEvent(snap.Header.Identifier, entrypath, restoreSkipExists))
+					return nil
+				}
+				if opts.DryRun {
+					if errors.Is(err, iofs.ErrNotExist) {
+						snap.Event(events.FileDryRunEvent(snap.Header.Identifier, entrypath, restoreActionCreate))
+					}
+					return nil
 				}
 			}
-			snap.Event(events.DirectoryOKEvent(snap.Header.Identifier, entrypath))
+
+			if err := exp.CreateDirectory(dest); err != nil {
+				snap.Event(events.DirectoryErrorEvent(snap.Header.Identifier, entrypath, err.Error()))
+				return err
+			}
+
+			// WalkDir handles recursion so we don’t need to iterate
+			// children manually, the metadata is applied once they
+			// are all restored.
+			restoreContext.directories = append(restoreContext.directories, restoreDirectory{
+				entrypath: entrypath,
+				dest:      dest,
+				entry:     e,
+			})
+			return nil
+		}
+
+		if restoreContext.filtering {
+			restoreContext.need(entrypath)
+		}
+
+		mode := e.Stat().Mode()
+		if mode&os.ModeSymlink != 0 || mode&(os.ModeNamedPipe|os.ModeDevice) != 0 {
+			snap.Event(events.FileEvent(snap.Header.Identifier, entrypath))
+
+			exists, reason, err := snap.checkDestination(exp, dest, e, opts.Mode)
+			if err != nil {
+				snap.Event(events.FileErrorEvent(snap.Header.Identifier, entrypath, err.Error()))
+				return nil
+			}
+			if snap.skipOrDryRun(fs, exp, entrypath, dest, e, opts, exists, reason) {
+				return nil
+			}
+
+			if err := exp.CreateDirectory(path.Dir(dest)); err != nil {
+				snap.Event(events.FileErrorEvent(snap.Header.Identifier, entrypath, err.Error()))
+				return nil
+			}
+
+			if mode&os.ModeSymlink != 0 {
+				err = exp.CreateLink(e.SymlinkTarget, dest, exporter.SYMLINK)
+			} else {
+				err = exp.CreateSpecialFile(dest, e.Stat())
+			}
+			if err != nil {
+				snap.Event(events.FileErrorEvent(snap.Header.Identifier, entrypath, err.Error()))
+				return nil
+			}
+
+			if err := restoreMetadata(fs, exp, dest, e, opts); err != nil {
+				snap.Event(events.FileErrorEvent(snap.Header.Identifier, entrypath, err.Error()))
+			} else {
+				snap.Event(events.FileOKEvent(snap.Header.Identifier, entrypath, 0))
+			}
 			return nil
 		}
 
-		// For non-directory entries, only process regular files.
-		if !e.Stat().Mode().IsRegular() {
-			snap.Event(events.FileErrorEvent(snap.Header.Identifier, entrypath, "unexpected vfs entry type"))
+		// Sockets can't be recreated, other entries are regular files.
+		if !mode.IsRegular() {
+			snap.Event(events.FileErrorEvent(snap.Header.Identifier, entrypath,
+				fmt.Sprintf("unsupported file type %s", e.Stat().Type())))
 			return nil
 		}
 
@@ -70,23 +455,43 @@ func snapshotRestorePath(snap *Snapshot, exp exporter.Exporter, target string, o
 			defer wg.Done()
 			defer func() { <-restoreContext.maxConcurrency }()
 
-			// Handle hard links.
+			// Handle hard links: the first one to get there restores
+			// the content, the others wait for it to link to it.
 			if e.Stat().Nlink() > 1 {
 				key := fmt.Sprintf("%d:%d", e.Stat().Dev(), e.Stat().Ino())
 				restoreContext.hardlinksMutex.Lock()
-				v, ok := restoreContext.hardlinks[key]
+				link, ok := restoreContext.hardlinks[key]
+				if !ok {
+					link = &restoreHardlink{dest: dest, done: make(chan struct{})}
+					restoreContext.hardlinks[key] = link
+				}
 				restoreContext.hardlinksMutex.Unlock()
+
 				if ok {
-					// Create a new link and return.
-					if err := os.Link(v, dest); err != nil {
+					<-link.done
+					if skip, err := snap.checkHardlink(exp, entrypath, link.dest, dest, opts); err != nil {
 						snap.Event(events.FileErrorEvent(snap.Header.Identifier, entrypath, err.Error()))
+						return
+					} else if skip {
+						return
+					}
+					if err := exp.CreateLink(link.dest, dest, exporter.HARDLINK); err != nil {
+						snap.Event(events.FileErrorEvent(snap.Header.Identifier, entrypath, err.Error()))
+					} else {
+						snap.Event(events.FileOKEvent(snap.Header.Identifier, entrypath, e.Size()))
 					}
 					return
-				} else {
-					restoreContext.hardlinksMutex.Lock()
-					restoreContext.hardlinks[key] = dest
-					restoreContext.hardlinksMutex.Unlock()
 				}
+				defer close(link.done)
+			}
+
+			exists, reason, err := snap.checkDestination(exp, dest, e, opts.Mode)
+			if err != nil {
+				snap.Event(events.FileErrorEvent(snap.Header.Identifier, entrypath, err.Error()))
+				return
+			}
+			if snap.skipOrDryRun(fs, exp, entrypath, dest, e, opts, exists, reason) {
+				return
 			}
 
 			rd, err := snap.NewReader(entrypath)
@@ -104,7 +509,9 @@ func snapshotRestorePath(snap *Snapshot, exp exporter.Exporter, target string, o
 			// Restore the file content.
 			if err := exp.StoreFile(dest, rd); err != nil {
 				snap.Event(events.FileErrorEvent(snap.Header.Identifier, entrypath, err.Error()))
-			} else if err := exp.SetPermissions(dest, e.Stat()); err != nil {
+				return
+			}
+			if err := restoreMetadata(fs, exp, dest, e, opts); err != nil {
 				snap.Event(events.FileErrorEvent(snap.Header.Identifier, entrypath, err.Error()))
 			} else {
 				snap.Event(events.FileOKEvent(snap.Header.Identifier, entrypath, e.Size()))
@@ -114,10 +521,48 @@ func snapshotRestorePath(snap *Snapshot, exp exporter.Exporter, target string, o
 	}
 }
 
+// checkHardlink is the counterpart of checkDestination for additional
+// links to a file, which need not be recreated if they already point
+// to it.  It reports the outcome and tells whether the link is done.
+func (snap *Snapshot) checkHardlink(exp exporter.Exporter, entrypath string, target string, dest string, opts *RestoreOptions) (bool, error) {
+	current, err := exp.Stat(dest)
+	exists := err == nil
+	if err != nil && !errors.Is(err, iofs.ErrNotExist) {
+		return true, err
+	}
+
+	if exists && opts.Mode == RestoreSkip {
+		snap.Event(events.FileSkippedEvent(snap.Header.Identifier, entrypath, restoreSkipExists))
+		return true, nil
+	}
+
+	if exists && (opts.Mode == RestoreUpdate || opts.Mode == RestoreVerify) {
+		if linked, err := exp.Stat(target); err == nil && current.Ino() != 0 &&
+			current.Dev() == linked.Dev() && current.Ino() == linked.Ino() {
+			snap.Event(events.FileSkippedEvent(snap.Header.Identifier, entrypath, restoreSkipUnchanged))
+			return true, nil
+		}
+	}
+
+	if opts.DryRun {
+		action := restoreActionCreate
+		if exists {
+			action = restoreActionOverwrite
+		}
+		snap.Event(events.FileDryRunEvent(snap.Header.Identifier, entrypath, action))
+		return true, nil
+	}
+	return false, nil
+}
+
 func (snap *Snapshot) Restore(exp exporter.Exporter, base string, pathname string, opts *RestoreOptions) error {
 	snap.Event(events.StartEvent())
 	defer snap.Event(events.DoneEvent())
 
+	return snap.restore(exp, base, pathname, opts)
+}
+
+func (snap *Snapshot) restore(exp exporter.Exporter, base string, pathname string, opts *RestoreOptions) error {
 	fs, err := snap.Filesystem()
 	if err != nil {
 		return err
@@ -129,9 +574,11 @@ func (snap *Snapshot) Restore(exp exporter.Exporter, base string, pathname strin
 	}
 
 	restoreContext := &restoreContext{
-		hardlinks:      make(map[string]string),
+		hardlinks:      make(map[string]*restoreHardlink),
 		hardlinksMutex: sync.Mutex{},
 		maxConcurrency: make(chan bool, maxConcurrency),
+		filtering:      opts.filtering(),
+		needed:         make(map[string]struct{}),
 	}
 	defer close(restoreContext.maxConcurrency)
 
@@ -141,7 +588,39 @@ func (snap *Snapshot) Restore(exp exporter.Exporter, base string, pathname strin
 	}
 
 	wg := sync.WaitGroup{}
-	defer wg.Wait()
+	err = fs.WalkDir(pathname, snapshotRestorePath(snap, fs, exp, base, opts, restoreContext, &wg))
+	wg.Wait()
+
+	// Directories are completed deepest first, once all of their
+	// content is in place, so that neither read-only permissions nor
+	// the changes to their modification times get in the way.
+	for i := len(restoreContext.directories) - 1; i >= 0; i-- {
+		dir := restoreContext.directories[i]
+		if restoreContext.filtering {
+			if _, ok := restoreContext.needed[dir.entrypath]; !ok {
+				continue
+			}
+			if dir.exists && opts.Mode == RestoreSkip {
+				snap.Event(events.FileSkippedEvent(snap.Header.Identifier, dir.entrypath, restoreSkipExists))
+				continue
+			}
+			if opts.DryRun {
+				if !dir.exists {
+					snap.Event(events.FileDryRunEvent(snap.Header.Identifier, dir.entrypath, restoreActionCreate))
+				}
+				continue
+			}
+			if err := exp.CreateDirectory(dir.dest); err != nil {
+				snap.Event(events.DirectoryErrorEvent(snap.Header.Identifier, dir.entrypath, err.Error()))
+				continue
+			}
+		}
+		if err := restoreMetadata(fs, exp, dir.dest, dir.entry, opts); err != nil {
+			snap.Event(events.DirectoryErrorEvent(snap.Header.Identifier, dir.entrypath, err.Error()))
+		} else {
+			snap.Event(events.DirectoryOKEvent(snap.Header.Identifier, dir.entrypath))
+		}
+	}
 
-	return fs.WalkDir(pathname, snapshotRestorePath(snap, exp, base, opts, restoreContext, &wg))
+	return err
 }
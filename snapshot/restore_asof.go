@@ -0,0 +1,163 @@
+package snapshot
+
+import (
+	"errors"
+	iofs "io/fs"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/PlakarKorp/plakar/events"
+	"github.com/PlakarKorp/plakar/resources"
+	"github.com/PlakarKorp/plakar/snapshot/exporter"
+	"github.com/PlakarKorp/plakar/snapshot/vfs"
+)
+
+// intact tells whether the content of a file is still available in the
+// repository.  Only the presence of its chunks is checked, as by a fast
+// check, unless verify is set: they are then read and their MACs
+// checked, so that corrupted content is noticed too.
+func (snap *Snapshot) intact(e *vfs.Entry, verify bool) bool {
+	if !e.Stat().Mode().IsRegular() || !e.HasObject() {
+		return true
+	}
+
+	object, err := snap.LookupObject(e.Object)
+	if err != nil {
+		return false
+	}
+	for _, chunk := range object.Chunks {
+		if chunk.IsHole() {
+			continue
+		}
+		if !snap.BlobExists(resources.RT_CHUNK, chunk.ContentMAC) {
+			return false
+		}
+		if verify {
+			data, err := snap.GetBlob(resources.RT_CHUNK, chunk.ContentMAC)
+			if err != nil || snap.repository.ComputeMAC(data) != chunk.ContentMAC {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// RestoreAsOf restores pathname as a tree merged from several
+// snapshots, given newest first: each file comes from the newest
+// snapshot holding an intact version of it, and its events are
+// reported by that snapshot.  A version is intact if none of its
+// chunks is missing, and with opts.Check if none is corrupted either.
+// The snapshots may be views on different sources: files are matched by their path relative to the directory
+// of their source, which is stripped when restoring them, and a
+// relative pathname is looked up below it.
+func RestoreAsOf(snapshots []*Snapshot, exp exporter.Exporter, base string, pathname string, opts *RestoreOptions) error {
+	if len(snapshots) == 0 {
+		return iofs.ErrNotExist
+	}
+
+	newest := snapshots[0]
+	newest.Event(events.StartEvent())
+	defer newest.Event(events.DoneEvent())
+
+	strips := make([]string, len(snapshots))
+	roots := make([]string, len(snapshots))
+	for i, snap := range snapshots {
+		strips[i] = snap.Source().Importer.Directory
+		roots[i] = pathname
+		if !strings.HasPrefix(pathname, "/") {
+			roots[i] = path.Join(strips[i], pathname)
+		}
+	}
+
+	maxConcurrency := opts.MaxConcurrency
+	if maxConcurrency == 0 {
+		maxConcurrency = uint64(newest.AppContext().MaxConcurrency)
+	}
+
+	type candidate struct {
+		rel   string
+		entry *vfs.Entry
+	}
+
+	owners := make(map[string]int)
+	owned := make([]int, len(snapshots))
+	for i, snap := range snapshots {
+		fs, err := snap.Filesystem()
+		if err != nil {
+			return err
+		}
+
+		var candidates []candidate
+		err = fs.WalkDir(roots[i], func(entrypath string, e *vfs.Entry, err error) error {
+			// errors are reported when restoring
+			if err != nil {
+				return nil
+			}
+			if entrypath != "/" && opts.excluded(entrypath) {
+				if e.IsDir() {
+					return iofs.SkipDir
+				}
+				return nil
+			}
+			if e.IsDir() {
+				return nil
+			}
+			rel := relativePath(entrypath, strips[i])
+			if _, ok := owners[rel]; !ok {
+				candidates = append(candidates, candidate{rel: rel, entry: e})
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, iofs.ErrNotExist) {
+			return err
+		}
+
+		intact := make([]bool, len(candidates))
+		concurrency := make(chan bool, maxConcurrency)
+		wg := sync.WaitGroup{}
+		for j, c := range candidates {
+			concurrency <- true
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-concurrency }()
+				intact[j] = snap.intact(c.entry, opts.Check)
+			}()
+		}
+		wg.Wait()
+
+		for j, c := range candidates {
+			if intact[j] {
+				owners[c.rel] = i
+				owned[i]++
+			}
+		}
+	}
+
+	// oldest first, so that newer directory metadata prevails
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if owned[i] == 0 {
+			continue
+		}
+
+		passOpts := *opts
+		passOpts.Strip = strips[i]
+		passOpts.pick = func(entrypath string) bool {
+			owner, ok := owners[relativePath(entrypath, strips[i])]
+			return ok && owner == i
+		}
+		if err := snapshots[i].restore(exp, base, roots[i], &passOpts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// relativePath returns pathname relative to the directory of its source.
+func relativePath(pathname, strip string) string {
+	if strip == "/" || !pathIsBelow(pathname, strip) {
+		return pathname
+	}
+	return "/" + strings.TrimPrefix(strings.TrimPrefix(pathname, strip), "/")
+}
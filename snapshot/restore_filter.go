@@ -0,0 +1,99 @@
+package snapshot
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/PlakarKorp/plakar/snapshot/vfs"
+	"github.com/gobwas/glob"
+)
+
+// regexpGlob lets regular expressions be used where globs are.
+type regexpGlob struct {
+	re *regexp.Regexp
+}
+
+func (g regexpGlob) Match(pathname string) bool {
+	return g.re.MatchString(pathname)
+}
+
+// CompileRegexp compiles a regular expression into a glob.Glob, so it
+// can be mixed with glob patterns in include and exclude lists.
+func CompileRegexp(expr string) (glob.Glob, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return regexpGlob{re: re}, nil
+}
+
+// pathIsBelow tells whether pathname is prefix or one of its children.
+func pathIsBelow(pathname, prefix string) bool {
+	return prefix == "/" || pathname == prefix || strings.HasPrefix(pathname, prefix+"/")
+}
+
+// filtering tells whether only some of the files are restored, in
+// which case directories are only created if they end up with content.
+func (opts *RestoreOptions) filtering() bool {
+	if len(opts.Includes) != 0 || opts.pick != nil {
+		return true
+	}
+	if f := opts.Filters; f != nil {
+		return f.Mime != "" || f.MinSize != 0 || f.MaxSize != 0 || !f.Since.IsZero() || !f.Before.IsZero()
+	}
+	return false
+}
+
+func (opts *RestoreOptions) excluded(entrypath string) bool {
+	for _, exclude := range opts.Excludes {
+		if exclude.Match(entrypath) {
+			return true
+		}
+	}
+	return false
+}
+
+// selected tells whether a file passes the include and search
+// filters, directories are always traversed.
+func (opts *RestoreOptions) selected(entrypath string, e *vfs.Entry) bool {
+	if opts.pick != nil && !opts.pick(entrypath) {
+		return false
+	}
+	if len(opts.Includes) != 0 {
+		found := false
+		for _, include := range opts.Includes {
+			if include.Match(entrypath) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if opts.Filters != nil && !opts.Filters.matchentry(e) {
+		return false
+	}
+	return true
+}
+
+// destination maps a snapshot pathname to where it is restored: the
+// longest matching Rename prefix is replaced, otherwise Strip is
+// removed from it.
+func (opts *RestoreOptions) destination(target, entrypath string) string {
+	var from, to string
+	for f, t := range opts.Rename {
+		if pathIsBelow(entrypath, f) && len(f) >= len(from) {
+			from, to = f, t
+		}
+	}
+	if from != "" {
+		rel := entrypath
+		if from != "/" {
+			rel = strings.TrimPrefix(entrypath, from)
+		}
+		return path.Join(target, to, rel)
+	}
+	return path.Join(target, strings.TrimPrefix(entrypath, opts.Strip))
+}
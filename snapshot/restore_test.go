@@ -3,11 +3,17 @@ package snapshot
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/PlakarKorp/plakar/resources"
 	"github.com/PlakarKorp/plakar/snapshot/exporter"
 	_ "github.com/PlakarKorp/plakar/snapshot/exporter/fs"
+	fsimporter "github.com/PlakarKorp/plakar/snapshot/importer/fs"
+	"github.com/PlakarKorp/plakar/storage"
+	"github.com/gobwas/glob"
 	"github.com/stretchr/testify/require"
 )
 
@@ -57,3 +63,306 @@ func TestRestore(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, "hello", string(contents))
 }
+
+func restoreTo(t *testing.T, snap *Snapshot, dir string, opts *RestoreOptions) {
+	exp, err := exporter.NewExporter(map[string]string{"location": dir})
+	require.NoError(t, err)
+	defer exp.Close()
+
+	opts.MaxConcurrency = 1
+	opts.Strip = snap.Header.GetSource(0).Importer.Directory
+	require.NoError(t, snap.Restore(exp, exp.Root(), opts.Strip, opts))
+}
+
+func TestRestoreModes(t *testing.T) {
+	mtime := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	snap := generateSnapshotFrom(t, nil, func(dir string) {
+		for name, content := range map[string]string{"a.txt": "alpha", "b.txt": "bravo"} {
+			require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+			require.NoError(t, os.Chtimes(filepath.Join(dir, name), mtime, mtime))
+		}
+	})
+	defer snap.Close()
+
+	tmpRestoreDir := t.TempDir()
+	a := filepath.Join(tmpRestoreDir, "a.txt")
+	b := filepath.Join(tmpRestoreDir, "b.txt")
+	restoreTo(t, snap, tmpRestoreDir, &RestoreOptions{})
+
+	readFile := func(pathname string) string {
+		content, err := os.ReadFile(pathname)
+		require.NoError(t, err)
+		return string(content)
+	}
+
+	// same size and mtime but different content: only verify notices
+	require.NoError(t, os.WriteFile(a, []byte("ALPHA"), 0644))
+	require.NoError(t, os.Chtimes(a, mtime, mtime))
+	// same content but a different mtime: update compares the content
+	later := mtime.Add(time.Hour)
+	require.NoError(t, os.Chtimes(b, later, later))
+
+	restoreTo(t, snap, tmpRestoreDir, &RestoreOptions{Mode: RestoreSkip})
+	require.Equal(t, "ALPHA", readFile(a))
+	fi, err := os.Stat(b)
+	require.NoError(t, err)
+	require.True(t, fi.ModTime().Equal(later))
+
+	restoreTo(t, snap, tmpRestoreDir, &RestoreOptions{Mode: RestoreUpdate})
+	require.Equal(t, "ALPHA", readFile(a))
+	fi, err = os.Stat(b)
+	require.NoError(t, err)
+	require.True(t, fi.ModTime().Equal(mtime))
+
+	restoreTo(t, snap, tmpRestoreDir, &RestoreOptions{Mode: RestoreVerify})
+	require.Equal(t, "alpha", readFile(a))
+	require.Equal(t, "bravo", readFile(b))
+
+	require.NoError(t, os.WriteFile(a, []byte("changed"), 0644))
+	restoreTo(t, snap, tmpRestoreDir, &RestoreOptions{Mode: RestoreUpdate})
+	require.Equal(t, "alpha", readFile(a))
+}
+
+func TestRestoreDryRun(t *testing.T) {
+	snap := generateSnapshot(t, nil)
+	defer snap.Close()
+
+	tmpRestoreDir := t.TempDir()
+	restoreTo(t, snap, tmpRestoreDir, &RestoreOptions{DryRun: true})
+
+	files, err := os.ReadDir(tmpRestoreDir)
+	require.NoError(t, err)
+	require.Empty(t, files)
+
+	dummy := filepath.Join(tmpRestoreDir, "dummy.txt")
+	require.NoError(t, os.WriteFile(dummy, []byte("world"), 0600))
+	restoreTo(t, snap, tmpRestoreDir, &RestoreOptions{DryRun: true, Mode: RestoreVerify})
+
+	content, err := os.ReadFile(dummy)
+	require.NoError(t, err)
+	require.Equal(t, "world", string(content))
+}
+
+func TestParseRestoreMode(t *testing.T) {
+	mode, err := ParseRestoreMode("verify")
+	require.NoError(t, err)
+	require.Equal(t, RestoreVerify, mode)
+
+	_, err = ParseRestoreMode("merge")
+	require.ErrorIs(t, err, ErrInvalidRestoreMode)
+}
+
+func TestRestoreFilters(t *testing.T) {
+	snap := generateSnapshotFrom(t, nil, func(dir string) {
+		for name, content := range map[string]string{
+			"etc/a.conf":      "a",
+			"etc/b.txt":       "b",
+			"etc/skip/c.conf": "c",
+			"var/big.conf":    strings.Repeat("x", 4096),
+			"var/empty/.keep": "",
+		} {
+			require.NoError(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755))
+			require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		}
+	})
+	defer snap.Close()
+
+	root := snap.Header.GetSource(0).Importer.Directory
+
+	include, err := glob.Compile("**.conf")
+	require.NoError(t, err)
+	exclude, err := CompileRegexp("/skip$")
+	require.NoError(t, err)
+
+	tmpRestoreDir := t.TempDir()
+	restoreTo(t, snap, tmpRestoreDir, &RestoreOptions{
+		Includes: []glob.Glob{include},
+		Excludes: []glob.Glob{exclude},
+		Filters:  &SearchOpts{MaxSize: 1024},
+		Rename:   map[string]string{root + "/etc": "/conf"},
+	})
+
+	var restored []string
+	err = filepath.WalkDir(tmpRestoreDir, func(pathname string, d os.DirEntry, err error) error {
+		require.NoError(t, err)
+		rel, err := filepath.Rel(tmpRestoreDir, pathname)
+		require.NoError(t, err)
+		restored = append(restored, filepath.ToSlash(rel))
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{".", "conf", "conf/a.conf"}, restored)
+
+	// existing directories are left alone when skipping
+	conf := filepath.Join(tmpRestoreDir, "conf")
+	require.NoError(t, os.Chmod(conf, 0700))
+	require.NoError(t, os.Remove(filepath.Join(conf, "a.conf")))
+	restoreTo(t, snap, tmpRestoreDir, &RestoreOptions{
+		Mode:     RestoreSkip,
+		Includes: []glob.Glob{include},
+		Rename:   map[string]string{root + "/etc": "/conf"},
+	})
+	require.FileExists(t, filepath.Join(conf, "a.conf"))
+	fi, err := os.Stat(conf)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0700), fi.Mode().Perm())
+}
+
+func TestRestoreDestination(t *testing.T) {
+	opts := &RestoreOptions{
+		Strip:  "/home/op",
+		Rename: map[string]string{"/home/op/etc": "/conf", "/home/op/etc/nginx": "/nginx"},
+	}
+	require.Equal(t, "/tmp/x/file", opts.destination("/tmp/x", "/home/op/file"))
+	require.Equal(t, "/tmp/x/conf/a", opts.destination("/tmp/x", "/home/op/etc/a"))
+	require.Equal(t, "/tmp/x/nginx/b", opts.destination("/tmp/x", "/home/op/etc/nginx/b"))
+	require.Equal(t, "/tmp/x/etcetera", opts.destination("/tmp/x", "/home/op/etcetera"))
+}
+
+func TestRestoreAsOf(t *testing.T) {
+	older := generateSnapshotFrom(t, nil, func(dir string) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("old"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "removed.txt"), []byte("gone"), 0644))
+	})
+	defer older.Close()
+
+	dir := older.Header.GetSource(0).Importer.Directory
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("new"), 0644))
+	require.NoError(t, os.Remove(filepath.Join(dir, "removed.txt")))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b.txt"), []byte("bee"), 0644))
+
+	newer, err := New(older.repository)
+	require.NoError(t, err)
+	defer newer.Close()
+	imp, err := fsimporter.NewFSImporter(map[string]string{"location": dir})
+	require.NoError(t, err)
+	require.NoError(t, newer.Backup(imp, &BackupOptions{Name: "test_backup", MaxConcurrency: 1}))
+
+	tmpRestoreDir := t.TempDir()
+	exp, err := exporter.NewExporter(map[string]string{"location": tmpRestoreDir})
+	require.NoError(t, err)
+	defer exp.Close()
+
+	opts := &RestoreOptions{MaxConcurrency: 1, Strip: dir}
+	require.NoError(t, RestoreAsOf([]*Snapshot{newer, older}, exp, exp.Root(), dir, opts))
+
+	for name, expected := range map[string]string{"a.txt": "new", "b.txt": "bee", "removed.txt": "gone"} {
+		content, err := os.ReadFile(filepath.Join(tmpRestoreDir, name))
+		require.NoError(t, err)
+		require.Equal(t, expected, string(content))
+	}
+}
+
+func TestRestoreAsOfCorrupted(t *testing.T) {
+	older := generateSnapshotFrom(t, nil, func(dir string) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("old"), 0644))
+	})
+	defer older.Close()
+
+	dir := older.Header.GetSource(0).Importer.Directory
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("newer content"), 0644))
+
+	newer, err := New(older.repository)
+	require.NoError(t, err)
+	defer newer.Close()
+	imp, err := fsimporter.NewFSImporter(map[string]string{"location": dir})
+	require.NoError(t, err)
+	require.NoError(t, newer.Backup(imp, &BackupOptions{Name: "test_backup", MaxConcurrency: 1}))
+
+	// damage the chunk of the newer version in its packfile
+	fs, err := newer.Filesystem()
+	require.NoError(t, err)
+	entry, err := fs.GetEntry(filepath.ToSlash(filepath.Join(dir, "a.txt")))
+	require.NoError(t, err)
+	object, err := newer.LookupObject(entry.Object)
+	require.NoError(t, err)
+	require.Len(t, object.Chunks, 1)
+
+	repo := newer.repository
+	packfileMAC, exists, err := repo.GetPackfileForBlob(resources.RT_CHUNK, object.Chunks[0].ContentMAC)
+	require.NoError(t, err)
+	require.True(t, exists)
+	pack, err := repo.GetPackfile(packfileMAC)
+	require.NoError(t, err)
+
+	var offset uint64
+	for _, blob := range pack.Index {
+		if blob.Type == resources.RT_CHUNK && blob.MAC == object.Chunks[0].ContentMAC {
+			offset = uint64(storage.STORAGE_HEADER_SIZE) + blob.Offset + uint64(blob.Length)/2
+		}
+	}
+	require.NotZero(t, offset)
+
+	packPath := filepath.Join(strings.TrimPrefix(repo.Location(), "fs://"), "packfiles",
+		fmt.Sprintf("%02x", packfileMAC[0]), fmt.Sprintf("%064x", packfileMAC))
+	data, err := os.ReadFile(packPath)
+	require.NoError(t, err)
+	data[offset] ^= 0xff
+	require.NoError(t, os.WriteFile(packPath, data, 0644))
+
+	restore := func(check bool) string {
+		tmpRestoreDir := t.TempDir()
+		exp, err := exporter.NewExporter(map[string]string{"location": tmpRestoreDir})
+		require.NoError(t, err)
+		defer exp.Close()
+
+		opts := &RestoreOptions{MaxConcurrency: 1, Strip: dir, Check: check}
+		require.NoError(t, RestoreAsOf([]*Snapshot{newer, older}, exp, exp.Root(), dir, opts))
+
+		content, err := os.ReadFile(filepath.Join(tmpRestoreDir, "a.txt"))
+		if err != nil {
+			return ""
+		}
+		return string(content)
+	}
+
+	// only a check notices the damage
+	require.NotEqual(t, "old", restore(false))
+	require.Equal(t, "old", restore(true))
+}
+
+func TestRestoreAsOfDirectories(t *testing.T) {
+	older := generateSnapshotFrom(t, nil, func(dir string) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("old"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "kept.txt"), []byte("kept"), 0644))
+	})
+	defer older.Close()
+
+	// the same tree, backed up from elsewhere
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("new"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "b.txt"), []byte("bee"), 0644))
+
+	newer, err := New(older.repository)
+	require.NoError(t, err)
+	defer newer.Close()
+	imp, err := fsimporter.NewFSImporter(map[string]string{"location": dir})
+	require.NoError(t, err)
+	require.NoError(t, newer.Backup(imp, &BackupOptions{Name: "test_backup", MaxConcurrency: 1}))
+
+	tmpRestoreDir := t.TempDir()
+	exp, err := exporter.NewExporter(map[string]string{"location": tmpRestoreDir})
+	require.NoError(t, err)
+	defer exp.Close()
+
+	opts := &RestoreOptions{MaxConcurrency: 1}
+	require.NoError(t, RestoreAsOf([]*Snapshot{newer, older}, exp, exp.Root(), ".", opts))
+
+	var restored []string
+	err = filepath.WalkDir(tmpRestoreDir, func(pathname string, d os.DirEntry, err error) error {
+		require.NoError(t, err)
+		if !d.IsDir() {
+			rel, err := filepath.Rel(tmpRestoreDir, pathname)
+			require.NoError(t, err)
+			restored = append(restored, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"a.txt", "kept.txt", "sub/b.txt"}, restored)
+
+	content, err := os.ReadFile(filepath.Join(tmpRestoreDir, "a.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "new", string(content))
+}
@@ -0,0 +1,240 @@
+//go:build !windows
+// +build !windows
+
+package snapshot
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/plakar/snapshot/exporter"
+	_ "github.com/PlakarKorp/plakar/snapshot/exporter/fs"
+	"github.com/pkg/xattr"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	fidelityMtime = time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	fidelityAtime = time.Date(2024, 6, 7, 8, 9, 10, 0, time.UTC)
+)
+
+func populateFidelity(t *testing.T, dir string) bool {
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "sub"), 0750))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "ro"), 0700))
+
+	file := filepath.Join(dir, "sub", "file.txt")
+	require.NoError(t, os.WriteFile(file, []byte("fidelity"), 0640))
+	hasXattrs := xattr.LSet(file, "user.plakar", []byte("value")) == nil
+
+	require.NoError(t, os.Link(file, filepath.Join(dir, "sub", "hard.txt")))
+	require.NoError(t, os.Symlink("file.txt", filepath.Join(dir, "sub", "link")))
+	require.NoError(t, unix.Mkfifo(filepath.Join(dir, "fifo"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ro", "inner"), []byte("inner"), 0400))
+
+	if os.Getuid() == 0 {
+		require.NoError(t, os.Lchown(file, 1234, 5678))
+	}
+
+	require.NoError(t, os.Chtimes(file, fidelityAtime, fidelityMtime))
+	require.NoError(t, unix.Lutimes(filepath.Join(dir, "sub", "link"), []unix.Timeval{
+		unix.NsecToTimeval(fidelityAtime.UnixNano()),
+		unix.NsecToTimeval(fidelityMtime.UnixNano()),
+	}))
+	require.NoError(t, os.Chmod(filepath.Join(dir, "ro"), 0555))
+	for _, d := range []string{"sub", "ro"} {
+		require.NoError(t, os.Chtimes(filepath.Join(dir, d), fidelityAtime, fidelityMtime))
+	}
+
+	t.Cleanup(func() {
+		os.Chmod(filepath.Join(dir, "ro"), 0700)
+	})
+	return hasXattrs
+}
+
+func restoreFidelity(t *testing.T, snap *Snapshot, opts *RestoreOptions) string {
+	tmpRestoreDir := t.TempDir()
+	t.Cleanup(func() {
+		os.Chmod(filepath.Join(tmpRestoreDir, "ro"), 0700)
+	})
+
+	exp, err := exporter.NewExporter(map[string]string{"location": tmpRestoreDir})
+	require.NoError(t, err)
+	defer exp.Close()
+
+	opts.MaxConcurrency = 2
+	opts.Strip = snap.Header.GetSource(0).Importer.Directory
+	require.NoError(t, snap.Restore(exp, exp.Root(), opts.Strip, opts))
+	return tmpRestoreDir
+}
+
+func TestRestoreFidelity(t *testing.T) {
+	var hasXattrs bool
+	snap := generateSnapshotFrom(t, nil, func(dir string) {
+		hasXattrs = populateFidelity(t, dir)
+	})
+	defer snap.Close()
+
+	restored := restoreFidelity(t, snap, &RestoreOptions{})
+
+	file := filepath.Join(restored, "sub", "file.txt")
+	content, err := os.ReadFile(file)
+	require.NoError(t, err)
+	require.Equal(t, "fidelity", string(content))
+
+	fi, err := os.Lstat(file)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0640), fi.Mode())
+	require.True(t, fi.ModTime().Equal(fidelityMtime))
+	stat := fi.Sys().(*syscall.Stat_t)
+	if os.Getuid() == 0 {
+		require.Equal(t, uint32(1234), stat.Uid)
+		require.Equal(t, uint32(5678), stat.Gid)
+	}
+	if hasXattrs {
+		value, err := xattr.LGet(file, "user.plakar")
+		require.NoError(t, err)
+		require.Equal(t, "value", string(value))
+	}
+
+	hard, err := os.Lstat(filepath.Join(restored, "sub", "hard.txt"))
+	require.NoError(t, err)
+	require.True(t, os.SameFile(fi, hard))
+
+	link := filepath.Join(restored, "sub", "link")
+	target, err := os.Readlink(link)
+	require.NoError(t, err)
+	require.Equal(t, "file.txt", target)
+	fi, err = os.Lstat(link)
+	require.NoError(t, err)
+	require.True(t, fi.ModTime().Equal(fidelityMtime))
+
+	fi, err = os.Lstat(filepath.Join(restored, "fifo"))
+	require.NoError(t, err)
+	require.NotZero(t, fi.Mode()&os.ModeNamedPipe)
+	require.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	for dir, mode := range map[string]os.FileMode{"sub": 0750, "ro": 0555} {
+		fi, err = os.Lstat(filepath.Join(restored, dir))
+		require.NoError(t, err)
+		require.Equal(t, os.ModeDir|mode, fi.Mode())
+		require.True(t, fi.ModTime().Equal(fidelityMtime), dir)
+	}
+
+	content, err = os.ReadFile(filepath.Join(restored, "ro", "inner"))
+	require.NoError(t, err)
+	require.Equal(t, "inner", string(content))
+}
+
+func TestRestoreSkipOwnershipAndXattrs(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("ownership can only be restored by root")
+	}
+
+	var hasXattrs bool
+	snap := generateSnapshotFrom(t, nil, func(dir string) {
+		hasXattrs = populateFidelity(t, dir)
+	})
+	defer snap.Close()
+
+	restored := restoreFidelity(t, snap, &RestoreOptions{SkipOwnership: true, SkipXattrs: true})
+
+	file := filepath.Join(restored, "sub", "file.txt")
+	fi, err := os.Lstat(file)
+	require.NoError(t, err)
+	require.Equal(t, uint32(0), fi.Sys().(*syscall.Stat_t).Uid)
+	require.True(t, fi.ModTime().Equal(fidelityMtime))
+
+	if hasXattrs {
+		_, err := xattr.LGet(file, "user.plakar")
+		require.Error(t, err)
+	}
+}
+
+func TestRestoreSparse(t *testing.T) {
+	const size = 8 << 20
+	data := bytes.Repeat([]byte("sparse!!"), 512)
+
+	var sparse bool
+	snap := generateSnapshotFrom(t, nil, func(dir string) {
+		fp, err := os.Create(filepath.Join(dir, "sparse"))
+		require.NoError(t, err)
+		defer fp.Close()
+
+		require.NoError(t, fp.Truncate(size))
+		for _, off := range []int64{1 << 20, 5 << 20} {
+			_, err := fp.WriteAt(data, off)
+			require.NoError(t, err)
+		}
+
+		info, err := fp.Stat()
+		require.NoError(t, err)
+		sparse = info.Sys().(*syscall.Stat_t).Blocks*512 < size
+	})
+	defer snap.Close()
+	if !sparse {
+		t.Skip("filesystem does not support sparse files")
+	}
+
+	expected := make([]byte, size)
+	copy(expected[1<<20:], data)
+	copy(expected[5<<20:], data)
+
+	pathname := path.Join(snap.Header.GetSource(0).Importer.Directory, "sparse")
+	fs, err := snap.Filesystem()
+	require.NoError(t, err)
+	entry, err := fs.GetEntry(pathname)
+	require.NoError(t, err)
+	require.True(t, entry.ResolvedObject.Sparse())
+	require.Equal(t, int64(size), entry.ResolvedObject.Size())
+
+	// the holes are not part of the MAC, the chunks are
+	object := entry.ResolvedObject
+	require.Equal(t, sparseMAC(snap.repository.GetMACHasher(), object.Chunks), object.ContentMAC)
+	require.NotEqual(t, snap.repository.ComputeMAC(expected), object.ContentMAC)
+
+	ok, err := snap.Check(pathname, &CheckOptions{MaxConcurrency: 1})
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	rd, err := snap.NewReader(pathname)
+	require.NoError(t, err)
+	content, err := io.ReadAll(rd)
+	rd.Close()
+	require.NoError(t, err)
+	require.Equal(t, expected, content)
+
+	tmpRestoreDir := restoreFidelity(t, snap, &RestoreOptions{})
+	restored := filepath.Join(tmpRestoreDir, "sparse")
+	content, err = os.ReadFile(restored)
+	require.NoError(t, err)
+	require.Equal(t, expected, content)
+
+	info, err := os.Stat(restored)
+	require.NoError(t, err)
+	require.Less(t, info.Sys().(*syscall.Stat_t).Blocks*512, int64(size))
+
+	exp, err := exporter.NewExporter(map[string]string{"location": tmpRestoreDir})
+	require.NoError(t, err)
+	defer exp.Close()
+
+	same, err := snap.sameContent(exp, restored, entry)
+	require.NoError(t, err)
+	require.True(t, same)
+
+	fp, err := os.OpenFile(restored, os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = fp.WriteAt([]byte{1}, 3<<20)
+	require.NoError(t, err)
+	require.NoError(t, fp.Close())
+
+	same, err = snap.sameContent(exp, restored, entry)
+	require.NoError(t, err)
+	require.False(t, same)
+}
@@ -4,6 +4,7 @@ import (
 	"iter"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/PlakarKorp/plakar/snapshot/vfs"
 )
@@ -13,6 +14,13 @@ type SearchOpts struct {
 	Recursive bool
 	Prefix    string // prefix directory
 	Mime      string
+	Class     string // class or class prefix, e.g. "secret"
+
+	// entries bounds, ignored when zero
+	MinSize int64
+	MaxSize int64
+	Since   time.Time // modified at or after
+	Before  time.Time // modified before
 
 	// pagination
 	Offset int
@@ -36,6 +44,30 @@ func matchmime(match, target string) bool {
 	return true
 }
 
+// matchentry applies the filters other than the prefix.
+func (opts *SearchOpts) matchentry(entry *vfs.Entry) bool {
+	if !matchmime(opts.Mime, entry.ContentType()) {
+		return false
+	}
+	if opts.Class != "" && !entry.HasClass(opts.Class) {
+		return false
+	}
+	if opts.MinSize != 0 && entry.Size() < opts.MinSize {
+		return false
+	}
+	if opts.MaxSize != 0 && entry.Size() > opts.MaxSize {
+		return false
+	}
+	mtime := entry.Stat().ModTime()
+	if !opts.Since.IsZero() && mtime.Before(opts.Since) {
+		return false
+	}
+	if !opts.Before.IsZero() && !mtime.Before(opts.Before) {
+		return false
+	}
+	return true
+}
+
 func visitmimes(snap *Snapshot, opts *SearchOpts) (iter.Seq2[*vfs.Entry, error], error) {
 	idx, err := snap.ContentTypeIdx()
 	if err != nil {
@@ -153,7 +185,9 @@ func (snap *Snapshot) Search(opts *SearchOpts) (iter.Seq2[*vfs.Entry, error], er
 				return
 			}
 
-			// eventually other filters on entry, e.g. size or pattern
+			if !entry.IsDir() && !opts.matchentry(entry) {
+				continue
+			}
 
 			if opts.Recursive && entry.IsDir() {
 				continue
@@ -27,7 +27,8 @@ import (
 )
 
 var (
-	ErrNotFound = errors.New("snapshot not found")
+	ErrNotFound      = errors.New("snapshot not found")
+	ErrInvalidSource = errors.New("invalid snapshot source")
 )
 
 type Snapshot struct {
@@ -41,6 +42,9 @@ type Snapshot struct {
 
 	filesystem *vfs.Filesystem
 
+	// index of the source the filesystem and indexes are read from
+	source int
+
 	SkipDirs []string
 
 	Header *header.Header
@@ -149,6 +153,32 @@ func Fork(repo *repository.Repository, Identifier objects.MAC) (*Snapshot, error
 	return snap, nil
 }
 
+// WithSource returns a read-only view of the snapshot on its idx-th
+// source: its filesystem and indexes are those of that source.
+func (snap *Snapshot) WithSource(idx int) (*Snapshot, error) {
+	if idx < 0 || idx >= len(snap.Header.Sources) {
+		return nil, fmt.Errorf("%w: #%d", ErrInvalidSource, idx)
+	}
+	if idx == snap.source {
+		return snap, nil
+	}
+	return &Snapshot{
+		repository: snap.repository,
+		Header:     snap.Header,
+		source:     idx,
+	}, nil
+}
+
+// Source returns the header of the source the snapshot is viewed on.
+func (snap *Snapshot) Source() *header.Source {
+	return snap.Header.GetSource(snap.source)
+}
+
+// SourceIndex returns the index of the source the snapshot is viewed on.
+func (snap *Snapshot) SourceIndex() int {
+	return snap.source
+}
+
 func MakeSnapIdentifier() (objects.MAC, error) {
 	var identifier objects.MAC
 	n, err := rand.Read(identifier[:])
@@ -228,9 +258,16 @@ func getPackfileForBlobWithError(snap *Snapshot, res resources.Type, mac objects
 }
 
 func (snap *Snapshot) ListPackfiles() (iter.Seq2[objects.MAC, error], error) {
-	pvfs, err := snap.Filesystem()
-	if err != nil {
-		return nil, err
+	sources := make([]*Snapshot, 0, len(snap.Header.Sources))
+	for i := range snap.Header.Sources {
+		view, err := snap.WithSource(i)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := view.Filesystem(); err != nil {
+			return nil, err
+		}
+		sources = append(sources, view)
 	}
 
 	return func(yield func(objects.MAC, error) bool) {
@@ -244,109 +281,127 @@ func (snap *Snapshot) ListPackfiles() (iter.Seq2[objects.MAC, error], error) {
 			}
 		}
 
-		if !yield(getPackfileForBlobWithError(snap, resources.RT_VFS_BTREE, snap.Header.Sources[0].VFS.Root)) {
-			return
+		for _, view := range sources {
+			if !view.listSourcePackfiles(yield) {
+				return
+			}
 		}
+	}, nil
+}
 
-		/* Iterate over all the VFS, resolving both Nodes and actual VFS entries. */
-		fsIter := pvfs.IterNodes()
-		for fsIter.Next() {
-			macNode, node := fsIter.Current()
-			if !yield(getPackfileForBlobWithError(snap, resources.RT_VFS_NODE, macNode)) {
-				return
+// listSourcePackfiles yields the packfiles holding the data of the
+// source the snapshot is viewed on, and tells whether to go on.
+func (snap *Snapshot) listSourcePackfiles(yield func(objects.MAC, error) bool) bool {
+	pvfs, err := snap.Filesystem()
+	if err != nil {
+		return yield(objects.MAC{}, err)
+	}
+
+	if !yield(getPackfileForBlobWithError(snap, resources.RT_VFS_BTREE, snap.Source().VFS.Root)) {
+		return false
+	}
+
+	/* Iterate over all the VFS, resolving both Nodes and actual VFS entries. */
+	fsIter := pvfs.IterNodes()
+	for fsIter.Next() {
+		macNode, node := fsIter.Current()
+		if !yield(getPackfileForBlobWithError(snap, resources.RT_VFS_NODE, macNode)) {
+			return false
+		}
+
+		for _, entry := range node.Values {
+			if !yield(getPackfileForBlobWithError(snap, resources.RT_VFS_ENTRY, entry)) {
+				return false
 			}
 
-			for _, entry := range node.Values {
-				if !yield(getPackfileForBlobWithError(snap, resources.RT_VFS_ENTRY, entry)) {
-					return
+			vfsEntry, err := pvfs.ResolveEntry(entry)
+			if err != nil {
+				if !yield(objects.MAC{}, fmt.Errorf("Failed to resolve entry %x", entry)) {
+					return false
 				}
+			}
 
-				vfsEntry, err := pvfs.ResolveEntry(entry)
-				if err != nil {
-					if !yield(objects.MAC{}, fmt.Errorf("Failed to resolve entry %x", entry)) {
-						return
-					}
+			if vfsEntry.HasObject() {
+				if !yield(getPackfileForBlobWithError(snap, resources.RT_OBJECT, vfsEntry.Object)) {
+					return false
 				}
 
-				if vfsEntry.HasObject() {
-					if !yield(getPackfileForBlobWithError(snap, resources.RT_OBJECT, vfsEntry.Object)) {
-						return
+				for _, chunk := range vfsEntry.ResolvedObject.Chunks {
+					if chunk.IsHole() {
+						continue
 					}
-
-					for _, chunk := range vfsEntry.ResolvedObject.Chunks {
-						if !yield(getPackfileForBlobWithError(snap, resources.RT_CHUNK, chunk.ContentMAC)) {
-							return
-						}
+					if !yield(getPackfileForBlobWithError(snap, resources.RT_CHUNK, chunk.ContentMAC)) {
+						return false
 					}
-
 				}
 
 			}
 
 		}
 
-		if !yield(getPackfileForBlobWithError(snap, resources.RT_ERROR_BTREE, snap.Header.Sources[0].VFS.Errors)) {
-			return
+	}
+
+	if !yield(getPackfileForBlobWithError(snap, resources.RT_ERROR_BTREE, snap.Source().VFS.Errors)) {
+		return false
+	}
+	errIter := pvfs.IterErrorNodes()
+	for errIter.Next() {
+		macNode, node := errIter.Current()
+		if !yield(getPackfileForBlobWithError(snap, resources.RT_ERROR_NODE, macNode)) {
+			return false
 		}
-		errIter := pvfs.IterErrorNodes()
-		for errIter.Next() {
-			macNode, node := errIter.Current()
-			if !yield(getPackfileForBlobWithError(snap, resources.RT_ERROR_NODE, macNode)) {
-				return
-			}
 
-			for _, error := range node.Values {
-				if !yield(getPackfileForBlobWithError(snap, resources.RT_ERROR_ENTRY, error)) {
-					return
-				}
+		for _, error := range node.Values {
+			if !yield(getPackfileForBlobWithError(snap, resources.RT_ERROR_ENTRY, error)) {
+				return false
 			}
 		}
+	}
 
-		if !yield(getPackfileForBlobWithError(snap, resources.RT_XATTR_BTREE, snap.Header.Sources[0].VFS.Xattrs)) {
-			return
+	if !yield(getPackfileForBlobWithError(snap, resources.RT_XATTR_BTREE, snap.Source().VFS.Xattrs)) {
+		return false
+	}
+	xattrIter := pvfs.XattrNodes()
+	for xattrIter.Next() {
+		mac, node := xattrIter.Current()
+		if !yield(getPackfileForBlobWithError(snap, resources.RT_XATTR_NODE, mac)) {
+			return false
 		}
-		xattrIter := pvfs.XattrNodes()
-		for xattrIter.Next() {
-			mac, node := xattrIter.Current()
-			if !yield(getPackfileForBlobWithError(snap, resources.RT_XATTR_NODE, mac)) {
-				return
-			}
 
-			for _, error := range node.Values {
-				if !yield(getPackfileForBlobWithError(snap, resources.RT_XATTR_ENTRY, error)) {
-					return
-				}
+		for _, error := range node.Values {
+			if !yield(getPackfileForBlobWithError(snap, resources.RT_XATTR_ENTRY, error)) {
+				return false
 			}
 		}
+	}
 
-		// Lastly going over the indexes.
-		if !yield(getPackfileForBlobWithError(snap, resources.RT_BTREE_ROOT, snap.Header.GetSource(0).Indexes[0].Value)) {
-			return
-		}
-		rd, err := snap.Repository().GetBlob(resources.RT_BTREE_ROOT, snap.Header.GetSource(0).Indexes[0].Value)
-		if err != nil {
-			if !yield(objects.MAC{}, fmt.Errorf("Failed to load Index root entry %s", err)) {
-				return
-			}
+	// Lastly going over the indexes.
+	if !yield(getPackfileForBlobWithError(snap, resources.RT_BTREE_ROOT, snap.Source().Indexes[0].Value)) {
+		return false
+	}
+	rd, err := snap.Repository().GetBlob(resources.RT_BTREE_ROOT, snap.Source().Indexes[0].Value)
+	if err != nil {
+		if !yield(objects.MAC{}, fmt.Errorf("Failed to load Index root entry %s", err)) {
+			return false
 		}
+	}
 
-		store := repository.NewRepositoryStore[string, objects.MAC](snap.Repository(), resources.RT_BTREE_NODE)
-		tree, err := btree.Deserialize(rd, store, strings.Compare)
-		if err != nil {
-			if !yield(objects.MAC{}, fmt.Errorf("Failed to deserialize root entry %s", err)) {
-				return
-			}
+	store := repository.NewRepositoryStore[string, objects.MAC](snap.Repository(), resources.RT_BTREE_NODE)
+	tree, err := btree.Deserialize(rd, store, strings.Compare)
+	if err != nil {
+		if !yield(objects.MAC{}, fmt.Errorf("Failed to deserialize root entry %s", err)) {
+			return false
 		}
+	}
 
-		indexIter := tree.IterDFS()
-		for indexIter.Next() {
-			mac, _ := indexIter.Current()
-			if !yield(getPackfileForBlobWithError(snap, resources.RT_BTREE_NODE, mac)) {
-				return
-			}
+	indexIter := tree.IterDFS()
+	for indexIter.Next() {
+		mac, _ := indexIter.Current()
+		if !yield(getPackfileForBlobWithError(snap, resources.RT_BTREE_NODE, mac)) {
+			return false
 		}
-
-	}, nil
+	}
+	return true
 }
 
 func (snap *Snapshot) Lock() (chan bool, error) {
@@ -10,11 +10,14 @@ import (
 
 	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/plakar/caching"
+	"github.com/PlakarKorp/plakar/encryption"
 	"github.com/PlakarKorp/plakar/encryption/keypair"
 	"github.com/PlakarKorp/plakar/hashing"
 	"github.com/PlakarKorp/plakar/logging"
+	"github.com/PlakarKorp/plakar/objects"
 	"github.com/PlakarKorp/plakar/repository"
 	"github.com/PlakarKorp/plakar/resources"
+	"github.com/PlakarKorp/plakar/snapshot/importer"
 	"github.com/PlakarKorp/plakar/snapshot/importer/fs"
 	"github.com/PlakarKorp/plakar/storage"
 	bfs "github.com/PlakarKorp/plakar/storage/backends/fs"
@@ -24,6 +27,15 @@ import (
 )
 
 func generateSnapshot(t *testing.T, keyPair *keypair.KeyPair) *Snapshot {
+	return generateSnapshotFrom(t, keyPair, func(tmpBackupDir string) {
+		// create a temporary file to backup later
+		err := os.WriteFile(tmpBackupDir+"/dummy.txt", []byte("hello"), 0644)
+		require.NoError(t, err)
+	})
+}
+
+// generateSnapshotFrom backs up a temporary directory filled by populate.
+func generateSnapshotFrom(t *testing.T, keyPair *keypair.KeyPair, populate func(tmpBackupDir string)) *Snapshot {
 	// init temporary directories
 	tmpRepoDirRoot, err := os.MkdirTemp("", "tmp_repo")
 	require.NoError(t, err)
@@ -38,9 +50,7 @@ func generateSnapshot(t *testing.T, keyPair *keypair.KeyPair) *Snapshot {
 		os.RemoveAll(tmpBackupDir)
 		os.RemoveAll(tmpRepoDirRoot)
 	})
-	// create a temporary file to backup later
-	err = os.WriteFile(tmpBackupDir+"/dummy.txt", []byte("hello"), 0644)
-	require.NoError(t, err)
+	populate(tmpBackupDir)
 
 	// create a storage
 	r, err := bfs.NewStore(map[string]string{"location": "fs://" + tmpRepoDir})
@@ -128,3 +138,212 @@ func TestSnapshot(t *testing.T) {
 
 	require.NotEqual(t, snap.Header.Identifier, snap4.Header.Identifier)
 }
+
+func TestBackupSources(t *testing.T) {
+	snap := generateSnapshot(t, nil)
+	defer snap.Close()
+
+	dirs := []string{t.TempDir(), t.TempDir()}
+	require.NoError(t, os.WriteFile(dirs[0]+"/first.txt", []byte("first"), 0644))
+	require.NoError(t, os.WriteFile(dirs[1]+"/second.txt", []byte("second"), 0644))
+
+	multi, err := New(snap.repository)
+	require.NoError(t, err)
+	defer multi.Close()
+
+	imps := make([]importer.Importer, 0, len(dirs))
+	for _, dir := range dirs {
+		imp, err := fs.NewFSImporter(map[string]string{"location": dir})
+		require.NoError(t, err)
+		imps = append(imps, imp)
+	}
+	require.NoError(t, multi.BackupSources(imps, &BackupOptions{Name: "test_backup", MaxConcurrency: 1}))
+
+	require.NoError(t, snap.repository.RebuildState())
+	loaded, err := Load(snap.repository, multi.Header.Identifier)
+	require.NoError(t, err)
+	require.Len(t, loaded.Header.Sources, 2)
+
+	for i, name := range []string{"first.txt", "second.txt"} {
+		source, err := loaded.WithSource(i)
+		require.NoError(t, err)
+		require.Equal(t, i, source.SourceIndex())
+		require.Equal(t, dirs[i], source.Source().Importer.Directory)
+
+		pvfs, err := source.Filesystem()
+		require.NoError(t, err)
+		_, err = pvfs.GetEntry(dirs[i] + "/" + name)
+		require.NoError(t, err)
+	}
+
+	_, err = loaded.WithSource(2)
+	require.ErrorIs(t, err, ErrInvalidSource)
+
+	packfiles, err := loaded.ListPackfiles()
+	require.NoError(t, err)
+	for _, err := range packfiles {
+		require.NoError(t, err)
+	}
+}
+
+func TestBackupWriteOnly(t *testing.T) {
+	tmpRepoDir := t.TempDir() + "/repo"
+	tmpBackupDir := t.TempDir()
+	require.NoError(t, os.WriteFile(tmpBackupDir+"/dummy.txt", []byte("hello"), 0644))
+
+	// create an X25519 repository
+	config := storage.NewConfiguration()
+	secret, err := encryption.NewAsymmetricKey(config.Encryption)
+	require.NoError(t, err)
+	config.Encryption.Canary, err = encryption.DeriveCanary(config.Encryption, secret)
+	require.NoError(t, err)
+	serialized, err := config.ToBytes()
+	require.NoError(t, err)
+
+	hasher := hashing.GetMACHasher(storage.DEFAULT_HASHING_ALGORITHM, encryption.MACKey(secret))
+	wrappedConfigRd, err := storage.Serialize(hasher, resources.RT_CONFIG, versioning.GetCurrentVersion(resources.RT_CONFIG), bytes.NewReader(serialized))
+	require.NoError(t, err)
+	wrappedConfig, err := io.ReadAll(wrappedConfigRd)
+	require.NoError(t, err)
+	r, err := bfs.NewStore(map[string]string{"location": "fs://" + tmpRepoDir})
+	require.NoError(t, err)
+	require.NoError(t, r.Create(wrappedConfig))
+
+	open := func(secret []byte) *repository.Repository {
+		r, serializedConfig, err := storage.Open(map[string]string{"location": "fs://" + tmpRepoDir})
+		require.NoError(t, err)
+
+		ctx := appcontext.NewAppContext()
+		ctx.SetCache(caching.NewManager(t.TempDir()))
+		t.Cleanup(func() { ctx.GetCache().Close() })
+		ctx.SetLogger(logging.NewLogger(os.Stdout, os.Stderr))
+		ctx.SetSecret(secret)
+
+		repo, err := repository.New(ctx, r, serializedConfig)
+		require.NoError(t, err)
+		return repo
+	}
+
+	// a write-only client backs up, deduplicating against the states
+	// but unable to read the snapshots back
+	writeOnly := encryption.MACKey(secret)
+	require.True(t, config.Encryption.IsWriteOnly(writeOnly))
+	require.True(t, encryption.VerifyCanary(config.Encryption, writeOnly))
+
+	writer := open(writeOnly)
+	var snapshotIDs []objects.MAC
+	for range 2 {
+		snap, err := New(writer)
+		require.NoError(t, err)
+		imp, err := fs.NewFSImporter(map[string]string{"location": tmpBackupDir})
+		require.NoError(t, err)
+		require.NoError(t, snap.Backup(imp, &BackupOptions{Name: "test_backup", MaxConcurrency: 1}))
+		snapshotIDs = append(snapshotIDs, snap.Header.Identifier)
+		snap.Close()
+		require.NoError(t, writer.RebuildState())
+	}
+
+	_, err = Load(writer, snapshotIDs[0])
+	require.ErrorIs(t, err, encryption.ErrWriteOnly)
+
+	// the full secret reads them
+	reader := open(secret)
+	for _, snapshotID := range snapshotIDs {
+		snap, err := Load(reader, snapshotID)
+		require.NoError(t, err)
+
+		pvfs, err := snap.Filesystem()
+		require.NoError(t, err)
+		entry, err := pvfs.GetEntry(tmpBackupDir + "/dummy.txt")
+		require.NoError(t, err)
+		rd := entry.Open(pvfs, tmpBackupDir+"/dummy.txt")
+		data, err := io.ReadAll(rd)
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(data))
+		snap.Close()
+	}
+}
+
+func TestBackupAfterUpgrade(t *testing.T) {
+	tmpRepoDir := t.TempDir() + "/repo"
+	tmpBackupDir := t.TempDir()
+	require.NoError(t, os.WriteFile(tmpBackupDir+"/dummy.txt", []byte("hello"), 0644))
+
+	// create an LZ4 repository
+	config := storage.NewConfiguration()
+	config.Encryption = nil
+	serialized, err := config.ToBytes()
+	require.NoError(t, err)
+
+	hasher := hashing.GetHasher(storage.DEFAULT_HASHING_ALGORITHM)
+	wrappedConfigRd, err := storage.Serialize(hasher, resources.RT_CONFIG, versioning.GetCurrentVersion(resources.RT_CONFIG), bytes.NewReader(serialized))
+	require.NoError(t, err)
+	wrappedConfig, err := io.ReadAll(wrappedConfigRd)
+	require.NoError(t, err)
+	r, err := bfs.NewStore(map[string]string{"location": "fs://" + tmpRepoDir})
+	require.NoError(t, err)
+	require.NoError(t, r.Create(wrappedConfig))
+
+	open := func() *repository.Repository {
+		r, serializedConfig, err := storage.Open(map[string]string{"location": "fs://" + tmpRepoDir})
+		require.NoError(t, err)
+
+		ctx := appcontext.NewAppContext()
+		ctx.SetCache(caching.NewManager(t.TempDir()))
+		t.Cleanup(func() { ctx.GetCache().Close() })
+		ctx.SetLogger(logging.NewLogger(os.Stdout, os.Stderr))
+
+		repo, err := repository.New(ctx, r, serializedConfig)
+		require.NoError(t, err)
+		return repo
+	}
+
+	backup := func(repo *repository.Repository) objects.MAC {
+		snap, err := New(repo)
+		require.NoError(t, err)
+		defer snap.Close()
+		imp, err := fs.NewFSImporter(map[string]string{"location": tmpBackupDir})
+		require.NoError(t, err)
+		require.NoError(t, snap.Backup(imp, &BackupOptions{Name: "test_backup", MaxConcurrency: 1}))
+		return snap.Header.Identifier
+	}
+
+	repo := open()
+	snapshotIDs := []objects.MAC{backup(repo)}
+
+	// upgrade to uncompressed packfiles, then back up new content
+	effective := repo.EffectiveConfiguration()
+	upgrade := repository.NewUpgrade(&effective)
+	upgrade.Compression = nil
+	upgrade.Packfile.MaxSize = 1 << 20
+	require.NoError(t, repo.Upgrade(upgrade))
+	require.Nil(t, repo.EffectiveConfiguration().Compression)
+	require.NotNil(t, repo.Configuration().Compression)
+
+	require.NoError(t, os.WriteFile(tmpBackupDir+"/dummy.txt", []byte("hello again"), 0644))
+	snapshotIDs = append(snapshotIDs, backup(repo))
+
+	// another client picks the upgrade up from the state, and reads
+	// the blobs of both snapshots
+	reader := open()
+	require.Nil(t, reader.EffectiveConfiguration().Compression)
+	require.Equal(t, uint64(1<<20), reader.EffectiveConfiguration().Packfile.MaxSize)
+
+	for i, expected := range []string{"hello", "hello again"} {
+		snap, err := Load(reader, snapshotIDs[i])
+		require.NoError(t, err)
+
+		pvfs, err := snap.Filesystem()
+		require.NoError(t, err)
+		entry, err := pvfs.GetEntry(tmpBackupDir + "/dummy.txt")
+		require.NoError(t, err)
+		data, err := io.ReadAll(entry.Open(pvfs, tmpBackupDir+"/dummy.txt"))
+		require.NoError(t, err)
+		require.Equal(t, expected, string(data))
+		snap.Close()
+	}
+
+	// invalid settings are refused
+	upgrade.Chunking.MinSize = upgrade.Chunking.MaxSize + 1
+	require.Error(t, reader.Upgrade(upgrade))
+}
@@ -17,13 +17,21 @@ func persistObject(src, dst *Snapshot, object *objects.Object) (objects.MAC, err
 	newObject := *object
 	newObject.Chunks = make([]objects.Chunk, 0, len(object.Chunks))
 
+	sparse := object.Sparse()
 	for _, chunkRef := range object.Chunks {
+		if chunkRef.IsHole() {
+			newObject.Chunks = append(newObject.Chunks, chunkRef)
+			continue
+		}
+
 		chunk, err := src.GetBlob(resources.RT_CHUNK, chunkRef.ContentMAC)
 		if err != nil {
 			return objects.MAC{}, err
 		}
 
-		hasher.Write(chunk)
+		if !sparse {
+			hasher.Write(chunk)
+		}
 
 		chunkMAC := dst.Repository().ComputeMAC(chunk)
 		if !dst.BlobExists(resources.RT_CHUNK, chunkMAC) {
@@ -42,7 +50,11 @@ func persistObject(src, dst *Snapshot, object *objects.Object) (objects.MAC, err
 		})
 	}
 
-	newObject.ContentMAC = objects.MAC(hasher.Sum(nil))
+	if sparse {
+		newObject.ContentMAC = sparseMAC(hasher, newObject.Chunks)
+	} else {
+		newObject.ContentMAC = objects.MAC(hasher.Sum(nil))
+	}
 	serializedObject, err := newObject.Serialize()
 	if err != nil {
 		return objects.MAC{}, err
@@ -155,6 +167,26 @@ func (src *Snapshot) Synchronize(dst *Snapshot) error {
 		}
 	}
 
+	for len(dst.Header.Sources) < len(src.Header.Sources) {
+		dst.Header.Sources = append(dst.Header.Sources, header.NewSource())
+	}
+
+	for i := range src.Header.Sources {
+		view, err := src.WithSource(i)
+		if err != nil {
+			return err
+		}
+		if err := view.synchronizeSource(dst, dst.Header.GetSource(i)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// synchronizeSource copies the source src is viewed on into dst,
+// recording the resulting indexes in the given source header.
+func (src *Snapshot) synchronizeSource(dst *Snapshot, source *header.Source) error {
 	fs, err := src.Filesystem()
 	if err != nil {
 		return err
@@ -164,19 +196,19 @@ func (src *Snapshot) Synchronize(dst *Snapshot) error {
 
 	ctidx, err := btree.New(&btree.InMemoryStore[string, objects.MAC]{}, strings.Compare, 50)
 
-	dst.Header.GetSource(0).VFS.Root, err = persistIndex(dst, vfs, resources.RT_VFS_BTREE,
+	source.VFS.Root, err = persistIndex(dst, vfs, resources.RT_VFS_BTREE,
 		resources.RT_VFS_NODE, persistVFS(src, dst, fs, ctidx))
 	if err != nil {
 		return err
 	}
 
-	dst.Header.GetSource(0).VFS.Errors, err = persistIndex(dst, errors, resources.RT_ERROR_BTREE,
+	source.VFS.Errors, err = persistIndex(dst, errors, resources.RT_ERROR_BTREE,
 		resources.RT_ERROR_NODE, persistErrors(src, dst))
 	if err != nil {
 		return err
 	}
 
-	dst.Header.GetSource(0).VFS.Xattrs, err = persistIndex(dst, xattrs, resources.RT_XATTR_BTREE,
+	source.VFS.Xattrs, err = persistIndex(dst, xattrs, resources.RT_XATTR_BTREE,
 		resources.RT_XATTR_NODE, persistXattrs(src, dst, fs))
 	if err != nil {
 		return err
@@ -185,7 +217,7 @@ func (src *Snapshot) Synchronize(dst *Snapshot) error {
 	ctsum, err := persistIndex(dst, ctidx, resources.RT_BTREE_ROOT, resources.RT_BTREE_NODE, func(mac objects.MAC) (objects.MAC, error) {
 		return mac, nil
 	})
-	dst.Header.GetSource(0).Indexes = []header.Index{
+	source.Indexes = []header.Index{
 		{
 			Name: "content-type",
 			Type: "btree",
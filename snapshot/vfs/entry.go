@@ -148,6 +148,19 @@ func (e *Entry) AddClassification(analyzer string, classes []string) {
 	})
 }
 
+// HasClass reports whether an analyzer classified the entry as class,
+// see MatchClass.
+func (e *Entry) HasClass(class string) bool {
+	for _, classification := range e.Classifications {
+		for _, c := range classification.Classes {
+			if MatchClass(class, c) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (e *Entry) Open(fs *Filesystem, path string) fs.File {
 	if e.FileInfo.IsDir() {
 		return &vdir{
@@ -295,6 +308,13 @@ func (vf *vfile) Seek(offset int64, whence int) (int64, error) {
 	return vf.rd.Seek(offset, whence)
 }
 
+func (vf *vfile) DataExtents() []objects.Extent {
+	if vf.entry.ResolvedObject == nil {
+		return nil
+	}
+	return vf.rd.DataExtents()
+}
+
 func (vf *vfile) Close() error {
 	if vf.closed {
 		return fs.ErrClosed
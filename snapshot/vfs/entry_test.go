@@ -7,6 +7,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/PlakarKorp/plakar/snapshot/vfs"
 	"github.com/stretchr/testify/require"
 )
 
@@ -58,7 +59,7 @@ func TestVfile(t *testing.T) {
 
 	seeked, err := vFile.(io.ReadSeeker).Seek(2, io.SeekCurrent)
 	require.NoError(t, err)
-	require.Equal(t, int64(0), seeked)
+	require.Equal(t, int64(2), seeked)
 
 	dst := make([]byte, 10)
 	require.Implements(t, (*io.ReadSeeker)(nil), vFile)
@@ -130,3 +131,16 @@ func TestVdir(t *testing.T) {
 	require.NoError(t, nil, err)
 	require.Implements(t, (*iofs.FileInfo)(nil), fileinfo)
 }
+
+func TestHasClass(t *testing.T) {
+	entry := &vfs.Entry{}
+	entry.AddClassification("secrets", []string{"secret/aws-access-key", "pii/email"})
+
+	require.True(t, entry.HasClass("secret"))
+	require.True(t, entry.HasClass("secret/"))
+	require.True(t, entry.HasClass("secret/aws-access-key"))
+	require.True(t, entry.HasClass("pii/email"))
+	require.False(t, entry.HasClass("secret/aws"))
+	require.False(t, entry.HasClass("pii/iban"))
+	require.False(t, (&vfs.Entry{}).HasClass("secret"))
+}
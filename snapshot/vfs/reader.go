@@ -9,14 +9,17 @@ import (
 	"github.com/PlakarKorp/plakar/resources"
 )
 
+var errNegativeOffset = errors.New("seek: negative offset")
+
 type ObjectReader struct {
 	object *objects.Object
 	repo   *repository.Repository
 	size   int64
 
-	objoff int
-	off    int64
-	rd     io.ReadSeeker
+	objoff   int   // current chunk
+	chunkoff int64 // offset in the current chunk
+	off      int64
+	rd       io.ReadSeeker
 }
 
 func NewObjectReader(repo *repository.Repository, object *objects.Object, size int64) *ObjectReader {
@@ -27,24 +30,57 @@ func NewObjectReader(repo *repository.Repository, object *objects.Object, size i
 	}
 }
 
+// DataExtents returns the regions of the object which are not holes.
+func (or *ObjectReader) DataExtents() []objects.Extent {
+	return or.object.DataExtents()
+}
+
 func (or *ObjectReader) Read(p []byte) (int, error) {
-	for or.objoff < len(or.object.Chunks) {
+	chunks := or.object.Chunks
+
+	for or.objoff < len(chunks) {
+		chunk := &chunks[or.objoff]
+		if or.chunkoff >= int64(chunk.Length) {
+			or.objoff++
+			or.chunkoff = 0
+			or.rd = nil
+			continue
+		}
+
+		// holes are not stored
+		if chunk.IsHole() {
+			n := int(min(int64(len(p)), int64(chunk.Length)-or.chunkoff))
+			clear(p[:n])
+			or.chunkoff += int64(n)
+			or.off += int64(n)
+			return n, nil
+		}
+
 		if or.rd == nil {
-			rd, err := or.repo.GetBlob(resources.RT_CHUNK,
-				or.object.Chunks[or.objoff].ContentMAC)
+			rd, err := or.repo.GetBlob(resources.RT_CHUNK, chunk.ContentMAC)
 			if err != nil {
 				return -1, err
 			}
+			if or.chunkoff != 0 {
+				if _, err := rd.Seek(or.chunkoff, io.SeekStart); err != nil {
+					return -1, err
+				}
+			}
 			or.rd = rd
 		}
 
 		n, err := or.rd.Read(p)
+		or.chunkoff += int64(n)
+		or.off += int64(n)
 		if errors.Is(err, io.EOF) {
 			or.objoff++
+			or.chunkoff = 0
 			or.rd = nil
-			continue
+			if n == 0 {
+				continue
+			}
+			err = nil
 		}
-		or.off += int64(n)
 		return n, err
 	}
 
@@ -52,88 +88,30 @@ func (or *ObjectReader) Read(p []byte) (int, error) {
 }
 
 func (or *ObjectReader) Seek(offset int64, whence int) (int64, error) {
-	chunks := or.object.Chunks
-
 	switch whence {
 	case io.SeekStart:
-		or.rd = nil
-		or.off = 0
-		for or.objoff = 0; or.objoff < len(chunks); or.objoff++ {
-			clen := int64(chunks[or.objoff].Length)
-			if offset > clen {
-				or.off += clen
-				offset -= clen
-				continue
-			}
-			or.off += offset
-			rd, err := or.repo.GetBlob(resources.RT_CHUNK,
-				chunks[or.objoff].ContentMAC)
-			if err != nil {
-				return 0, err
-			}
-			if _, err := rd.Seek(offset, whence); err != nil {
-				return 0, err
-			}
-			or.rd = rd
-			break
-		}
-
-	case io.SeekEnd:
-		or.rd = nil
-		or.off = or.size
-		for or.objoff = len(chunks) - 1; or.objoff >= 0; or.objoff-- {
-			clen := int64(chunks[or.objoff].Length)
-			if offset > clen {
-				or.off -= clen
-				offset -= clen
-				continue
-			}
-			or.off -= offset
-			rd, err := or.repo.GetBlob(resources.RT_CHUNK,
-				chunks[or.objoff].ContentMAC)
-			if err != nil {
-				return 0, err
-			}
-			if _, err := rd.Seek(offset, whence); err != nil {
-				return 0, err
-			}
-			or.rd = rd
-			break
-		}
-
 	case io.SeekCurrent:
-		if or.rd != nil {
-			n, err := or.rd.Seek(offset, whence)
-			if err != nil {
-				return 0, err
-			}
-			diff := n - or.off
-			or.off += diff
-			offset -= diff
-		}
+		offset += or.off
+	case io.SeekEnd:
+		offset += or.size
+	default:
+		return 0, errors.New("seek: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errNegativeOffset
+	}
 
-		if offset == 0 {
+	// the chunk holding offset is only fetched when read
+	or.rd = nil
+	or.off = offset
+	or.chunkoff = offset
+	chunks := or.object.Chunks
+	for or.objoff = 0; or.objoff < len(chunks); or.objoff++ {
+		clen := int64(chunks[or.objoff].Length)
+		if or.chunkoff < clen {
 			break
 		}
-
-		or.objoff++
-		for or.objoff < len(chunks) {
-			clen := int64(chunks[or.objoff].Length)
-			if offset > clen {
-				or.off += clen
-				offset -= clen
-			}
-			or.off += offset
-			rd, err := or.repo.GetBlob(resources.RT_CHUNK,
-				chunks[or.objoff].ContentMAC)
-			if err != nil {
-				return 0, err
-			}
-			if _, err := rd.Seek(offset, whence); err != nil {
-				return 0, err
-			}
-			or.rd = rd
-		}
+		or.chunkoff -= clen
 	}
 
 	return or.off, nil
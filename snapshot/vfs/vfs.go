@@ -32,6 +32,12 @@ type Classification struct {
 	Scores   []Score  `msgpack:"scores" json:"scores"`
 }
 
+// MatchClass reports whether class is pattern or a class below it, such
+// as secret/aws-access-key for secret.
+func MatchClass(pattern, class string) bool {
+	return class == pattern || strings.HasPrefix(class, strings.TrimSuffix(pattern, "/")+"/")
+}
+
 type ExtendedAttribute struct {
 	Name  string `msgpack:"name" json:"name"`
 	Value []byte `msgpack:"value" json:"value"`
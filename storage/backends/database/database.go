@@ -145,6 +145,17 @@ func (s *Store) Create(config []byte) error {
 	return nil
 }
 
+func (s *Store) PutConfig(config []byte) error {
+	statement, err := s.conn.Prepare(`UPDATE configuration SET value = ?`)
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	_, err = statement.Exec(config)
+	return err
+}
+
 func (s *Store) Open() ([]byte, error) {
 	err := s.connect(s.location)
 	if err != nil {
@@ -18,8 +18,10 @@ package fs
 
 import (
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 
@@ -96,6 +98,14 @@ func (buckets *Buckets) Path(mac objects.MAC) string {
 		fmt.Sprintf("%064x", mac))
 }
 
+func (buckets *Buckets) Has(mac objects.MAC) (bool, error) {
+	_, err := os.Stat(buckets.Path(mac))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
 func (buckets *Buckets) Get(mac objects.MAC) (io.Reader, error) {
 	fp, err := os.Open(buckets.Path(mac))
 	if err != nil {
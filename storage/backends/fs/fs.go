@@ -104,6 +104,10 @@ func (s *Store) Create(config []byte) error {
 	return WriteToFileAtomic(s.Path("CONFIG"), bytes.NewReader(config))
 }
 
+func (s *Store) PutConfig(config []byte) error {
+	return WriteToFileAtomic(s.Path("CONFIG"), bytes.NewReader(config))
+}
+
 func (s *Store) Open() ([]byte, error) {
 
 	s.packfiles = NewBuckets(s.Path("packfiles"))
@@ -127,6 +131,10 @@ func (s *Store) GetPackfiles() ([]objects.MAC, error) {
 	return s.packfiles.List()
 }
 
+func (s *Store) HasPackfile(mac objects.MAC) (bool, error) {
+	return s.packfiles.Has(mac)
+}
+
 func (s *Store) GetPackfile(mac objects.MAC) (io.Reader, error) {
 	fp, err := s.packfiles.Get(mac)
 	if err != nil {
@@ -171,6 +179,10 @@ func (s *Store) PutState(mac objects.MAC, rd io.Reader) error {
 	return s.states.Put(mac, rd)
 }
 
+func (s *Store) HasState(mac objects.MAC) (bool, error) {
+	return s.states.Has(mac)
+}
+
 func (s *Store) GetState(mac objects.MAC) (io.Reader, error) {
 	return s.states.Get(mac)
 }
@@ -64,6 +64,13 @@ func TestFsBackend(t *testing.T) {
 	err = repo.DeleteState(mac1)
 	require.NoError(t, err)
 
+	exists, err := repo.(*Store).HasState(mac1)
+	require.NoError(t, err)
+	require.False(t, exists)
+	exists, err = repo.(*Store).HasState(mac2)
+	require.NoError(t, err)
+	require.True(t, exists)
+
 	states, err = repo.GetStates()
 	require.NoError(t, err)
 	expected = []objects.MAC{{0x30, 0x40, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0}}
@@ -94,6 +101,13 @@ func TestFsBackend(t *testing.T) {
 	err = repo.DeletePackfile(mac3)
 	require.NoError(t, err)
 
+	exists, err = repo.(*Store).HasPackfile(mac3)
+	require.NoError(t, err)
+	require.False(t, exists)
+	exists, err = repo.(*Store).HasPackfile(mac4)
+	require.NoError(t, err)
+	require.True(t, exists)
+
 	packfiles, err = repo.GetPackfiles()
 	require.NoError(t, err)
 	expected = []objects.MAC{{0x60, 0x70, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0}}
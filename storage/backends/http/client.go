@@ -18,10 +18,14 @@ package http
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"strings"
 
 	"github.com/PlakarKorp/plakar/network"
 	"github.com/PlakarKorp/plakar/objects"
@@ -32,6 +36,8 @@ type Store struct {
 	config     storage.Configuration
 	Repository string
 	location   string
+	token      string
+	client     *http.Client
 }
 
 func init() {
@@ -39,11 +45,56 @@ func init() {
 }
 
 func NewStore(storeConfig map[string]string) (storage.Store, error) {
+	client, err := newClient(storeConfig)
+	if err != nil {
+		return nil, err
+	}
 	return &Store{
 		location: storeConfig["location"],
+		token:    storeConfig["token"],
+		client:   client,
 	}, nil
 }
 
+// newClient returns a client trusting the servers signed by the tls_ca
+// certificates, if set, and presenting the tls_cert certificate to them.
+func newClient(storeConfig map[string]string) (*http.Client, error) {
+	caFile := storeConfig["tls_ca"]
+	certFile := storeConfig["tls_cert"]
+	keyFile := storeConfig["tls_key"]
+	if caFile == "" && certFile == "" && keyFile == "" {
+		return &http.Client{}, nil
+	}
+
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if caFile != "" {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificate found in %s", caFile)
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("tls_cert and tls_key must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return &http.Client{Transport: transport}, nil
+}
+
 func (s *Store) Location() string {
 	return s.location
 }
@@ -58,8 +109,20 @@ func (s *Store) sendRequest(method string, requestType string, payload interface
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
-	client := &http.Client{}
-	return client.Do(req)
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	r, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if r.StatusCode == http.StatusUnauthorized || r.StatusCode == http.StatusForbidden {
+		defer r.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(r.Body, 1024))
+		return nil, fmt.Errorf("%s: %s", http.StatusText(r.StatusCode), strings.TrimSpace(string(msg)))
+	}
+	return r, nil
 }
 
 func (s *Store) Create(config []byte) error {
@@ -85,6 +148,24 @@ func (s *Store) Open() ([]byte, error) {
 	return resOpen.Configuration, nil
 }
 
+func (s *Store) PutConfig(config []byte) error {
+	r, err := s.sendRequest("PUT", "/config", network.ReqPutConfig{
+		Data: config,
+	})
+	if err != nil {
+		return err
+	}
+
+	var resPutConfig network.ResPutConfig
+	if err := json.NewDecoder(r.Body).Decode(&resPutConfig); err != nil {
+		return err
+	}
+	if resPutConfig.Err != "" {
+		return fmt.Errorf("%s", resPutConfig.Err)
+	}
+	return nil
+}
+
 func (s *Store) Close() error {
 	return nil
 }
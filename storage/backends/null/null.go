@@ -49,6 +49,11 @@ func (s *Store) Create(config []byte) error {
 	return nil
 }
 
+func (s *Store) PutConfig(config []byte) error {
+	s.config = config
+	return nil
+}
+
 func (s *Store) Open() ([]byte, error) {
 	return s.config, nil
 }
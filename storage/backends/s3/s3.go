@@ -25,6 +25,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/PlakarKorp/plakar/objects"
 	"github.com/PlakarKorp/plakar/storage"
@@ -44,6 +45,11 @@ type Store struct {
 	secretAccessKey string
 
 	putObjectOptions minio.PutObjectOptions
+
+	// objectLock creates the bucket with object lock enabled, and
+	// retention locks the states and packfiles written to it.
+	objectLock bool
+	retention  *storage.ImmutabilityConfiguration
 }
 
 func init() {
@@ -74,11 +80,21 @@ func NewStore(storeConfig map[string]string) (storage.Store, error) {
 		useSsl = tmp
 	}
 
+	objectLock := false
+	if value, ok := storeConfig["object_lock"]; ok {
+		tmp, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid object_lock value")
+		}
+		objectLock = tmp
+	}
+
 	return &Store{
 		location:        storeConfig["location"],
 		accessKey:       accessKey,
 		secretAccessKey: secretAccessKey,
 		useSsl:          useSsl,
+		objectLock:      objectLock,
 		putObjectOptions: minio.PutObjectOptions{
 			// Some providers (eg. BlackBlaze) return the error
 			// "Unsupported header 'x-amz-checksum-algorithm'" if SendContentMd5
@@ -92,6 +108,47 @@ func (s *Store) Location() string {
 	return s.location
 }
 
+// SetRetention locks the states and packfiles written from now on for
+// the retention period of config, if the bucket has object lock enabled.
+// S3 rejects retention on other buckets, which are only protected by the
+// immutable store.
+func (s *Store) SetRetention(config *storage.ImmutabilityConfiguration) error {
+	mode := minio.RetentionMode(config.Mode)
+	if !mode.IsValid() {
+		return fmt.Errorf("invalid retention mode: %s", config.Mode)
+	}
+	if s.objectLockEnabled() {
+		s.retention = config
+	} else {
+		s.retention = nil
+	}
+	return nil
+}
+
+// objectLockEnabled reports whether the bucket was created with object
+// lock, either by this store or beforehand.
+func (s *Store) objectLockEnabled() bool {
+	if s.objectLock {
+		return true
+	}
+	if s.minioClient == nil {
+		return false
+	}
+	status, _, _, _, err := s.minioClient.GetObjectLockConfig(context.Background(), s.bucketName)
+	return err == nil && status == "Enabled"
+}
+
+// retainedObjectOptions returns the options of objects that must not be
+// altered before the end of the retention period.
+func (s *Store) retainedObjectOptions() minio.PutObjectOptions {
+	opts := s.putObjectOptions
+	if s.retention != nil {
+		opts.Mode = minio.RetentionMode(s.retention.Mode)
+		opts.RetainUntilDate = time.Now().Add(s.retention.Retention()).UTC()
+	}
+	return opts
+}
+
 func (s *Store) connect(location *url.URL) error {
 	endpoint := location.Host
 	useSSL := s.useSsl
@@ -126,7 +183,7 @@ func (s *Store) Create(config []byte) error {
 		return err
 	}
 	if !exists {
-		err = s.minioClient.MakeBucket(context.Background(), s.bucketName, minio.MakeBucketOptions{})
+		err = s.minioClient.MakeBucket(context.Background(), s.bucketName, minio.MakeBucketOptions{ObjectLocking: s.objectLock})
 		if err != nil {
 			return err
 		}
@@ -149,6 +206,11 @@ func (s *Store) Create(config []byte) error {
 	return nil
 }
 
+func (s *Store) PutConfig(config []byte) error {
+	_, err := s.minioClient.PutObject(context.Background(), s.bucketName, "CONFIG", bytes.NewReader(config), int64(len(config)), s.putObjectOptions)
+	return err
+}
+
 func (s *Store) Open() ([]byte, error) {
 	parsed, err := url.Parse(s.location)
 	if err != nil {
@@ -219,13 +281,29 @@ func (s *Store) GetStates() ([]objects.MAC, error) {
 }
 
 func (s *Store) PutState(mac objects.MAC, rd io.Reader) error {
-	_, err := s.minioClient.PutObject(context.Background(), s.bucketName, fmt.Sprintf("states/%02x/%016x", mac[0], mac), rd, -1, s.putObjectOptions)
+	_, err := s.minioClient.PutObject(context.Background(), s.bucketName, fmt.Sprintf("states/%02x/%016x", mac[0], mac), rd, -1, s.retainedObjectOptions())
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// hasObject reports whether key exists, without reading it.
+func (s *Store) hasObject(key string) (bool, error) {
+	_, err := s.minioClient.StatObject(context.Background(), s.bucketName, key, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *Store) HasState(mac objects.MAC) (bool, error) {
+	return s.hasObject(fmt.Sprintf("states/%02x/%016x", mac[0], mac))
+}
+
 func (s *Store) GetState(mac objects.MAC) (io.Reader, error) {
 	object, err := s.minioClient.GetObject(context.Background(), s.bucketName, fmt.Sprintf("states/%02x/%016x", mac[0], mac), minio.GetObjectOptions{})
 	if err != nil {
@@ -267,13 +345,17 @@ func (s *Store) GetPackfiles() ([]objects.MAC, error) {
 }
 
 func (s *Store) PutPackfile(mac objects.MAC, rd io.Reader) error {
-	_, err := s.minioClient.PutObject(context.Background(), s.bucketName, fmt.Sprintf("packfiles/%02x/%016x", mac[0], mac), rd, -1, s.putObjectOptions)
+	_, err := s.minioClient.PutObject(context.Background(), s.bucketName, fmt.Sprintf("packfiles/%02x/%016x", mac[0], mac), rd, -1, s.retainedObjectOptions())
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+func (s *Store) HasPackfile(mac objects.MAC) (bool, error) {
+	return s.hasObject(fmt.Sprintf("packfiles/%02x/%016x", mac[0], mac))
+}
+
 func (s *Store) GetPackfile(mac objects.MAC) (io.Reader, error) {
 	object, err := s.minioClient.GetObject(context.Background(), s.bucketName, fmt.Sprintf("packfiles/%02x/%016x", mac[0], mac), minio.GetObjectOptions{})
 	if err != nil {
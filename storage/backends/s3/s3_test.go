@@ -2,15 +2,18 @@ package s3
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/PlakarKorp/plakar/objects"
 	"github.com/PlakarKorp/plakar/storage"
 	"github.com/johannesboyne/gofakes3"
 	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/minio/minio-go/v7"
 	"github.com/stretchr/testify/require"
 )
 
@@ -111,3 +114,90 @@ func TestS3Backend(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, "test4", buf.String())
 }
+
+func TestS3ObjectLock(t *testing.T) {
+	backend := s3mem.New()
+	faker := gofakes3.New(backend)
+	ts := httptest.NewServer(faker.Server())
+	defer ts.Close()
+
+	st, err := NewStore(map[string]string{"location": ts.URL + "/lockedbucket", "access_key": "", "secret_access_key": "", "use_tls": "false", "object_lock": "true"})
+	require.NoError(t, err)
+
+	config := storage.NewConfiguration()
+	serializedConfig, err := config.ToBytes()
+	require.NoError(t, err)
+	require.NoError(t, st.Create(serializedConfig))
+
+	immutability := storage.NewImmutabilityConfiguration(7)
+	immutability.Mode = storage.RETENTION_COMPLIANCE
+	require.NoError(t, st.(storage.Retainer).SetRetention(immutability))
+
+	mac := objects.MAC{0x10, 0x20}
+	require.NoError(t, st.PutState(mac, bytes.NewReader([]byte("state"))))
+	require.NoError(t, st.PutPackfile(mac, bytes.NewReader([]byte("packfile"))))
+	require.NoError(t, st.PutLock(mac, bytes.NewReader([]byte("lock"))))
+
+	s3store := st.(*Store)
+	stat := func(key string) (string, time.Time) {
+		info, err := s3store.minioClient.StatObject(context.Background(), s3store.bucketName, key, minio.StatObjectOptions{})
+		require.NoError(t, err)
+		until, _ := time.Parse(time.RFC3339, info.Metadata.Get("X-Amz-Object-Lock-Retain-Until-Date"))
+		return info.Metadata.Get("X-Amz-Object-Lock-Mode"), until
+	}
+
+	for _, key := range []string{fmt.Sprintf("states/%02x/%016x", mac[0], mac), fmt.Sprintf("packfiles/%02x/%016x", mac[0], mac)} {
+		mode, until := stat(key)
+		require.Equal(t, storage.RETENTION_COMPLIANCE, mode)
+		require.WithinDuration(t, time.Now().Add(7*24*time.Hour), until, time.Minute)
+	}
+
+	// locks come and go, they are never retained
+	mode, _ := stat(fmt.Sprintf("locks/%016x", mac))
+	require.Empty(t, mode)
+
+	immutability.Mode = "FOREVER"
+	require.Error(t, st.(storage.Retainer).SetRetention(immutability))
+}
+
+func TestS3NoObjectLock(t *testing.T) {
+	backend := s3mem.New()
+	faker := gofakes3.New(backend)
+	ts := httptest.NewServer(faker.Server())
+	defer ts.Close()
+
+	st, err := NewStore(map[string]string{"location": ts.URL + "/bucket", "access_key": "", "secret_access_key": "", "use_tls": "false"})
+	require.NoError(t, err)
+
+	config := storage.NewConfiguration()
+	serializedConfig, err := config.ToBytes()
+	require.NoError(t, err)
+	require.NoError(t, st.Create(serializedConfig))
+
+	// S3 rejects retention on buckets without object lock, the store
+	// must not send it
+	require.NoError(t, st.(storage.Retainer).SetRetention(storage.NewImmutabilityConfiguration(7)))
+
+	mac := objects.MAC{0x10, 0x20}
+	opts := st.(*Store).retainedObjectOptions()
+	require.Empty(t, opts.Mode)
+	require.True(t, opts.RetainUntilDate.IsZero())
+
+	stater := st.(storage.Stater)
+	exists, err := stater.HasState(mac)
+	require.NoError(t, err)
+	require.False(t, exists)
+	exists, err = stater.HasPackfile(mac)
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	require.NoError(t, st.PutState(mac, bytes.NewReader([]byte("state"))))
+	require.NoError(t, st.PutPackfile(mac, bytes.NewReader([]byte("packfile"))))
+
+	exists, err = stater.HasState(mac)
+	require.NoError(t, err)
+	require.True(t, exists)
+	exists, err = stater.HasPackfile(mac)
+	require.NoError(t, err)
+	require.True(t, exists)
+}
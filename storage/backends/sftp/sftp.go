@@ -224,6 +224,32 @@ func (s *Store) Create(config []byte) error {
 	return WriteToFileAtomic(client, s.Path("CONFIG"), bytes.NewReader(config))
 }
 
+func (s *Store) PutConfig(config []byte) error {
+	tmp := s.Path("CONFIG.tmp")
+	f, err := s.client.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(config); err != nil {
+		f.Close()
+		s.client.Remove(tmp)
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		s.client.Remove(tmp)
+		return err
+	}
+
+	// unlike Rename, PosixRename replaces the existing CONFIG
+	if err := s.client.PosixRename(tmp, s.Path("CONFIG")); err != nil {
+		s.client.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
 func (s *Store) Open() ([]byte, error) {
 	client, err := connect(s.location)
 	if err != nil {
@@ -0,0 +1,251 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PlakarKorp/plakar/objects"
+)
+
+const (
+	RETENTION_GOVERNANCE = "GOVERNANCE"
+	RETENTION_COMPLIANCE = "COMPLIANCE"
+)
+
+var ErrImmutable = errors.New("repository is immutable")
+
+// ImmutabilityConfiguration is the write-once policy of a repository:
+// states and packfiles are never overwritten nor deleted, and snapshots
+// cannot be removed until they are RetentionDays old.
+type ImmutabilityConfiguration struct {
+	RetentionDays uint32
+
+	// Mode is the object lock mode requested from stores able to lock
+	// what they write on their own.
+	Mode string
+}
+
+func NewImmutabilityConfiguration(days uint32) *ImmutabilityConfiguration {
+	return &ImmutabilityConfiguration{
+		RetentionDays: days,
+		Mode:          RETENTION_GOVERNANCE,
+	}
+}
+
+func (c *ImmutabilityConfiguration) Validate() error {
+	if c.RetentionDays == 0 {
+		return fmt.Errorf("invalid retention: 0 days")
+	}
+	switch c.Mode {
+	case RETENTION_GOVERNANCE, RETENTION_COMPLIANCE:
+		return nil
+	default:
+		return fmt.Errorf("invalid retention mode: %s", c.Mode)
+	}
+}
+
+// ParseRetentionMode returns the mode named by name, case-insensitively.
+func ParseRetentionMode(name string) (string, error) {
+	mode := strings.ToUpper(name)
+	if mode != RETENTION_GOVERNANCE && mode != RETENTION_COMPLIANCE {
+		return "", fmt.Errorf("invalid retention mode: %s", name)
+	}
+	return mode, nil
+}
+
+func (c *ImmutabilityConfiguration) Retention() time.Duration {
+	return time.Duration(c.RetentionDays) * 24 * time.Hour
+}
+
+// ProtectedUntil returns the date until which data written at t is
+// protected.
+func (c *ImmutabilityConfiguration) ProtectedUntil(t time.Time) time.Time {
+	return t.Add(c.Retention())
+}
+
+// Protects reports whether data written at t is still protected.
+func (c *ImmutabilityConfiguration) Protects(t time.Time) bool {
+	return c != nil && time.Now().Before(c.ProtectedUntil(t))
+}
+
+// Weakens reports whether replacing c with next would lift protection.
+func (c *ImmutabilityConfiguration) Weakens(next *ImmutabilityConfiguration) bool {
+	if next == nil {
+		return true
+	}
+	if next.RetentionDays < c.RetentionDays {
+		return true
+	}
+	return c.Mode == RETENTION_COMPLIANCE && next.Mode != RETENTION_COMPLIANCE
+}
+
+// Retainer is implemented by stores that can lock the objects they
+// write for a retention period themselves, such as S3 object lock.
+type Retainer interface {
+	SetRetention(config *ImmutabilityConfiguration) error
+}
+
+// Stater is implemented by stores that can tell whether a single state
+// or packfile exists without listing them all.
+type Stater interface {
+	HasState(mac objects.MAC) (bool, error)
+	HasPackfile(mac objects.MAC) (bool, error)
+}
+
+// immutableStore enforces an immutability policy on the store it wraps,
+// so that it holds whichever client or server talks to the store.
+type immutableStore struct {
+	Store
+	config *ImmutabilityConfiguration
+	stater Stater
+
+	mu        sync.Mutex
+	states    map[objects.MAC]struct{}
+	packfiles map[objects.MAC]struct{}
+}
+
+// NewImmutableStore returns store refusing to overwrite or delete states
+// and packfiles, and to weaken config. Stores implementing Retainer are
+// also asked to lock the objects they write.
+func NewImmutableStore(store Store, config *ImmutabilityConfiguration) (Store, error) {
+	if retainer, ok := store.(Retainer); ok {
+		if err := retainer.SetRetention(config); err != nil {
+			return nil, err
+		}
+	}
+	stater, _ := store.(Stater)
+	return &immutableStore{
+		Store:  store,
+		config: config,
+		stater: stater,
+	}, nil
+}
+
+func (s *immutableStore) PutConfig(config []byte) error {
+	if len(config) < int(STORAGE_HEADER_SIZE+STORAGE_FOOTER_SIZE) {
+		return fmt.Errorf("invalid configuration")
+	}
+	next, err := NewConfigurationFromWrappedBytes(config)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	weakens := s.config.Weakens(next.Immutability)
+	s.mu.Unlock()
+	if weakens {
+		return fmt.Errorf("%w: retention cannot be lowered", ErrImmutable)
+	}
+	if err := s.Store.PutConfig(config); err != nil {
+		return err
+	}
+	if retainer, ok := s.Store.(Retainer); ok {
+		if err := retainer.SetRetention(next.Immutability); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	s.config = next.Immutability
+	s.mu.Unlock()
+	return nil
+}
+
+// claim records mac in known, and fails if it was already written. The
+// store is asked for mac with has if it is a Stater, otherwise known is
+// loaded with list on first use.
+func (s *immutableStore) claim(known *map[objects.MAC]struct{}, has func(objects.MAC) (bool, error), list func() ([]objects.MAC, error), mac objects.MAC) error {
+	s.mu.Lock()
+	if *known == nil {
+		var macs []objects.MAC
+		if has == nil {
+			var err error
+			if macs, err = list(); err != nil {
+				s.mu.Unlock()
+				return err
+			}
+		}
+		*known = make(map[objects.MAC]struct{}, len(macs))
+		for _, mac := range macs {
+			(*known)[mac] = struct{}{}
+		}
+	}
+	_, exists := (*known)[mac]
+	(*known)[mac] = struct{}{}
+	s.mu.Unlock()
+
+	if !exists && has != nil {
+		var err error
+		if exists, err = has(mac); err != nil {
+			s.release(*known, mac)
+			return err
+		}
+	}
+	if exists {
+		return fmt.Errorf("%w: %x cannot be overwritten", ErrImmutable, mac)
+	}
+	return nil
+}
+
+func (s *immutableStore) release(known map[objects.MAC]struct{}, mac objects.MAC) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(known, mac)
+}
+
+func (s *immutableStore) PutState(mac objects.MAC, rd io.Reader) error {
+	var has func(objects.MAC) (bool, error)
+	if s.stater != nil {
+		has = s.stater.HasState
+	}
+	if err := s.claim(&s.states, has, s.Store.GetStates, mac); err != nil {
+		return err
+	}
+	if err := s.Store.PutState(mac, rd); err != nil {
+		s.release(s.states, mac)
+		return err
+	}
+	return nil
+}
+
+func (s *immutableStore) DeleteState(mac objects.MAC) error {
+	return fmt.Errorf("%w: state %x cannot be deleted", ErrImmutable, mac)
+}
+
+func (s *immutableStore) PutPackfile(mac objects.MAC, rd io.Reader) error {
+	var has func(objects.MAC) (bool, error)
+	if s.stater != nil {
+		has = s.stater.HasPackfile
+	}
+	if err := s.claim(&s.packfiles, has, s.Store.GetPackfiles, mac); err != nil {
+		return err
+	}
+	if err := s.Store.PutPackfile(mac, rd); err != nil {
+		s.release(s.packfiles, mac)
+		return err
+	}
+	return nil
+}
+
+func (s *immutableStore) DeletePackfile(mac objects.MAC) error {
+	return fmt.Errorf("%w: packfile %x cannot be deleted", ErrImmutable, mac)
+}
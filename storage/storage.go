@@ -57,6 +57,13 @@ type Configuration struct {
 	Hashing     hashing.Configuration
 	Compression *compression.Configuration
 	Encryption  *encryption.Configuration
+
+	// Rekey is the encryption a rekey of the repository moves it to,
+	// set until the rekey completes so that it can be resumed.
+	Rekey *encryption.Configuration `msgpack:",omitempty"`
+
+	// Immutability is the write-once policy of the repository, if any.
+	Immutability *ImmutabilityConfiguration `msgpack:",omitempty"`
 }
 
 func NewConfiguration() *Configuration {
@@ -107,6 +114,7 @@ func (c *Configuration) ToBytes() ([]byte, error) {
 type Store interface {
 	Create(config []byte) error
 	Open() ([]byte, error)
+	PutConfig(config []byte) error
 	Location() string
 
 	GetStates() ([]objects.MAC, error)
@@ -106,6 +106,11 @@ func (mb *MockBackend) Open() ([]byte, error) {
 	return mb.configuration, nil
 }
 
+func (mb *MockBackend) PutConfig(configuration []byte) error {
+	mb.configuration = configuration
+	return nil
+}
+
 func (mb *MockBackend) Location() string {
 	return mb.location
 }
@@ -30,6 +30,7 @@ import (
 
 	"github.com/PlakarKorp/plakar/api"
 	"github.com/PlakarKorp/plakar/repository"
+	"github.com/PlakarKorp/plakar/server/audit"
 )
 
 type UiOptions struct {
@@ -37,6 +38,8 @@ type UiOptions struct {
 	NoSpawn        bool
 	Cors           bool
 	Token          string
+	AccessLog      *audit.Logger
+	RateLimit      *audit.Limiter
 }
 
 //go:embed frontend/*
@@ -114,10 +117,11 @@ func Ui(repo *repository.Repository, addr string, opts *UiOptions) error {
 		fmt.Println("launching webUI at", url)
 	}
 
+	var handler http.Handler = server
 	if opts.Cors {
-		return http.ListenAndServe(addr, corsMiddleware(server))
+		handler = corsMiddleware(server)
 	}
-	return http.ListenAndServe(addr, server)
+	return http.ListenAndServe(addr, audit.Middleware(opts.AccessLog, opts.RateLimit, handler))
 }
 
 func corsMiddleware(next http.Handler) http.Handler {